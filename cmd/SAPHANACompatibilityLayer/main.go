@@ -35,6 +35,8 @@ import (
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/clientconn"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/auth"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/crud"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/debug"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/logging"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/version"
@@ -42,16 +44,27 @@ import (
 
 //nolint:gochecknoglobals // flags are defined there to be visible in `bin/SAPHANACompatibilitylayer-testcover -h` output
 var (
-	debugAddrF       = flag.String("debug-addr", "127.0.0.1:8088", "debug address")
-	listenAddrF      = flag.String("listen-addr", "127.0.0.1:27017", "listen address")
-	modeF            = flag.String("mode", string(clientconn.AllModes[0]), fmt.Sprintf("operation mode: %v", clientconn.AllModes))
-	proxyAddrF       = flag.String("proxy-addr", "127.0.0.1:37017", "")
-	tlsF             = flag.Bool("tls", false, "enable TLS")
-	tlsCertFilePathF = flag.String("certFile", "", "path to file containing certificate for TLS")
-	tlsKeyFilePathF  = flag.String("keyFile", "", "path to file containing key for TLS")
-	versionF         = flag.Bool("version", false, "print version to stdout (full version, commit, branch, dirty flag) and exit")
-	testConnTimeoutF = flag.Duration("test-conn-timeout", 0, "test: set connection timeout")
-	saphanaURL       = flag.String("HANAConnectString", "", "SAP HANA Cloud instance connect string")
+	debugAddrF          = flag.String("debug-addr", "127.0.0.1:8088", "debug address")
+	listenAddrF         = flag.String("listen-addr", "127.0.0.1:27017", "listen address")
+	modeF               = flag.String("mode", string(clientconn.AllModes[0]), fmt.Sprintf("operation mode: %v", clientconn.AllModes))
+	proxyAddrF          = flag.String("proxy-addr", "127.0.0.1:37017", "")
+	tlsF                = flag.Bool("tls", false, "enable TLS")
+	tlsCertFilePathF    = flag.String("certFile", "", "path to file containing certificate for TLS")
+	tlsKeyFilePathF     = flag.String("keyFile", "", "path to file containing key for TLS")
+	tlsCAFilePathF      = flag.String("caFile", "", "path to file containing the certificate authorities client certificates are verified against")
+	tlsAllowNoCertF     = flag.Bool("tlsAllowConnectionsWithoutCertificates", false, "allow clients without certificate if -caFile is set")
+	tlsMinVersionF      = flag.String("tlsMinVersion", "", "minimum TLS version: 1.0, 1.1, 1.2 or 1.3")
+	tlsMaxVersionF      = flag.String("tlsMaxVersion", "", "maximum TLS version: 1.0, 1.1, 1.2 or 1.3")
+	tlsCipherSuitesF    = flag.String("tlsCipherSuites", "", "comma separated cipher suites for TLS 1.2 and lower")
+	versionF            = flag.Bool("version", false, "print version to stdout (full version, commit, branch, dirty flag) and exit")
+	cursorTimeoutF      = flag.Duration("cursor-timeout", crud.DefaultCursorTimeout, "close cursors idle for longer than this")
+	transactionTimeoutF = flag.Duration("transaction-timeout", crud.DefaultTransactionTimeout, "abort transactions idle for longer than this")
+	insertBatchSizeF    = flag.Int("insert-batch-size", crud.DefaultInsertBatchSize, "number of documents inserted in one transaction")
+	authF               = flag.Bool("auth", true, "require clients to authenticate with SCRAM-SHA-256 or SCRAM-SHA-1")
+	adminUserF          = flag.String("admin-user", "", "create or update this user of the admin database at startup")
+	adminPasswordF      = flag.String("admin-password", "", "password of the admin user")
+	testConnTimeoutF    = flag.Duration("test-conn-timeout", 0, "test: set connection timeout")
+	saphanaURL          = flag.String("HANAConnectString", "", "SAP HANA Cloud instance connect string")
 )
 
 func main() {
@@ -104,22 +117,54 @@ func main() {
 
 	defer hanaPool.Close()
 
+	var authenticator *auth.Authenticator
+	if *authF {
+		authenticator = auth.NewAuthenticator(&auth.NewAuthenticatorOpts{
+			HanaPool:      hanaPool,
+			Logger:        logger.Named("auth"),
+			ConnectString: *saphanaURL,
+		})
+		defer authenticator.Close()
+	}
+
+	if *adminUserF != "" {
+		if authenticator == nil {
+			logger.Fatal("-admin-user requires -auth")
+		}
+		if err = authenticator.SetPassword(ctx, "admin", *adminUserF, *adminPasswordF); err != nil {
+			logger.Fatal(err.Error())
+		}
+	}
+
 	listenerMetrics := clientconn.NewListenerMetrics()
 	handlersMetrics := handlers.NewMetrics()
 	prometheus.DefaultRegisterer.MustRegister(listenerMetrics, handlersMetrics)
 
+	tlsOpts := &clientconn.TLSOpts{
+		CertFilePath:                        *tlsCertFilePathF,
+		KeyFilePath:                         *tlsKeyFilePathF,
+		CAFilePath:                          *tlsCAFilePathF,
+		AllowConnectionsWithoutCertificates: *tlsAllowNoCertF,
+		MinVersion:                          *tlsMinVersionF,
+		MaxVersion:                          *tlsMaxVersionF,
+		CipherSuites:                        *tlsCipherSuitesF,
+	}
+
 	l := clientconn.NewListener(&clientconn.NewListenerOpts{
-		ListenAddr:      *listenAddrF,
-		TLS:             *tlsF,
-		TLSCertFilePath: *tlsCertFilePathF,
-		TLSKeyFilePath:  *tlsKeyFilePathF,
-		ProxyAddr:       *proxyAddrF,
-		Mode:            clientconn.Mode(*modeF),
-		HanaPool:        hanaPool,
-		Logger:          logger.Named("listener"),
-		Metrics:         listenerMetrics,
-		HandlersMetrics: handlersMetrics,
-		TestConnTimeout: *testConnTimeoutF,
+		ListenAddr:         *listenAddrF,
+		TLS:                *tlsF,
+		TLSOpts:            tlsOpts,
+		ProxyAddr:          *proxyAddrF,
+		Mode:               clientconn.Mode(*modeF),
+		HanaPool:           hanaPool,
+		Logger:             logger.Named("listener"),
+		Metrics:            listenerMetrics,
+		HandlersMetrics:    handlersMetrics,
+		CursorTimeout:      *cursorTimeoutF,
+		TransactionTimeout: *transactionTimeoutF,
+		InsertBatchSize:    *insertBatchSizeF,
+		TestConnTimeout:    *testConnTimeoutF,
+		Authenticator:      authenticator,
 	})
 
 	err = l.Run(ctx)
@@ -21,6 +21,8 @@ package clientconn
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"net"
@@ -30,6 +32,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/auth"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/crud"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/proxy"
 
@@ -71,6 +74,11 @@ type newConnOpts struct {
 	proxyAddr       string
 	mode            Mode
 	handlersMetrics *handlers.Metrics
+	cursors         *crud.Cursors
+	sessions        *crud.Sessions
+	insertBatchSize int
+	connectionID    int32
+	authenticator   *auth.Authenticator
 }
 
 // newConn creates a new client connection for given net.Conn.
@@ -80,7 +88,17 @@ func newConn(opts *newConnOpts) (*conn, error) {
 
 	peerAddr := opts.netConn.RemoteAddr().String()
 
-	crudH := crud.NewStorage(opts.hanaPool, l)
+	// the connection has its own handle of the pool, so that authentication
+	// can switch it to the pool of a SAP HANA user without affecting other connections
+	hanaPool := &hana.Hpool{DB: opts.hanaPool.DB}
+
+	crudH := crud.NewStorage(&crud.NewStorageOpts{
+		HanaPool:        hanaPool,
+		Logger:          l,
+		Cursors:         opts.cursors,
+		Sessions:        opts.sessions,
+		InsertBatchSize: opts.insertBatchSize,
+	})
 
 	var p *proxy.Handler
 	if opts.mode != NormalMode {
@@ -91,11 +109,24 @@ func newConn(opts *newConnOpts) (*conn, error) {
 	}
 
 	handlerOpts := &handlers.NewOpts{
-		HanaPool:    opts.hanaPool,
+		HanaPool:    hanaPool,
 		Logger:      l,
 		CrudStorage: crudH,
 		Metrics:     opts.handlersMetrics,
 		PeerAddr:    peerAddr,
+
+		ConnectionID:  opts.connectionID,
+		Authenticator: opts.authenticator,
+	}
+
+	if tlsConn, ok := opts.netConn.(*tls.Conn); ok {
+		// the handshake is done when the first message is read, before any command is handled
+		handlerOpts.PeerCertificate = func() *x509.Certificate {
+			if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+				return certs[0]
+			}
+			return nil
+		}
 	}
 
 	return &conn{
@@ -147,6 +178,8 @@ func (c *conn) run(ctx context.Context) (err error) {
 			c.proxy.Close()
 		}
 
+		c.h.Close()
+
 		// c.netConn is closed by the caller
 	}()
 
@@ -33,6 +33,8 @@ import (
 
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/auth"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/crud"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/ctxutil"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
 )
@@ -43,17 +45,22 @@ type Listener struct {
 }
 
 type NewListenerOpts struct {
-	ListenAddr      string
-	TLS             bool
-	TLSCertFilePath string
-	TLSKeyFilePath  string
-	ProxyAddr       string
-	Mode            Mode
-	HanaPool        *hana.Hpool
-	Logger          *zap.Logger
-	Metrics         *ListenerMetrics
-	HandlersMetrics *handlers.Metrics
-	TestConnTimeout time.Duration
+	ListenAddr         string
+	TLS                bool
+	TLSOpts            *TLSOpts
+	ProxyAddr          string
+	Mode               Mode
+	HanaPool           *hana.Hpool
+	Logger             *zap.Logger
+	Metrics            *ListenerMetrics
+	HandlersMetrics    *handlers.Metrics
+	CursorTimeout      time.Duration
+	TransactionTimeout time.Duration
+	InsertBatchSize    int
+	TestConnTimeout    time.Duration
+
+	// Authenticator verifies the credentials of clients. Authentication is disabled if it is nil.
+	Authenticator *auth.Authenticator
 }
 
 // NewListener returns a new listener, configured by the NewListenerOpts argument.
@@ -73,7 +80,7 @@ func (l *Listener) Run(ctx context.Context) error {
 	l.opts.Logger.Sugar().Infof("Listening on %s ...", l.opts.ListenAddr)
 
 	if l.opts.TLS {
-		tlsConfig, err := generateX509Cert(l.opts.TLSCertFilePath, l.opts.TLSKeyFilePath)
+		tlsConfig, err := generateX509Cert(l.opts.TLSOpts, l.opts.Logger.Named("tls"))
 		if err != nil {
 			return err
 		}
@@ -87,9 +94,18 @@ func (l *Listener) Run(ctx context.Context) error {
 		lis.Close()
 	}()
 
+	// cursors are shared by all connections since drivers may send getMore on any pooled connection
+	cursors := crud.NewCursors(l.opts.CursorTimeout, l.opts.Logger.Named("cursors"))
+	go cursors.Run(ctx)
+
+	// transactions are shared as well since commands of a session may be sent on any pooled connection
+	sessions := crud.NewSessions(l.opts.TransactionTimeout, l.opts.Logger.Named("sessions"))
+	go sessions.Run(ctx)
+
 	const delay = 3 * time.Second
 
 	var wg sync.WaitGroup
+	var connectionID int32
 	for {
 		netConn, err := lis.Accept()
 		if err != nil {
@@ -106,6 +122,8 @@ func (l *Listener) Run(ctx context.Context) error {
 
 		wg.Add(1)
 		l.opts.Metrics.ConnectedClients.Inc()
+		connectionID++
+		connID := connectionID
 
 		// run connection
 		go func() {
@@ -121,6 +139,11 @@ func (l *Listener) Run(ctx context.Context) error {
 				proxyAddr:       l.opts.ProxyAddr,
 				mode:            l.opts.Mode,
 				handlersMetrics: l.opts.HandlersMetrics,
+				cursors:         cursors,
+				sessions:        sessions,
+				connectionID:    connID,
+				authenticator:   l.opts.Authenticator,
+				insertBatchSize: l.opts.InsertBatchSize,
 			}
 			conn, e := newConn(opts)
 			if e != nil {
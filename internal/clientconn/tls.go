@@ -6,22 +6,214 @@ package clientconn
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
 
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
 )
 
-func generateX509Cert(certFilePath string, keyFilePath string) (*tls.Config, error) {
-	if certFilePath == "" {
+// TLSOpts represents the TLS options of the listener.
+type TLSOpts struct {
+	CertFilePath string
+	KeyFilePath  string
+
+	// CAFilePath is the path to the PEM file of the certificate authorities which client certificates are verified against.
+	// Client certificates are not requested if it is empty.
+	CAFilePath string
+
+	// AllowConnectionsWithoutCertificates allows clients without certificate to connect if CAFilePath is set.
+	// Certificates which are presented are verified all the same.
+	AllowConnectionsWithoutCertificates bool
+
+	// MinVersion and MaxVersion are the TLS versions "1.0", "1.1", "1.2" or "1.3". Empty values are the defaults of Go.
+	MinVersion string
+	MaxVersion string
+
+	// CipherSuites is a comma separated list of the names of the cipher suites for TLS 1.2 and lower,
+	// for example "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256". Empty means the defaults of Go.
+	// The cipher suites of TLS 1.3 are not configurable.
+	CipherSuites string
+}
+
+// tlsVersions maps the names of the TLS versions to their values.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// generateX509Cert returns the TLS configuration of the options.
+// The certificate and key files are reloaded when they change, so they can be rotated without restarting the listener.
+func generateX509Cert(opts *TLSOpts, l *zap.Logger) (*tls.Config, error) {
+	if opts.CertFilePath == "" {
 		return nil, lazyerrors.Errorf("No path was given for the certificate file for TLS")
-	} else if keyFilePath == "" {
+	} else if opts.KeyFilePath == "" {
 		return nil, lazyerrors.Errorf("No path was given for the key file for TLS")
 	}
 
-	cert, err := tls.LoadX509KeyPair(certFilePath, keyFilePath)
+	reloader := &certReloader{
+		certFilePath: opts.CertFilePath,
+		keyFilePath:  opts.KeyFilePath,
+		l:            l,
+	}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{GetCertificate: reloader.getCertificate}
+
+	if opts.CAFilePath != "" {
+		pem, err := os.ReadFile(opts.CAFilePath)
+		if err != nil {
+			return nil, lazyerrors.Errorf("Following error occured when reading the CA file: %w", err)
+		}
+
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, lazyerrors.Errorf("No certificate was found in the CA file %s", opts.CAFilePath)
+		}
+
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		if opts.AllowConnectionsWithoutCertificates {
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	var err error
+	if config.MinVersion, err = parseTLSVersion(opts.MinVersion); err != nil {
+		return nil, err
+	}
+	if config.MaxVersion, err = parseTLSVersion(opts.MaxVersion); err != nil {
+		return nil, err
+	}
+	if config.MaxVersion != 0 && config.MinVersion > config.MaxVersion {
+		return nil, lazyerrors.Errorf("The minimum TLS version %s is greater than the maximum TLS version %s", opts.MinVersion, opts.MaxVersion)
+	}
+
+	if config.CipherSuites, err = parseCipherSuites(opts.CipherSuites); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// parseTLSVersion returns the value of the TLS version, or 0 for the default if version is empty.
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, lazyerrors.Errorf("Unknown TLS version %q, supported are 1.0, 1.1, 1.2 and 1.3", version)
+	}
+
+	return v, nil
+}
+
+// parseCipherSuites returns the IDs of the comma separated cipher suites, or nil for the defaults if names is empty.
+// Insecure cipher suites have to be given explicitly.
+func parseCipherSuites(names string) ([]uint16, error) {
+	if names == "" {
+		return nil, nil
+	}
+
+	ids := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		ids[s.Name] = s.ID
+	}
+	for _, s := range tls.InsecureCipherSuites() {
+		ids[s.Name] = s.ID
+	}
+
+	var res []uint16
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := ids[name]
+		if !ok {
+			return nil, lazyerrors.Errorf("Unknown cipher suite %q", name)
+		}
+		res = append(res, id)
+	}
+
+	return res, nil
+}
+
+// certReloader holds the server certificate and reloads it when the certificate or key file is modified.
+type certReloader struct {
+	certFilePath string
+	keyFilePath  string
+	l            *zap.Logger
+
+	rw          sync.RWMutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+// getCertificate returns the certificate for a handshake. It is the GetCertificate function of tls.Config.
+// If the files changed but cannot be loaded, e.g. because only one of them was replaced so far,
+// the previous certificate is used and loading is retried on the next handshake.
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if r.modified() {
+		if err := r.reload(); err != nil {
+			r.l.Warn("Failed to reload the certificate for TLS", zap.Error(err))
+		} else {
+			r.l.Info("Reloaded the certificate for TLS", zap.String("certFile", r.certFilePath))
+		}
+	}
+
+	r.rw.RLock()
+	defer r.rw.RUnlock()
+
+	return r.cert, nil
+}
+
+// modified returns true if the modification time of the certificate or key file differs from the loaded one.
+func (r *certReloader) modified() bool {
+	certInfo, err := os.Stat(r.certFilePath)
 	if err != nil {
-		return nil, lazyerrors.Errorf("Following error occured when loading the x509 key and cert files: %w", err)
+		return false
 	}
-	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	keyInfo, err := os.Stat(r.keyFilePath)
+	if err != nil {
+		return false
+	}
+
+	r.rw.RLock()
+	defer r.rw.RUnlock()
+
+	return !certInfo.ModTime().Equal(r.certModTime) || !keyInfo.ModTime().Equal(r.keyModTime)
+}
+
+// reload loads the certificate and key files.
+func (r *certReloader) reload() error {
+	certInfo, err := os.Stat(r.certFilePath)
+	if err != nil {
+		return lazyerrors.Error(err)
+	}
+	keyInfo, err := os.Stat(r.keyFilePath)
+	if err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFilePath, r.keyFilePath)
+	if err != nil {
+		return lazyerrors.Errorf("Following error occured when loading the x509 key and cert files: %w", err)
+	}
+
+	r.rw.Lock()
+	defer r.rw.Unlock()
+
+	r.cert = &cert
+	r.certModTime = certInfo.ModTime()
+	r.keyModTime = keyInfo.ModTime()
 
-	return config, err
+	return nil
 }
@@ -0,0 +1,138 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package clientconn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+// writeCert writes a self-signed certificate with the common name and its key to the files.
+func writeCert(t *testing.T, commonName, certFilePath, keyFilePath string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	require.NoError(t, os.WriteFile(certFilePath, certPEM, 0o600))
+	require.NoError(t, os.WriteFile(keyFilePath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+}
+
+// commonName returns the common name of the certificate.
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+
+	return parsed.Subject.CommonName
+}
+
+func TestGenerateX509Cert(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeCert(t, "server", certFile, keyFile)
+
+	t.Run("Options", func(t *testing.T) {
+		t.Parallel()
+
+		config, err := generateX509Cert(&TLSOpts{
+			CertFilePath: certFile,
+			KeyFilePath:  keyFile,
+			CAFilePath:   certFile,
+			MinVersion:   "1.2",
+			MaxVersion:   "1.3",
+			CipherSuites: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+		}, zaptest.NewLogger(t))
+		require.NoError(t, err)
+
+		assert.Equal(t, tls.RequireAndVerifyClientCert, config.ClientAuth)
+		assert.NotNil(t, config.ClientCAs)
+		assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+		assert.Equal(t, uint16(tls.VersionTLS13), config.MaxVersion)
+		expected := []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}
+		assert.Equal(t, expected, config.CipherSuites)
+	})
+
+	t.Run("InvalidOptions", func(t *testing.T) {
+		t.Parallel()
+
+		for name, opts := range map[string]*TLSOpts{
+			"MinVersion":   {CertFilePath: certFile, KeyFilePath: keyFile, MinVersion: "1.4"},
+			"Versions":     {CertFilePath: certFile, KeyFilePath: keyFile, MinVersion: "1.3", MaxVersion: "1.2"},
+			"CipherSuites": {CertFilePath: certFile, KeyFilePath: keyFile, CipherSuites: "TLS_UNKNOWN"},
+			"CAFile":       {CertFilePath: certFile, KeyFilePath: keyFile, CAFilePath: filepath.Join(dir, "missing.pem")},
+		} {
+			_, err := generateX509Cert(opts, zaptest.NewLogger(t))
+			assert.Error(t, err, name)
+		}
+	})
+
+	t.Run("Reload", func(t *testing.T) {
+		t.Parallel()
+
+		certFile := filepath.Join(dir, "reload-cert.pem")
+		keyFile := filepath.Join(dir, "reload-key.pem")
+		writeCert(t, "old", certFile, keyFile)
+
+		config, err := generateX509Cert(&TLSOpts{CertFilePath: certFile, KeyFilePath: keyFile}, zaptest.NewLogger(t))
+		require.NoError(t, err)
+		assert.Equal(t, tls.NoClientCert, config.ClientAuth)
+
+		cert, err := config.GetCertificate(nil)
+		require.NoError(t, err)
+		assert.Equal(t, "old", commonName(t, cert))
+
+		writeCert(t, "new", certFile, keyFile)
+
+		// the modification time may not have changed within its resolution
+		future := time.Now().Add(time.Minute)
+		require.NoError(t, os.Chtimes(certFile, future, future))
+		require.NoError(t, os.Chtimes(keyFile, future, future))
+
+		cert, err = config.GetCertificate(nil)
+		require.NoError(t, err)
+		assert.Equal(t, "new", commonName(t, cert))
+
+		// an incomplete rotation keeps the previous certificate
+		require.NoError(t, os.WriteFile(keyFile, []byte("invalid"), 0o600))
+
+		cert, err = config.GetCertificate(nil)
+		require.NoError(t, err)
+		assert.Equal(t, "new", commonName(t, cert))
+	})
+}
@@ -178,6 +178,8 @@ func toFJSONHANA(v any) (fjsontype, error) {
 		return pointer.To(Int64(v)), nil
 	case int32:
 		return pointer.To(Int64(v)), nil
+	case time.Time:
+		return pointer.To(DateTime(v)), nil
 	default:
 		return nil, fmt.Errorf("datatype %T is not supported", v)
 	}
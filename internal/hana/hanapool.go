@@ -11,6 +11,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"net/url"
 	"strings"
 
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
@@ -67,6 +68,34 @@ func CreatePool(connectString string, logger *zap.Logger, lazy bool) (*Hpool, er
 	return res, err
 }
 
+// CreateUserPool sets up the connection to SAP HANA JSON Document Store as the given SAP HANA user.
+// The host and the options are taken from the connect string, the user and the password are replaced.
+func CreateUserPool(connectString, user, password string) (*Hpool, error) {
+	u, err := url.Parse(connectString)
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	u.User = url.UserPassword(user, password)
+
+	db, err := sql.Open("hdb", u.String())
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	return &Hpool{DB: db}, nil
+}
+
+// IsInsufficientPrivilege returns true if err is the SAP HANA error 258 "insufficient privilege".
+func IsInsufficientPrivilege(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "insufficient privilege")
+}
+
+// IsAuthenticationFailed returns true if err is the SAP HANA error 10 "authentication failed".
+func IsAuthenticationFailed(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "authentication failed")
+}
+
 // Tables returns a sorted list of SAP HANA JSON Document Store collection names.
 func (hanaPool *Hpool) Tables(ctx context.Context, db string) ([]string, error) {
 	if err := hanaPool.CreateSchema(ctx, db); err != nil && err != ErrAlreadyExist {
@@ -98,8 +127,15 @@ func (hanaPool *Hpool) Tables(ctx context.Context, db string) ([]string, error)
 
 // CreateSchema creates a schema in SAP HANA JSON Document Store.
 func (hanaPool *Hpool) CreateSchema(ctx context.Context, db string) error {
-	sql := `CREATE SCHEMA ` + db
-	_, err := hanaPool.ExecContext(ctx, sql)
+	schema, err := Schema(db)
+	if err != nil {
+		return err
+	}
+
+	_, err = hanaPool.ExecContext(ctx, `CREATE SCHEMA `+schema)
+	if IsInsufficientPrivilege(err) {
+		return lazyerrors.Error(err)
+	}
 	if err != nil {
 		return ErrAlreadyExist
 	}
@@ -107,17 +143,34 @@ func (hanaPool *Hpool) CreateSchema(ctx context.Context, db string) error {
 	return err
 }
 
-// CreateCollection creates a new SAP HANA JSON Document Store collection.
+// CreateCollection creates a new SAP HANA JSON Document Store collection
+// with a unique index on _id.
 //
 // It returns ErrAlreadyExist if collection already exist.
 func (hanaPool *Hpool) CreateCollection(ctx context.Context, db, collection string) error {
-	sql := `CREATE COLLECTION ` + db + "." + collection
-	_, err := hanaPool.ExecContext(ctx, sql)
+	table, err := Table(db, collection)
+	if err != nil {
+		return err
+	}
+
+	_, err = hanaPool.ExecContext(ctx, `CREATE COLLECTION `+table)
+	if IsInsufficientPrivilege(err) {
+		return lazyerrors.Error(err)
+	}
 	if err != nil {
 		return ErrAlreadyExist
 	}
 
-	return err
+	idIndex := Index{Name: IDIndexName, Keys: []IndexKey{{Field: "_id", Order: 1}}, Unique: true}
+	if err = hanaPool.CreateIndex(ctx, db, collection, idIndex); err != nil {
+		// a collection without the index would accept duplicate _id values
+		_ = hanaPool.DropTable(ctx, db, collection)
+		return err
+	}
+
+	idIndexTables.Store(idIndexTable{db: hanaPool.DB, table: table}, struct{}{})
+
+	return nil
 }
 
 // Schemas returns a sorted list of SAP HANA JSON Document Store schema names.
@@ -205,8 +258,12 @@ func (hanaPool *Hpool) Schemas(ctx context.Context) ([]string, error) {
 //
 // It returns ErrNotExist is collection does not exist.
 func (hanaPool *Hpool) DropTable(ctx context.Context, db, collection string) error {
-	sql := `DROP COLLECTION ` + db + "." + collection
-	_, err := hanaPool.ExecContext(ctx, sql)
+	table, err := Table(db, collection)
+	if err != nil {
+		return err
+	}
+
+	_, err = hanaPool.ExecContext(ctx, `DROP COLLECTION `+table)
 	if err != nil {
 		return ErrNotExist
 	}
@@ -218,8 +275,12 @@ func (hanaPool *Hpool) DropTable(ctx context.Context, db, collection string) err
 //
 // It returns ErrNotExist if schema does not exist.
 func (hanaPool *Hpool) DropSchema(ctx context.Context, db string) error {
-	sql := `DROP SCHEMA ` + db + " cascade"
-	_, err := hanaPool.ExecContext(ctx, sql)
+	schema, err := Schema(db)
+	if err != nil {
+		return err
+	}
+
+	_, err = hanaPool.ExecContext(ctx, `DROP SCHEMA `+schema+" cascade")
 
 	return err
 }
@@ -45,7 +45,7 @@ func TestHanapool(t *testing.T) {
 
 		row := sqlmock.NewRows([]string{"table_name"}).AddRow("testTable")
 		args := []driver.Value{"TESTDATABASE"}
-		mock.ExpectExec("CREATE SCHEMA testDatabase").WillReturnError(fmt.Errorf("error"))
+		mock.ExpectExec(`CREATE SCHEMA "TESTDATABASE"`).WillReturnError(fmt.Errorf("error"))
 		mock.ExpectQuery("SELECT TABLE_NAME FROM \"PUBLIC\".\"M_TABLES\" WHERE SCHEMA_NAME = $1 AND TABLE_TYPE = 'COLLECTION';").WithArgs(args...).WillReturnRows(row)
 
 		h := Hpool{
@@ -65,7 +65,7 @@ func TestHanapool(t *testing.T) {
 		nilRow := sqlmock.NewRows([]string{"table_name"}).AddRow(nil)
 		args = []driver.Value{"TESTDATABASE"}
 
-		mock.ExpectExec("CREATE SCHEMA testDatabase").WillReturnError(fmt.Errorf("error"))
+		mock.ExpectExec(`CREATE SCHEMA "TESTDATABASE"`).WillReturnError(fmt.Errorf("error"))
 		mock.ExpectQuery("SELECT TABLE_NAME FROM \"PUBLIC\".\"M_TABLES\" WHERE SCHEMA_NAME = $1 AND TABLE_TYPE = 'COLLECTION';").WithArgs(args...).WillReturnRows(nilRow)
 
 		tables, err = h.Tables(ctx, "testDatabase")
@@ -114,7 +114,7 @@ func TestHanapool(t *testing.T) {
 		}
 		defer db.Close()
 
-		mock.ExpectExec("CREATE SCHEMA database").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE SCHEMA "DATABASE"`).WillReturnResult(sqlmock.NewResult(1, 1))
 
 		h := Hpool{
 			db,
@@ -132,13 +132,14 @@ func TestHanapool(t *testing.T) {
 	t.Run("create collection", func(t *testing.T) {
 		t.Parallel()
 
-		db, mock, err := sqlmock.New()
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(QueryMatcherEqualBytes))
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
 		defer db.Close()
 
-		mock.ExpectExec("CREATE COLLECTION database.collection").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE COLLECTION "DATABASE"."COLLECTION"`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE UNIQUE INDEX "DATABASE"."COLLECTION._id_" ON "DATABASE"."COLLECTION"("_id")`).WillReturnResult(sqlmock.NewResult(0, 0))
 
 		h := Hpool{
 			db,
@@ -152,11 +153,23 @@ func TestHanapool(t *testing.T) {
 			t.Errorf("there were unfulfilled expectations: %s", err)
 		}
 
-		mock.ExpectExec("CREATE COLLECTION database.collection").WillReturnResult(sqlmock.NewResult(1, 1)).WillReturnError(ErrAlreadyExist)
+		mock.ExpectExec(`CREATE COLLECTION "DATABASE"."COLLECTION"`).WillReturnResult(sqlmock.NewResult(1, 1)).WillReturnError(ErrAlreadyExist)
 
 		err = h.CreateCollection(ctx, "database", "collection")
 
 		assert.EqualError(t, err, ErrAlreadyExist.Error())
+
+		mock.ExpectExec(`CREATE COLLECTION "DATABASE"."COLLECTION"`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE UNIQUE INDEX "DATABASE"."COLLECTION._id_"`).WillReturnError(fmt.Errorf("insufficient privilege"))
+		mock.ExpectExec(`DROP COLLECTION "DATABASE"."COLLECTION"`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		err = h.CreateCollection(ctx, "database", "collection")
+
+		assert.ErrorContains(t, err, "insufficient privilege")
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
 	})
 
 	t.Run("Drop table", func(t *testing.T) {
@@ -168,7 +181,7 @@ func TestHanapool(t *testing.T) {
 		}
 		defer db.Close()
 
-		mock.ExpectExec("DROP COLLECTION testDatabase.testCollection").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`DROP COLLECTION "TESTDATABASE"."TESTCOLLECTION"`).WillReturnResult(sqlmock.NewResult(1, 1))
 
 		h := Hpool{
 			db,
@@ -183,7 +196,7 @@ func TestHanapool(t *testing.T) {
 			t.Errorf("there were unfulfilled expectations: %s", err)
 		}
 
-		mock.ExpectExec("DROP COLLECTION testDatabase.testCollection").WillReturnResult(sqlmock.NewResult(0, 0)).WillReturnError(ErrNotExist)
+		mock.ExpectExec(`DROP COLLECTION "TESTDATABASE"."TESTCOLLECTION"`).WillReturnResult(sqlmock.NewResult(0, 0)).WillReturnError(ErrNotExist)
 
 		err = h.DropTable(ctx, "testDatabase", "testCollection")
 
@@ -199,7 +212,7 @@ func TestHanapool(t *testing.T) {
 		}
 		defer db.Close()
 
-		mock.ExpectExec("DROP SCHEMA testDatabase").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`DROP SCHEMA "TESTDATABASE"`).WillReturnResult(sqlmock.NewResult(1, 1))
 
 		h := Hpool{
 			db,
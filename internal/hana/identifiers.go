@@ -0,0 +1,50 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hana
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidName is returned for names of databases and collections which are no valid SAP HANA identifiers.
+var ErrInvalidName = errors.New("invalid name")
+
+// nameRe matches the regular identifiers of SAP HANA, which databases and collections have always been created with.
+var nameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$#]*$`)
+
+// QuoteIdentifier returns the identifier as delimited identifier, which keeps its case.
+func QuoteIdentifier(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
+
+// Schema validates the name of the database and returns the delimited identifier of its schema.
+// Like regular identifiers, the name is case-insensitive.
+func Schema(db string) (string, error) {
+	if !nameRe.MatchString(db) {
+		return "", fmt.Errorf("%w: database name %q must start with a letter or underscore "+
+			"followed by letters, digits, underscores, $ or #", ErrInvalidName, db)
+	}
+
+	return QuoteIdentifier(strings.ToUpper(db)), nil
+}
+
+// Table validates the names of the database and the collection and returns the delimited identifier of the collection.
+// Like regular identifiers, the names are case-insensitive.
+func Table(db, collection string) (string, error) {
+	schema, err := Schema(db)
+	if err != nil {
+		return "", err
+	}
+
+	if !nameRe.MatchString(collection) {
+		return "", fmt.Errorf("%w: collection name %q must start with a letter or underscore "+
+			"followed by letters, digits, underscores, $ or #", ErrInvalidName, collection)
+	}
+
+	return schema + "." + QuoteIdentifier(strings.ToUpper(collection)), nil
+}
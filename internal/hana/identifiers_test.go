@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hana
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuoteIdentifier(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, `"field"`, QuoteIdentifier("field"))
+	assert.Equal(t, `"fi""eld"`, QuoteIdentifier(`fi"eld`))
+	assert.Equal(t, `"a.b"`, QuoteIdentifier("a.b"))
+}
+
+func TestTable(t *testing.T) {
+	t.Parallel()
+
+	for name, expected := range map[[2]string]string{
+		{"test", "values"}:        `"TEST"."VALUES"`,
+		{"_my_db", "coll$1#"}:     `"_MY_DB"."COLL$1#"`,
+		{"Test", "MixedCase"}:     `"TEST"."MIXEDCASE"`,
+		{"test", `a"; DROP x --`}: "",
+		{"test", "system.js"}:     "",
+		{"1test", "values"}:       "",
+		{"test", ""}:              "",
+		{"", "values"}:            "",
+	} {
+		actual, err := Table(name[0], name[1])
+		if expected == "" {
+			require.ErrorIs(t, err, ErrInvalidName, name)
+			continue
+		}
+
+		require.NoError(t, err, name)
+		assert.Equal(t, expected, actual, name)
+	}
+}
@@ -0,0 +1,232 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hana
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+)
+
+// IDIndexName is the name of the unique index on _id created with every collection.
+const IDIndexName = "_id_"
+
+// errCodeUniqueViolation is the SAP HANA error code of a violated unique constraint.
+const errCodeUniqueViolation = 301
+
+// uniqueViolationIndex matches the name of the violated index in the message of a unique constraint violation.
+var uniqueViolationIndex = regexp.MustCompile(`Index\(([^)]+)\)`)
+
+// idIndexTables holds the tables which are known to have the _id index, per database connection pool.
+var idIndexTables sync.Map
+
+// idIndexTable is a key of idIndexTables.
+type idIndexTable struct {
+	db    *sql.DB
+	table string
+}
+
+// IndexKey is a field of an index with its sort order, 1 for ascending and -1 for descending.
+type IndexKey struct {
+	Field string
+	Order int32
+}
+
+// Index describes an index of a SAP HANA JSON Document Store collection.
+type Index struct {
+	Name   string
+	Keys   []IndexKey
+	Unique bool
+
+	sqlName string // name in SAP HANA, set for indexes returned by Indexes
+}
+
+// indexName returns the SAP HANA name of the index. Index names are unique per schema in SAP HANA
+// but only per collection in MongoDB, so the collection name is used as prefix.
+func indexName(collection, name string) string {
+	return strings.ToUpper(collection) + "." + name
+}
+
+// indexField returns the SQL for a field of an index given in dot notation.
+func indexField(field string) string {
+	path := strings.Split(field, ".")
+	for i, p := range path {
+		path[i] = QuoteIdentifier(p)
+	}
+
+	return strings.Join(path, ".")
+}
+
+// Indexes returns the indexes of the collection read from the system views SYS.INDEXES and SYS.INDEX_COLUMNS.
+func (hanaPool *Hpool) Indexes(ctx context.Context, db, collection string) ([]Index, error) {
+	sql := "SELECT i.INDEX_NAME, i.CONSTRAINT, c.COLUMN_NAME, c.ASCENDING_ORDER FROM SYS.INDEXES i " +
+		"JOIN SYS.INDEX_COLUMNS c ON i.SCHEMA_NAME = c.SCHEMA_NAME AND i.INDEX_NAME = c.INDEX_NAME " +
+		"WHERE i.SCHEMA_NAME = $1 AND i.TABLE_NAME = $2 ORDER BY i.INDEX_NAME, c.POSITION"
+
+	rows, err := hanaPool.QueryContext(ctx, sql, strings.ToUpper(db), strings.ToUpper(collection))
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+	defer rows.Close()
+
+	prefix := indexName(collection, "")
+
+	var res []Index
+	for rows.Next() {
+		var sqlName, column, ascending string
+		var constraint any
+		if err = rows.Scan(&sqlName, &constraint, &column, &ascending); err != nil {
+			return nil, lazyerrors.Error(err)
+		}
+
+		// indexes not created through the compatibility layer keep their SAP HANA name
+		name := strings.TrimPrefix(sqlName, prefix)
+
+		key := IndexKey{
+			Field: strings.ReplaceAll(column, "\"", ""),
+			Order: 1,
+		}
+		if strings.EqualFold(ascending, "FALSE") {
+			key.Order = -1
+		}
+
+		if len(res) == 0 || res[len(res)-1].Name != name {
+			res = append(res, Index{
+				Name:    name,
+				Unique:  constraint != nil,
+				sqlName: sqlName,
+			})
+		}
+
+		res[len(res)-1].Keys = append(res[len(res)-1].Keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	return res, nil
+}
+
+// CreateIndex creates the index on the collection.
+func (hanaPool *Hpool) CreateIndex(ctx context.Context, db, collection string, index Index) error {
+	sql := "CREATE INDEX "
+	if index.Unique {
+		sql = "CREATE UNIQUE INDEX "
+	}
+
+	table, err := Table(db, collection)
+	if err != nil {
+		return err
+	}
+
+	schema, _ := Schema(db)
+
+	sql += schema + "." + QuoteIdentifier(indexName(collection, index.Name)) + " ON " + table + "("
+	for i, key := range index.Keys {
+		if i != 0 {
+			sql += ", "
+		}
+
+		sql += indexField(key.Field)
+		if key.Order == -1 {
+			sql += " DESC"
+		}
+	}
+	sql += ")"
+
+	if _, err := hanaPool.ExecContext(ctx, sql); err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	return nil
+}
+
+// EnsureIDIndex creates the unique index on _id if the collection has none.
+// Collections created before _id uniqueness was enforced by an index do not have it.
+// The indexes are only read once per collection.
+func (hanaPool *Hpool) EnsureIDIndex(ctx context.Context, db, collection string) error {
+	table, err := Table(db, collection)
+	if err != nil {
+		return err
+	}
+
+	key := idIndexTable{db: hanaPool.DB, table: table}
+	if _, ok := idIndexTables.Load(key); ok {
+		return nil
+	}
+
+	indexes, err := hanaPool.Indexes(ctx, db, collection)
+	if err != nil {
+		return err
+	}
+
+	exists := false
+	for _, index := range indexes {
+		if index.Name == IDIndexName {
+			exists = true
+			break
+		}
+	}
+
+	if !exists {
+		idIndex := Index{Name: IDIndexName, Keys: []IndexKey{{Field: "_id", Order: 1}}, Unique: true}
+		if err = hanaPool.CreateIndex(ctx, db, collection, idIndex); err != nil {
+			return lazyerrors.Errorf("the unique index %s of %s cannot be created: %w", IDIndexName, table, err)
+		}
+	}
+
+	idIndexTables.Store(key, struct{}{})
+
+	return nil
+}
+
+// DropIndex drops the index of the collection.
+func (hanaPool *Hpool) DropIndex(ctx context.Context, db, collection string, index Index) error {
+	name := index.sqlName
+	if name == "" {
+		name = indexName(collection, index.Name)
+	}
+
+	schema, err := Schema(db)
+	if err != nil {
+		return err
+	}
+
+	if _, err = hanaPool.ExecContext(ctx, "DROP INDEX "+schema+"."+QuoteIdentifier(name)); err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	return nil
+}
+
+// UniqueViolation returns true if err is a violation of a unique index reported by SAP HANA.
+// The SAP HANA name of the violated index is returned if it is part of the error message.
+func UniqueViolation(err error) (index string, ok bool) {
+	if err == nil {
+		return "", false
+	}
+
+	var hdbErr interface{ Code() int }
+	if errors.As(err, &hdbErr) {
+		ok = hdbErr.Code() == errCodeUniqueViolation
+	} else {
+		ok = strings.Contains(err.Error(), "unique constraint violated")
+	}
+
+	if !ok {
+		return "", false
+	}
+
+	if m := uniqueViolationIndex.FindStringSubmatch(err.Error()); m != nil {
+		index = m[1]
+	}
+
+	return index, true
+}
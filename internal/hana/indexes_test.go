@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hana
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexes(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(QueryMatcherEqualBytes))
+	require.NoError(t, err)
+	defer db.Close()
+
+	h := Hpool{db}
+	ctx := testutil.Ctx(t)
+
+	t.Run("Indexes", func(t *testing.T) {
+		rows := sqlmock.NewRows([]string{"INDEX_NAME", "CONSTRAINT", "COLUMN_NAME", "ASCENDING_ORDER"}).
+			AddRow("TESTCOLLECTION._id_", "UNIQUE", "\"_id\"", "TRUE").
+			AddRow("TESTCOLLECTION.a_1_b_-1", nil, "\"a\"", "TRUE").
+			AddRow("TESTCOLLECTION.a_1_b_-1", nil, "\"b\".\"c\"", "FALSE").
+			AddRow("OTHER_INDEX", "UNIQUE", "\"x\"", "TRUE")
+		mock.ExpectQuery("SELECT i.INDEX_NAME, i.CONSTRAINT, c.COLUMN_NAME, c.ASCENDING_ORDER FROM SYS.INDEXES i").
+			WithArgs("TESTDATABASE", "TESTCOLLECTION").
+			WillReturnRows(rows)
+
+		indexes, err := h.Indexes(ctx, "testDatabase", "testCollection")
+		require.NoError(t, err)
+
+		expected := []Index{
+			{Name: "_id_", Keys: []IndexKey{{"_id", 1}}, Unique: true, sqlName: "TESTCOLLECTION._id_"},
+			{Name: "a_1_b_-1", Keys: []IndexKey{{"a", 1}, {"b.c", -1}}, sqlName: "TESTCOLLECTION.a_1_b_-1"},
+			{Name: "OTHER_INDEX", Keys: []IndexKey{{"x", 1}}, Unique: true, sqlName: "OTHER_INDEX"},
+		}
+		assert.Equal(t, expected, indexes)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("CreateIndex", func(t *testing.T) {
+		mock.ExpectExec(`CREATE UNIQUE INDEX "TESTDATABASE"."TESTCOLLECTION.ab" ON "TESTDATABASE"."TESTCOLLECTION"("a"."b" DESC, "c")`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		index := Index{Name: "ab", Keys: []IndexKey{{"a.b", -1}, {"c", 1}}, Unique: true}
+		require.NoError(t, h.CreateIndex(ctx, "testDatabase", "testCollection", index))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("DropIndex", func(t *testing.T) {
+		mock.ExpectExec(`DROP INDEX "TESTDATABASE"."TESTCOLLECTION.ab"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`DROP INDEX "TESTDATABASE"."OTHER_INDEX"`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		require.NoError(t, h.DropIndex(ctx, "testDatabase", "testCollection", Index{Name: "ab"}))
+		require.NoError(t, h.DropIndex(ctx, "testDatabase", "testCollection", Index{Name: "OTHER_INDEX", sqlName: "OTHER_INDEX"}))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("EnsureIDIndex", func(t *testing.T) {
+		columns := []string{"INDEX_NAME", "CONSTRAINT", "COLUMN_NAME", "ASCENDING_ORDER"}
+
+		// a collection created before _id uniqueness was enforced by an index
+		mock.ExpectQuery("SELECT i.INDEX_NAME, i.CONSTRAINT, c.COLUMN_NAME, c.ASCENDING_ORDER FROM SYS.INDEXES i").
+			WithArgs("TESTDATABASE", "OLDCOLLECTION").
+			WillReturnRows(sqlmock.NewRows(columns).AddRow("OLDCOLLECTION.a_1", nil, "\"a\"", "TRUE"))
+		mock.ExpectExec(`CREATE UNIQUE INDEX "TESTDATABASE"."OLDCOLLECTION._id_" ON "TESTDATABASE"."OLDCOLLECTION"("_id")`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		mock.ExpectQuery("SELECT i.INDEX_NAME, i.CONSTRAINT, c.COLUMN_NAME, c.ASCENDING_ORDER FROM SYS.INDEXES i").
+			WithArgs("TESTDATABASE", "TESTCOLLECTION").
+			WillReturnRows(sqlmock.NewRows(columns).AddRow("TESTCOLLECTION._id_", "UNIQUE", "\"_id\"", "TRUE"))
+
+		mock.ExpectQuery("SELECT i.INDEX_NAME, i.CONSTRAINT, c.COLUMN_NAME, c.ASCENDING_ORDER FROM SYS.INDEXES i").
+			WithArgs("TESTDATABASE", "DUPCOLLECTION").
+			WillReturnRows(sqlmock.NewRows(columns))
+		mock.ExpectExec(`CREATE UNIQUE INDEX "TESTDATABASE"."DUPCOLLECTION._id_"`).
+			WillReturnError(errors.New("cannot CREATE UNIQUE INDEX; duplicate key found"))
+
+		require.NoError(t, h.EnsureIDIndex(ctx, "testDatabase", "oldCollection"))
+		require.NoError(t, h.EnsureIDIndex(ctx, "testDatabase", "testCollection"))
+
+		// the indexes are only read once per collection
+		require.NoError(t, h.EnsureIDIndex(ctx, "testDatabase", "oldCollection"))
+		require.NoError(t, h.EnsureIDIndex(ctx, "testDatabase", "testCollection"))
+
+		err := h.EnsureIDIndex(ctx, "testDatabase", "dupCollection")
+		assert.ErrorContains(t, err, "duplicate key found")
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// hdbError imitates the errors of the SAP HANA driver.
+type hdbError struct {
+	code int
+	msg  string
+}
+
+func (e hdbError) Code() int     { return e.code }
+func (e hdbError) Error() string { return e.msg }
+
+func TestUniqueViolation(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		err   error
+		index string
+		ok    bool
+	}{
+		"Code": {
+			err:   fmt.Errorf("exec: %w", hdbError{301, "unique constraint violated: Table(TESTCOLLECTION), Index(TESTCOLLECTION._id_)"}),
+			index: "TESTCOLLECTION._id_",
+			ok:    true,
+		},
+		"OtherCode": {
+			err: hdbError{258, "insufficient privilege"},
+		},
+		"Message": {
+			err: errors.New("SQL Error 301 - unique constraint violated"),
+			ok:  true,
+		},
+		"Nil": {},
+	} {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			index, ok := UniqueViolation(tc.err)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.index, index)
+		})
+	}
+}
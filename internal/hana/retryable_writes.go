@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hana
+
+import (
+	"context"
+	"time"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+)
+
+// RetryableWritesTable is the table in the current schema in which the replies of retryable writes are recorded
+// by session id and transaction number, so that retried writes are not applied twice.
+const RetryableWritesTable = "MONGODB_RETRYABLE_WRITES"
+
+// CreateRetryableWritesTable creates the table of retryable writes if it does not exist yet.
+func (hanaPool *Hpool) CreateRetryableWritesTable(ctx context.Context) error {
+	return hanaPool.createTable(
+		ctx, RetryableWritesTable,
+		"LSID VARCHAR(64) NOT NULL, TXN_NUMBER BIGINT NOT NULL, REPLY BLOB NOT NULL, CREATED TIMESTAMP NOT NULL, "+
+			"PRIMARY KEY (LSID, TXN_NUMBER)",
+	)
+}
+
+// createTable creates a column table in the current schema with the given column definitions if it does not exist yet.
+func (hanaPool *Hpool) createTable(ctx context.Context, table, columns string) error {
+	var count int
+	sql := "SELECT COUNT(*) FROM SYS.TABLES WHERE SCHEMA_NAME = CURRENT_SCHEMA AND TABLE_NAME = $1"
+	if err := hanaPool.QueryRowContext(ctx, sql, table).Scan(&count); err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	if count != 0 {
+		return nil
+	}
+
+	if _, err := hanaPool.ExecContext(ctx, "CREATE COLUMN TABLE "+table+" ("+columns+")"); err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	return nil
+}
+
+// DeleteExpiredRetryableWrites deletes the recorded retryable writes which are older than the given age.
+func (hanaPool *Hpool) DeleteExpiredRetryableWrites(ctx context.Context, age time.Duration) error {
+	sql := "DELETE FROM " + RetryableWritesTable + " WHERE CREATED < ADD_SECONDS(CURRENT_UTCTIMESTAMP, $1)"
+	if _, err := hanaPool.ExecContext(ctx, sql, -int64(age/time.Second)); err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hana
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryableWrites(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(QueryMatcherEqualBytes))
+	require.NoError(t, err)
+	defer db.Close()
+
+	h := Hpool{db}
+	ctx := testutil.Ctx(t)
+
+	tableExists := func(count int) {
+		mock.ExpectQuery("SELECT COUNT(*) FROM SYS.TABLES WHERE SCHEMA_NAME = CURRENT_SCHEMA AND TABLE_NAME = $1").
+			WithArgs("MONGODB_RETRYABLE_WRITES").
+			WillReturnRows(sqlmock.NewRows([]string{"COUNT"}).AddRow(count))
+	}
+
+	t.Run("CreateRetryableWritesTable", func(t *testing.T) {
+		tableExists(0)
+		mock.ExpectExec("CREATE COLUMN TABLE MONGODB_RETRYABLE_WRITES (LSID VARCHAR(64) NOT NULL, TXN_NUMBER BIGINT NOT NULL").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		require.NoError(t, h.CreateRetryableWritesTable(ctx))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("TableExists", func(t *testing.T) {
+		tableExists(1)
+
+		require.NoError(t, h.CreateRetryableWritesTable(ctx))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("DeleteExpiredRetryableWrites", func(t *testing.T) {
+		mock.ExpectExec("DELETE FROM MONGODB_RETRYABLE_WRITES WHERE CREATED < ADD_SECONDS(CURRENT_UTCTIMESTAMP, $1)").
+			WithArgs(int64(-1800)).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+
+		require.NoError(t, h.DeleteExpiredRetryableWrites(ctx, 30*time.Minute))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
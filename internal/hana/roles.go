@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hana
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+)
+
+// schemaRolePrefix is the prefix of the SAP HANA roles which implement built-in MongoDB roles.
+const schemaRolePrefix = "MONGODB_"
+
+// schemaPrivileges are the privileges on the schema of a database granted by the built-in MongoDB roles.
+var schemaPrivileges = map[string]string{
+	"read":      "SELECT",
+	"readWrite": "SELECT, INSERT, UPDATE, DELETE, CREATE ANY, DROP, INDEX",
+	"dbAdmin":   "CREATE ANY, ALTER, DROP, INDEX",
+}
+
+// SchemaRole is a built-in MongoDB role on a database.
+// It is implemented by the SAP HANA role MONGODB_<role>_<db> with privileges on the schema of the database.
+type SchemaRole struct {
+	Role string
+	DB   string
+}
+
+// BuiltinRoles returns the sorted names of the built-in MongoDB roles.
+func BuiltinRoles() []string {
+	res := make([]string, 0, len(schemaPrivileges))
+	for role := range schemaPrivileges {
+		res = append(res, role)
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+// IsBuiltinRole returns true if role is a built-in MongoDB role.
+func IsBuiltinRole(role string) bool {
+	_, ok := schemaPrivileges[role]
+	return ok
+}
+
+// name returns the name of the SAP HANA role.
+func (r SchemaRole) name() string {
+	return schemaRolePrefix + r.Role + "_" + r.DB
+}
+
+// parseSchemaRole returns the built-in MongoDB role implemented by the SAP HANA role.
+func parseSchemaRole(name string) (SchemaRole, bool) {
+	if !strings.HasPrefix(name, schemaRolePrefix) {
+		return SchemaRole{}, false
+	}
+
+	for role := range schemaPrivileges {
+		if db := strings.TrimPrefix(name, schemaRolePrefix+role+"_"); db != name && db != "" {
+			return SchemaRole{Role: role, DB: db}, true
+		}
+	}
+
+	return SchemaRole{}, false
+}
+
+// createSchemaRole creates the SAP HANA role of the built-in MongoDB role if it does not exist yet.
+func (hanaPool *Hpool) createSchemaRole(ctx context.Context, r SchemaRole) error {
+	var count int
+	sql := "SELECT COUNT(*) FROM SYS.ROLES WHERE ROLE_NAME = $1"
+	if err := hanaPool.QueryRowContext(ctx, sql, r.name()).Scan(&count); err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	if count != 0 {
+		return nil
+	}
+
+	schema, err := Schema(r.DB)
+	if err != nil {
+		return err
+	}
+
+	if err = hanaPool.CreateSchema(ctx, r.DB); err != nil && err != ErrAlreadyExist {
+		return err
+	}
+
+	if _, err = hanaPool.ExecContext(ctx, "CREATE ROLE "+QuoteIdentifier(r.name())); err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	sql = "GRANT " + schemaPrivileges[r.Role] + " ON SCHEMA " + schema + " TO " + QuoteIdentifier(r.name())
+	if _, err = hanaPool.ExecContext(ctx, sql); err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	return nil
+}
+
+// GrantSchemaRole grants the built-in MongoDB role to the SAP HANA user, creating the SAP HANA role on first use.
+func (hanaPool *Hpool) GrantSchemaRole(ctx context.Context, user string, r SchemaRole) error {
+	if err := hanaPool.createSchemaRole(ctx, r); err != nil {
+		return err
+	}
+
+	if _, err := hanaPool.ExecContext(ctx, "GRANT "+QuoteIdentifier(r.name())+" TO "+QuoteIdentifier(user)); err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	return nil
+}
+
+// RevokeSchemaRole revokes the built-in MongoDB role from the SAP HANA user.
+func (hanaPool *Hpool) RevokeSchemaRole(ctx context.Context, user string, r SchemaRole) error {
+	if _, err := hanaPool.ExecContext(ctx, "REVOKE "+QuoteIdentifier(r.name())+" FROM "+QuoteIdentifier(user)); err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	return nil
+}
+
+// SchemaRoles returns the built-in MongoDB roles granted to the SAP HANA user.
+func (hanaPool *Hpool) SchemaRoles(ctx context.Context, user string) ([]SchemaRole, error) {
+	sql := "SELECT ROLE_NAME FROM SYS.GRANTED_ROLES WHERE GRANTEE = $1 ORDER BY ROLE_NAME"
+	rows, err := hanaPool.QueryContext(ctx, sql, user)
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+	defer rows.Close()
+
+	var res []SchemaRole
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, lazyerrors.Error(err)
+		}
+
+		if r, ok := parseSchemaRole(name); ok {
+			res = append(res, r)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hana
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSchemaRole(t *testing.T) {
+	t.Parallel()
+
+	for name, expected := range map[string]*SchemaRole{
+		"MONGODB_read_test":      {Role: "read", DB: "test"},
+		"MONGODB_readWrite_test": {Role: "readWrite", DB: "test"},
+		"MONGODB_dbAdmin_my_db":  {Role: "dbAdmin", DB: "my_db"},
+		"MONGODB_read_":          nil,
+		"MONGODB_root_test":      nil,
+		"PUBLIC":                 nil,
+	} {
+		actual, ok := parseSchemaRole(name)
+		if expected == nil {
+			assert.False(t, ok, name)
+			continue
+		}
+
+		assert.True(t, ok, name)
+		assert.Equal(t, *expected, actual, name)
+	}
+}
+
+func TestSchemaRoles(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(QueryMatcherEqualBytes))
+	require.NoError(t, err)
+	defer db.Close()
+
+	h := Hpool{db}
+	ctx := testutil.Ctx(t)
+
+	t.Run("GrantSchemaRole", func(t *testing.T) {
+		mock.ExpectQuery("SELECT COUNT(*) FROM SYS.ROLES WHERE ROLE_NAME = $1").
+			WithArgs("MONGODB_read_test").
+			WillReturnRows(sqlmock.NewRows([]string{"COUNT"}).AddRow(0))
+		mock.ExpectExec(`CREATE SCHEMA "TEST"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`CREATE ROLE "MONGODB_read_test"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`GRANT SELECT ON SCHEMA "TEST" TO "MONGODB_read_test"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`GRANT "MONGODB_read_test" TO "user"`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		require.NoError(t, h.GrantSchemaRole(ctx, "user", SchemaRole{Role: "read", DB: "test"}))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("GrantExistingSchemaRole", func(t *testing.T) {
+		mock.ExpectQuery("SELECT COUNT(*) FROM SYS.ROLES WHERE ROLE_NAME = $1").
+			WithArgs("MONGODB_readWrite_test").
+			WillReturnRows(sqlmock.NewRows([]string{"COUNT"}).AddRow(1))
+		mock.ExpectExec(`GRANT "MONGODB_readWrite_test" TO "user"`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		require.NoError(t, h.GrantSchemaRole(ctx, "user", SchemaRole{Role: "readWrite", DB: "test"}))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("SchemaRoles", func(t *testing.T) {
+		mock.ExpectQuery("SELECT ROLE_NAME FROM SYS.GRANTED_ROLES WHERE GRANTEE = $1 ORDER BY ROLE_NAME").
+			WithArgs("user").
+			WillReturnRows(sqlmock.NewRows([]string{"ROLE_NAME"}).
+				AddRow("MONGODB_read_test").
+				AddRow("MONGODB_readWrite_other").
+				AddRow("PUBLIC"))
+
+		actual, err := h.SchemaRoles(ctx, "user")
+		require.NoError(t, err)
+		expected := []SchemaRole{{Role: "read", DB: "test"}, {Role: "readWrite", DB: "other"}}
+		assert.Equal(t, expected, actual)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,198 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hana
+
+import (
+	"context"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+)
+
+// UsersTable is the table in the current schema in which the SCRAM credentials of users are stored.
+// Like in MongoDB, a user is identified by its name and the database it was created in.
+const UsersTable = "MONGODB_USERS"
+
+// Credentials are the SCRAM credentials of a user for one mechanism. The password itself is not stored.
+type Credentials struct {
+	Mechanism  string
+	Salt       []byte
+	Iterations int
+	StoredKey  []byte
+	ServerKey  []byte
+}
+
+// CreateUsersTable creates the table of users if it does not exist yet.
+func (hanaPool *Hpool) CreateUsersTable(ctx context.Context) error {
+	return hanaPool.createTable(
+		ctx, UsersTable,
+		"DB NVARCHAR(64) NOT NULL, USER_NAME NVARCHAR(256) NOT NULL, MECHANISM VARCHAR(32) NOT NULL, "+
+			"SALT VARBINARY(64) NOT NULL, ITERATIONS INTEGER NOT NULL, STORED_KEY VARBINARY(64) NOT NULL, SERVER_KEY VARBINARY(64) NOT NULL, "+
+			"PRIMARY KEY (DB, USER_NAME, MECHANISM)",
+	)
+}
+
+// UserCredentials returns the credentials of the user for all mechanisms. It returns no credentials if the user does not exist.
+func (hanaPool *Hpool) UserCredentials(ctx context.Context, db, user string) ([]Credentials, error) {
+	sql := "SELECT MECHANISM, SALT, ITERATIONS, STORED_KEY, SERVER_KEY FROM " + UsersTable + " WHERE DB = $1 AND USER_NAME = $2"
+	rows, err := hanaPool.QueryContext(ctx, sql, db, user)
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+	defer rows.Close()
+
+	var res []Credentials
+	for rows.Next() {
+		var c Credentials
+		if err = rows.Scan(&c.Mechanism, &c.Salt, &c.Iterations, &c.StoredKey, &c.ServerKey); err != nil {
+			return nil, lazyerrors.Error(err)
+		}
+		res = append(res, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	return res, nil
+}
+
+// User is a user stored in the table of users with the mechanisms it has credentials for.
+type User struct {
+	DB         string
+	Name       string
+	Mechanisms []string
+}
+
+// Users returns the users of the database sorted by database and name, or the users of all databases if db is empty.
+func (hanaPool *Hpool) Users(ctx context.Context, db string) ([]User, error) {
+	sql := "SELECT DB, USER_NAME, MECHANISM FROM " + UsersTable
+	var args []any
+	if db != "" {
+		sql += " WHERE DB = $1"
+		args = append(args, db)
+	}
+	sql += " ORDER BY DB, USER_NAME, MECHANISM"
+
+	rows, err := hanaPool.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+	defer rows.Close()
+
+	var res []User
+	for rows.Next() {
+		var userDB, name, mechanism string
+		if err = rows.Scan(&userDB, &name, &mechanism); err != nil {
+			return nil, lazyerrors.Error(err)
+		}
+
+		if n := len(res); n > 0 && res[n-1].DB == userDB && res[n-1].Name == name {
+			res[n-1].Mechanisms = append(res[n-1].Mechanisms, mechanism)
+			continue
+		}
+
+		res = append(res, User{DB: userDB, Name: name, Mechanisms: []string{mechanism}})
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	return res, nil
+}
+
+// DeleteUserCredentials deletes the credentials of the user. It returns false if the user does not exist.
+func (hanaPool *Hpool) DeleteUserCredentials(ctx context.Context, db, user string) (bool, error) {
+	res, err := hanaPool.ExecContext(ctx, "DELETE FROM "+UsersTable+" WHERE DB = $1 AND USER_NAME = $2", db, user)
+	if err != nil {
+		return false, lazyerrors.Error(err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return false, lazyerrors.Error(err)
+	}
+
+	return deleted > 0, nil
+}
+
+// CreateHanaUser creates the SAP HANA user with the password.
+// The name is a delimited identifier, so that it keeps the case of the MongoDB user.
+func (hanaPool *Hpool) CreateHanaUser(ctx context.Context, user, password string) error {
+	sql := "CREATE USER " + QuoteIdentifier(user) + " PASSWORD " + QuoteIdentifier(password) + " NO FORCE_FIRST_PASSWORD_CHANGE"
+	if _, err := hanaPool.ExecContext(ctx, sql); err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	return nil
+}
+
+// SetHanaUserPassword changes the password of the SAP HANA user.
+func (hanaPool *Hpool) SetHanaUserPassword(ctx context.Context, user, password string) error {
+	sql := "ALTER USER " + QuoteIdentifier(user) + " PASSWORD " + QuoteIdentifier(password) + " NO FORCE_FIRST_PASSWORD_CHANGE"
+	if _, err := hanaPool.ExecContext(ctx, sql); err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	return nil
+}
+
+// HanaUserExists returns true if the SAP HANA user exists.
+func (hanaPool *Hpool) HanaUserExists(ctx context.Context, user string) (bool, error) {
+	var count int
+	if err := hanaPool.QueryRowContext(ctx, "SELECT COUNT(*) FROM SYS.USERS WHERE USER_NAME = $1", user).Scan(&count); err != nil {
+		return false, lazyerrors.Error(err)
+	}
+
+	return count != 0, nil
+}
+
+// HasSystemPrivilege returns true if the SAP HANA user of the pool has the system privilege.
+func (hanaPool *Hpool) HasSystemPrivilege(ctx context.Context, privilege string) (bool, error) {
+	sql := "SELECT COUNT(*) FROM SYS.EFFECTIVE_PRIVILEGES WHERE USER_NAME = CURRENT_USER " +
+		"AND OBJECT_TYPE = 'SYSTEMPRIVILEGE' AND PRIVILEGE = $1"
+
+	var count int
+	if err := hanaPool.QueryRowContext(ctx, sql, privilege).Scan(&count); err != nil {
+		return false, lazyerrors.Error(err)
+	}
+
+	return count != 0, nil
+}
+
+// DropHanaUser drops the SAP HANA user.
+func (hanaPool *Hpool) DropHanaUser(ctx context.Context, user string) error {
+	if _, err := hanaPool.ExecContext(ctx, "DROP USER "+QuoteIdentifier(user)); err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	return nil
+}
+
+// SetUserCredentials replaces the credentials of the user, creating the user if it does not exist.
+func (hanaPool *Hpool) SetUserCredentials(ctx context.Context, db, user string, credentials []Credentials) error {
+	tx, err := hanaPool.BeginTx(ctx, nil)
+	if err != nil {
+		return lazyerrors.Error(err)
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM "+UsersTable+" WHERE DB = $1 AND USER_NAME = $2", db, user); err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	sql := "INSERT INTO " + UsersTable + " VALUES ($1, $2, $3, $4, $5, $6, $7)"
+	for _, c := range credentials {
+		if _, err = tx.ExecContext(ctx, sql, db, user, c.Mechanism, c.Salt, c.Iterations, c.StoredKey, c.ServerKey); err != nil {
+			return lazyerrors.Error(err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	return nil
+}
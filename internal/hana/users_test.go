@@ -0,0 +1,72 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hana
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsers(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(QueryMatcherEqualBytes))
+	require.NoError(t, err)
+	defer db.Close()
+
+	h := Hpool{db}
+	ctx := testutil.Ctx(t)
+
+	credentials := Credentials{
+		Mechanism:  "SCRAM-SHA-256",
+		Salt:       []byte("salt"),
+		Iterations: 15000,
+		StoredKey:  []byte("stored"),
+		ServerKey:  []byte("server"),
+	}
+
+	t.Run("CreateUsersTable", func(t *testing.T) {
+		mock.ExpectQuery("SELECT COUNT(*) FROM SYS.TABLES WHERE SCHEMA_NAME = CURRENT_SCHEMA AND TABLE_NAME = $1").
+			WithArgs("MONGODB_USERS").
+			WillReturnRows(sqlmock.NewRows([]string{"COUNT"}).AddRow(0))
+		mock.ExpectExec("CREATE COLUMN TABLE MONGODB_USERS (DB NVARCHAR(64) NOT NULL, USER_NAME NVARCHAR(256) NOT NULL").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		require.NoError(t, h.CreateUsersTable(ctx))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("SetUserCredentials", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE FROM MONGODB_USERS WHERE DB = $1 AND USER_NAME = $2").
+			WithArgs("admin", "user").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec("INSERT INTO MONGODB_USERS VALUES ($1, $2, $3, $4, $5, $6, $7)").
+			WithArgs("admin", "user", "SCRAM-SHA-256", []byte("salt"), 15000, []byte("stored"), []byte("server")).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		require.NoError(t, h.SetUserCredentials(ctx, "admin", "user", []Credentials{credentials}))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("UserCredentials", func(t *testing.T) {
+		mock.ExpectQuery("SELECT MECHANISM, SALT, ITERATIONS, STORED_KEY, SERVER_KEY FROM MONGODB_USERS WHERE DB = $1 AND USER_NAME = $2").
+			WithArgs("admin", "user").
+			WillReturnRows(
+				sqlmock.NewRows([]string{"MECHANISM", "SALT", "ITERATIONS", "STORED_KEY", "SERVER_KEY"}).
+					AddRow("SCRAM-SHA-256", []byte("salt"), 15000, []byte("stored"), []byte("server")),
+			)
+
+		actual, err := h.UserCredentials(ctx, "admin", "user")
+		require.NoError(t, err)
+		assert.Equal(t, []Credentials{credentials}, actual)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
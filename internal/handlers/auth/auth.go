@@ -0,0 +1,399 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package auth implements SCRAM authentication of clients against the users stored in SAP HANA
+// and PLAIN authentication of clients as SAP HANA users.
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+)
+
+// errAuthenticationFailed is returned for unknown users and wrong passwords alike.
+var errAuthenticationFailed = common.NewErrorMessage(common.ErrAuthenticationFailed, "Authentication failed.")
+
+// PLAIN is the mechanism with which clients authenticate as SAP HANA users.
+const PLAIN = "PLAIN"
+
+// externalDB is the database of users which are not stored by SAP HANA compatibility layer for MongoDB Wire Protocol.
+const externalDB = "$external"
+
+// Authenticator verifies the credentials of clients. It is shared by all client connections.
+//
+// User management creates, alters and drops SAP HANA users and grants their roles with clientPool,
+// the pool of the client running the command, so that SAP HANA checks the privileges of the client.
+// Only the table of users is accessed with the pool of the authenticator.
+type Authenticator struct {
+	hanaPool      *hana.Hpool
+	connectString string
+	l             *zap.Logger
+
+	tableMu      sync.Mutex
+	tableCreated bool
+
+	poolsMu sync.Mutex
+	pools   map[string]*userPool // of SAP HANA users by user name and password digest
+	closed  uint64               // incremented by closeUserPools
+}
+
+// userPool is the pool of a SAP HANA user shared by its client connections.
+// It is closed once no client connection uses it anymore.
+type userPool struct {
+	db   *sql.DB
+	user string
+	refs int // client connections using the pool
+}
+
+// NewAuthenticatorOpts represents options for NewAuthenticator.
+type NewAuthenticatorOpts struct {
+	HanaPool *hana.Hpool
+	Logger   *zap.Logger
+
+	// ConnectString is the connect string of HanaPool.
+	// The pools of SAP HANA users authenticated with PLAIN connect to the same instance.
+	ConnectString string
+}
+
+// NewAuthenticator creates a new Authenticator.
+func NewAuthenticator(opts *NewAuthenticatorOpts) *Authenticator {
+	return &Authenticator{
+		hanaPool:      opts.HanaPool,
+		connectString: opts.ConnectString,
+		l:             opts.Logger,
+		pools:         make(map[string]*userPool),
+	}
+}
+
+// Close closes the pools of SAP HANA users.
+func (a *Authenticator) Close() {
+	a.poolsMu.Lock()
+	defer a.poolsMu.Unlock()
+
+	for key, p := range a.pools {
+		_ = p.db.Close()
+		delete(a.pools, key)
+	}
+}
+
+// ReleasePool releases the pool of a SAP HANA user returned by a PLAIN conversation
+// once the client connection no longer uses it. The last client connection closes the pool.
+func (a *Authenticator) ReleasePool(hanaPool *hana.Hpool) {
+	a.poolsMu.Lock()
+	defer a.poolsMu.Unlock()
+
+	for key, p := range a.pools {
+		if p.db != hanaPool.DB {
+			continue
+		}
+
+		if p.refs--; p.refs == 0 {
+			_ = p.db.Close()
+			delete(a.pools, key)
+		}
+
+		return
+	}
+}
+
+// closeUserPools closes the pools of the SAP HANA user after its password was changed or it was dropped,
+// so that neither the old password nor the open connections can be used anymore.
+func (a *Authenticator) closeUserPools(user string) {
+	a.poolsMu.Lock()
+	defer a.poolsMu.Unlock()
+
+	a.closed++
+
+	for key, p := range a.pools {
+		if p.user == user {
+			_ = p.db.Close()
+			delete(a.pools, key)
+		}
+	}
+}
+
+// acquirePool returns the pool of the SAP HANA user, opening it if no client connection uses the password.
+// It returns an AuthenticationFailed error if SAP HANA rejects the credentials.
+// The pool must be released with ReleasePool.
+//
+// The pool is opened without holding poolsMu, so that a slow login to SAP HANA does not block other clients.
+func (a *Authenticator) acquirePool(ctx context.Context, user, password string) (*hana.Hpool, error) {
+	key := poolKey(user, password)
+
+	a.poolsMu.Lock()
+	p, ok := a.pools[key]
+	if ok {
+		p.refs++
+	}
+	closed := a.closed
+	a.poolsMu.Unlock()
+
+	if ok {
+		return &hana.Hpool{DB: p.db}, nil
+	}
+
+	hanaPool, err := hana.CreateUserPool(a.connectString, user, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = hanaPool.PingContext(ctx); err != nil {
+		_ = hanaPool.Close()
+
+		if hana.IsAuthenticationFailed(err) {
+			a.l.Info("Authentication of SAP HANA user failed", zap.String("user", user))
+			return nil, errAuthenticationFailed
+		}
+
+		return nil, lazyerrors.Error(err)
+	}
+
+	a.poolsMu.Lock()
+
+	// the password may have been changed while the pool was opened, it is verified again
+	if a.closed != closed {
+		a.poolsMu.Unlock()
+		_ = hanaPool.Close()
+
+		return a.acquirePool(ctx, user, password)
+	}
+
+	defer a.poolsMu.Unlock()
+
+	// another client of the user may have opened a pool in the meantime
+	if p, ok := a.pools[key]; ok {
+		_ = hanaPool.Close()
+		p.refs++
+		return &hana.Hpool{DB: p.db}, nil
+	}
+
+	a.pools[key] = &userPool{db: hanaPool.DB, user: user, refs: 1}
+
+	return hanaPool, nil
+}
+
+// poolKey returns the key of the pool of the SAP HANA user. The password is only kept as digest.
+func poolKey(user, password string) string {
+	digest := sha256.Sum256([]byte(user + "\x00" + password))
+	return user + ":" + hex.EncodeToString(digest[:])
+}
+
+// startPlain authenticates a client with the PLAIN message authzid NUL user NUL password as SAP HANA user.
+// The conversation is done at once, there are no further messages.
+//
+// Only SAP HANA users created by CreateUser in the database are authenticated,
+// other SAP HANA users like the one of the connect string are rejected.
+func (a *Authenticator) startPlain(ctx context.Context, db string, payload []byte) (*Conversation, error) {
+	parts := bytes.Split(payload, []byte{0})
+	if len(parts) != 3 || len(parts[1]) == 0 {
+		return nil, common.NewErrorMessage(common.ErrBadValue, "Invalid PLAIN message")
+	}
+
+	user := string(parts[1])
+	if authzid := string(parts[0]); authzid != "" && authzid != user {
+		return nil, common.NewErrorMessage(common.ErrBadValue, "Authorization as another user is not supported")
+	}
+
+	if err := a.createUsersTable(ctx); err != nil {
+		return nil, err
+	}
+
+	credentials, err := a.hanaPool.UserCredentials(ctx, db, user)
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+	if !hasPlainCredentials(credentials) {
+		a.l.Info("PLAIN authentication of unknown SAP HANA user", zap.String("user", user), zap.String("db", db))
+		return nil, errAuthenticationFailed
+	}
+
+	hanaPool, err := a.acquirePool(ctx, user, string(parts[2]))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conversation{
+		db:       db,
+		user:     user,
+		hanaPool: hanaPool,
+		done:     true,
+	}, nil
+}
+
+// createUsersTable creates the table of users once.
+func (a *Authenticator) createUsersTable(ctx context.Context) error {
+	a.tableMu.Lock()
+	defer a.tableMu.Unlock()
+
+	if a.tableCreated {
+		return nil
+	}
+
+	if err := a.hanaPool.CreateUsersTable(ctx); err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	a.tableCreated = true
+	return nil
+}
+
+// SetPassword stores the SCRAM-SHA-1 and SCRAM-SHA-256 credentials of the user of the database,
+// creating the user if it does not exist.
+func (a *Authenticator) SetPassword(ctx context.Context, db, user, password string) error {
+	if err := a.createUsersTable(ctx); err != nil {
+		return err
+	}
+
+	credentials := make([]hana.Credentials, 0, len(mechanisms))
+	for _, m := range []string{ScramSHA1, ScramSHA256} {
+		c, err := newCredentials(m, user, password)
+		if err != nil {
+			return err
+		}
+		credentials = append(credentials, c)
+	}
+
+	if err := a.hanaPool.SetUserCredentials(ctx, db, user, credentials); err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	return nil
+}
+
+// SupportedMechanisms returns the mechanisms with which the user of the database can authenticate,
+// or nil if the user does not exist.
+func (a *Authenticator) SupportedMechanisms(ctx context.Context, db, user string) ([]string, error) {
+	if err := a.createUsersTable(ctx); err != nil {
+		return nil, err
+	}
+
+	credentials, err := a.hanaPool.UserCredentials(ctx, db, user)
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+	if len(credentials) == 0 {
+		return nil, nil
+	}
+
+	if hasPlainCredentials(credentials) {
+		return []string{PLAIN}, nil
+	}
+
+	res := []string{}
+	for _, m := range []string{ScramSHA1, ScramSHA256} {
+		for _, c := range credentials {
+			if c.Mechanism == m {
+				res = append(res, m)
+			}
+		}
+	}
+
+	if len(res) == 0 {
+		return res, nil
+	}
+
+	// SCRAM is refused for users with roles, see StartConversation
+	roles, err := a.hanaPool.SchemaRoles(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) > 0 {
+		return []string{}, nil
+	}
+
+	return res, nil
+}
+
+// StartConversation starts the SCRAM conversation of the mechanism for a user of the database
+// with the client-first message and returns the conversation and the server-first message.
+// A PLAIN conversation is done at once and has no reply.
+//
+// SCRAM conversations run statements with the privileges of the SAP HANA user of the connect string,
+// so they are refused for SAP HANA users created by CreateUser and for users with roles.
+func (a *Authenticator) StartConversation(
+	ctx context.Context, db, mechanism string, payload []byte, skipEmptyExchange bool,
+) (*Conversation, []byte, error) {
+	if mechanism == PLAIN {
+		c, err := a.startPlain(ctx, db, payload)
+		return c, nil, err
+	}
+
+	m, ok := mechanisms[mechanism]
+	if !ok {
+		return nil, nil, common.NewErrorMessage(
+			common.ErrMechanismUnavailable, "Received authentication for mechanism %s which is not enabled", mechanism,
+		)
+	}
+
+	user, bare, err := parseClientFirst(string(payload))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = a.createUsersTable(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	credentials, err := a.hanaPool.UserCredentials(ctx, db, user)
+	if err != nil {
+		return nil, nil, lazyerrors.Error(err)
+	}
+
+	c := &Conversation{
+		mechanism:         m,
+		db:                db,
+		user:              user,
+		skipEmptyExchange: skipEmptyExchange,
+		clientFirstBare:   bare,
+	}
+
+	var found, hanaUser bool
+	for _, cred := range credentials {
+		switch cred.Mechanism {
+		case PLAIN:
+			hanaUser = true
+		case mechanism:
+			c.credentials = cred
+			found = true
+		}
+	}
+
+	// SAP HANA users must run statements with their own privileges, which only PLAIN provides
+	if hanaUser {
+		a.l.Info("SAP HANA user must authenticate with PLAIN", zap.String("user", user), zap.String("db", db))
+		return nil, nil, errAuthenticationFailed
+	}
+
+	if !found {
+		a.l.Info("Authentication of unknown user", zap.String("user", user), zap.String("db", db))
+		return nil, nil, errAuthenticationFailed
+	}
+
+	// users created before they were marked as SAP HANA users are recognized by their roles
+	roles, err := a.hanaPool.SchemaRoles(ctx, user)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(roles) > 0 {
+		a.l.Info("User with roles must authenticate with PLAIN", zap.String("user", user), zap.String("db", db))
+		return nil, nil, errAuthenticationFailed
+	}
+
+	serverFirst, err := c.start()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c, []byte(serverFirst), nil
+}
@@ -0,0 +1,237 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/md5" //nolint:gosec // required by SCRAM-SHA-1 of MongoDB
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // required by SCRAM-SHA-1
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+)
+
+// SCRAM mechanisms supported by saslStart.
+const (
+	ScramSHA1   = "SCRAM-SHA-1"
+	ScramSHA256 = "SCRAM-SHA-256"
+)
+
+// mechanism describes a SCRAM mechanism. The iterations and salt lengths are the same as in MongoDB.
+type mechanism struct {
+	hash       func() hash.Hash
+	iterations int
+	saltLen    int
+}
+
+var mechanisms = map[string]mechanism{
+	ScramSHA1:   {hash: sha1.New, iterations: 10000, saltLen: 16},
+	ScramSHA256: {hash: sha256.New, iterations: 15000, saltLen: 28},
+}
+
+// newCredentials derives the SCRAM credentials of the mechanism from the password with a new random salt.
+func newCredentials(mechanism, user, password string) (hana.Credentials, error) {
+	m := mechanisms[mechanism]
+
+	salt := make([]byte, m.saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return hana.Credentials{}, lazyerrors.Error(err)
+	}
+
+	// MongoDB uses a digest of user and password for SCRAM-SHA-1 for compatibility with MONGODB-CR
+	if mechanism == ScramSHA1 {
+		digest := md5.Sum([]byte(user + ":mongo:" + password)) //nolint:gosec // see above
+		password = hex.EncodeToString(digest[:])
+	}
+
+	saltedPassword := pbkdf2(m.hash, []byte(password), salt, m.iterations)
+	clientKey := computeHMAC(m.hash, saltedPassword, "Client Key")
+
+	return hana.Credentials{
+		Mechanism:  mechanism,
+		Salt:       salt,
+		Iterations: m.iterations,
+		StoredKey:  computeHash(m.hash, clientKey),
+		ServerKey:  computeHMAC(m.hash, saltedPassword, "Server Key"),
+	}, nil
+}
+
+// Conversation is the server side of a SCRAM authentication started by saslStart and continued by saslContinue,
+// or of a PLAIN authentication which is done with saslStart.
+type Conversation struct {
+	mechanism         mechanism
+	credentials       hana.Credentials
+	db                string
+	user              string
+	skipEmptyExchange bool
+	hanaPool          *hana.Hpool // of the SAP HANA user authenticated with PLAIN
+
+	nonce           string
+	clientFirstBare string
+	serverFirst     string
+	verified        bool
+	done            bool
+}
+
+// User returns the name of the user and the database the user was created in.
+func (c *Conversation) User() (user, db string) {
+	return c.user, c.db
+}
+
+// HanaPool returns the pool of the SAP HANA user authenticated with PLAIN
+// or nil if the user is stored by SAP HANA compatibility layer for MongoDB Wire Protocol.
+func (c *Conversation) HanaPool() *hana.Hpool {
+	return c.hanaPool
+}
+
+// Done returns true if the client has been authenticated.
+func (c *Conversation) Done() bool {
+	return c.done
+}
+
+// start handles the client-first message n,,n=user,r=nonce and returns the server-first message.
+func (c *Conversation) start() (string, error) {
+	clientNonce, err := scramAttribute(c.clientFirstBare, "r")
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, 24)
+	if _, err = rand.Read(nonce); err != nil {
+		return "", lazyerrors.Error(err)
+	}
+
+	c.nonce = clientNonce + base64.StdEncoding.EncodeToString(nonce)
+	c.serverFirst = fmt.Sprintf(
+		"r=%s,s=%s,i=%d", c.nonce, base64.StdEncoding.EncodeToString(c.credentials.Salt), c.credentials.Iterations,
+	)
+
+	return c.serverFirst, nil
+}
+
+// Continue handles the next message of the client and returns the reply.
+// The client-final message c=biws,r=nonce,p=proof is answered with the server signature.
+// Unless skipEmptyExchange was requested, the client then sends an empty message which completes the conversation.
+func (c *Conversation) Continue(payload []byte) ([]byte, error) {
+	if c.done {
+		return nil, common.NewErrorMessage(common.ErrAuthenticationFailed, "The authentication conversation is already done")
+	}
+
+	if c.verified {
+		c.done = true
+		return nil, nil
+	}
+
+	clientFinal := string(payload)
+	proofAttr := strings.LastIndex(clientFinal, ",p=")
+	if proofAttr < 0 {
+		return nil, errAuthenticationFailed
+	}
+
+	clientFinalWithoutProof := clientFinal[:proofAttr]
+	proof, err := base64.StdEncoding.DecodeString(clientFinal[proofAttr+len(",p="):])
+	if err != nil {
+		return nil, errAuthenticationFailed
+	}
+
+	if nonce, err := scramAttribute(clientFinalWithoutProof, "r"); err != nil || nonce != c.nonce {
+		return nil, errAuthenticationFailed
+	}
+
+	authMessage := c.clientFirstBare + "," + c.serverFirst + "," + clientFinalWithoutProof
+	clientSignature := computeHMAC(c.mechanism.hash, c.credentials.StoredKey, authMessage)
+	if len(proof) != len(clientSignature) {
+		return nil, errAuthenticationFailed
+	}
+
+	clientKey := make([]byte, len(proof))
+	for i := range proof {
+		clientKey[i] = proof[i] ^ clientSignature[i]
+	}
+
+	if subtle.ConstantTimeCompare(computeHash(c.mechanism.hash, clientKey), c.credentials.StoredKey) != 1 {
+		return nil, errAuthenticationFailed
+	}
+
+	c.verified = true
+	c.done = c.skipEmptyExchange
+
+	serverSignature := computeHMAC(c.mechanism.hash, c.credentials.ServerKey, authMessage)
+
+	return []byte("v=" + base64.StdEncoding.EncodeToString(serverSignature)), nil
+}
+
+// parseClientFirst returns the user name and the client-first message without the GS2 header.
+// Channel binding and authorization identities are not supported.
+func parseClientFirst(clientFirst string) (user, bare string, err error) {
+	parts := strings.SplitN(clientFirst, ",", 3)
+	if len(parts) != 3 || (parts[0] != "n" && parts[0] != "y") || parts[1] != "" {
+		return "", "", common.NewErrorMessage(common.ErrBadValue, "Invalid SCRAM client-first message")
+	}
+
+	bare = parts[2]
+	if user, err = scramAttribute(bare, "n"); err != nil {
+		return "", "", err
+	}
+
+	user = strings.NewReplacer("=2C", ",", "=3D", "=").Replace(user)
+
+	return user, bare, nil
+}
+
+// scramAttribute returns the value of the attribute of a SCRAM message.
+func scramAttribute(message, name string) (string, error) {
+	for _, attr := range strings.Split(message, ",") {
+		if strings.HasPrefix(attr, name+"=") {
+			return attr[len(name)+1:], nil
+		}
+	}
+
+	return "", common.NewErrorMessage(common.ErrBadValue, "SCRAM message is missing the attribute %q", name)
+}
+
+// computeHMAC returns the HMAC of the message with the given key.
+func computeHMAC(h func() hash.Hash, key []byte, message string) []byte {
+	mac := hmac.New(h, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// computeHash returns the hash of b.
+func computeHash(h func() hash.Hash, b []byte) []byte {
+	hash := h()
+	hash.Write(b)
+	return hash.Sum(nil)
+}
+
+// pbkdf2 derives a key from the password as defined by RFC 8018. SCRAM uses a key length of one hash block.
+func pbkdf2(h func() hash.Hash, password, salt []byte, iterations int) []byte {
+	mac := hmac.New(h, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+
+	res := make([]byte, len(u))
+	copy(res, u)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range res {
+			res[j] ^= u[j]
+		}
+	}
+
+	return res
+}
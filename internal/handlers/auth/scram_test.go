@@ -0,0 +1,242 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package auth
+
+import (
+	"crypto/md5"  //nolint:gosec // required by SCRAM-SHA-1 of MongoDB
+	"crypto/sha1" //nolint:gosec // required by SCRAM-SHA-1
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/testutil"
+)
+
+// QueryMatcherEqualBytes looks if all bytes of actual SQL string is in the expected SQL string.
+var QueryMatcherEqualBytes sqlmock.QueryMatcher = sqlmock.QueryMatcherFunc(func(expectedSQL, actualSQL string) error {
+	expectedBytes := []byte(expectedSQL)
+	actualBytes := []byte(actualSQL)
+
+	for i, a := range actualBytes {
+		if i >= len(expectedBytes) {
+			return nil
+		}
+
+		if expectedBytes[i] != a {
+			return fmt.Errorf(`could not match actual sql: "%s" with expected regexp "%s"`, actualSQL, expectedSQL)
+		}
+	}
+
+	return nil
+})
+
+// clientFinal returns the client-final message of a SCRAM client for the server-first message
+// and the server signature the client expects in return.
+func clientFinal(t *testing.T, mechanism, user, password, clientFirstBare, serverFirst string) (string, string) {
+	t.Helper()
+
+	m := mechanisms[mechanism]
+
+	nonce, err := scramAttribute(serverFirst, "r")
+	require.NoError(t, err)
+	s, err := scramAttribute(serverFirst, "s")
+	require.NoError(t, err)
+	salt, err := base64.StdEncoding.DecodeString(s)
+	require.NoError(t, err)
+	i, err := scramAttribute(serverFirst, "i")
+	require.NoError(t, err)
+	iterations, err := strconv.Atoi(i)
+	require.NoError(t, err)
+
+	if mechanism == ScramSHA1 {
+		digest := md5.Sum([]byte(user + ":mongo:" + password)) //nolint:gosec // see above
+		password = hex.EncodeToString(digest[:])
+	}
+
+	saltedPassword := pbkdf2(m.hash, []byte(password), salt, iterations)
+	clientKey := computeHMAC(m.hash, saltedPassword, "Client Key")
+	withoutProof := "c=biws,r=" + nonce
+	authMessage := clientFirstBare + "," + serverFirst + "," + withoutProof
+
+	proof := computeHMAC(m.hash, computeHash(m.hash, clientKey), authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+
+	serverSignature := computeHMAC(m.hash, computeHMAC(m.hash, saltedPassword, "Server Key"), authMessage)
+
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof),
+		"v=" + base64.StdEncoding.EncodeToString(serverSignature)
+}
+
+func TestPBKDF2(t *testing.T) {
+	t.Parallel()
+
+	// test vectors of RFC 6070
+	for iterations, expected := range map[int]string{
+		1:    "0c60c80f961f0e71f3a9b524af6012062fe037a6",
+		2:    "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957",
+		4096: "4b007901b765489abead49d926f721d065a429c1",
+	} {
+		actual := pbkdf2(sha1.New, []byte("password"), []byte("salt"), iterations)
+		assert.Equal(t, expected, hex.EncodeToString(actual), "iterations: %d", iterations)
+	}
+}
+
+func TestConversation(t *testing.T) {
+	t.Parallel()
+
+	for _, mechanism := range []string{ScramSHA1, ScramSHA256} {
+		mechanism := mechanism
+		t.Run(mechanism, func(t *testing.T) {
+			t.Parallel()
+
+			credentials, err := newCredentials(mechanism, "user,1", "pencil")
+			require.NoError(t, err)
+
+			start := func(skipEmptyExchange bool) (*Conversation, string) {
+				user, bare, err := parseClientFirst("n,,n=user=2C1,r=rOprNGfwEbeRWgbNEkqO")
+				require.NoError(t, err)
+				assert.Equal(t, "user,1", user)
+
+				c := &Conversation{
+					mechanism:         mechanisms[mechanism],
+					credentials:       credentials,
+					db:                "admin",
+					user:              user,
+					skipEmptyExchange: skipEmptyExchange,
+					clientFirstBare:   bare,
+				}
+				serverFirst, err := c.start()
+				require.NoError(t, err)
+
+				return c, serverFirst
+			}
+
+			t.Run("EmptyExchange", func(t *testing.T) {
+				c, serverFirst := start(false)
+				final, signature := clientFinal(t, mechanism, "user,1", "pencil", "n=user=2C1,r=rOprNGfwEbeRWgbNEkqO", serverFirst)
+
+				reply, err := c.Continue([]byte(final))
+				require.NoError(t, err)
+				assert.Equal(t, signature, string(reply))
+				assert.False(t, c.Done())
+
+				reply, err = c.Continue(nil)
+				require.NoError(t, err)
+				assert.Empty(t, reply)
+				assert.True(t, c.Done())
+
+				user, db := c.User()
+				assert.Equal(t, "user,1", user)
+				assert.Equal(t, "admin", db)
+			})
+
+			t.Run("SkipEmptyExchange", func(t *testing.T) {
+				c, serverFirst := start(true)
+				final, _ := clientFinal(t, mechanism, "user,1", "pencil", "n=user=2C1,r=rOprNGfwEbeRWgbNEkqO", serverFirst)
+
+				_, err := c.Continue([]byte(final))
+				require.NoError(t, err)
+				assert.True(t, c.Done())
+			})
+
+			t.Run("WrongPassword", func(t *testing.T) {
+				c, serverFirst := start(false)
+				final, _ := clientFinal(t, mechanism, "user,1", "pen", "n=user=2C1,r=rOprNGfwEbeRWgbNEkqO", serverFirst)
+
+				_, err := c.Continue([]byte(final))
+				assert.Equal(t, errAuthenticationFailed, err)
+				assert.False(t, c.Done())
+			})
+		})
+	}
+}
+
+func TestStartConversation(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(QueryMatcherEqualBytes))
+	require.NoError(t, err)
+	defer db.Close()
+
+	a := NewAuthenticator(&NewAuthenticatorOpts{HanaPool: &hana.Hpool{DB: db}, Logger: zaptest.NewLogger(t)})
+	ctx := testutil.Ctx(t)
+
+	credentials, err := newCredentials(ScramSHA256, "user", "pencil")
+	require.NoError(t, err)
+
+	selectCredentials := "SELECT MECHANISM, SALT, ITERATIONS, STORED_KEY, SERVER_KEY FROM MONGODB_USERS WHERE DB = $1 AND USER_NAME = $2"
+
+	t.Run("UnknownMechanism", func(t *testing.T) {
+		_, _, err := a.StartConversation(ctx, "admin", "GSSAPI", []byte("n,,n=user,r=nonce"), false)
+		expected := common.NewErrorMessage(common.ErrMechanismUnavailable, "Received authentication for mechanism GSSAPI which is not enabled")
+		assert.Equal(t, expected, err)
+	})
+
+	t.Run("UnknownUser", func(t *testing.T) {
+		mock.ExpectQuery("SELECT COUNT(*) FROM SYS.TABLES WHERE SCHEMA_NAME = CURRENT_SCHEMA").
+			WillReturnRows(sqlmock.NewRows([]string{"COUNT"}).AddRow(1))
+		mock.ExpectQuery(selectCredentials).WithArgs("admin", "unknown").
+			WillReturnRows(sqlmock.NewRows([]string{"MECHANISM", "SALT", "ITERATIONS", "STORED_KEY", "SERVER_KEY"}))
+
+		_, _, err := a.StartConversation(ctx, "admin", ScramSHA256, []byte("n,,n=unknown,r=nonce"), false)
+		assert.Equal(t, errAuthenticationFailed, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("HanaUser", func(t *testing.T) {
+		mock.ExpectQuery(selectCredentials).WithArgs("test", "user").
+			WillReturnRows(sqlmock.NewRows([]string{"MECHANISM", "SALT", "ITERATIONS", "STORED_KEY", "SERVER_KEY"}).
+				AddRow(PLAIN, []byte{}, 0, []byte{}, []byte{}))
+
+		_, _, err := a.StartConversation(ctx, "test", ScramSHA256, []byte("n,,n=user,r=nonce"), false)
+		assert.Equal(t, errAuthenticationFailed, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("UserWithRoles", func(t *testing.T) {
+		mock.ExpectQuery(selectCredentials).WithArgs("test", "user").
+			WillReturnRows(
+				sqlmock.NewRows([]string{"MECHANISM", "SALT", "ITERATIONS", "STORED_KEY", "SERVER_KEY"}).
+					AddRow(credentials.Mechanism, credentials.Salt, credentials.Iterations, credentials.StoredKey, credentials.ServerKey),
+			)
+		mock.ExpectQuery("SELECT ROLE_NAME FROM SYS.GRANTED_ROLES WHERE GRANTEE = $1").WithArgs("user").
+			WillReturnRows(sqlmock.NewRows([]string{"ROLE_NAME"}).AddRow("MONGODB_read_test"))
+
+		_, _, err := a.StartConversation(ctx, "test", ScramSHA256, []byte("n,,n=user,r=nonce"), false)
+		assert.Equal(t, errAuthenticationFailed, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("KnownUser", func(t *testing.T) {
+		mock.ExpectQuery(selectCredentials).WithArgs("admin", "user").
+			WillReturnRows(
+				sqlmock.NewRows([]string{"MECHANISM", "SALT", "ITERATIONS", "STORED_KEY", "SERVER_KEY"}).
+					AddRow(credentials.Mechanism, credentials.Salt, credentials.Iterations, credentials.StoredKey, credentials.ServerKey),
+			)
+		mock.ExpectQuery("SELECT ROLE_NAME FROM SYS.GRANTED_ROLES WHERE GRANTEE = $1").WithArgs("user").
+			WillReturnRows(sqlmock.NewRows([]string{"ROLE_NAME"}))
+
+		c, serverFirst, err := a.StartConversation(ctx, "admin", ScramSHA256, []byte("n,,n=user,r=nonce"), true)
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+
+		final, signature := clientFinal(t, ScramSHA256, "user", "pencil", "n=user,r=nonce", string(serverFirst))
+		reply, err := c.Continue([]byte(final))
+		require.NoError(t, err)
+		assert.Equal(t, signature, string(reply))
+		assert.True(t, c.Done())
+	})
+}
@@ -0,0 +1,277 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package auth
+
+import (
+	"context"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+)
+
+// User is a user with the built-in roles granted to it.
+type User struct {
+	hana.User
+	Roles []hana.SchemaRole
+}
+
+// plainCredentials mark a user as SAP HANA user created by CreateUser.
+// It authenticates with PLAIN, SAP HANA verifies its password.
+var plainCredentials = hana.Credentials{Mechanism: PLAIN, Salt: []byte{}, StoredKey: []byte{}, ServerKey: []byte{}}
+
+// CreateUser creates the user of the database as SAP HANA user with the password and the roles.
+// It authenticates with PLAIN, so that its statements run with the privileges of its roles.
+func (a *Authenticator) CreateUser(
+	ctx context.Context, clientPool *hana.Hpool, db, user, password string, roles []hana.SchemaRole,
+) error {
+	exists, err := a.userExists(ctx, db, user)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return common.NewErrorMessage(common.ErrUserAlreadyExists, "User \"%s@%s\" already exists", user, db)
+	}
+
+	if err = clientPool.CreateHanaUser(ctx, user, password); err != nil {
+		return err
+	}
+
+	for _, r := range roles {
+		if err = clientPool.GrantSchemaRole(ctx, user, r); err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		if err = a.hanaPool.SetUserCredentials(ctx, db, user, []hana.Credentials{plainCredentials}); err != nil {
+			err = lazyerrors.Error(err)
+		}
+	}
+
+	if err != nil {
+		// DDL statements are committed at once, so the user is dropped again
+		_ = clientPool.DropHanaUser(ctx, user)
+		return err
+	}
+
+	return nil
+}
+
+// DropUser drops the user of the database. The SAP HANA user is only dropped if it was created by CreateUser.
+func (a *Authenticator) DropUser(ctx context.Context, clientPool *hana.Hpool, db, user string) error {
+	hanaUser, err := a.isHanaUser(ctx, db, user)
+	if err != nil {
+		return err
+	}
+
+	if hanaUser {
+		// the SAP HANA user may have been dropped already
+		exists, err := clientPool.HanaUserExists(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			if err = clientPool.DropHanaUser(ctx, user); err != nil {
+				return err
+			}
+		}
+
+		a.closeUserPools(user)
+	}
+
+	deleted, err := a.hanaPool.DeleteUserCredentials(ctx, db, user)
+	if err != nil {
+		return lazyerrors.Error(err)
+	}
+	if !deleted {
+		return userNotFound(db, user)
+	}
+
+	return nil
+}
+
+// UpdateUser changes the password of the user of the database if password is not empty
+// and replaces the roles of the user if roles is not nil.
+func (a *Authenticator) UpdateUser(
+	ctx context.Context, clientPool *hana.Hpool, db, user, password string, roles []hana.SchemaRole,
+) error {
+	hanaUser, err := a.isHanaUser(ctx, db, user)
+	if err != nil {
+		return err
+	}
+
+	if password != "" {
+		// users created with -admin-user are no SAP HANA users and authenticate with SCRAM
+		if hanaUser {
+			if err = clientPool.SetHanaUserPassword(ctx, user, password); err == nil {
+				// the roles are still replaced with the pool of the client, which may be one of the user
+				defer a.closeUserPools(user)
+			}
+		} else {
+			err = a.SetPassword(ctx, db, user, password)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if roles == nil {
+		return nil
+	}
+
+	if !hanaUser {
+		return noHanaUser(db, user)
+	}
+
+	granted, err := clientPool.SchemaRoles(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	if err = a.RevokeRoles(ctx, clientPool, db, user, granted); err != nil {
+		return err
+	}
+
+	return a.GrantRoles(ctx, clientPool, db, user, roles)
+}
+
+// GrantRoles grants the roles to the user of the database.
+func (a *Authenticator) GrantRoles(ctx context.Context, clientPool *hana.Hpool, db, user string, roles []hana.SchemaRole) error {
+	if err := a.checkHanaUser(ctx, db, user); err != nil {
+		return err
+	}
+
+	for _, r := range roles {
+		if err := clientPool.GrantSchemaRole(ctx, user, r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RevokeRoles revokes the roles from the user of the database. Roles which are not granted are skipped.
+func (a *Authenticator) RevokeRoles(ctx context.Context, clientPool *hana.Hpool, db, user string, roles []hana.SchemaRole) error {
+	if err := a.checkHanaUser(ctx, db, user); err != nil {
+		return err
+	}
+
+	granted, err := clientPool.SchemaRoles(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range roles {
+		for _, g := range granted {
+			if r != g {
+				continue
+			}
+
+			if err = clientPool.RevokeSchemaRole(ctx, user, r); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Users returns the users of the database with their roles, or the users of all databases if db is empty.
+func (a *Authenticator) Users(ctx context.Context, db string) ([]User, error) {
+	if err := a.createUsersTable(ctx); err != nil {
+		return nil, err
+	}
+
+	users, err := a.hanaPool.Users(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]User, len(users))
+	for i, u := range users {
+		res[i].User = u
+
+		// only SAP HANA users created by CreateUser have roles
+		if !slices.Contains(u.Mechanisms, PLAIN) {
+			continue
+		}
+
+		if res[i].Roles, err = a.hanaPool.SchemaRoles(ctx, u.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// userExists returns true if the user of the database exists.
+func (a *Authenticator) userExists(ctx context.Context, db, user string) (bool, error) {
+	if err := a.createUsersTable(ctx); err != nil {
+		return false, err
+	}
+
+	credentials, err := a.hanaPool.UserCredentials(ctx, db, user)
+	if err != nil {
+		return false, lazyerrors.Error(err)
+	}
+
+	return len(credentials) > 0, nil
+}
+
+// isHanaUser returns true if the user of the database is a SAP HANA user created by CreateUser.
+// It returns a UserNotFound error if the user does not exist.
+func (a *Authenticator) isHanaUser(ctx context.Context, db, user string) (bool, error) {
+	if err := a.createUsersTable(ctx); err != nil {
+		return false, err
+	}
+
+	credentials, err := a.hanaPool.UserCredentials(ctx, db, user)
+	if err != nil {
+		return false, lazyerrors.Error(err)
+	}
+	if len(credentials) == 0 {
+		return false, userNotFound(db, user)
+	}
+
+	return hasPlainCredentials(credentials), nil
+}
+
+// hasPlainCredentials returns true if the credentials mark a SAP HANA user created by CreateUser.
+func hasPlainCredentials(credentials []hana.Credentials) bool {
+	for _, c := range credentials {
+		if c.Mechanism == PLAIN {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkHanaUser returns an error if the user of the database does not exist or is no SAP HANA user created by CreateUser.
+func (a *Authenticator) checkHanaUser(ctx context.Context, db, user string) error {
+	hanaUser, err := a.isHanaUser(ctx, db, user)
+	if err != nil {
+		return err
+	}
+	if !hanaUser {
+		return noHanaUser(db, user)
+	}
+
+	return nil
+}
+
+// userNotFound returns the error for a user of the database which does not exist.
+func userNotFound(db, user string) error {
+	return common.NewErrorMessage(common.ErrUserNotFound, "Could not find user \"%s\" for db \"%s\"", user, db)
+}
+
+// noHanaUser returns the error for roles of a user of the database which is no SAP HANA user.
+func noHanaUser(db, user string) error {
+	return common.NewErrorMessage(common.ErrBadValue, "User \"%s@%s\" is no SAP HANA user and cannot have roles", user, db)
+}
@@ -0,0 +1,145 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package auth
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/testutil"
+)
+
+func TestUsers(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(QueryMatcherEqualBytes))
+	require.NoError(t, err)
+	defer db.Close()
+
+	hanaPool := &hana.Hpool{DB: db}
+	a := NewAuthenticator(&NewAuthenticatorOpts{HanaPool: hanaPool, Logger: zaptest.NewLogger(t)})
+	a.tableCreated = true
+	ctx := testutil.Ctx(t)
+
+	selectCredentials := "SELECT MECHANISM, SALT, ITERATIONS, STORED_KEY, SERVER_KEY FROM MONGODB_USERS WHERE DB = $1 AND USER_NAME = $2"
+	credentialsColumns := []string{"MECHANISM", "SALT", "ITERATIONS", "STORED_KEY", "SERVER_KEY"}
+
+	t.Run("CreateExistingUser", func(t *testing.T) {
+		mock.ExpectQuery(selectCredentials).WithArgs("test", "user").
+			WillReturnRows(sqlmock.NewRows(credentialsColumns).AddRow(ScramSHA256, []byte("salt"), 15000, []byte("stored"), []byte("server")))
+
+		err := a.CreateUser(ctx, hanaPool, "test", "user", "pencil", nil)
+		assert.Equal(t, common.NewErrorMessage(common.ErrUserAlreadyExists, `User "user@test" already exists`), err)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("CreateUser", func(t *testing.T) {
+		mock.ExpectQuery(selectCredentials).WithArgs("test", "user").
+			WillReturnRows(sqlmock.NewRows(credentialsColumns))
+		mock.ExpectExec(`CREATE USER "user" PASSWORD`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE FROM MONGODB_USERS WHERE DB = $1 AND USER_NAME = $2").WithArgs("test", "user").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO MONGODB_USERS").WithArgs("test", "user", PLAIN, []byte{}, 0, []byte{}, []byte{}).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		require.NoError(t, a.CreateUser(ctx, hanaPool, "test", "user", "pencil", nil))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("UpdateHanaUserPassword", func(t *testing.T) {
+		mock.ExpectQuery(selectCredentials).WithArgs("test", "user").
+			WillReturnRows(sqlmock.NewRows(credentialsColumns).AddRow(PLAIN, []byte{}, 0, []byte{}, []byte{}))
+		mock.ExpectExec(`ALTER USER "user" PASSWORD`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		require.NoError(t, a.UpdateUser(ctx, hanaPool, "test", "user", "pencil2", nil))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("DropUnknownUser", func(t *testing.T) {
+		mock.ExpectQuery(selectCredentials).WithArgs("test", "unknown").
+			WillReturnRows(sqlmock.NewRows(credentialsColumns))
+
+		err := a.DropUser(ctx, hanaPool, "test", "unknown")
+		assert.Equal(t, common.NewErrorMessage(common.ErrUserNotFound, `Could not find user "unknown" for db "test"`), err)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("DropHanaUser", func(t *testing.T) {
+		mock.ExpectQuery(selectCredentials).WithArgs("test", "user").
+			WillReturnRows(sqlmock.NewRows(credentialsColumns).AddRow(PLAIN, []byte{}, 0, []byte{}, []byte{}))
+		mock.ExpectQuery("SELECT COUNT(*) FROM SYS.USERS WHERE USER_NAME = $1").WithArgs("user").
+			WillReturnRows(sqlmock.NewRows([]string{"COUNT"}).AddRow(1))
+		mock.ExpectExec(`DROP USER "user"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("DELETE FROM MONGODB_USERS WHERE DB = $1 AND USER_NAME = $2").WithArgs("test", "user").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		require.NoError(t, a.DropUser(ctx, hanaPool, "test", "user"))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("DropScramUser", func(t *testing.T) {
+		// a SAP HANA user of the same name is not dropped
+		mock.ExpectQuery(selectCredentials).WithArgs("admin", "user").
+			WillReturnRows(sqlmock.NewRows(credentialsColumns).AddRow(ScramSHA256, []byte("salt"), 15000, []byte("stored"), []byte("server")))
+		mock.ExpectExec("DELETE FROM MONGODB_USERS WHERE DB = $1 AND USER_NAME = $2").WithArgs("admin", "user").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+
+		require.NoError(t, a.DropUser(ctx, hanaPool, "admin", "user"))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("GrantRolesToScramUser", func(t *testing.T) {
+		mock.ExpectQuery(selectCredentials).WithArgs("admin", "user").
+			WillReturnRows(sqlmock.NewRows(credentialsColumns).AddRow(ScramSHA256, []byte("salt"), 15000, []byte("stored"), []byte("server")))
+
+		err := a.GrantRoles(ctx, hanaPool, "admin", "user", []hana.SchemaRole{{Role: "read", DB: "test"}})
+		expected := common.NewErrorMessage(common.ErrBadValue, `User "user@admin" is no SAP HANA user and cannot have roles`)
+		assert.Equal(t, expected, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("RevokeRoles", func(t *testing.T) {
+		mock.ExpectQuery(selectCredentials).WithArgs("test", "user").
+			WillReturnRows(sqlmock.NewRows(credentialsColumns).AddRow(PLAIN, []byte{}, 0, []byte{}, []byte{}))
+		mock.ExpectQuery("SELECT ROLE_NAME FROM SYS.GRANTED_ROLES WHERE GRANTEE = $1").WithArgs("user").
+			WillReturnRows(sqlmock.NewRows([]string{"ROLE_NAME"}).AddRow("MONGODB_read_test"))
+		mock.ExpectExec(`REVOKE "MONGODB_read_test" FROM "user"`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		// readWrite is not granted and skipped
+		roles := []hana.SchemaRole{{Role: "read", DB: "test"}, {Role: "readWrite", DB: "test"}}
+		require.NoError(t, a.RevokeRoles(ctx, hanaPool, "test", "user", roles))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Users", func(t *testing.T) {
+		mock.ExpectQuery("SELECT DB, USER_NAME, MECHANISM FROM MONGODB_USERS WHERE DB = $1 ORDER BY DB, USER_NAME, MECHANISM").
+			WithArgs("test").
+			WillReturnRows(sqlmock.NewRows([]string{"DB", "USER_NAME", "MECHANISM"}).
+				AddRow("test", "admin", ScramSHA1).
+				AddRow("test", "admin", ScramSHA256).
+				AddRow("test", "user", PLAIN))
+		mock.ExpectQuery("SELECT ROLE_NAME FROM SYS.GRANTED_ROLES WHERE GRANTEE = $1").WithArgs("user").
+			WillReturnRows(sqlmock.NewRows([]string{"ROLE_NAME"}).AddRow("MONGODB_readWrite_test"))
+
+		actual, err := a.Users(ctx, "test")
+		require.NoError(t, err)
+		expected := []User{{
+			User: hana.User{DB: "test", Name: "admin", Mechanisms: []string{ScramSHA1, ScramSHA256}},
+		}, {
+			User:  hana.User{DB: "test", Name: "user", Mechanisms: []string{PLAIN}},
+			Roles: []hana.SchemaRole{{Role: "readWrite", DB: "test"}},
+		}}
+		assert.Equal(t, expected, actual)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
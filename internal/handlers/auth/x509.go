@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+
+	"go.uber.org/zap"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+)
+
+// X509 is the mechanism with which clients authenticate with the subject of their client certificate.
+const X509 = "MONGODB-X509"
+
+// CreateX509User creates the user of the database $external which is authenticated by a client certificate
+// with the user as subject. It has no password and is no SAP HANA user.
+func (a *Authenticator) CreateX509User(ctx context.Context, user string) error {
+	exists, err := a.userExists(ctx, externalDB, user)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return common.NewErrorMessage(common.ErrUserAlreadyExists, "User \"%s@%s\" already exists", user, externalDB)
+	}
+
+	credentials := []hana.Credentials{{Mechanism: X509, Salt: []byte{}, StoredKey: []byte{}, ServerKey: []byte{}}}
+	if err = a.hanaPool.SetUserCredentials(ctx, externalDB, user, credentials); err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	return nil
+}
+
+// AuthenticateX509 authenticates a client of the database $external as the user which is the subject of its
+// verified client certificate. If user is not empty, it must be the subject. The conversation is done at once.
+func (a *Authenticator) AuthenticateX509(ctx context.Context, db, user string, cert *x509.Certificate) (*Conversation, error) {
+	if db != externalDB {
+		return nil, common.NewErrorMessage(
+			common.ErrBadValue, "X.509 authentication must always use the database %s", externalDB,
+		)
+	}
+
+	if cert == nil {
+		return nil, common.NewErrorMessage(common.ErrAuthenticationFailed, "No verified subject name available from client")
+	}
+
+	subject := x509Subject(cert)
+	if user != "" && user != subject {
+		return nil, common.NewErrorMessage(
+			common.ErrAuthenticationFailed, "There is no x.509 client certificate matching the user.",
+		)
+	}
+
+	if err := a.createUsersTable(ctx); err != nil {
+		return nil, err
+	}
+
+	credentials, err := a.hanaPool.UserCredentials(ctx, externalDB, subject)
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	for _, c := range credentials {
+		if c.Mechanism == X509 {
+			return &Conversation{db: externalDB, user: subject, done: true}, nil
+		}
+	}
+
+	a.l.Info("Authentication of unknown X.509 user", zap.String("user", subject))
+	return nil, errAuthenticationFailed
+}
+
+// x509Subject returns the subject of the certificate in the RFC 2253 format used as user name, e.g. "CN=client,OU=dev,O=SAP".
+func x509Subject(cert *x509.Certificate) string {
+	return cert.Subject.String()
+}
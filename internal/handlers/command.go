@@ -47,12 +47,12 @@ var commands = map[string]command{
 	},
 	"usersinfo": {
 		name:    "usersinfo",
-		help:    "Returns user USERNAME. Is used as a workaround to allow use of some GUIs",
+		help:    "Returns information about users.",
 		handler: (*Handler).MsgUsersInfo,
 	},
 	"rolesinfo": {
 		name:    "rolesinfo",
-		help:    "Return role readWrite. Is used as a workaround to allow use of some GUIs",
+		help:    "Returns information about the built-in roles read, readWrite and dbAdmin.",
 		handler: (*Handler).MsgRolesInfo,
 	},
 	"getlasterror": {
@@ -73,11 +73,12 @@ var commands = map[string]command{
 	// 	help:    "Storage data for a collection. Still needs to be implemented",
 	// 	handler: (*Handler).MsgCollStats,
 	// },
-	// "createindexes": {
-	// 	name:           "createIndexes",
-	// 	help:           "Creates indexes on a collection. Still needs to be implemented.",
-	// 	storageHandler: (common.Storage).MsgCreateIndexes,
-	// },
+	"createindexes": {
+		// db.collection.createIndex() or db.collection.createIndexes()
+		name:           "createIndexes",
+		help:           "Creates indexes on a collection.",
+		storageHandler: (common.Storage).MsgCreateIndexes,
+	},
 	"create": {
 		// db.createCollection()
 		name:    "create",
@@ -174,30 +175,141 @@ var commands = map[string]command{
 		handler: (*Handler).MsgWhatsMyURI,
 	},
 	"authenticate": {
-		// So far only used for the authenticate required by MongoDB drivers when using tls
-		// At the moment it just sends ok back to MongoDB
+		// Authenticates clients with MONGODB-X509.
+		// Without authentication it just sends ok back to MongoDB, as required by MongoDB drivers when using tls
 		name:    "authenticate",
 		help:    "a method for authentication",
 		handler: (*Handler).MsgAuthenticate,
 	},
+	"saslstart": {
+		name:    "saslStart",
+		help:    "Starts a SCRAM-SHA-256 or SCRAM-SHA-1 authentication.",
+		handler: (*Handler).MsgSaslStart,
+	},
+	"saslcontinue": {
+		name:    "saslContinue",
+		help:    "Continues a SCRAM-SHA-256 or SCRAM-SHA-1 authentication.",
+		handler: (*Handler).MsgSaslContinue,
+	},
+	"createuser": {
+		name:    "createUser",
+		help:    "Creates a user as SAP HANA user with the given password and roles.",
+		handler: (*Handler).MsgCreateUser,
+	},
+	"dropuser": {
+		name:    "dropUser",
+		help:    "Drops the user.",
+		handler: (*Handler).MsgDropUser,
+	},
+	"updateuser": {
+		name:    "updateUser",
+		help:    "Changes the password or replaces the roles of the user.",
+		handler: (*Handler).MsgUpdateUser,
+	},
+	"grantrolestouser": {
+		name:    "grantRolesToUser",
+		help:    "Grants the roles to the user.",
+		handler: (*Handler).MsgGrantRolesToUser,
+	},
+	"revokerolesfromuser": {
+		name:    "revokeRolesFromUser",
+		help:    "Revokes the roles from the user.",
+		handler: (*Handler).MsgRevokeRolesFromUser,
+	},
 	// "serverstatus": {
 	// 	// db.serverStatus()
 	// 	name:    "serverStatus",
 	// 	help:    "Returns an overview of the databases state.",
 	// 	handler: (*Handler).MsgServerStatus,
 	// },
+	"aggregate": {
+		// db.collection.aggregate()
+		name:           "aggregate",
+		help:           "Returns the result of an aggregation pipeline.",
+		storageHandler: (common.Storage).MsgAggregate,
+	},
 	"delete": {
 		// db.collection.deleteOne() or db.collection.deleteMany()
 		name:           "delete",
 		help:           "Deletes documents matched by the query.",
 		storageHandler: (common.Storage).MsgDelete,
 	},
+	"distinct": {
+		// db.collection.distinct()
+		name:           "distinct",
+		help:           "Returns the distinct values of a field.",
+		storageHandler: (common.Storage).MsgDistinct,
+	},
+	"dropindexes": {
+		// db.collection.dropIndex() or db.collection.dropIndexes()
+		name:           "dropIndexes",
+		help:           "Drops indexes of a collection.",
+		storageHandler: (common.Storage).MsgDropIndexes,
+	},
 	"find": {
 		// db.collection.find()
 		name:           "find",
 		help:           "Returns documents matched by the custom query.",
 		storageHandler: (common.Storage).MsgFindOrCount,
 	},
+	"findandmodify": {
+		// db.collection.findOneAndUpdate(), db.collection.findOneAndReplace() or db.collection.findOneAndDelete()
+		name:           "findAndModify",
+		help:           "Modifies and returns a single document.",
+		storageHandler: (common.Storage).MsgFindAndModify,
+	},
+	"getmore": {
+		// cursor.next() when the current batch is exhausted
+		name:           "getMore",
+		help:           "Returns the next batch of documents of a cursor.",
+		storageHandler: (common.Storage).MsgGetMore,
+	},
+	"killcursors": {
+		// cursor.close()
+		name:           "killCursors",
+		help:           "Closes the given cursors.",
+		storageHandler: (common.Storage).MsgKillCursors,
+	},
+	"committransaction": {
+		// session.commitTransaction()
+		name:           "commitTransaction",
+		help:           "Commits the transaction of a session.",
+		storageHandler: (common.Storage).MsgCommitTransaction,
+	},
+	"aborttransaction": {
+		// session.abortTransaction()
+		name:           "abortTransaction",
+		help:           "Aborts the transaction of a session.",
+		storageHandler: (common.Storage).MsgAbortTransaction,
+	},
+	"startsession": {
+		// db.getMongo().startSession()
+		name:           "startSession",
+		help:           "Starts a new logical session.",
+		storageHandler: (common.Storage).MsgStartSession,
+	},
+	"endsessions": {
+		// session.endSession()
+		name:           "endSessions",
+		help:           "Ends the given sessions.",
+		storageHandler: (common.Storage).MsgEndSessions,
+	},
+	"refreshsessions": {
+		name:           "refreshSessions",
+		help:           "Updates the last use time of the given sessions.",
+		storageHandler: (common.Storage).MsgRefreshSessions,
+	},
+	"killsessions": {
+		name:           "killSessions",
+		help:           "Kills the given sessions.",
+		storageHandler: (common.Storage).MsgKillSessions,
+	},
+	"listindexes": {
+		// db.collection.getIndexes()
+		name:           "listIndexes",
+		help:           "Returns a list of the indexes of a collection.",
+		storageHandler: (common.Storage).MsgListIndexes,
+	},
 	"count": {
 		// db.collection.find().count()
 		name:           "count",
@@ -87,6 +87,27 @@ func TestSupportedCommands(t *testing.T) {
 			"authenticate", types.MustMakeDocument(
 				"help", "a method for authentication",
 			),
+			"saslStart", types.MustMakeDocument(
+				"help", "Starts a SCRAM-SHA-256 or SCRAM-SHA-1 authentication.",
+			),
+			"saslContinue", types.MustMakeDocument(
+				"help", "Continues a SCRAM-SHA-256 or SCRAM-SHA-1 authentication.",
+			),
+			"createUser", types.MustMakeDocument(
+				"help", "Creates a user as SAP HANA user with the given password and roles.",
+			),
+			"dropUser", types.MustMakeDocument(
+				"help", "Drops the user.",
+			),
+			"updateUser", types.MustMakeDocument(
+				"help", "Changes the password or replaces the roles of the user.",
+			),
+			"grantRolesToUser", types.MustMakeDocument(
+				"help", "Grants the roles to the user.",
+			),
+			"revokeRolesFromUser", types.MustMakeDocument(
+				"help", "Revokes the roles from the user.",
+			),
 			"debug_error", types.MustMakeDocument(
 				"help", "Used for debugging purposes.",
 			),
@@ -102,15 +123,57 @@ func TestSupportedCommands(t *testing.T) {
 			"whatsmyuri", types.MustMakeDocument(
 				"help", "An internal command.",
 			),
+			"aggregate", types.MustMakeDocument(
+				"help", "Returns the result of an aggregation pipeline.",
+			),
 			"find", types.MustMakeDocument(
 				"help", "Returns documents matched by the custom query.",
 			),
+			"findAndModify", types.MustMakeDocument(
+				"help", "Modifies and returns a single document.",
+			),
+			"getMore", types.MustMakeDocument(
+				"help", "Returns the next batch of documents of a cursor.",
+			),
+			"killCursors", types.MustMakeDocument(
+				"help", "Closes the given cursors.",
+			),
+			"commitTransaction", types.MustMakeDocument(
+				"help", "Commits the transaction of a session.",
+			),
+			"abortTransaction", types.MustMakeDocument(
+				"help", "Aborts the transaction of a session.",
+			),
+			"startSession", types.MustMakeDocument(
+				"help", "Starts a new logical session.",
+			),
+			"endSessions", types.MustMakeDocument(
+				"help", "Ends the given sessions.",
+			),
+			"refreshSessions", types.MustMakeDocument(
+				"help", "Updates the last use time of the given sessions.",
+			),
+			"killSessions", types.MustMakeDocument(
+				"help", "Kills the given sessions.",
+			),
 			"count", types.MustMakeDocument(
 				"help", "Returns the count of documents that's matched by the query.",
 			),
 			"delete", types.MustMakeDocument(
 				"help", "Deletes documents matched by the query.",
 			),
+			"distinct", types.MustMakeDocument(
+				"help", "Returns the distinct values of a field.",
+			),
+			"createIndexes", types.MustMakeDocument(
+				"help", "Creates indexes on a collection.",
+			),
+			"listIndexes", types.MustMakeDocument(
+				"help", "Returns a list of the indexes of a collection.",
+			),
+			"dropIndexes", types.MustMakeDocument(
+				"help", "Drops indexes of a collection.",
+			),
 			"insert", types.MustMakeDocument(
 				"help", "Inserts documents into the database.",
 			),
@@ -124,10 +187,10 @@ func TestSupportedCommands(t *testing.T) {
 				"help", "Does not return last error. Is used as a workaround to allow use of some GUIs.",
 			),
 			"usersinfo", types.MustMakeDocument(
-				"help", "Returns user USERNAME. Is used as a workaround to allow use of some GUIs",
+				"help", "Returns information about users.",
 			),
 			"rolesinfo", types.MustMakeDocument(
-				"help", "Return role readWrite. Is used as a workaround to allow use of some GUIs",
+				"help", "Returns information about the built-in roles read, readWrite and dbAdmin.",
 			),
 			"connectionstatus", types.MustMakeDocument(
 				"help", "checks connection",
@@ -22,6 +22,7 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
 )
 
@@ -36,15 +37,39 @@ const (
 	// For ProtocolError only.
 	errInternalError = ErrorCode(1) // InternalError
 
-	ErrBadValue          = ErrorCode(2)     // BadValue
-	ErrNamespaceNotFound = ErrorCode(26)    // NamespaceNotFound
-	ErrNamespaceExists   = ErrorCode(48)    // NamespaceExists
-	ErrCommandNotFound   = ErrorCode(59)    // CommandNotFound
-	ErrNotImplemented    = ErrorCode(238)   // NotImplemented
-	ErrSortBadValue      = ErrorCode(15974) // SortBadValue
-	ErrProjectionInEx    = ErrorCode(31253) // Location31253
-	ErrProjectionExIn    = ErrorCode(31254) // Location31254
-	ErrRegexOptions      = ErrorCode(51075) // Location51075
+	ErrBadValue              = ErrorCode(2)     // BadValue
+	ErrFailedToParse         = ErrorCode(9)     // FailedToParse
+	ErrUserNotFound          = ErrorCode(11)    // UserNotFound
+	ErrUnauthorized          = ErrorCode(13)    // Unauthorized
+	ErrTypeMismatch          = ErrorCode(14)    // TypeMismatch
+	ErrAuthenticationFailed  = ErrorCode(18)    // AuthenticationFailed
+	ErrNamespaceNotFound     = ErrorCode(26)    // NamespaceNotFound
+	ErrIndexNotFound         = ErrorCode(27)    // IndexNotFound
+	ErrPathNotViable         = ErrorCode(28)    // PathNotViable
+	ErrRoleNotFound          = ErrorCode(31)    // RoleNotFound
+	ErrCursorNotFound        = ErrorCode(43)    // CursorNotFound
+	ErrNamespaceExists       = ErrorCode(48)    // NamespaceExists
+	ErrCommandNotFound       = ErrorCode(59)    // CommandNotFound
+	ErrImmutableField        = ErrorCode(66)    // ImmutableField
+	ErrCannotCreateIndex     = ErrorCode(67)    // CannotCreateIndex
+	ErrInvalidOptions        = ErrorCode(72)    // InvalidOptions
+	ErrInvalidNamespace      = ErrorCode(73)    // InvalidNamespace
+	ErrIndexOptionsConflict  = ErrorCode(85)    // IndexOptionsConflict
+	ErrIndexKeySpecsConflict = ErrorCode(86)    // IndexKeySpecsConflict
+	ErrWriteConflict         = ErrorCode(112)   // WriteConflict
+	ErrConflictingOperation  = ErrorCode(117)   // ConflictingOperationInProgress
+	ErrTransactionTooOld     = ErrorCode(225)   // TransactionTooOld
+	ErrNotImplemented        = ErrorCode(238)   // NotImplemented
+	ErrNoSuchTransaction     = ErrorCode(251)   // NoSuchTransaction
+	ErrNotInTransaction      = ErrorCode(263)   // OperationNotSupportedInTransaction
+	ErrCursorInUse           = ErrorCode(292)   // CursorInUse
+	ErrMechanismUnavailable  = ErrorCode(334)   // MechanismUnavailable
+	ErrDuplicateKey          = ErrorCode(11000) // DuplicateKey
+	ErrSortBadValue          = ErrorCode(15974) // SortBadValue
+	ErrProjectionInEx        = ErrorCode(31253) // Location31253
+	ErrProjectionExIn        = ErrorCode(31254) // Location31254
+	ErrUserAlreadyExists     = ErrorCode(51003) // Location51003
+	ErrRegexOptions          = ErrorCode(51075) // Location51075
 )
 
 // Error represents wire protocol error.
@@ -84,6 +109,11 @@ func (e *Error) Error() string {
 	return fmt.Sprintf("%[1]s (%[1]d): %[2]v", e.code, e.err)
 }
 
+// Code returns wire protocol error code.
+func (e *Error) Code() ErrorCode {
+	return e.code
+}
+
 // Unwrap implements standard error unwrapping interface.
 func (e *Error) Unwrap() error {
 	return e.err
@@ -102,6 +132,8 @@ func (e *Error) Document() types.Document {
 // ProtocolError converts any error to wire protocol error.
 //
 // Nil panics, *Error (possibly wrapped) is returned unwrapped with true,
+// a missing SAP HANA privilege is wrapped with Unauthorized and returned with true,
+// an invalid name of a database or collection is wrapped with InvalidNamespace and returned with true,
 // any other value is wrapped with InternalError and returned with false.
 func ProtocolError(err error) (*Error, bool) {
 	if err == nil {
@@ -113,6 +145,14 @@ func ProtocolError(err error) (*Error, bool) {
 		return e, true
 	}
 
+	if hana.IsInsufficientPrivilege(err) {
+		return NewError(ErrUnauthorized, err).(*Error), true
+	}
+
+	if errors.Is(err, hana.ErrInvalidName) {
+		return NewError(ErrInvalidNamespace, err).(*Error), true
+	}
+
 	return NewError(errInternalError, err).(*Error), false
 }
 
@@ -10,53 +10,82 @@ func _() {
 	var x [1]struct{}
 	_ = x[errInternalError-1]
 	_ = x[ErrBadValue-2]
+	_ = x[ErrFailedToParse-9]
+	_ = x[ErrUserNotFound-11]
+	_ = x[ErrUnauthorized-13]
+	_ = x[ErrTypeMismatch-14]
+	_ = x[ErrAuthenticationFailed-18]
 	_ = x[ErrNamespaceNotFound-26]
+	_ = x[ErrIndexNotFound-27]
+	_ = x[ErrPathNotViable-28]
+	_ = x[ErrRoleNotFound-31]
+	_ = x[ErrCursorNotFound-43]
 	_ = x[ErrNamespaceExists-48]
 	_ = x[ErrCommandNotFound-59]
+	_ = x[ErrImmutableField-66]
+	_ = x[ErrCannotCreateIndex-67]
+	_ = x[ErrInvalidOptions-72]
+	_ = x[ErrInvalidNamespace-73]
+	_ = x[ErrIndexOptionsConflict-85]
+	_ = x[ErrIndexKeySpecsConflict-86]
+	_ = x[ErrWriteConflict-112]
+	_ = x[ErrConflictingOperation-117]
+	_ = x[ErrTransactionTooOld-225]
 	_ = x[ErrNotImplemented-238]
+	_ = x[ErrNoSuchTransaction-251]
+	_ = x[ErrNotInTransaction-263]
+	_ = x[ErrCursorInUse-292]
+	_ = x[ErrMechanismUnavailable-334]
+	_ = x[ErrDuplicateKey-11000]
 	_ = x[ErrSortBadValue-15974]
 	_ = x[ErrProjectionInEx-31253]
 	_ = x[ErrProjectionExIn-31254]
+	_ = x[ErrUserAlreadyExists-51003]
 	_ = x[ErrRegexOptions-51075]
 }
 
-const (
-	_ErrorCode_name_0 = "InternalErrorBadValue"
-	_ErrorCode_name_1 = "NamespaceNotFound"
-	_ErrorCode_name_2 = "NamespaceExists"
-	_ErrorCode_name_3 = "CommandNotFound"
-	_ErrorCode_name_4 = "NotImplemented"
-	_ErrorCode_name_5 = "SortBadValue"
-	_ErrorCode_name_6 = "Location31253Location31254"
-	_ErrorCode_name_7 = "Location51075"
-)
+const _ErrorCode_name = "InternalErrorBadValueFailedToParseUserNotFoundUnauthorizedTypeMismatchAuthenticationFailedNamespaceNotFoundIndexNotFoundPathNotViableRoleNotFoundCursorNotFoundNamespaceExistsCommandNotFoundImmutableFieldCannotCreateIndexInvalidOptionsInvalidNamespaceIndexOptionsConflictIndexKeySpecsConflictWriteConflictConflictingOperationInProgressTransactionTooOldNotImplementedNoSuchTransactionOperationNotSupportedInTransactionCursorInUseMechanismUnavailableDuplicateKeySortBadValueLocation31253Location31254Location51003Location51075"
 
-var (
-	_ErrorCode_index_0 = [...]uint8{0, 13, 21}
-	_ErrorCode_index_6 = [...]uint8{0, 13, 26}
-)
+var _ErrorCode_map = map[ErrorCode]string{
+	1:     _ErrorCode_name[0:13],
+	2:     _ErrorCode_name[13:21],
+	9:     _ErrorCode_name[21:34],
+	11:    _ErrorCode_name[34:46],
+	13:    _ErrorCode_name[46:58],
+	14:    _ErrorCode_name[58:70],
+	18:    _ErrorCode_name[70:90],
+	26:    _ErrorCode_name[90:107],
+	27:    _ErrorCode_name[107:120],
+	28:    _ErrorCode_name[120:133],
+	31:    _ErrorCode_name[133:145],
+	43:    _ErrorCode_name[145:159],
+	48:    _ErrorCode_name[159:174],
+	59:    _ErrorCode_name[174:189],
+	66:    _ErrorCode_name[189:203],
+	67:    _ErrorCode_name[203:220],
+	72:    _ErrorCode_name[220:234],
+	73:    _ErrorCode_name[234:250],
+	85:    _ErrorCode_name[250:270],
+	86:    _ErrorCode_name[270:291],
+	112:   _ErrorCode_name[291:304],
+	117:   _ErrorCode_name[304:334],
+	225:   _ErrorCode_name[334:351],
+	238:   _ErrorCode_name[351:365],
+	251:   _ErrorCode_name[365:382],
+	263:   _ErrorCode_name[382:416],
+	292:   _ErrorCode_name[416:427],
+	334:   _ErrorCode_name[427:447],
+	11000: _ErrorCode_name[447:459],
+	15974: _ErrorCode_name[459:471],
+	31253: _ErrorCode_name[471:484],
+	31254: _ErrorCode_name[484:497],
+	51003: _ErrorCode_name[497:510],
+	51075: _ErrorCode_name[510:523],
+}
 
 func (i ErrorCode) String() string {
-	switch {
-	case 1 <= i && i <= 2:
-		i -= 1
-		return _ErrorCode_name_0[_ErrorCode_index_0[i]:_ErrorCode_index_0[i+1]]
-	case i == 26:
-		return _ErrorCode_name_1
-	case i == 48:
-		return _ErrorCode_name_2
-	case i == 59:
-		return _ErrorCode_name_3
-	case i == 238:
-		return _ErrorCode_name_4
-	case i == 15974:
-		return _ErrorCode_name_5
-	case 31253 <= i && i <= 31254:
-		i -= 31253
-		return _ErrorCode_name_6[_ErrorCode_index_6[i]:_ErrorCode_index_6[i+1]]
-	case i == 51075:
-		return _ErrorCode_name_7
-	default:
-		return "ErrorCode(" + strconv.FormatInt(int64(i), 10) + ")"
+	if str, ok := _ErrorCode_map[i]; ok {
+		return str
 	}
+	return "ErrorCode(" + strconv.FormatInt(int64(i), 10) + ")"
 }
@@ -0,0 +1,248 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"strings"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+)
+
+// missing is returned by EvaluateExpression for field paths that do not exist.
+// It is never returned to the client, fields with a missing value are omitted.
+type missing struct{}
+
+// IsMissing returns true if the value is the result of evaluating a field path that does not exist.
+func IsMissing(value any) bool {
+	_, ok := value.(missing)
+	return ok
+}
+
+// EvaluateExpression evaluates an aggregation expression on the given document.
+// Supported are field paths like "$field.nested", the variables $$ROOT and $$CURRENT,
+// $literal, the comparison operators $eq, $ne, $gt, $gte, $lt, $lte and $cmp, the boolean operators $and, $or and $not
+// and documents and arrays containing expressions.
+func EvaluateExpression(doc types.Document, expr any) (any, error) {
+	switch expr := expr.(type) {
+	case string:
+		switch {
+		case strings.HasPrefix(expr, "$$"):
+			return evaluateVariable(doc, expr)
+		case strings.HasPrefix(expr, "$"):
+			return evaluateFieldPath(doc, strings.TrimPrefix(expr, "$")), nil
+		default:
+			return expr, nil
+		}
+
+	case types.Document:
+		keys := expr.Keys()
+		if len(keys) == 1 && strings.HasPrefix(keys[0], "$") {
+			return evaluateOperator(doc, keys[0], expr.Map()[keys[0]])
+		}
+
+		res := types.MustMakeDocument()
+		for _, key := range keys {
+			if strings.HasPrefix(key, "$") {
+				return nil, NewErrorMessage(ErrBadValue, "an expression specification must contain exactly one field, "+
+					"the name of the expression. Found %d fields in %s", len(keys), keys)
+			}
+
+			value, err := EvaluateExpression(doc, expr.Map()[key])
+			if err != nil {
+				return nil, err
+			}
+
+			if IsMissing(value) {
+				continue
+			}
+
+			if err = res.Set(key, value); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+
+	case *types.Array:
+		res := types.MakeArray(expr.Len())
+		for i := 0; i < expr.Len(); i++ {
+			value, err := EvaluateExpression(doc, must(expr.Get(i)))
+			if err != nil {
+				return nil, err
+			}
+
+			// missing values in arrays become null like in MongoDB
+			if IsMissing(value) {
+				value = nil
+			}
+
+			if err = res.Append(value); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+
+	default:
+		return expr, nil
+	}
+}
+
+// evaluateVariable evaluates $$ROOT and $$CURRENT with optional field paths.
+func evaluateVariable(doc types.Document, expr string) (any, error) {
+	name, path, _ := strings.Cut(strings.TrimPrefix(expr, "$$"), ".")
+
+	switch name {
+	case "ROOT", "CURRENT":
+	default:
+		return nil, NewErrorMessage(ErrNotImplemented, "variable $$%s is not implemented yet", name)
+	}
+
+	if path == "" {
+		return doc, nil
+	}
+
+	return evaluateFieldPath(doc, path), nil
+}
+
+// evaluateFieldPath returns the value at the given path.
+// Arrays on the path result in an array of the values found in its documents.
+func evaluateFieldPath(value any, path string) any {
+	if path == "" {
+		return value
+	}
+
+	key, rest, _ := strings.Cut(path, ".")
+
+	switch value := value.(type) {
+	case types.Document:
+		next, err := value.Get(key)
+		if err != nil {
+			return missing{}
+		}
+		return evaluateFieldPath(next, rest)
+
+	case *types.Array:
+		res := new(types.Array)
+		for i := 0; i < value.Len(); i++ {
+			elem, ok := must(value.Get(i)).(types.Document)
+			if !ok {
+				continue
+			}
+
+			if next := evaluateFieldPath(elem, path); !IsMissing(next) {
+				_ = res.Append(next)
+			}
+		}
+		return res
+
+	default:
+		return missing{}
+	}
+}
+
+// evaluateOperator evaluates an expression operator like {$literal: value}.
+func evaluateOperator(doc types.Document, op string, value any) (any, error) {
+	switch op {
+	case "$literal":
+		return value, nil
+
+	case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte", "$cmp":
+		args, err := evaluateArguments(doc, op, value, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		res := compareExpressionValues(args[0], args[1])
+		switch op {
+		case "$eq":
+			return res == 0, nil
+		case "$ne":
+			return res != 0, nil
+		case "$gt":
+			return res > 0, nil
+		case "$gte":
+			return res >= 0, nil
+		case "$lt":
+			return res < 0, nil
+		case "$lte":
+			return res <= 0, nil
+		default:
+			return int32(res), nil
+		}
+
+	case "$and", "$or":
+		args, err := evaluateArguments(doc, op, value, -1)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, arg := range args {
+			if isTrue(arg) != (op == "$and") {
+				return op == "$or", nil
+			}
+		}
+		return op == "$and", nil
+
+	case "$not":
+		args, err := evaluateArguments(doc, op, value, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		return !isTrue(args[0]), nil
+
+	default:
+		return nil, NewErrorMessage(ErrNotImplemented, "expression operator %s is not implemented yet", op)
+	}
+}
+
+// evaluateArguments evaluates the arguments of an expression operator, given as array or as single argument.
+// n is the required number of arguments, or -1 for any number.
+func evaluateArguments(doc types.Document, op string, value any, n int) ([]any, error) {
+	exprs := []any{value}
+	if arr, ok := value.(*types.Array); ok {
+		exprs = make([]any, arr.Len())
+		for i := range exprs {
+			exprs[i] = must(arr.Get(i))
+		}
+	}
+
+	if n >= 0 && len(exprs) != n {
+		return nil, NewErrorMessage(ErrBadValue, "Expression %s takes exactly %d arguments. %d were passed in.", op, n, len(exprs))
+	}
+
+	args := make([]any, len(exprs))
+	for i, expr := range exprs {
+		arg, err := EvaluateExpression(doc, expr)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = arg
+	}
+
+	return args, nil
+}
+
+// compareExpressionValues compares values like CompareValues. Missing values are less than any other value.
+func compareExpressionValues(a, b any) int {
+	switch aMissing, bMissing := IsMissing(a), IsMissing(b); {
+	case aMissing && bMissing:
+		return 0
+	case aMissing:
+		return -1
+	case bMissing:
+		return 1
+	default:
+		return CompareValues(a, b)
+	}
+}
+
+// isTrue returns true if the result of an expression counts as true: everything but false, null, 0 and missing values.
+func isTrue(value any) bool {
+	if value == nil || IsMissing(value) {
+		return false
+	}
+
+	return !isFalsy(value)
+}
@@ -0,0 +1,916 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"bytes"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+)
+
+// FilterDocument returns true if the document matches the filter.
+// It evaluates the filter in memory. find, count, distinct, update, delete and findAndModify use it
+// as the post-filter for the documents read from SAP HANA JSON Document Store if the filter cannot be translated
+// to SQL completely, see CreatePrefilterWhereClause. It also evaluates $match stages and array filters in memory.
+func FilterDocument(doc types.Document, filter types.Document) (bool, error) {
+	for _, key := range filter.Keys() {
+		value := filter.Map()[key]
+
+		var matches bool
+		var err error
+		if strings.HasPrefix(key, "$") {
+			matches, err = filterLogic(doc, key, value)
+		} else {
+			matches, err = filterField(doc, key, value)
+		}
+
+		if err != nil || !matches {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// filterLogic evaluates $and, $or, $nor, $expr and $jsonSchema.
+func filterLogic(doc types.Document, key string, value any) (bool, error) {
+	lowerKey := strings.ToLower(key)
+
+	switch lowerKey {
+	case "$and", "$or", "$nor":
+	case "$expr":
+		return filterExpr(doc, value)
+	case "$jsonschema":
+		return filterJSONSchema(doc, value)
+	default:
+		return false, NewErrorMessage(ErrNotImplemented, "support for %s is not implemented yet", key)
+	}
+
+	exprs, ok := value.(*types.Array)
+	if !ok {
+		return false, NewErrorMessage(ErrBadValue, "%s must be an array", lowerKey)
+	}
+
+	for i := 0; i < exprs.Len(); i++ {
+		expr, _ := exprs.Get(i)
+
+		exprDoc, ok := expr.(types.Document)
+		if !ok {
+			return false, NewErrorMessage(ErrBadValue, "%s entries need to be full objects", lowerKey)
+		}
+
+		matches, err := FilterDocument(doc, exprDoc)
+		if err != nil {
+			return false, err
+		}
+
+		switch {
+		case lowerKey == "$and" && !matches:
+			return false, nil
+		case lowerKey == "$or" && matches:
+			return true, nil
+		case lowerKey == "$nor" && matches:
+			return false, nil
+		}
+	}
+
+	return lowerKey != "$or", nil
+}
+
+// filterField evaluates {field: value} and {field: {$operator: value}}.
+func filterField(doc types.Document, key string, value any) (bool, error) {
+	values := LookupValues(doc, key)
+
+	if expr, ok := value.(types.Document); ok && len(expr.Keys()) != 0 && strings.HasPrefix(expr.Keys()[0], "$") {
+		return filterOperators(values, expr)
+	}
+
+	if regex, ok := value.(types.Regex); ok {
+		return filterRegex(values, regex.Pattern, regex.Options)
+	}
+
+	return filterEqual(values, value), nil
+}
+
+// filterOperators evaluates all operators of {$operator: value, ...} on the values of a field.
+func filterOperators(values []any, expr types.Document) (bool, error) {
+	for _, op := range expr.Keys() {
+		value := expr.Map()[op]
+
+		var matches bool
+		var err error
+
+		switch strings.ToLower(op) {
+		case "$eq":
+			matches = filterEqual(values, value)
+		case "$ne":
+			matches = !filterEqual(values, value)
+		case "$gt", "$gte", "$lt", "$lte":
+			matches = filterCompare(values, strings.ToLower(op), value)
+		case "$exists":
+			exists, ok := value.(bool)
+			if !ok {
+				return false, NewErrorMessage(ErrBadValue, "$exists only works with boolean")
+			}
+			matches = (len(values) != 0) == exists
+		case "$in":
+			matches, err = filterIn(values, value)
+		case "$nin":
+			matches, err = filterIn(values, value)
+			matches = !matches
+		case "$size":
+			matches, err = filterSize(values, value)
+		case "$type":
+			matches, err = filterType(values, value)
+		case "$mod":
+			matches, err = filterMod(values, value)
+		case "$all":
+			matches, err = filterAll(values, value)
+		case "$elemmatch":
+			matches, err = filterElemMatch(values, value)
+		case "$not":
+			matches, err = filterNot(values, value)
+		case "$regex":
+			var options string
+			if o, ok := expr.Map()["$options"].(string); ok {
+				options = o
+			}
+			matches, err = filterRegexValue(values, value, options)
+		case "$options":
+			if _, ok := expr.Map()["$regex"]; !ok {
+				return false, NewErrorMessage(ErrBadValue, "$options needs a $regex")
+			}
+			continue
+		default:
+			return false, NewErrorMessage(ErrNotImplemented, "support for %s is not implemented yet", op)
+		}
+
+		if err != nil || !matches {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// filterEqual returns true if any value or any element of an array value equals the given value.
+// A null value matches missing fields.
+func filterEqual(values []any, value any) bool {
+	if value == nil && len(values) == 0 {
+		return true
+	}
+
+	for _, v := range values {
+		if EqualValues(v, value) {
+			return true
+		}
+
+		if arr, ok := v.(*types.Array); ok {
+			for i := 0; i < arr.Len(); i++ {
+				elem, _ := arr.Get(i)
+				if EqualValues(elem, value) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// filterCompare evaluates $gt, $gte, $lt and $lte. Only values of the same type bracket are compared.
+func filterCompare(values []any, op string, value any) bool {
+	compare := func(v any) bool {
+		if typeOrder(v) != typeOrder(value) {
+			return false
+		}
+
+		res := CompareValues(v, value)
+		switch op {
+		case "$gt":
+			return res > 0
+		case "$gte":
+			return res >= 0
+		case "$lt":
+			return res < 0
+		default:
+			return res <= 0
+		}
+	}
+
+	for _, v := range values {
+		if compare(v) {
+			return true
+		}
+
+		if arr, ok := v.(*types.Array); ok {
+			for i := 0; i < arr.Len(); i++ {
+				elem, _ := arr.Get(i)
+				if compare(elem) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// filterIn evaluates $in: any value equals or, for regular expressions, matches an element of the array.
+func filterIn(values []any, value any) (bool, error) {
+	in, ok := value.(*types.Array)
+	if !ok {
+		return false, NewErrorMessage(ErrBadValue, "$in needs an array")
+	}
+
+	for i := 0; i < in.Len(); i++ {
+		var matches bool
+		var err error
+		switch elem := must(in.Get(i)).(type) {
+		case types.Regex:
+			matches, err = filterRegex(values, elem.Pattern, elem.Options)
+		default:
+			matches = filterEqual(values, elem)
+		}
+
+		if err != nil || matches {
+			return matches, err
+		}
+	}
+
+	return false, nil
+}
+
+// filterType evaluates $type: any value or element of an array value has one of the types.
+// Types are given by alias or BSON type number, "number" matches all numeric types.
+func filterType(values []any, value any) (bool, error) {
+	specs := []any{value}
+	if arr, ok := value.(*types.Array); ok {
+		specs = make([]any, arr.Len())
+		for i := range specs {
+			specs[i] = must(arr.Get(i))
+		}
+	}
+
+	if len(specs) == 0 {
+		return false, NewErrorMessage(ErrFailedToParse, "$type must match at least one type")
+	}
+
+	var typeNumbers []int32
+	for _, spec := range specs {
+		switch spec := spec.(type) {
+		case string:
+			if spec == "number" {
+				typeNumbers = append(typeNumbers, numberTypes...)
+				continue
+			}
+
+			n, ok := bsonTypeAliases[spec]
+			if !ok {
+				return false, NewErrorMessage(ErrBadValue, "Unknown type name alias: %s", spec)
+			}
+			typeNumbers = append(typeNumbers, n)
+
+		case int32, int64, float64:
+			n, ok := toInt64(spec)
+			if !ok || !isBSONTypeNumber(n) {
+				return false, NewErrorMessage(ErrBadValue, "Invalid numerical type code: %v", spec)
+			}
+			typeNumbers = append(typeNumbers, int32(n))
+
+		default:
+			return false, NewErrorMessage(ErrTypeMismatch, "type must be represented as a number or a string")
+		}
+	}
+
+	hasType := func(v any) bool {
+		n := bsonTypeNumber(v)
+		for _, t := range typeNumbers {
+			if n == t {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, v := range values {
+		if hasType(v) {
+			return true, nil
+		}
+
+		if arr, ok := v.(*types.Array); ok {
+			for i := 0; i < arr.Len(); i++ {
+				if hasType(must(arr.Get(i))) {
+					return true, nil
+				}
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// bsonTypeAliases maps the type aliases of $type to BSON type numbers.
+var bsonTypeAliases = map[string]int32{
+	"double":    1,
+	"string":    2,
+	"object":    3,
+	"array":     4,
+	"binData":   5,
+	"objectId":  7,
+	"bool":      8,
+	"date":      9,
+	"null":      10,
+	"regex":     11,
+	"int":       16,
+	"timestamp": 17,
+	"long":      18,
+	"decimal":   19,
+	"minKey":    -1,
+	"maxKey":    127,
+}
+
+// numberTypes are the BSON type numbers matched by the alias "number".
+var numberTypes = []int32{1, 16, 18, 19}
+
+// isBSONTypeNumber returns true if n is the number of a BSON type.
+func isBSONTypeNumber(n int64) bool {
+	for _, t := range bsonTypeAliases {
+		if int64(t) == n {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bsonTypeNumber returns the BSON type number of the value, or 0 for unknown types.
+func bsonTypeNumber(value any) int32 {
+	switch value.(type) {
+	case float64:
+		return 1
+	case string:
+		return 2
+	case types.Document:
+		return 3
+	case *types.Array:
+		return 4
+	case types.Binary:
+		return 5
+	case types.ObjectID:
+		return 7
+	case bool:
+		return 8
+	case time.Time:
+		return 9
+	case nil:
+		return 10
+	case types.Regex:
+		return 11
+	case int32:
+		return 16
+	case types.Timestamp:
+		return 17
+	case int64:
+		return 18
+	default:
+		return 0
+	}
+}
+
+// filterMod evaluates $mod: any number or number element of an array value divided by the divisor leaves the remainder.
+// Like in MongoDB, the numbers are truncated toward zero.
+func filterMod(values []any, value any) (bool, error) {
+	arr, ok := value.(*types.Array)
+	if !ok {
+		return false, NewErrorMessage(ErrBadValue, "malformed mod, needs to be an array")
+	}
+
+	switch {
+	case arr.Len() < 2:
+		return false, NewErrorMessage(ErrBadValue, "malformed mod, not enough elements")
+	case arr.Len() > 2:
+		return false, NewErrorMessage(ErrBadValue, "malformed mod, too many elements")
+	}
+
+	divisor, ok := truncateNumber(must(arr.Get(0)))
+	if !ok {
+		return false, NewErrorMessage(ErrBadValue, "malformed mod, divisor not a number")
+	}
+	remainder, ok := truncateNumber(must(arr.Get(1)))
+	if !ok {
+		return false, NewErrorMessage(ErrBadValue, "malformed mod, remainder not a number")
+	}
+	if divisor == 0 {
+		return false, NewErrorMessage(ErrBadValue, "divisor cannot be 0")
+	}
+
+	hasRemainder := func(v any) bool {
+		n, ok := truncateNumber(v)
+		return ok && n%divisor == remainder
+	}
+
+	for _, v := range values {
+		if hasRemainder(v) {
+			return true, nil
+		}
+
+		if arr, ok := v.(*types.Array); ok {
+			for i := 0; i < arr.Len(); i++ {
+				if hasRemainder(must(arr.Get(i))) {
+					return true, nil
+				}
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// truncateNumber converts a number to int64, truncating toward zero.
+// ok is false if the value is not a number or not representable as int64.
+func truncateNumber(value any) (res int64, ok bool) {
+	switch value := value.(type) {
+	case int32:
+		return int64(value), true
+	case int64:
+		return value, true
+	case float64:
+		if math.IsNaN(value) || math.Abs(value) >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(value), true
+	default:
+		return 0, false
+	}
+}
+
+// filterExpr evaluates $expr: the aggregation expression is true.
+func filterExpr(doc types.Document, expr any) (bool, error) {
+	res, err := EvaluateExpression(doc, expr)
+	if err != nil {
+		return false, err
+	}
+
+	return isTrue(res), nil
+}
+
+// filterSize evaluates $size.
+func filterSize(values []any, value any) (bool, error) {
+	size, ok := toInt64(value)
+	if !ok {
+		return false, NewErrorMessage(ErrBadValue, "$size needs a number")
+	}
+
+	for _, v := range values {
+		if arr, ok := v.(*types.Array); ok && int64(arr.Len()) == size {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// filterAll evaluates $all.
+func filterAll(values []any, value any) (bool, error) {
+	all, ok := value.(*types.Array)
+	if !ok {
+		return false, NewErrorMessage(ErrBadValue, "$all needs an array")
+	}
+
+	if all.Len() == 0 {
+		return false, nil
+	}
+
+	for i := 0; i < all.Len(); i++ {
+		elem, _ := all.Get(i)
+		if !filterEqual(values, elem) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// filterElemMatch evaluates $elemMatch.
+func filterElemMatch(values []any, value any) (bool, error) {
+	expr, ok := value.(types.Document)
+	if !ok {
+		return false, NewErrorMessage(ErrBadValue, "$elemMatch needs an Object")
+	}
+
+	isOperator := len(expr.Keys()) != 0 && isFieldOperator(expr.Keys()[0])
+
+	for _, v := range values {
+		arr, ok := v.(*types.Array)
+		if !ok {
+			continue
+		}
+
+		for i := 0; i < arr.Len(); i++ {
+			elem, _ := arr.Get(i)
+
+			var matches bool
+			var err error
+			if isOperator {
+				matches, err = filterOperators([]any{elem}, expr)
+			} else if elemDoc, ok := elem.(types.Document); ok {
+				matches, err = FilterDocument(elemDoc, expr)
+			}
+
+			if err != nil {
+				return false, err
+			}
+			if matches {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// filterNot evaluates $not.
+func filterNot(values []any, value any) (bool, error) {
+	var matches bool
+	var err error
+
+	switch value := value.(type) {
+	case types.Document:
+		matches, err = filterOperators(values, value)
+	case types.Regex:
+		matches, err = filterRegex(values, value.Pattern, value.Options)
+	default:
+		return false, NewErrorMessage(ErrBadValue, "$not needs a regex or a document")
+	}
+
+	return !matches, err
+}
+
+// filterRegexValue evaluates $regex given as string or regular expression.
+func filterRegexValue(values []any, value any, options string) (bool, error) {
+	switch value := value.(type) {
+	case string:
+		return filterRegex(values, value, options)
+	case types.Regex:
+		if options == "" {
+			options = value.Options
+		}
+		return filterRegex(values, value.Pattern, options)
+	default:
+		return false, NewErrorMessage(ErrBadValue, "$regex has to be a string")
+	}
+}
+
+// filterRegex returns true if any string value or string array element matches the regular expression.
+func filterRegex(values []any, pattern, options string) (bool, error) {
+	re, err := CompileRegex(pattern, options)
+	if err != nil {
+		return false, err
+	}
+
+	for _, v := range values {
+		switch v := v.(type) {
+		case string:
+			if re.MatchString(v) {
+				return true, nil
+			}
+		case *types.Array:
+			for i := 0; i < v.Len(); i++ {
+				if s, ok := must(v.Get(i)).(string); ok && re.MatchString(s) {
+					return true, nil
+				}
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// CompileRegex compiles a MongoDB regular expression with the options i, m, s, u and x.
+// Go regular expressions always match UTF-8, so u has no effect.
+func CompileRegex(pattern, options string) (*regexp.Regexp, error) {
+	var flags string
+	for _, o := range options {
+		switch o {
+		case 'i', 'm', 's':
+			if !strings.ContainsRune(flags, o) {
+				flags += string(o)
+			}
+		case 'u':
+		case 'x':
+			pattern = stripExtendedRegex(pattern)
+		default:
+			return nil, NewErrorMessage(ErrRegexOptions, "invalid flag in regex options: %c", o)
+		}
+	}
+
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, NewErrorMessage(ErrBadValue, "Regular expression is invalid: %s", err)
+	}
+
+	return re, nil
+}
+
+// stripExtendedRegex removes whitespace and comments from a pattern using the x option.
+func stripExtendedRegex(pattern string) string {
+	var res strings.Builder
+	var escaped, inClass, inComment bool
+
+	for _, r := range pattern {
+		switch {
+		case inComment:
+			inComment = r != '\n'
+			continue
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '[':
+			inClass = true
+		case r == ']':
+			inClass = false
+		case !inClass && r == '#':
+			inComment = true
+			continue
+		case !inClass && (r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f' || r == '\v'):
+			continue
+		}
+
+		res.WriteRune(r)
+	}
+
+	return res.String()
+}
+
+// LookupValues returns all values found at the given dot notation path.
+// Arrays on the path are traversed like MongoDB does, i.e. "a.b" finds b of all documents in the array a.
+// Numeric path elements index arrays.
+func LookupValues(value any, path string) []any {
+	return lookupPath(value, strings.Split(path, "."))
+}
+
+func lookupPath(value any, path []string) []any {
+	if len(path) == 0 {
+		return []any{value}
+	}
+
+	switch value := value.(type) {
+	case types.Document:
+		next, err := value.Get(path[0])
+		if err != nil {
+			return nil
+		}
+		return lookupPath(next, path[1:])
+
+	case *types.Array:
+		var res []any
+		if index, err := strconv.Atoi(path[0]); err == nil {
+			if next, err := value.Get(index); err == nil {
+				res = append(res, lookupPath(next, path[1:])...)
+			}
+		}
+
+		for i := 0; i < value.Len(); i++ {
+			if elem, ok := must(value.Get(i)).(types.Document); ok {
+				res = append(res, lookupPath(elem, path)...)
+			}
+		}
+		return res
+
+	default:
+		return nil
+	}
+}
+
+// GetPathValue returns the value at the given dot notation path, without traversing arrays
+// besides numeric indexes. ok is false if there is no such value.
+func GetPathValue(doc types.Document, path string) (value any, ok bool) {
+	value, err := doc.GetByPath(strings.Split(path, ".")...)
+	if err != nil {
+		return nil, false
+	}
+
+	return value, true
+}
+
+// EqualValues returns true if both values are equal. Numbers of different types are equal if their values are equal.
+func EqualValues(a, b any) bool {
+	return typeOrder(a) == typeOrder(b) && CompareValues(a, b) == 0
+}
+
+// typeOrder returns the position of the type of the value in the MongoDB comparison order of types.
+func typeOrder(value any) int {
+	switch value.(type) {
+	case nil:
+		return 1
+	case float64, int32, int64:
+		return 2
+	case string:
+		return 3
+	case types.Document:
+		return 4
+	case *types.Array:
+		return 5
+	case types.Binary:
+		return 6
+	case types.ObjectID:
+		return 7
+	case bool:
+		return 8
+	case time.Time:
+		return 9
+	case types.Timestamp:
+		return 10
+	case types.Regex:
+		return 11
+	default:
+		return 12
+	}
+}
+
+// CompareValues compares two values of any type in the MongoDB comparison order.
+// It returns -1 if a is less than b, 0 if they are equal and 1 if a is greater than b.
+func CompareValues(a, b any) int {
+	if ta, tb := typeOrder(a), typeOrder(b); ta != tb {
+		return compareInts(int64(ta), int64(tb))
+	}
+
+	switch a := a.(type) {
+	case float64, int32, int64:
+		return compareNumbers(a, b)
+
+	case string:
+		return strings.Compare(a, b.(string))
+
+	case types.Document:
+		b := b.(types.Document)
+		for i, key := range a.Keys() {
+			if i >= len(b.Keys()) {
+				return 1
+			}
+
+			if res := strings.Compare(key, b.Keys()[i]); res != 0 {
+				return res
+			}
+
+			if res := CompareValues(a.Map()[key], b.Map()[key]); res != 0 {
+				return res
+			}
+		}
+		return compareInts(int64(len(a.Keys())), int64(len(b.Keys())))
+
+	case *types.Array:
+		b := b.(*types.Array)
+		for i := 0; i < a.Len(); i++ {
+			if i >= b.Len() {
+				return 1
+			}
+
+			if res := CompareValues(must(a.Get(i)), must(b.Get(i))); res != 0 {
+				return res
+			}
+		}
+		return compareInts(int64(a.Len()), int64(b.Len()))
+
+	case types.Binary:
+		b := b.(types.Binary)
+		if res := compareInts(int64(len(a.B)), int64(len(b.B))); res != 0 {
+			return res
+		}
+		if res := compareInts(int64(a.Subtype), int64(b.Subtype)); res != 0 {
+			return res
+		}
+		return bytes.Compare(a.B, b.B)
+
+	case types.ObjectID:
+		b := b.(types.ObjectID)
+		return bytes.Compare(a[:], b[:])
+
+	case bool:
+		b := b.(bool)
+		switch {
+		case a == b:
+			return 0
+		case b:
+			return -1
+		default:
+			return 1
+		}
+
+	case time.Time:
+		return compareInts(a.UnixMilli(), b.(time.Time).UnixMilli())
+
+	case types.Timestamp:
+		b := b.(types.Timestamp)
+		switch {
+		case a == b:
+			return 0
+		case a < b:
+			return -1
+		default:
+			return 1
+		}
+
+	case types.Regex:
+		b := b.(types.Regex)
+		if res := strings.Compare(a.Pattern, b.Pattern); res != 0 {
+			return res
+		}
+		return strings.Compare(a.Options, b.Options)
+
+	default:
+		return 0
+	}
+}
+
+// compareNumbers compares two numbers of type float64, int32 or int64. NaN is less than any other number.
+func compareNumbers(a, b any) int {
+	ai, aIsInt := toInt64(a)
+	bi, bIsInt := toInt64(b)
+	_, aIsFloat := a.(float64)
+	_, bIsFloat := b.(float64)
+
+	if aIsInt && bIsInt && !aIsFloat && !bIsFloat {
+		return compareInts(ai, bi)
+	}
+
+	af, bf := toFloat64(a), toFloat64(b)
+	switch {
+	case math.IsNaN(af) && math.IsNaN(bf):
+		return 0
+	case math.IsNaN(af):
+		return -1
+	case math.IsNaN(bf):
+		return 1
+	case af < bf:
+		return -1
+	case af > bf:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// toInt64 converts a number to int64. ok is false if value is not a number with an integer value.
+func toInt64(value any) (res int64, ok bool) {
+	switch value := value.(type) {
+	case int32:
+		return int64(value), true
+	case int64:
+		return value, true
+	case float64:
+		if value != math.Trunc(value) || math.IsInf(value, 0) {
+			return 0, false
+		}
+		return int64(value), true
+	default:
+		return 0, false
+	}
+}
+
+// toFloat64 converts a number to float64. Any other value is converted to NaN.
+func toFloat64(value any) float64 {
+	switch value := value.(type) {
+	case int32:
+		return float64(value)
+	case int64:
+		return float64(value)
+	case float64:
+		return value
+	default:
+		return math.NaN()
+	}
+}
+
+// must panics if err is not nil. It is used for accessing array elements by valid index.
+func must(value any, err error) any {
+	if err != nil {
+		panic(err)
+	}
+
+	return value
+}
@@ -0,0 +1,395 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"math"
+	"unicode/utf8"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+)
+
+// filterJSONSchema evaluates $jsonSchema: the document is valid against the schema.
+func filterJSONSchema(doc types.Document, value any) (bool, error) {
+	schema, ok := value.(types.Document)
+	if !ok {
+		return false, NewErrorMessage(ErrTypeMismatch, "$jsonSchema must be an object")
+	}
+
+	return validateSchema(doc, schema)
+}
+
+// validateSchema returns true if the value is valid against the schema.
+// Like in MongoDB, keywords which do not apply to the type of the value are ignored,
+// e.g. minimum for a string.
+func validateSchema(value any, schema types.Document) (bool, error) {
+	for _, keyword := range schema.Keys() {
+		spec := schema.Map()[keyword]
+
+		var valid bool
+		var err error
+		switch keyword {
+		case "bsonType":
+			valid, err = schemaType(value, spec, bsonTypeAliases)
+		case "type":
+			valid, err = schemaType(value, spec, jsonTypeAliases)
+		case "enum":
+			valid, err = schemaEnum(value, spec)
+		case "required", "properties", "additionalProperties", "minProperties", "maxProperties":
+			valid, err = schemaObject(value, keyword, spec, schema)
+		case "items", "minItems", "maxItems", "uniqueItems":
+			valid, err = schemaArray(value, keyword, spec)
+		case "minimum", "maximum", "multipleOf":
+			valid, err = schemaNumber(value, keyword, spec, schema)
+		case "minLength", "maxLength", "pattern":
+			valid, err = schemaString(value, keyword, spec)
+		case "allOf", "anyOf", "oneOf":
+			valid, err = schemaCombination(value, keyword, spec)
+		case "not":
+			var sub types.Document
+			if sub, err = schemaDocument(keyword, spec); err == nil {
+				valid, err = validateSchema(value, sub)
+				valid = !valid
+			}
+		case "exclusiveMinimum", "exclusiveMaximum", "title", "description":
+			// exclusiveMinimum and exclusiveMaximum are applied with minimum and maximum
+			valid = true
+		default:
+			return false, NewErrorMessage(ErrNotImplemented, "$jsonSchema keyword '%s' is not implemented yet", keyword)
+		}
+
+		if err != nil || !valid {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// jsonTypeAliases maps the JSON types of the keyword type to BSON type numbers.
+// "number" is handled like by $type.
+var jsonTypeAliases = map[string]int32{
+	"object":  3,
+	"array":   4,
+	"boolean": 8,
+	"null":    10,
+	"string":  2,
+}
+
+// schemaType evaluates bsonType and type, given as a type alias or an array of type aliases.
+func schemaType(value any, spec any, aliases map[string]int32) (bool, error) {
+	names := []any{spec}
+	if arr, ok := spec.(*types.Array); ok {
+		names = make([]any, arr.Len())
+		for i := range names {
+			names[i] = must(arr.Get(i))
+		}
+	}
+
+	n := bsonTypeNumber(value)
+	for _, name := range names {
+		name, ok := name.(string)
+		if !ok {
+			return false, NewErrorMessage(ErrTypeMismatch, "$jsonSchema type must be a string or an array of strings")
+		}
+
+		if name == "number" {
+			for _, t := range numberTypes {
+				if n == t {
+					return true, nil
+				}
+			}
+			continue
+		}
+
+		t, ok := aliases[name]
+		if !ok {
+			return false, NewErrorMessage(ErrBadValue, "Unknown type name alias: %s", name)
+		}
+		if n == t {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// schemaEnum evaluates enum: the value equals one of the values.
+func schemaEnum(value any, spec any) (bool, error) {
+	values, ok := spec.(*types.Array)
+	if !ok || values.Len() == 0 {
+		return false, NewErrorMessage(ErrFailedToParse, "$jsonSchema keyword 'enum' must be a non-empty array")
+	}
+
+	for i := 0; i < values.Len(); i++ {
+		if EqualValues(value, must(values.Get(i))) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// schemaObject evaluates the keywords of documents.
+func schemaObject(value any, keyword string, spec any, schema types.Document) (bool, error) {
+	doc, ok := value.(types.Document)
+	if !ok {
+		return true, nil
+	}
+
+	switch keyword {
+	case "required":
+		names, ok := spec.(*types.Array)
+		if !ok {
+			return false, NewErrorMessage(ErrTypeMismatch, "$jsonSchema keyword 'required' must be an array")
+		}
+
+		for i := 0; i < names.Len(); i++ {
+			name, ok := must(names.Get(i)).(string)
+			if !ok {
+				return false, NewErrorMessage(ErrTypeMismatch, "$jsonSchema keyword 'required' must contain strings")
+			}
+			if _, err := doc.Get(name); err != nil {
+				return false, nil
+			}
+		}
+
+	case "properties":
+		properties, err := schemaDocument(keyword, spec)
+		if err != nil {
+			return false, err
+		}
+
+		for _, name := range properties.Keys() {
+			propertyValue, err := doc.Get(name)
+			if err != nil {
+				continue
+			}
+
+			propertySchema, err := schemaDocument(keyword, properties.Map()[name])
+			if err != nil {
+				return false, err
+			}
+
+			if valid, err := validateSchema(propertyValue, propertySchema); err != nil || !valid {
+				return false, err
+			}
+		}
+
+	case "additionalProperties":
+		properties, _ := schema.Map()["properties"].(types.Document)
+		for _, name := range doc.Keys() {
+			if _, err := properties.Get(name); err == nil {
+				continue
+			}
+
+			switch spec := spec.(type) {
+			case bool:
+				if !spec {
+					return false, nil
+				}
+			case types.Document:
+				if valid, err := validateSchema(doc.Map()[name], spec); err != nil || !valid {
+					return false, err
+				}
+			default:
+				return false, NewErrorMessage(ErrTypeMismatch, "$jsonSchema keyword 'additionalProperties' must be a boolean or an object")
+			}
+		}
+
+	case "minProperties", "maxProperties":
+		limit, err := schemaLimit(keyword, spec)
+		if err != nil {
+			return false, err
+		}
+
+		n := int64(len(doc.Keys()))
+		return (keyword == "minProperties" && n >= limit) || (keyword == "maxProperties" && n <= limit), nil
+	}
+
+	return true, nil
+}
+
+// schemaArray evaluates the keywords of arrays.
+func schemaArray(value any, keyword string, spec any) (bool, error) {
+	arr, ok := value.(*types.Array)
+	if !ok {
+		return true, nil
+	}
+
+	switch keyword {
+	case "items":
+		switch spec := spec.(type) {
+		case types.Document:
+			for i := 0; i < arr.Len(); i++ {
+				if valid, err := validateSchema(must(arr.Get(i)), spec); err != nil || !valid {
+					return false, err
+				}
+			}
+
+		case *types.Array:
+			// schemas of the elements at the same positions
+			for i := 0; i < arr.Len() && i < spec.Len(); i++ {
+				itemSchema, err := schemaDocument(keyword, must(spec.Get(i)))
+				if err != nil {
+					return false, err
+				}
+
+				if valid, err := validateSchema(must(arr.Get(i)), itemSchema); err != nil || !valid {
+					return false, err
+				}
+			}
+
+		default:
+			return false, NewErrorMessage(ErrTypeMismatch, "$jsonSchema keyword 'items' must be an array or an object")
+		}
+
+	case "minItems", "maxItems":
+		limit, err := schemaLimit(keyword, spec)
+		if err != nil {
+			return false, err
+		}
+
+		n := int64(arr.Len())
+		return (keyword == "minItems" && n >= limit) || (keyword == "maxItems" && n <= limit), nil
+
+	case "uniqueItems":
+		unique, ok := spec.(bool)
+		if !ok {
+			return false, NewErrorMessage(ErrTypeMismatch, "$jsonSchema keyword 'uniqueItems' must be a boolean")
+		}
+
+		if unique {
+			for i := 0; i < arr.Len(); i++ {
+				for j := i + 1; j < arr.Len(); j++ {
+					if EqualValues(must(arr.Get(i)), must(arr.Get(j))) {
+						return false, nil
+					}
+				}
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// schemaNumber evaluates the keywords of numbers.
+func schemaNumber(value any, keyword string, spec any, schema types.Document) (bool, error) {
+	if !isNumber(spec) {
+		return false, NewErrorMessage(ErrTypeMismatch, "$jsonSchema keyword '%s' must be a number", keyword)
+	}
+
+	if !isNumber(value) {
+		return true, nil
+	}
+
+	switch keyword {
+	case "minimum":
+		res := compareNumbers(value, spec)
+		if exclusive, _ := schema.Map()["exclusiveMinimum"].(bool); exclusive {
+			return res > 0, nil
+		}
+		return res >= 0, nil
+
+	case "maximum":
+		res := compareNumbers(value, spec)
+		if exclusive, _ := schema.Map()["exclusiveMaximum"].(bool); exclusive {
+			return res < 0, nil
+		}
+		return res <= 0, nil
+
+	default:
+		divisor := toFloat64(spec)
+		if divisor <= 0 {
+			return false, NewErrorMessage(ErrFailedToParse, "$jsonSchema keyword 'multipleOf' must have a positive value")
+		}
+
+		quotient := toFloat64(value) / divisor
+		return quotient == math.Trunc(quotient), nil
+	}
+}
+
+// schemaString evaluates the keywords of strings.
+func schemaString(value any, keyword string, spec any) (bool, error) {
+	if keyword == "pattern" {
+		if _, ok := spec.(string); !ok {
+			return false, NewErrorMessage(ErrTypeMismatch, "$jsonSchema keyword 'pattern' must be a string")
+		}
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return true, nil
+	}
+
+	if keyword == "pattern" {
+		re, err := CompileRegex(spec.(string), "")
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(s), nil
+	}
+
+	limit, err := schemaLimit(keyword, spec)
+	if err != nil {
+		return false, err
+	}
+
+	n := int64(utf8.RuneCountInString(s))
+	return (keyword == "minLength" && n >= limit) || (keyword == "maxLength" && n <= limit), nil
+}
+
+// schemaCombination evaluates allOf, anyOf and oneOf.
+func schemaCombination(value any, keyword string, spec any) (bool, error) {
+	schemas, ok := spec.(*types.Array)
+	if !ok || schemas.Len() == 0 {
+		return false, NewErrorMessage(ErrFailedToParse, "$jsonSchema keyword '%s' must be a non-empty array", keyword)
+	}
+
+	var validCount int
+	for i := 0; i < schemas.Len(); i++ {
+		schema, err := schemaDocument(keyword, must(schemas.Get(i)))
+		if err != nil {
+			return false, err
+		}
+
+		valid, err := validateSchema(value, schema)
+		if err != nil {
+			return false, err
+		}
+		if valid {
+			validCount++
+		}
+	}
+
+	switch keyword {
+	case "allOf":
+		return validCount == schemas.Len(), nil
+	case "anyOf":
+		return validCount > 0, nil
+	default:
+		return validCount == 1, nil
+	}
+}
+
+// schemaDocument returns the schema given for the keyword.
+func schemaDocument(keyword string, spec any) (types.Document, error) {
+	schema, ok := spec.(types.Document)
+	if !ok {
+		return schema, NewErrorMessage(ErrTypeMismatch, "$jsonSchema keyword '%s' must be an object", keyword)
+	}
+
+	return schema, nil
+}
+
+// schemaLimit returns the non-negative integer given for a keyword like minLength.
+func schemaLimit(keyword string, spec any) (int64, error) {
+	limit, ok := toInt64(spec)
+	if !ok || limit < 0 {
+		return 0, NewErrorMessage(ErrFailedToParse, "$jsonSchema keyword '%s' must be a non-negative integer", keyword)
+	}
+
+	return limit, nil
+}
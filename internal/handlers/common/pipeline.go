@@ -0,0 +1,721 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+)
+
+// ParsePipeline checks that every stage of the pipeline is a document with exactly one stage name
+// and returns the stages.
+func ParsePipeline(pipeline *types.Array) (stages []types.Document, err error) {
+	for i := 0; i < pipeline.Len(); i++ {
+		stage, ok := must(pipeline.Get(i)).(types.Document)
+		if !ok {
+			return nil, NewErrorMessage(ErrBadValue, "Each element of the 'pipeline' array must be an object")
+		}
+
+		if len(stage.Keys()) != 1 {
+			return nil, NewErrorMessage(ErrBadValue, "A pipeline stage specification object must contain exactly one field.")
+		}
+
+		stages = append(stages, stage)
+	}
+
+	return
+}
+
+// ProcessStages runs the given pipeline stages in memory.
+func ProcessStages(docs []types.Document, stages []types.Document) ([]types.Document, error) {
+	var err error
+	for _, stage := range stages {
+		name := stage.Command()
+		value := stage.Map()[name]
+
+		switch name {
+		case "$match":
+			docs, err = stageMatch(docs, value)
+		case "$project":
+			docs, err = stageProject(docs, value)
+		case "$sort":
+			docs, err = stageSort(docs, value)
+		case "$skip":
+			docs, err = stageSkip(docs, value)
+		case "$limit":
+			docs, err = stageLimit(docs, value)
+		case "$count":
+			docs, err = stageCount(docs, value)
+		case "$group":
+			docs, err = stageGroup(docs, value)
+		default:
+			return nil, NewErrorMessage(ErrNotImplemented, "pipeline stage %s is not implemented yet", name)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return docs, nil
+}
+
+// stageMatch filters the documents.
+func stageMatch(docs []types.Document, value any) ([]types.Document, error) {
+	filter, ok := value.(types.Document)
+	if !ok {
+		return nil, NewErrorMessage(ErrBadValue, "the match filter must be an expression in an object")
+	}
+
+	res := make([]types.Document, 0, len(docs))
+	for _, doc := range docs {
+		matches, err := FilterDocument(doc, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		if matches {
+			res = append(res, doc)
+		}
+	}
+
+	return res, nil
+}
+
+// stageProject includes, excludes or computes fields.
+func stageProject(docs []types.Document, value any) ([]types.Document, error) {
+	projection, ok := value.(types.Document)
+	if !ok {
+		return nil, NewErrorMessage(ErrBadValue, "$project specification must be an object")
+	}
+
+	if len(projection.Keys()) == 0 {
+		return nil, NewErrorMessage(ErrBadValue, "$project requires at least one output field")
+	}
+
+	exclusion, err := IsExclusionProjection(projection)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]types.Document, 0, len(docs))
+	for _, doc := range docs {
+		var projected types.Document
+		if exclusion {
+			projected, err = excludeFields(doc, projection)
+		} else {
+			projected, err = includeFields(doc, projection)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, projected)
+	}
+
+	return res, nil
+}
+
+// ApplyProjection applies a projection like the one of find or $project to the document.
+func ApplyProjection(doc types.Document, projection types.Document) (types.Document, error) {
+	if len(projection.Keys()) == 0 {
+		return doc, nil
+	}
+
+	exclusion, err := IsExclusionProjection(projection)
+	if err != nil {
+		return doc, err
+	}
+
+	if exclusion {
+		return excludeFields(doc, projection)
+	}
+
+	return includeFields(doc, projection)
+}
+
+// IsExclusionProjection returns true if all fields of the $project specification are excluded.
+// Excluding _id is allowed in both inclusion and exclusion projections.
+func IsExclusionProjection(projection types.Document) (exclusion bool, err error) {
+	var inclusion bool
+	for _, key := range projection.Keys() {
+		value := projection.Map()[key]
+
+		isExclusion := isFalsy(value)
+		if key == "_id" && isExclusion {
+			continue
+		}
+
+		if isExclusion {
+			if inclusion {
+				return false, NewErrorMessage(ErrProjectionExIn, "Cannot do exclusion on field %s in inclusion projection", key)
+			}
+			exclusion = true
+			continue
+		}
+
+		if exclusion {
+			return false, NewErrorMessage(ErrProjectionInEx, "Cannot do inclusion on field %s in exclusion projection", key)
+		}
+		inclusion = true
+	}
+
+	// {_id: 0} excludes only _id
+	if !inclusion {
+		exclusion = true
+	}
+
+	return
+}
+
+// isFalsy returns true for false and the number 0.
+func isFalsy(value any) bool {
+	switch value := value.(type) {
+	case bool:
+		return !value
+	case int32, int64, float64:
+		return toFloat64(value) == 0
+	default:
+		return false
+	}
+}
+
+// isInclusionValue returns true for true and numbers other than 0.
+func isInclusionValue(value any) bool {
+	switch value.(type) {
+	case bool, int32, int64, float64:
+		return !isFalsy(value)
+	default:
+		return false
+	}
+}
+
+// includeFields returns a new document with the included and computed fields of the projection.
+func includeFields(doc types.Document, projection types.Document) (types.Document, error) {
+	res := types.MustMakeDocument()
+
+	if idValue, ok := projection.Map()["_id"]; !ok || !isFalsy(idValue) {
+		if id, err := doc.Get("_id"); err == nil {
+			if err = res.Set("_id", id); err != nil {
+				return res, err
+			}
+		}
+	}
+
+	for _, key := range projection.Keys() {
+		spec := projection.Map()[key]
+
+		if key == "_id" && isFalsy(spec) {
+			continue
+		}
+
+		path := strings.Split(key, ".")
+
+		if isInclusionValue(spec) {
+			if key == "_id" {
+				continue
+			}
+
+			value, ok := GetPathValue(doc, key)
+			if !ok {
+				continue
+			}
+
+			if err := setPath(&res, path, value); err != nil {
+				return res, err
+			}
+			continue
+		}
+
+		value, err := EvaluateExpression(doc, spec)
+		if err != nil {
+			return res, err
+		}
+
+		if IsMissing(value) {
+			continue
+		}
+
+		if err = setPath(&res, path, value); err != nil {
+			return res, err
+		}
+	}
+
+	return res, nil
+}
+
+// excludeFields returns a copy of the document without the excluded fields.
+func excludeFields(doc types.Document, projection types.Document) (types.Document, error) {
+	res := DeepCopyDocument(doc)
+
+	for _, key := range projection.Keys() {
+		removePath(&res, strings.Split(key, "."))
+	}
+
+	return res, nil
+}
+
+// setPath sets the value at the given path, creating embedded documents as needed.
+func setPath(doc *types.Document, path []string, value any) error {
+	if len(path) == 1 {
+		return doc.Set(path[0], value)
+	}
+
+	next, ok := doc.Map()[path[0]].(types.Document)
+	if !ok {
+		next = types.MustMakeDocument()
+	}
+
+	if err := setPath(&next, path[1:], value); err != nil {
+		return err
+	}
+
+	return doc.Set(path[0], next)
+}
+
+// removePath removes the value at the given path. Embedded documents in arrays are traversed.
+func removePath(doc *types.Document, path []string) {
+	if len(path) == 1 {
+		doc.Remove(path[0])
+		return
+	}
+
+	switch next := doc.Map()[path[0]].(type) {
+	case types.Document:
+		removePath(&next, path[1:])
+		_ = doc.Set(path[0], next)
+
+	case *types.Array:
+		for i := 0; i < next.Len(); i++ {
+			if elem, ok := must(next.Get(i)).(types.Document); ok {
+				removePath(&elem, path[1:])
+				_ = next.Set(i, elem)
+			}
+		}
+	}
+}
+
+// DeepCopyDocument returns a copy of the document which can be modified without changing the original.
+func DeepCopyDocument(doc types.Document) types.Document {
+	return deepCopy(doc).(types.Document)
+}
+
+func deepCopy(value any) any {
+	switch value := value.(type) {
+	case types.Document:
+		res := types.MustMakeDocument()
+		for _, key := range value.Keys() {
+			_ = res.Set(key, deepCopy(value.Map()[key]))
+		}
+		return res
+
+	case *types.Array:
+		res := types.MakeArray(value.Len())
+		for i := 0; i < value.Len(); i++ {
+			_ = res.Append(deepCopy(must(value.Get(i))))
+		}
+		return res
+
+	default:
+		return value
+	}
+}
+
+// stageSort sorts the documents stably.
+func stageSort(docs []types.Document, value any) ([]types.Document, error) {
+	sortSpec, ok := value.(types.Document)
+	if !ok || len(sortSpec.Keys()) == 0 {
+		return nil, NewErrorMessage(ErrSortBadValue, "$sort key specification must be an object")
+	}
+
+	orders := make([]int, len(sortSpec.Keys()))
+	for i, key := range sortSpec.Keys() {
+		order, ok := toInt64(sortSpec.Map()[key])
+		if !ok || (order != 1 && order != -1) {
+			return nil, NewErrorMessage(ErrSortBadValue, "$sort key ordering must be 1 (for ascending) or -1 (for descending)")
+		}
+		orders[i] = int(order)
+	}
+
+	sort.SliceStable(docs, func(i, j int) bool {
+		for k, key := range sortSpec.Keys() {
+			a, _ := GetPathValue(docs[i], key)
+			b, _ := GetPathValue(docs[j], key)
+
+			if res := CompareValues(a, b) * orders[k]; res != 0 {
+				return res < 0
+			}
+		}
+		return false
+	})
+
+	return docs, nil
+}
+
+// stageSkip skips the given number of documents.
+func stageSkip(docs []types.Document, value any) ([]types.Document, error) {
+	skip, ok := toInt64(value)
+	if !ok || skip < 0 {
+		return nil, NewErrorMessage(ErrBadValue, "invalid argument to $skip stage: Expected a non-negative number in: $skip: %v", value)
+	}
+
+	if skip >= int64(len(docs)) {
+		return []types.Document{}, nil
+	}
+
+	return docs[skip:], nil
+}
+
+// stageLimit limits the number of documents.
+func stageLimit(docs []types.Document, value any) ([]types.Document, error) {
+	limit, ok := toInt64(value)
+	if !ok || limit <= 0 {
+		return nil, NewErrorMessage(ErrBadValue, "invalid argument to $limit stage: Expected a positive number in: $limit: %v", value)
+	}
+
+	if limit < int64(len(docs)) {
+		return docs[:limit], nil
+	}
+
+	return docs, nil
+}
+
+// stageCount returns one document with the number of documents.
+func stageCount(docs []types.Document, value any) ([]types.Document, error) {
+	field, err := CountField(value)
+	if err != nil {
+		return nil, err
+	}
+
+	// like MongoDB, no document is returned for no input documents
+	if len(docs) == 0 {
+		return []types.Document{}, nil
+	}
+
+	return []types.Document{types.MustMakeDocument(field, int32(len(docs)))}, nil
+}
+
+// CountField validates the field name of a $count stage.
+func CountField(value any) (string, error) {
+	field, ok := value.(string)
+	switch {
+	case !ok:
+		return "", NewErrorMessage(ErrBadValue, "the count field must be a non-empty string")
+	case field == "":
+		return "", NewErrorMessage(ErrBadValue, "the count field must be a non-empty string")
+	case strings.HasPrefix(field, "$"):
+		return "", NewErrorMessage(ErrBadValue, "the count field cannot be a $-prefixed path")
+	case strings.Contains(field, "."):
+		return "", NewErrorMessage(ErrBadValue, "the count field cannot contain '.'")
+	}
+
+	return field, nil
+}
+
+// group holds the state of one group of a $group stage.
+type group struct {
+	id     any
+	values []any
+	counts []int64
+	sets   []*types.Array
+}
+
+// stageGroup groups the documents by the _id expression and computes the accumulators.
+func stageGroup(docs []types.Document, value any) ([]types.Document, error) {
+	spec, ok := value.(types.Document)
+	if !ok {
+		return nil, NewErrorMessage(ErrBadValue, "a group's fields must be specified in an object")
+	}
+
+	idExpr, ok := spec.Map()["_id"]
+	if !ok {
+		return nil, NewErrorMessage(ErrBadValue, "a group specification must include an _id")
+	}
+
+	var fields []string
+	var accumulators []Accumulator
+	for _, key := range spec.Keys() {
+		if key == "_id" {
+			continue
+		}
+
+		acc, err := ParseAccumulator(key, spec.Map()[key])
+		if err != nil {
+			return nil, err
+		}
+
+		fields = append(fields, key)
+		accumulators = append(accumulators, acc)
+	}
+
+	var groups []*group
+	index := make(map[string]*group)
+
+	for _, doc := range docs {
+		id, err := EvaluateExpression(doc, idExpr)
+		if err != nil {
+			return nil, err
+		}
+		if IsMissing(id) {
+			id = nil
+		}
+
+		key := ValueKey(id)
+		g, ok := index[key]
+		if !ok {
+			g = &group{
+				id:     id,
+				values: make([]any, len(accumulators)),
+				counts: make([]int64, len(accumulators)),
+				sets:   make([]*types.Array, len(accumulators)),
+			}
+			for i := range g.values {
+				g.values[i] = missing{}
+			}
+
+			index[key] = g
+			groups = append(groups, g)
+		}
+
+		for i, acc := range accumulators {
+			if err = acc.accumulate(doc, g, i); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	res := make([]types.Document, 0, len(groups))
+	for _, g := range groups {
+		doc := types.MustMakeDocument("_id", g.id)
+
+		for i, acc := range accumulators {
+			if err := doc.Set(fields[i], acc.result(g, i)); err != nil {
+				return nil, err
+			}
+		}
+
+		res = append(res, doc)
+	}
+
+	return res, nil
+}
+
+// ValueKey returns a string which is equal for values considered equal by $group and distinct.
+func ValueKey(value any) string {
+	switch value := value.(type) {
+	case int32, int64, float64:
+		if i, ok := toInt64(value); ok {
+			return "n" + strconv.FormatInt(i, 10)
+		}
+		return "n" + strconv.FormatFloat(toFloat64(value), 'g', -1, 64)
+
+	case types.Document:
+		var sb strings.Builder
+		sb.WriteString("{")
+		for _, key := range value.Keys() {
+			sb.WriteString(strconv.Quote(key) + ":" + ValueKey(value.Map()[key]) + ",")
+		}
+		sb.WriteString("}")
+		return sb.String()
+
+	case *types.Array:
+		var sb strings.Builder
+		sb.WriteString("[")
+		for i := 0; i < value.Len(); i++ {
+			sb.WriteString(ValueKey(must(value.Get(i))) + ",")
+		}
+		sb.WriteString("]")
+		return sb.String()
+
+	case string:
+		return "s" + strconv.Quote(value)
+
+	default:
+		return fmt.Sprintf("%d%#v", typeOrder(value), value)
+	}
+}
+
+// Accumulator is an accumulator expression of a $group stage like {$sum: "$field"}.
+type Accumulator struct {
+	Operator string
+	Expr     any
+}
+
+// ParseAccumulator validates the accumulator of the given field.
+func ParseAccumulator(field string, value any) (acc Accumulator, err error) {
+	if strings.Contains(field, ".") {
+		return acc, NewErrorMessage(ErrBadValue, "the group aggregate field name '%s' cannot contain '.'", field)
+	}
+
+	spec, ok := value.(types.Document)
+	if !ok || len(spec.Keys()) != 1 {
+		return acc, NewErrorMessage(ErrBadValue, "the group aggregate field '%s' must be defined as an expression inside an object", field)
+	}
+
+	acc.Operator = spec.Keys()[0]
+	acc.Expr = spec.Map()[acc.Operator]
+
+	switch acc.Operator {
+	case "$sum", "$avg", "$min", "$max", "$first", "$last", "$push", "$addToSet":
+	case "$count":
+		if args, ok := acc.Expr.(types.Document); !ok || len(args.Keys()) != 0 {
+			return acc, NewErrorMessage(ErrBadValue, "$count takes no arguments, i.e. $count:{}")
+		}
+	default:
+		return acc, NewErrorMessage(ErrNotImplemented, "accumulator %s is not implemented yet", acc.Operator)
+	}
+
+	return acc, nil
+}
+
+// accumulate adds the value of the document to the i-th accumulator of the group.
+func (acc Accumulator) accumulate(doc types.Document, g *group, i int) error {
+	if acc.Operator == "$count" {
+		g.values[i] = addNumbers(g.values[i], int32(1))
+		return nil
+	}
+
+	value, err := EvaluateExpression(doc, acc.Expr)
+	if err != nil {
+		return err
+	}
+
+	switch acc.Operator {
+	case "$sum":
+		if isNumber(value) {
+			g.values[i] = addNumbers(g.values[i], value)
+		} else if IsMissing(g.values[i]) {
+			g.values[i] = int32(0)
+		}
+
+	case "$avg":
+		if isNumber(value) {
+			g.values[i] = addNumbers(g.values[i], value)
+			g.counts[i]++
+		}
+
+	case "$min", "$max":
+		if IsMissing(value) || value == nil {
+			return nil
+		}
+
+		current := g.values[i]
+		switch {
+		case IsMissing(current):
+			g.values[i] = value
+		case acc.Operator == "$min" && CompareValues(value, current) < 0:
+			g.values[i] = value
+		case acc.Operator == "$max" && CompareValues(value, current) > 0:
+			g.values[i] = value
+		}
+
+	case "$first":
+		if g.counts[i] == 0 {
+			g.values[i] = value
+			g.counts[i]++
+		}
+
+	case "$last":
+		g.values[i] = value
+
+	case "$push", "$addToSet":
+		if g.sets[i] == nil {
+			g.sets[i] = new(types.Array)
+		}
+
+		if IsMissing(value) {
+			return nil
+		}
+
+		if acc.Operator == "$addToSet" {
+			for j := 0; j < g.sets[i].Len(); j++ {
+				if EqualValues(must(g.sets[i].Get(j)), value) {
+					return nil
+				}
+			}
+		}
+
+		return g.sets[i].Append(value)
+	}
+
+	return nil
+}
+
+// result returns the final value of the i-th accumulator of the group.
+func (acc Accumulator) result(g *group, i int) any {
+	value := g.values[i]
+
+	switch acc.Operator {
+	case "$sum", "$count":
+		if IsMissing(value) {
+			return int32(0)
+		}
+
+	case "$avg":
+		if g.counts[i] == 0 {
+			return nil
+		}
+		return toFloat64(value) / float64(g.counts[i])
+
+	case "$push", "$addToSet":
+		if g.sets[i] == nil {
+			return new(types.Array)
+		}
+		return g.sets[i]
+	}
+
+	if IsMissing(value) {
+		return nil
+	}
+
+	return value
+}
+
+// isNumber returns true for int32, int64 and float64.
+func isNumber(value any) bool {
+	switch value.(type) {
+	case int32, int64, float64:
+		return true
+	default:
+		return false
+	}
+}
+
+// addNumbers adds two numbers. A missing value counts as 0.
+// The result is int32 if possible, int64 on int32 overflow and float64 if any number is a float64.
+func addNumbers(a, b any) any {
+	if IsMissing(a) {
+		return b
+	}
+
+	_, aIsFloat := a.(float64)
+	_, bIsFloat := b.(float64)
+	if aIsFloat || bIsFloat {
+		return toFloat64(a) + toFloat64(b)
+	}
+
+	ai, _ := toInt64(a)
+	bi, _ := toInt64(b)
+
+	sum := ai + bi
+	if (sum > ai) != (bi > 0) {
+		// int64 overflow
+		return float64(ai) + float64(bi)
+	}
+
+	_, aIsInt64 := a.(int64)
+	_, bIsInt64 := b.(int64)
+	if !aIsInt64 && !bIsInt64 && sum >= math.MinInt32 && sum <= math.MaxInt32 {
+		return int32(sum)
+	}
+
+	return sum
+}
@@ -0,0 +1,320 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+)
+
+func testDocuments() []types.Document {
+	return []types.Document{
+		types.MustMakeDocument("_id", int32(1), "item", "a", "qty", int32(5), "price", float64(2.5), "tags", types.MustNewArray("x", "y")),
+		types.MustMakeDocument("_id", int32(2), "item", "b", "qty", int32(10), "price", float64(1), "tags", types.MustNewArray("y")),
+		types.MustMakeDocument("_id", int32(3), "item", "a", "qty", int64(15), "price", float64(4)),
+		types.MustMakeDocument("_id", int32(4), "item", "c", "size", types.MustMakeDocument("h", int32(2))),
+	}
+}
+
+func TestFilterDocument(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		filter   types.Document
+		expected []int32
+		err      string
+	}{
+		"Equal": {
+			filter:   types.MustMakeDocument("item", "a"),
+			expected: []int32{1, 3},
+		},
+		"EqualArrayElement": {
+			filter:   types.MustMakeDocument("tags", "y"),
+			expected: []int32{1, 2},
+		},
+		"EqualNull": {
+			filter:   types.MustMakeDocument("qty", nil),
+			expected: []int32{4},
+		},
+		"Nested": {
+			filter:   types.MustMakeDocument("size.h", types.MustMakeDocument("$gte", float64(2))),
+			expected: []int32{4},
+		},
+		"Comparison": {
+			filter:   types.MustMakeDocument("qty", types.MustMakeDocument("$gt", int32(5), "$lte", float64(15))),
+			expected: []int32{2, 3},
+		},
+		"ComparisonTypeBracket": {
+			filter:   types.MustMakeDocument("item", types.MustMakeDocument("$gt", int32(0))),
+			expected: nil,
+		},
+		"Ne": {
+			filter:   types.MustMakeDocument("item", types.MustMakeDocument("$ne", "a")),
+			expected: []int32{2, 4},
+		},
+		"Exists": {
+			filter:   types.MustMakeDocument("tags", types.MustMakeDocument("$exists", false)),
+			expected: []int32{3, 4},
+		},
+		"Size": {
+			filter:   types.MustMakeDocument("tags", types.MustMakeDocument("$size", int32(2))),
+			expected: []int32{1},
+		},
+		"All": {
+			filter:   types.MustMakeDocument("tags", types.MustMakeDocument("$all", types.MustNewArray("x", "y"))),
+			expected: []int32{1},
+		},
+		"In": {
+			filter:   types.MustMakeDocument("qty", types.MustMakeDocument("$in", types.MustNewArray(float64(5), "10"))),
+			expected: []int32{1},
+		},
+		"InArrayElement": {
+			filter:   types.MustMakeDocument("tags", types.MustMakeDocument("$in", types.MustNewArray("x", "z"))),
+			expected: []int32{1},
+		},
+		"InNullAndRegex": {
+			filter:   types.MustMakeDocument("tags", types.MustMakeDocument("$in", types.MustNewArray(nil, types.Regex{Pattern: "^Y$", Options: "i"}))),
+			expected: []int32{1, 2, 3, 4},
+		},
+		"Nin": {
+			filter:   types.MustMakeDocument("tags", types.MustMakeDocument("$nin", types.MustNewArray("x"))),
+			expected: []int32{2, 3, 4},
+		},
+		"InNotArray": {
+			filter: types.MustMakeDocument("qty", types.MustMakeDocument("$in", int32(5))),
+			err:    "BadValue (2): $in needs an array",
+		},
+		"Type": {
+			filter:   types.MustMakeDocument("qty", types.MustMakeDocument("$type", "int")),
+			expected: []int32{1, 2},
+		},
+		"TypeNumberAlias": {
+			filter:   types.MustMakeDocument("qty", types.MustMakeDocument("$type", "number")),
+			expected: []int32{1, 2, 3},
+		},
+		"TypeCodesOfArrayElements": {
+			filter:   types.MustMakeDocument("tags", types.MustMakeDocument("$type", types.MustNewArray(int32(2), float64(3)))),
+			expected: []int32{1, 2},
+		},
+		"TypeUnknownAlias": {
+			filter: types.MustMakeDocument("qty", types.MustMakeDocument("$type", "integer")),
+			err:    "BadValue (2): Unknown type name alias: integer",
+		},
+		"Mod": {
+			filter:   types.MustMakeDocument("price", types.MustMakeDocument("$mod", types.MustNewArray(int32(2), int32(0)))),
+			expected: []int32{1, 3},
+		},
+		"ModZeroDivisor": {
+			filter: types.MustMakeDocument("qty", types.MustMakeDocument("$mod", types.MustNewArray(int32(0), int32(1)))),
+			err:    "BadValue (2): divisor cannot be 0",
+		},
+		"Expr": {
+			filter: types.MustMakeDocument("$expr", types.MustMakeDocument("$gt", types.MustNewArray(
+				"$qty", types.MustMakeDocument("$literal", int32(5)),
+			))),
+			expected: []int32{2, 3},
+		},
+		"ExprFields": {
+			filter: types.MustMakeDocument("$expr", types.MustMakeDocument("$and", types.MustNewArray(
+				types.MustMakeDocument("$lt", types.MustNewArray("$price", "$qty")),
+				types.MustMakeDocument("$not", types.MustNewArray(types.MustMakeDocument("$eq", types.MustNewArray("$item", "b")))),
+			))),
+			expected: []int32{1, 3},
+		},
+		"JSONSchema": {
+			filter: types.MustMakeDocument("$jsonSchema", types.MustMakeDocument(
+				"required", types.MustNewArray("item", "qty"),
+				"properties", types.MustMakeDocument(
+					"qty", types.MustMakeDocument("bsonType", "int", "minimum", int32(5), "exclusiveMinimum", true),
+					"tags", types.MustMakeDocument("type", "array", "items", types.MustMakeDocument("enum", types.MustNewArray("x", "y"))),
+				),
+			)),
+			expected: []int32{2},
+		},
+		"JSONSchemaUnknownKeyword": {
+			filter: types.MustMakeDocument("$jsonSchema", types.MustMakeDocument("dependencies", types.MustMakeDocument())),
+			err:    "NotImplemented (238): $jsonSchema keyword 'dependencies' is not implemented yet",
+		},
+		"Or": {
+			filter: types.MustMakeDocument("$or", types.MustNewArray(
+				types.MustMakeDocument("item", "b"),
+				types.MustMakeDocument("qty", int32(15)),
+			)),
+			expected: []int32{2, 3},
+		},
+		"Nor": {
+			filter: types.MustMakeDocument("$nor", types.MustNewArray(
+				types.MustMakeDocument("item", "a"),
+				types.MustMakeDocument("item", "b"),
+			)),
+			expected: []int32{4},
+		},
+		"Not": {
+			filter:   types.MustMakeDocument("qty", types.MustMakeDocument("$not", types.MustMakeDocument("$gt", int32(5)))),
+			expected: []int32{1, 4},
+		},
+		"Regex": {
+			filter:   types.MustMakeDocument("item", types.MustMakeDocument("$regex", "^[AB]$", "$options", "i")),
+			expected: []int32{1, 2, 3},
+		},
+		"UnknownOperator": {
+			filter: types.MustMakeDocument("item", types.MustMakeDocument("$where", "true")),
+			err:    "NotImplemented (238): support for $where is not implemented yet",
+		},
+	} {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var actual []int32
+			for _, doc := range testDocuments() {
+				matches, err := FilterDocument(doc, tc.filter)
+				if tc.err != "" {
+					require.EqualError(t, err, tc.err)
+					return
+				}
+				require.NoError(t, err)
+
+				if matches {
+					actual = append(actual, doc.Map()["_id"].(int32))
+				}
+			}
+
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestProcessStages(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		pipeline *types.Array
+		expected []types.Document
+		err      string
+	}{
+		"MatchSortSkipLimit": {
+			pipeline: types.MustNewArray(
+				types.MustMakeDocument("$match", types.MustMakeDocument("qty", types.MustMakeDocument("$exists", true))),
+				types.MustMakeDocument("$sort", types.MustMakeDocument("qty", int32(-1))),
+				types.MustMakeDocument("$skip", int32(1)),
+				types.MustMakeDocument("$limit", int32(1)),
+				types.MustMakeDocument("$project", types.MustMakeDocument("_id", int32(0), "item", true)),
+			),
+			expected: []types.Document{types.MustMakeDocument("item", "b")},
+		},
+		"ProjectComputedAndNested": {
+			pipeline: types.MustNewArray(
+				types.MustMakeDocument("$match", types.MustMakeDocument("_id", int32(4))),
+				types.MustMakeDocument("$project", types.MustMakeDocument(
+					"height", "$size.h",
+					"info.item", "$item",
+					"const", types.MustMakeDocument("$literal", "$item"),
+				)),
+			),
+			expected: []types.Document{types.MustMakeDocument(
+				"_id", int32(4),
+				"height", int32(2),
+				"info", types.MustMakeDocument("item", "c"),
+				"const", "$item",
+			)},
+		},
+		"ProjectExclusion": {
+			pipeline: types.MustNewArray(
+				types.MustMakeDocument("$match", types.MustMakeDocument("_id", int32(4))),
+				types.MustMakeDocument("$project", types.MustMakeDocument("size.h", int32(0), "item", false)),
+			),
+			expected: []types.Document{types.MustMakeDocument("_id", int32(4), "size", types.MustMakeDocument())},
+		},
+		"Group": {
+			pipeline: types.MustNewArray(
+				types.MustMakeDocument("$group", types.MustMakeDocument(
+					"_id", "$item",
+					"total", types.MustMakeDocument("$sum", "$qty"),
+					"count", types.MustMakeDocument("$sum", int32(1)),
+					"avg", types.MustMakeDocument("$avg", "$price"),
+					"min", types.MustMakeDocument("$min", "$qty"),
+					"max", types.MustMakeDocument("$max", "$qty"),
+					"first", types.MustMakeDocument("$first", "$_id"),
+					"ids", types.MustMakeDocument("$push", "$_id"),
+				)),
+				types.MustMakeDocument("$sort", types.MustMakeDocument("_id", int32(1))),
+			),
+			expected: []types.Document{
+				types.MustMakeDocument(
+					"_id", "a", "total", int64(20), "count", int32(2), "avg", float64(3.25), "min", int32(5), "max", int64(15),
+					"first", int32(1), "ids", types.MustNewArray(int32(1), int32(3)),
+				),
+				types.MustMakeDocument(
+					"_id", "b", "total", int32(10), "count", int32(1), "avg", float64(1), "min", int32(10), "max", int32(10),
+					"first", int32(2), "ids", types.MustNewArray(int32(2)),
+				),
+				types.MustMakeDocument(
+					"_id", "c", "total", int32(0), "count", int32(1), "avg", nil, "min", nil, "max", nil,
+					"first", int32(4), "ids", types.MustNewArray(int32(4)),
+				),
+			},
+		},
+		"GroupNullID": {
+			pipeline: types.MustNewArray(
+				types.MustMakeDocument("$group", types.MustMakeDocument(
+					"_id", nil,
+					"n", types.MustMakeDocument("$count", types.MustMakeDocument()),
+				)),
+			),
+			expected: []types.Document{types.MustMakeDocument("_id", nil, "n", int32(4))},
+		},
+		"Count": {
+			pipeline: types.MustNewArray(
+				types.MustMakeDocument("$match", types.MustMakeDocument("item", "a")),
+				types.MustMakeDocument("$count", "n"),
+			),
+			expected: []types.Document{types.MustMakeDocument("n", int32(2))},
+		},
+		"CountNone": {
+			pipeline: types.MustNewArray(
+				types.MustMakeDocument("$match", types.MustMakeDocument("item", "z")),
+				types.MustMakeDocument("$count", "n"),
+			),
+			expected: []types.Document{},
+		},
+		"InvalidLimit": {
+			pipeline: types.MustNewArray(types.MustMakeDocument("$limit", int32(0))),
+			err:      "BadValue (2): invalid argument to $limit stage: Expected a positive number in: $limit: 0",
+		},
+		"UnknownStage": {
+			pipeline: types.MustNewArray(types.MustMakeDocument("$lookup", types.MustMakeDocument())),
+			err:      "NotImplemented (238): pipeline stage $lookup is not implemented yet",
+		},
+		"UnknownAccumulator": {
+			pipeline: types.MustNewArray(types.MustMakeDocument("$group", types.MustMakeDocument(
+				"_id", nil,
+				"x", types.MustMakeDocument("$stdDevPop", "$qty"),
+			))),
+			err: "NotImplemented (238): accumulator $stdDevPop is not implemented yet",
+		},
+	} {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			stages, err := ParsePipeline(tc.pipeline)
+			require.NoError(t, err)
+
+			actual, err := ProcessStages(testDocuments(), stages)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
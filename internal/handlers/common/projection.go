@@ -21,10 +21,10 @@
 package common
 
 import (
-	"fmt"
 	"strconv"
 	"strings"
 
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
 )
@@ -183,12 +183,12 @@ func inclusionProjection(projection types.Document) (sql string) {
 		}
 
 		id = false
-		sql += "\"" + k + "\": \"" + k + "\""
+		field := hana.QuoteIdentifier(k)
+		sql += field + ": " + field
 
 	}
 
 	sql += "}"
-	fmt.Println(sql)
 	return
 }
 
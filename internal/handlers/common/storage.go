@@ -24,10 +24,39 @@ import (
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
 )
 
+// ownerKey is the context key of the authenticated user of the client.
+type ownerKey struct{}
+
+// WithOwner returns a context carrying the authenticated user of the client, like "user@db".
+// Cursors and sessions created with that context belong to that user.
+func WithOwner(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, ownerKey{}, owner)
+}
+
+// Owner returns the authenticated user of the client.
+// It is empty if authentication is disabled.
+func Owner(ctx context.Context) string {
+	owner, _ := ctx.Value(ownerKey{}).(string)
+	return owner
+}
+
 type Storage interface {
+	MsgAbortTransaction(context.Context, *wire.OpMsg) (*wire.OpMsg, error)
+	MsgAggregate(context.Context, *wire.OpMsg) (*wire.OpMsg, error)
+	MsgCommitTransaction(context.Context, *wire.OpMsg) (*wire.OpMsg, error)
 	MsgCreateIndexes(context.Context, *wire.OpMsg) (*wire.OpMsg, error)
 	MsgDelete(context.Context, *wire.OpMsg) (*wire.OpMsg, error)
+	MsgDistinct(context.Context, *wire.OpMsg) (*wire.OpMsg, error)
+	MsgDropIndexes(context.Context, *wire.OpMsg) (*wire.OpMsg, error)
+	MsgEndSessions(context.Context, *wire.OpMsg) (*wire.OpMsg, error)
+	MsgFindAndModify(context.Context, *wire.OpMsg) (*wire.OpMsg, error)
 	MsgFindOrCount(context.Context, *wire.OpMsg) (*wire.OpMsg, error)
+	MsgGetMore(context.Context, *wire.OpMsg) (*wire.OpMsg, error)
 	MsgInsert(context.Context, *wire.OpMsg) (*wire.OpMsg, error)
+	MsgKillCursors(context.Context, *wire.OpMsg) (*wire.OpMsg, error)
+	MsgKillSessions(context.Context, *wire.OpMsg) (*wire.OpMsg, error)
+	MsgListIndexes(context.Context, *wire.OpMsg) (*wire.OpMsg, error)
+	MsgRefreshSessions(context.Context, *wire.OpMsg) (*wire.OpMsg, error)
+	MsgStartSession(context.Context, *wire.OpMsg) (*wire.OpMsg, error)
 	MsgUpdate(context.Context, *wire.OpMsg) (*wire.OpMsg, error)
 }
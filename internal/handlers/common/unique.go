@@ -5,7 +5,6 @@
 package common
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"strings"
@@ -15,43 +14,36 @@ import (
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
 )
 
-// IsIdUnique will check if _id for a document is unique before insertion
-func IsIdUnique(id any, db, collection string, ctx context.Context, hanapool *hana.Hpool) (unique bool, errMsg error, err error) {
-	sql := "SELECT _id FROM %s.%s "
-
-	whereSQL, errSQL := CreateWhereClause(types.MustMakeDocument([]any{"_id", id}...))
-	if errSQL != nil {
-		err = errSQL
-		return
+// CheckDuplicateKey maps a violation of a unique index reported by SAP HANA to a DuplicateKey error.
+// If the _id index was violated, the _id of the written document is part of the message.
+// Any other error is returned unchanged.
+func CheckDuplicateKey(err error, db, collection string, doc types.Document) error {
+	sqlIndex, ok := hana.UniqueViolation(err)
+	if !ok {
+		return err
 	}
 
-	sql = fmt.Sprintf(sql, db, collection) + whereSQL + " LIMIT 1"
-
-	var returnValue any
-	ScanErr := hanapool.QueryRowContext(ctx, sql).Scan(&returnValue)
+	msg := fmt.Sprintf("E11000 duplicate key error collection: %s.%s", db, collection)
+	if sqlIndex == "" {
+		return NewError(ErrDuplicateKey, errors.New(msg))
+	}
 
-	if ScanErr != nil {
-		if strings.EqualFold(ScanErr.Error(), "sql: no rows in result set") {
-			unique = true
+	index := strings.TrimPrefix(sqlIndex, strings.ToUpper(collection)+".")
+	msg += " index: " + index
 
-			return
+	if id, getErr := doc.Get("_id"); getErr == nil && index == hana.IDIndexName {
+		byteID, marshalErr := fjson.MarshalHANA(id)
+		if marshalErr != nil {
+			return marshalErr
 		}
-		err = ScanErr
-		return
-	}
 
-	byteID, errMarshal := fjson.MarshalHANA(id)
-	if errMarshal != nil {
-		err = errMarshal
-		return
-	}
+		dupKey := string(byteID)
+		if strings.HasPrefix(dupKey, "{\"oid\":") {
+			dupKey = strings.TrimSuffix(strings.TrimPrefix(dupKey, "{\"oid\":"), "}")
+		}
 
-	msg := fmt.Sprintf("E11000 duplicate key error collection: %s.%s index: _id_ dup key: { _id: %s }", db, collection, string(byteID))
-	if strings.Contains(msg, "{\"oid\":") {
-		msg = strings.Replace(msg, "{\"oid\":", "", 1)
-		msg = strings.Replace(msg, "}", "", 1)
+		msg += " dup key: { _id: " + dupKey + " }"
 	}
-	errMsg = errors.New(msg)
 
-	return
+	return NewError(ErrDuplicateKey, errors.New(msg))
 }
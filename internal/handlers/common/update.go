@@ -0,0 +1,371 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+)
+
+// UpdateParams holds the context in which the update operators are applied.
+type UpdateParams struct {
+	// Filter is the query of the update, it is used by the positional $ operator.
+	Filter types.Document
+	// ArrayFilters are the filters of the $[<identifier>] operator.
+	ArrayFilters *types.Array
+	// Insert is true if the document is inserted by an upsert, only then $setOnInsert is applied.
+	Insert bool
+}
+
+// ApplyUpdate applies the update operators to the document in memory.
+// It returns true if the document was changed.
+func ApplyUpdate(doc *types.Document, update types.Document, params *UpdateParams) (changed bool, err error) {
+	before := DeepCopyDocument(*doc)
+
+	arrayFilters, err := parseArrayFilters(params.ArrayFilters)
+	if err != nil {
+		return false, err
+	}
+
+	// all fields of $currentDate get the same date, stored with millisecond precision
+	now := time.UnixMilli(time.Now().UnixMilli())
+
+	for _, op := range update.Keys() {
+		fields, ok := update.Map()[op].(types.Document)
+		if !ok {
+			return false, NewErrorMessage(
+				ErrBadValue, "Modifiers operate on fields but we found type %T instead. For example: {$mod: {<field>: ...}} not {%s: ...}",
+				update.Map()[op], op,
+			)
+		}
+
+		for _, key := range fields.Keys() {
+			if key == "_id" && !params.Insert {
+				return false, NewErrorMessage(ErrImmutableField, "Performing an update on the path '_id' would modify the immutable field '_id'")
+			}
+
+			value := fields.Map()[key]
+
+			if op == "$rename" {
+				if err = applyRename(doc, key, value); err != nil {
+					return false, err
+				}
+				continue
+			}
+
+			paths, err := expandPositionalPath(*doc, strings.Split(key, "."), params.Filter, arrayFilters)
+			if err != nil {
+				return false, err
+			}
+
+			for _, path := range paths {
+				switch op {
+				case "$set":
+					err = setUpdatePath(doc, path, value)
+				case "$setOnInsert":
+					if params.Insert {
+						err = setUpdatePath(doc, path, value)
+					}
+				case "$unset":
+					unsetUpdatePath(doc, path)
+				case "$inc", "$mul":
+					err = applyArithmetic(doc, op, path, value)
+				case "$min", "$max":
+					err = applyMinMax(doc, op, path, value)
+				case "$currentDate":
+					err = applyCurrentDate(doc, path, value, now)
+				case "$push":
+					err = applyPush(doc, path, value)
+				case "$addToSet":
+					err = applyAddToSet(doc, path, value)
+				case "$pop":
+					err = applyPop(doc, path, value)
+				case "$pull":
+					err = applyPull(doc, path, value)
+				case "$pullAll":
+					err = applyPullAll(doc, path, value)
+				default:
+					return false, NewErrorMessage(ErrNotImplemented, "update operator %s is not implemented yet", op)
+				}
+
+				if err != nil {
+					return false, err
+				}
+			}
+		}
+	}
+
+	return !EqualValues(before, *doc), nil
+}
+
+// applyArithmetic applies $inc or $mul to the field. A missing field is set to the operand for $inc
+// and to zero for $mul.
+func applyArithmetic(doc *types.Document, op string, path []string, operand any) error {
+	if !isNumber(operand) {
+		verb := "increment"
+		if op == "$mul" {
+			verb = "multiply"
+		}
+		return NewErrorMessage(ErrTypeMismatch, "Cannot %s with non-numeric argument: {%s: %v}", verb, strings.Join(path, "."), operand)
+	}
+
+	current, ok := getUpdatePath(*doc, path)
+	if !ok {
+		if op == "$mul" {
+			return setUpdatePath(doc, path, multiplyNumbers(int32(0), operand))
+		}
+		return setUpdatePath(doc, path, operand)
+	}
+
+	if !isNumber(current) {
+		return NewErrorMessage(
+			ErrTypeMismatch, "Cannot apply %s to a value of non-numeric type. {_id: %v} has the field '%s' of non-numeric type %T",
+			op, doc.Map()["_id"], path[len(path)-1], current,
+		)
+	}
+
+	if op == "$mul" {
+		return setUpdatePath(doc, path, multiplyNumbers(current, operand))
+	}
+	return setUpdatePath(doc, path, addNumbers(current, operand))
+}
+
+// applyMinMax applies $min or $max to the field. The field is set if it is missing
+// or if the operand is less ($min) or greater ($max) than the current value.
+func applyMinMax(doc *types.Document, op string, path []string, operand any) error {
+	current, ok := getUpdatePath(*doc, path)
+	if ok {
+		cmp := CompareValues(operand, current)
+		if (op == "$min" && cmp >= 0) || (op == "$max" && cmp <= 0) {
+			return nil
+		}
+	}
+
+	return setUpdatePath(doc, path, operand)
+}
+
+// applyRename moves the value of the field to the new name. A missing field is ignored.
+func applyRename(doc *types.Document, key string, operand any) error {
+	to, ok := operand.(string)
+	if !ok {
+		return NewErrorMessage(ErrBadValue, "The 'to' field for $rename must be a string: %s: %v", key, operand)
+	}
+
+	if to == key {
+		return NewErrorMessage(ErrBadValue, "The source and target field for $rename must differ: %s: %q", key, to)
+	}
+
+	if to == "_id" {
+		return NewErrorMessage(ErrImmutableField, "Performing an update on the path '_id' would modify the immutable field '_id'")
+	}
+
+	path := strings.Split(key, ".")
+
+	value, ok := getUpdatePath(*doc, path)
+	if !ok {
+		return nil
+	}
+
+	unsetUpdatePath(doc, path)
+	return setUpdatePath(doc, strings.Split(to, "."), value)
+}
+
+// applyCurrentDate sets the field to the current date. Only the date type is supported, timestamps can not be stored.
+func applyCurrentDate(doc *types.Document, path []string, operand any, now time.Time) error {
+	switch operand := operand.(type) {
+	case bool:
+	case types.Document:
+		switch t, _ := operand.Get("$type"); t {
+		case "date":
+		case "timestamp":
+			return NewErrorMessage(ErrNotImplemented, "$currentDate with $type timestamp is not implemented yet")
+		default:
+			return NewErrorMessage(ErrBadValue, "The '$type' string field is required to be 'date' or 'timestamp': {$currentDate: {field : {$type: 'date'}}}")
+		}
+	default:
+		return NewErrorMessage(
+			ErrBadValue, "%v is not valid type for $currentDate. Please use a boolean ('true') or a $type expression ({$type: 'timestamp/date'}).",
+			operand,
+		)
+	}
+
+	return setUpdatePath(doc, path, now)
+}
+
+// multiplyNumbers multiplies two numbers with the same type rules as addNumbers.
+func multiplyNumbers(a, b any) any {
+	_, aIsFloat := a.(float64)
+	_, bIsFloat := b.(float64)
+	if aIsFloat || bIsFloat {
+		return toFloat64(a) * toFloat64(b)
+	}
+
+	ai, _ := toInt64(a)
+	bi, _ := toInt64(b)
+
+	product := ai * bi
+	if ai != 0 && (product/ai != bi || (ai == -1 && bi == math.MinInt64)) {
+		// int64 overflow
+		return float64(ai) * float64(bi)
+	}
+
+	_, aIsInt64 := a.(int64)
+	_, bIsInt64 := b.(int64)
+	if !aIsInt64 && !bIsInt64 && product >= math.MinInt32 && product <= math.MaxInt32 {
+		return int32(product)
+	}
+
+	return product
+}
+
+// getUpdatePath returns the value at the given path. Unlike filters, arrays are only traversed by numeric path elements.
+func getUpdatePath(doc types.Document, path []string) (any, bool) {
+	var current any = doc
+	for _, key := range path {
+		switch c := current.(type) {
+		case types.Document:
+			value, err := c.Get(key)
+			if err != nil {
+				return nil, false
+			}
+			current = value
+
+		case *types.Array:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= c.Len() {
+				return nil, false
+			}
+			current = must(c.Get(index))
+
+		default:
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// setUpdatePath sets the value at the given path like MongoDB update operators do.
+// Missing embedded documents are created, numeric path elements index arrays which are padded with null.
+func setUpdatePath(doc *types.Document, path []string, value any) error {
+	res, err := setContainerValue(*doc, path, value)
+	if err != nil {
+		return err
+	}
+
+	*doc = res.(types.Document)
+	return nil
+}
+
+// setContainerValue sets the value at the path within the given document or array and returns it.
+func setContainerValue(container any, path []string, value any) (any, error) {
+	key := path[0]
+
+	switch c := container.(type) {
+	case types.Document:
+		if len(path) == 1 {
+			if err := c.Set(key, value); err != nil {
+				return nil, err
+			}
+			return c, nil
+		}
+
+		next, err := c.Get(key)
+		if err != nil {
+			next = types.MustMakeDocument()
+		}
+
+		if next, err = setContainerValue(next, path[1:], value); err != nil {
+			return nil, err
+		}
+
+		if err = c.Set(key, next); err != nil {
+			return nil, err
+		}
+		return c, nil
+
+	case *types.Array:
+		index, err := strconv.Atoi(key)
+		if err != nil || index < 0 {
+			return nil, NewErrorMessage(ErrPathNotViable, "Cannot create field '%s' in element of type array", key)
+		}
+
+		for c.Len() <= index {
+			if err = c.Append(nil); err != nil {
+				return nil, err
+			}
+		}
+
+		if len(path) == 1 {
+			if err = c.Set(index, value); err != nil {
+				return nil, err
+			}
+			return c, nil
+		}
+
+		next := must(c.Get(index))
+		if next == nil {
+			next = types.MustMakeDocument()
+		}
+
+		if next, err = setContainerValue(next, path[1:], value); err != nil {
+			return nil, err
+		}
+
+		if err = c.Set(index, next); err != nil {
+			return nil, err
+		}
+		return c, nil
+
+	default:
+		return nil, NewErrorMessage(ErrPathNotViable, "Cannot create field '%s' in element of type %T", key, container)
+	}
+}
+
+// unsetUpdatePath removes the value at the given path like $unset does.
+// Array elements are set to null instead of being removed.
+func unsetUpdatePath(doc *types.Document, path []string) {
+	*doc = unsetContainerValue(*doc, path).(types.Document)
+}
+
+// unsetContainerValue removes the value at the path within the given document or array and returns it.
+// Documents are values sharing their keys, so changed embedded documents are always set again.
+func unsetContainerValue(container any, path []string) any {
+	key := path[0]
+
+	switch c := container.(type) {
+	case types.Document:
+		if len(path) == 1 {
+			c.Remove(key)
+			return c
+		}
+
+		if next, err := c.Get(key); err == nil {
+			_ = c.Set(key, unsetContainerValue(next, path[1:]))
+		}
+		return c
+
+	case *types.Array:
+		index, err := strconv.Atoi(key)
+		if err != nil || index < 0 || index >= c.Len() {
+			return c
+		}
+
+		if len(path) == 1 {
+			_ = c.Set(index, nil)
+			return c
+		}
+
+		_ = c.Set(index, unsetContainerValue(must(c.Get(index)), path[1:]))
+		return c
+
+	default:
+		return container
+	}
+}
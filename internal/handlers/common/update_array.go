@@ -0,0 +1,486 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+)
+
+// parseArrayFilters returns the array filters by their identifier.
+func parseArrayFilters(arrayFilters *types.Array) (map[string]types.Document, error) {
+	res := map[string]types.Document{}
+	if arrayFilters == nil {
+		return res, nil
+	}
+
+	for i := 0; i < arrayFilters.Len(); i++ {
+		filter, ok := must(arrayFilters.Get(i)).(types.Document)
+		if !ok || len(filter.Keys()) == 0 {
+			return nil, NewErrorMessage(ErrBadValue, "Cannot use an expression without a top-level field name in arrayFilters")
+		}
+
+		var id string
+		for _, key := range filter.Keys() {
+			keyID, _, _ := strings.Cut(key, ".")
+			if id != "" && keyID != id {
+				return nil, NewErrorMessage(
+					ErrBadValue, "Error parsing array filter :: caused by :: Expected a single top-level field name, found '%s' and '%s'", id, keyID,
+				)
+			}
+			id = keyID
+		}
+
+		if _, ok := res[id]; ok {
+			return nil, NewErrorMessage(ErrBadValue, "Found multiple array filters with the same top-level field name %s", id)
+		}
+		res[id] = filter
+	}
+
+	return res, nil
+}
+
+// expandPositionalPath replaces the positional operators $, $[] and $[<identifier>] of the path
+// by the indexes of the array elements they refer to. It returns all resulting paths.
+func expandPositionalPath(doc types.Document, path []string, filter types.Document, arrayFilters map[string]types.Document) ([][]string, error) {
+	for i, elem := range path {
+		if !strings.HasPrefix(elem, "$") {
+			continue
+		}
+
+		prefix := path[:i]
+
+		var indexes []int
+		switch {
+		case elem == "$":
+			index, err := positionalIndex(doc, prefix, filter)
+			if err != nil {
+				return nil, err
+			}
+			indexes = []int{index}
+
+		case strings.HasPrefix(elem, "$[") && strings.HasSuffix(elem, "]"):
+			id := elem[2 : len(elem)-1]
+
+			var arrayFilter types.Document
+			if id != "" {
+				var ok bool
+				if arrayFilter, ok = arrayFilters[id]; !ok {
+					return nil, NewErrorMessage(ErrBadValue, "No array filter found for identifier '%s' in path '%s'", id, strings.Join(path, "."))
+				}
+			}
+
+			arr, err := getUpdateArray(doc, prefix, true)
+			if err != nil {
+				return nil, err
+			}
+
+			for j := 0; j < arr.Len(); j++ {
+				if id != "" {
+					matches, err := FilterDocument(types.MustMakeDocument(id, must(arr.Get(j))), arrayFilter)
+					if err != nil {
+						return nil, err
+					}
+					if !matches {
+						continue
+					}
+				}
+				indexes = append(indexes, j)
+			}
+
+		default:
+			continue
+		}
+
+		var res [][]string
+		for _, index := range indexes {
+			concrete := append(append(append([]string{}, prefix...), strconv.Itoa(index)), path[i+1:]...)
+
+			// the rest of the path may contain further positional operators
+			paths, err := expandPositionalPath(doc, concrete, filter, arrayFilters)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, paths...)
+		}
+
+		return res, nil
+	}
+
+	return [][]string{path}, nil
+}
+
+// positionalIndex returns the index of the first element of the array at the path which matches the filter.
+// Each element is checked by matching the filter against the document with the array replaced by only this element.
+func positionalIndex(doc types.Document, path []string, filter types.Document) (int, error) {
+	notFound := NewErrorMessage(ErrBadValue, "The positional operator did not find the match needed from the query.")
+
+	key := strings.Join(path, ".")
+
+	arrayFilter := types.MustMakeDocument()
+	for _, filterKey := range filter.Keys() {
+		if filterKey == key || strings.HasPrefix(filterKey, key+".") {
+			if err := arrayFilter.Set(filterKey, filter.Map()[filterKey]); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if len(arrayFilter.Keys()) == 0 {
+		return 0, notFound
+	}
+
+	value, ok := getUpdatePath(doc, path)
+	if !ok {
+		return 0, notFound
+	}
+
+	arr, ok := value.(*types.Array)
+	if !ok {
+		return 0, notFound
+	}
+
+	for i := 0; i < arr.Len(); i++ {
+		single := DeepCopyDocument(doc)
+		if err := setUpdatePath(&single, path, types.MustNewArray(must(arr.Get(i)))); err != nil {
+			return 0, err
+		}
+
+		matches, err := FilterDocument(single, arrayFilter)
+		if err != nil {
+			return 0, err
+		}
+		if matches {
+			return i, nil
+		}
+	}
+
+	return 0, notFound
+}
+
+// getUpdateArray returns the array at the path. A missing field is returned as empty array
+// unless mustExist is true.
+func getUpdateArray(doc types.Document, path []string, mustExist bool) (*types.Array, error) {
+	value, ok := getUpdatePath(doc, path)
+	if !ok {
+		if mustExist {
+			return nil, NewErrorMessage(ErrBadValue, "The path '%s' must exist in the document in order to apply array updates.", strings.Join(path, "."))
+		}
+		return new(types.Array), nil
+	}
+
+	arr, ok := value.(*types.Array)
+	if !ok {
+		return nil, NewErrorMessage(
+			ErrBadValue, "The field '%s' must be an array but is of type %T in document {_id: %v}",
+			strings.Join(path, "."), value, doc.Map()["_id"],
+		)
+	}
+
+	return arr, nil
+}
+
+// applyPush appends the value or the values of $each to the array.
+// The modifiers $position, $sort and $slice are applied in this order.
+func applyPush(doc *types.Document, path []string, operand any) error {
+	arr, err := getUpdateArray(*doc, path, false)
+	if err != nil {
+		return err
+	}
+
+	values := []any{operand}
+	position := arr.Len()
+
+	modifiers, ok := operand.(types.Document)
+	if _, hasEach := modifiers.Map()["$each"]; !ok || !hasEach {
+		return setUpdatePath(doc, path, appendValues(arr, position, values))
+	}
+
+	if values, err = eachValues(modifiers.Map()["$each"], "$push"); err != nil {
+		return err
+	}
+
+	for _, key := range modifiers.Keys() {
+		switch key {
+		case "$each", "$sort", "$slice":
+		case "$position":
+			p, ok := toInt64(modifiers.Map()[key])
+			if !ok {
+				return NewErrorMessage(ErrBadValue, "The value for $position must be an integer value, not of type: %T", modifiers.Map()[key])
+			}
+
+			if p < 0 {
+				p += int64(arr.Len())
+			}
+			if p < 0 {
+				p = 0
+			}
+			if p < int64(position) {
+				position = int(p)
+			}
+		default:
+			return NewErrorMessage(ErrBadValue, "Unrecognized clause in $push: %s", key)
+		}
+	}
+
+	res := appendValues(arr, position, values)
+
+	if spec, ok := modifiers.Map()["$sort"]; ok {
+		if res, err = sortArray(res, spec); err != nil {
+			return err
+		}
+	}
+
+	if value, ok := modifiers.Map()["$slice"]; ok {
+		n, ok := toInt64(value)
+		if !ok {
+			return NewErrorMessage(ErrBadValue, "The value for $slice must be an integer value but was given type: %T", value)
+		}
+		res = sliceArray(res, n)
+	}
+
+	return setUpdatePath(doc, path, res)
+}
+
+// applyAddToSet appends the value or the values of $each to the array if they are not already contained.
+func applyAddToSet(doc *types.Document, path []string, operand any) error {
+	arr, err := getUpdateArray(*doc, path, false)
+	if err != nil {
+		return err
+	}
+
+	values := []any{operand}
+	if modifiers, ok := operand.(types.Document); ok {
+		if each, ok := modifiers.Map()["$each"]; ok {
+			if values, err = eachValues(each, "$addToSet"); err != nil {
+				return err
+			}
+		}
+	}
+
+	res := appendValues(arr, arr.Len(), nil)
+	for _, value := range values {
+		if !containsValue(res, value) {
+			_ = res.Append(value)
+		}
+	}
+
+	return setUpdatePath(doc, path, res)
+}
+
+// applyPop removes the first (-1) or the last (1) element of the array.
+func applyPop(doc *types.Document, path []string, operand any) error {
+	n, ok := toInt64(operand)
+	if !ok || (n != 1 && n != -1) {
+		return NewErrorMessage(ErrBadValue, "$pop expects 1 or -1, found: %v", operand)
+	}
+
+	if _, ok := getUpdatePath(*doc, path); !ok {
+		return nil
+	}
+
+	arr, err := getUpdateArray(*doc, path, true)
+	if err != nil || arr.Len() == 0 {
+		return err
+	}
+
+	res := new(types.Array)
+	for i := 0; i < arr.Len(); i++ {
+		if (n == -1 && i == 0) || (n == 1 && i == arr.Len()-1) {
+			continue
+		}
+		_ = res.Append(must(arr.Get(i)))
+	}
+
+	return setUpdatePath(doc, path, res)
+}
+
+// applyPull removes all elements matching the condition. The condition is either a value,
+// query operators applied to the elements or a query on embedded documents.
+func applyPull(doc *types.Document, path []string, operand any) error {
+	return removeElements(doc, path, func(elem any) (bool, error) {
+		cond, ok := operand.(types.Document)
+		if !ok {
+			return EqualValues(elem, operand), nil
+		}
+
+		if len(cond.Keys()) != 0 && strings.HasPrefix(cond.Keys()[0], "$") {
+			return FilterDocument(types.MustMakeDocument("element", elem), types.MustMakeDocument("element", cond))
+		}
+
+		elemDoc, ok := elem.(types.Document)
+		if !ok {
+			return false, nil
+		}
+		return FilterDocument(elemDoc, cond)
+	})
+}
+
+// applyPullAll removes all elements equal to any of the given values.
+func applyPullAll(doc *types.Document, path []string, operand any) error {
+	values, ok := operand.(*types.Array)
+	if !ok {
+		return NewErrorMessage(ErrBadValue, "$pullAll requires an array argument but was given a %T", operand)
+	}
+
+	return removeElements(doc, path, func(elem any) (bool, error) {
+		return containsValue(values, elem), nil
+	})
+}
+
+// removeElements removes the elements of the array for which remove returns true. A missing field is ignored.
+func removeElements(doc *types.Document, path []string, remove func(elem any) (bool, error)) error {
+	if _, ok := getUpdatePath(*doc, path); !ok {
+		return nil
+	}
+
+	arr, err := getUpdateArray(*doc, path, true)
+	if err != nil {
+		return err
+	}
+
+	res := new(types.Array)
+	for i := 0; i < arr.Len(); i++ {
+		elem := must(arr.Get(i))
+
+		removed, err := remove(elem)
+		if err != nil {
+			return err
+		}
+
+		if !removed {
+			_ = res.Append(elem)
+		}
+	}
+
+	return setUpdatePath(doc, path, res)
+}
+
+// eachValues returns the values of the $each modifier.
+func eachValues(each any, op string) ([]any, error) {
+	arr, ok := each.(*types.Array)
+	if !ok {
+		return nil, NewErrorMessage(ErrBadValue, "The argument to $each in %s must be an array but it was of type: %T", op, each)
+	}
+
+	values := make([]any, arr.Len())
+	for i := range values {
+		values[i] = must(arr.Get(i))
+	}
+
+	return values, nil
+}
+
+// appendValues returns a new array with the values inserted at the given position.
+func appendValues(arr *types.Array, position int, values []any) *types.Array {
+	res := types.MakeArray(arr.Len() + len(values))
+	for i := 0; i < position; i++ {
+		_ = res.Append(must(arr.Get(i)))
+	}
+	for _, value := range values {
+		_ = res.Append(value)
+	}
+	for i := position; i < arr.Len(); i++ {
+		_ = res.Append(must(arr.Get(i)))
+	}
+
+	return res
+}
+
+// containsValue returns true if the array contains an element equal to the value.
+func containsValue(arr *types.Array, value any) bool {
+	for i := 0; i < arr.Len(); i++ {
+		if EqualValues(must(arr.Get(i)), value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sortArray sorts the elements stably by the $sort modifier of $push.
+// It is either 1 or -1 to sort by the elements or a document with the sort order of embedded fields.
+func sortArray(arr *types.Array, spec any) (*types.Array, error) {
+	invalid := NewErrorMessage(ErrBadValue, "The $sort is invalid: use 1/-1 to sort the whole element, or {field:1/-1} to sort embedded fields")
+
+	elems := make([]any, arr.Len())
+	for i := range elems {
+		elems[i] = must(arr.Get(i))
+	}
+
+	var less func(a, b any) bool
+	switch spec := spec.(type) {
+	case types.Document:
+		if len(spec.Keys()) == 0 {
+			return nil, invalid
+		}
+
+		orders := make([]int, len(spec.Keys()))
+		for i, key := range spec.Keys() {
+			order, ok := toInt64(spec.Map()[key])
+			if !ok || (order != 1 && order != -1) {
+				return nil, invalid
+			}
+			orders[i] = int(order)
+		}
+
+		less = func(a, b any) bool {
+			aDoc, _ := a.(types.Document)
+			bDoc, _ := b.(types.Document)
+
+			for i, key := range spec.Keys() {
+				aValue, _ := GetPathValue(aDoc, key)
+				bValue, _ := GetPathValue(bDoc, key)
+
+				if res := CompareValues(aValue, bValue) * orders[i]; res != 0 {
+					return res < 0
+				}
+			}
+			return false
+		}
+
+	default:
+		order, ok := toInt64(spec)
+		if !ok || (order != 1 && order != -1) {
+			return nil, invalid
+		}
+
+		less = func(a, b any) bool {
+			return CompareValues(a, b)*int(order) < 0
+		}
+	}
+
+	sort.SliceStable(elems, func(i, j int) bool {
+		return less(elems[i], elems[j])
+	})
+
+	res := types.MakeArray(len(elems))
+	for _, elem := range elems {
+		_ = res.Append(elem)
+	}
+
+	return res, nil
+}
+
+// sliceArray keeps the first n elements or the last -n elements if n is negative.
+func sliceArray(arr *types.Array, n int64) *types.Array {
+	start, end := int64(0), int64(arr.Len())
+	switch {
+	case n >= 0 && n < end:
+		end = n
+	case n < 0 && -n < end:
+		start = end + n
+	}
+
+	res := types.MakeArray(int(end - start))
+	for i := start; i < end; i++ {
+		_ = res.Append(must(arr.Get(int(i))))
+	}
+
+	return res
+}
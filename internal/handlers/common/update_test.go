@@ -0,0 +1,312 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+)
+
+func TestApplyUpdate(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		doc          types.Document
+		update       types.Document
+		filter       types.Document
+		arrayFilters *types.Array
+		insert       bool
+		expected     types.Document
+		changed      bool
+		err          string
+	}{
+		"Set": {
+			doc:      types.MustMakeDocument("_id", int32(1), "a", int32(1)),
+			update:   types.MustMakeDocument("$set", types.MustMakeDocument("a", int32(2), "b.c", "x")),
+			expected: types.MustMakeDocument("_id", int32(1), "a", int32(2), "b", types.MustMakeDocument("c", "x")),
+			changed:  true,
+		},
+		"SetUnchanged": {
+			doc:      types.MustMakeDocument("_id", int32(1), "a", int32(1)),
+			update:   types.MustMakeDocument("$set", types.MustMakeDocument("a", int32(1))),
+			expected: types.MustMakeDocument("_id", int32(1), "a", int32(1)),
+		},
+		"SetArrayIndex": {
+			doc:      types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(int32(1))),
+			update:   types.MustMakeDocument("$set", types.MustMakeDocument("a.2", int32(3))),
+			expected: types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(int32(1), nil, int32(3))),
+			changed:  true,
+		},
+		"SetOnInsert": {
+			doc:      types.MustMakeDocument("_id", int32(1)),
+			update:   types.MustMakeDocument("$setOnInsert", types.MustMakeDocument("a", int32(1))),
+			insert:   true,
+			expected: types.MustMakeDocument("_id", int32(1), "a", int32(1)),
+			changed:  true,
+		},
+		"SetOnInsertExisting": {
+			doc:      types.MustMakeDocument("_id", int32(1)),
+			update:   types.MustMakeDocument("$setOnInsert", types.MustMakeDocument("a", int32(1))),
+			expected: types.MustMakeDocument("_id", int32(1)),
+		},
+		"Unset": {
+			doc:      types.MustMakeDocument("_id", int32(1), "a", types.MustMakeDocument("b", int32(1), "c", int32(2))),
+			update:   types.MustMakeDocument("$unset", types.MustMakeDocument("a.b", "")),
+			expected: types.MustMakeDocument("_id", int32(1), "a", types.MustMakeDocument("c", int32(2))),
+			changed:  true,
+		},
+		"Inc": {
+			doc:      types.MustMakeDocument("_id", int32(1), "a", int32(1), "b", float64(1.5)),
+			update:   types.MustMakeDocument("$inc", types.MustMakeDocument("a", int32(2), "b", int32(1), "c", int64(3))),
+			expected: types.MustMakeDocument("_id", int32(1), "a", int32(3), "b", float64(2.5), "c", int64(3)),
+			changed:  true,
+		},
+		"IncOverflow": {
+			doc:      types.MustMakeDocument("_id", int32(1), "a", int32(math.MaxInt32)),
+			update:   types.MustMakeDocument("$inc", types.MustMakeDocument("a", int32(1))),
+			expected: types.MustMakeDocument("_id", int32(1), "a", int64(math.MaxInt32)+1),
+			changed:  true,
+		},
+		"IncNonNumeric": {
+			doc:    types.MustMakeDocument("_id", int32(1), "a", "x"),
+			update: types.MustMakeDocument("$inc", types.MustMakeDocument("a", int32(1))),
+			err:    "TypeMismatch (14): Cannot apply $inc to a value of non-numeric type. {_id: 1} has the field 'a' of non-numeric type string",
+		},
+		"IncNonNumericArgument": {
+			doc:    types.MustMakeDocument("_id", int32(1)),
+			update: types.MustMakeDocument("$inc", types.MustMakeDocument("a", "x")),
+			err:    "TypeMismatch (14): Cannot increment with non-numeric argument: {a: x}",
+		},
+		"Mul": {
+			doc:      types.MustMakeDocument("_id", int32(1), "a", int32(3)),
+			update:   types.MustMakeDocument("$mul", types.MustMakeDocument("a", float64(1.5), "b", int32(2))),
+			expected: types.MustMakeDocument("_id", int32(1), "a", float64(4.5), "b", int32(0)),
+			changed:  true,
+		},
+		"MinMax": {
+			doc: types.MustMakeDocument("_id", int32(1), "low", int32(5), "high", int32(5)),
+			update: types.MustMakeDocument(
+				"$min", types.MustMakeDocument("low", int32(3), "high", int32(7)),
+				"$max", types.MustMakeDocument("high", int32(9), "new", int32(1)),
+			),
+			expected: types.MustMakeDocument("_id", int32(1), "low", int32(3), "high", int32(9), "new", int32(1)),
+			changed:  true,
+		},
+		"MinUnchanged": {
+			doc:      types.MustMakeDocument("_id", int32(1), "a", int32(5)),
+			update:   types.MustMakeDocument("$min", types.MustMakeDocument("a", int64(5))),
+			expected: types.MustMakeDocument("_id", int32(1), "a", int32(5)),
+		},
+		"Rename": {
+			doc:      types.MustMakeDocument("_id", int32(1), "a", int32(1), "b", types.MustMakeDocument("c", int32(2))),
+			update:   types.MustMakeDocument("$rename", types.MustMakeDocument("a", "x.y", "b.c", "d", "missing", "e")),
+			expected: types.MustMakeDocument("_id", int32(1), "b", types.MustMakeDocument(), "x", types.MustMakeDocument("y", int32(1)), "d", int32(2)),
+			changed:  true,
+		},
+		"RenameSame": {
+			doc:    types.MustMakeDocument("_id", int32(1), "a", int32(1)),
+			update: types.MustMakeDocument("$rename", types.MustMakeDocument("a", "a")),
+			err:    `BadValue (2): The source and target field for $rename must differ: a: "a"`,
+		},
+		"CurrentDateTimestamp": {
+			doc:    types.MustMakeDocument("_id", int32(1)),
+			update: types.MustMakeDocument("$currentDate", types.MustMakeDocument("a", types.MustMakeDocument("$type", "timestamp"))),
+			err:    "NotImplemented (238): $currentDate with $type timestamp is not implemented yet",
+		},
+		"Push": {
+			doc:      types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(int32(1))),
+			update:   types.MustMakeDocument("$push", types.MustMakeDocument("a", int32(2), "b", "x")),
+			expected: types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(int32(1), int32(2)), "b", types.MustNewArray("x")),
+			changed:  true,
+		},
+		"PushModifiers": {
+			doc: types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(int32(5), int32(1))),
+			update: types.MustMakeDocument("$push", types.MustMakeDocument("a", types.MustMakeDocument(
+				"$each", types.MustNewArray(int32(3), int32(9)),
+				"$position", int32(0),
+				"$sort", int32(-1),
+				"$slice", int32(3),
+			))),
+			expected: types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(int32(9), int32(5), int32(3))),
+			changed:  true,
+		},
+		"PushPosition": {
+			doc: types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(int32(1), int32(2), int32(3))),
+			update: types.MustMakeDocument("$push", types.MustMakeDocument("a", types.MustMakeDocument(
+				"$each", types.MustNewArray(int32(9)),
+				"$position", int32(-1),
+			))),
+			expected: types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(int32(1), int32(2), int32(9), int32(3))),
+			changed:  true,
+		},
+		"PushSortDocuments": {
+			doc: types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(
+				types.MustMakeDocument("s", int32(2)),
+				types.MustMakeDocument("s", int32(3)),
+			)),
+			update: types.MustMakeDocument("$push", types.MustMakeDocument("a", types.MustMakeDocument(
+				"$each", types.MustNewArray(types.MustMakeDocument("s", int32(1))),
+				"$sort", types.MustMakeDocument("s", int32(1)),
+				"$slice", int32(-2),
+			))),
+			expected: types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(
+				types.MustMakeDocument("s", int32(2)),
+				types.MustMakeDocument("s", int32(3)),
+			)),
+		},
+		"PushNonArray": {
+			doc:    types.MustMakeDocument("_id", int32(1), "a", "x"),
+			update: types.MustMakeDocument("$push", types.MustMakeDocument("a", int32(1))),
+			err:    "BadValue (2): The field 'a' must be an array but is of type string in document {_id: 1}",
+		},
+		"AddToSet": {
+			doc: types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray("x")),
+			update: types.MustMakeDocument("$addToSet", types.MustMakeDocument(
+				"a", types.MustMakeDocument("$each", types.MustNewArray("x", "y", "y")),
+			)),
+			expected: types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray("x", "y")),
+			changed:  true,
+		},
+		"AddToSetExisting": {
+			doc:      types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(int32(1))),
+			update:   types.MustMakeDocument("$addToSet", types.MustMakeDocument("a", float64(1))),
+			expected: types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(int32(1))),
+		},
+		"Pop": {
+			doc:      types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(int32(1), int32(2)), "b", types.MustNewArray(int32(1), int32(2))),
+			update:   types.MustMakeDocument("$pop", types.MustMakeDocument("a", int32(-1), "b", int32(1), "c", int32(1))),
+			expected: types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(int32(2)), "b", types.MustNewArray(int32(1))),
+			changed:  true,
+		},
+		"Pull": {
+			doc: types.MustMakeDocument(
+				"_id", int32(1),
+				"a", types.MustNewArray(int32(1), int32(5), int32(8)),
+				"b", types.MustNewArray("x", "y"),
+				"c", types.MustNewArray(types.MustMakeDocument("k", int32(1)), types.MustMakeDocument("k", int32(2))),
+			),
+			update: types.MustMakeDocument("$pull", types.MustMakeDocument(
+				"a", types.MustMakeDocument("$gte", int32(5)),
+				"b", "x",
+				"c", types.MustMakeDocument("k", int32(2)),
+			)),
+			expected: types.MustMakeDocument(
+				"_id", int32(1),
+				"a", types.MustNewArray(int32(1)),
+				"b", types.MustNewArray("y"),
+				"c", types.MustNewArray(types.MustMakeDocument("k", int32(1))),
+			),
+			changed: true,
+		},
+		"PullAll": {
+			doc:      types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(int32(1), int32(2), int32(1), int32(3))),
+			update:   types.MustMakeDocument("$pullAll", types.MustMakeDocument("a", types.MustNewArray(int32(1), int32(3)))),
+			expected: types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(int32(2))),
+			changed:  true,
+		},
+		"Positional": {
+			doc:      types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(int32(1), int32(5), int32(5))),
+			update:   types.MustMakeDocument("$set", types.MustMakeDocument("a.$", int32(6))),
+			filter:   types.MustMakeDocument("_id", int32(1), "a", int32(5)),
+			expected: types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(int32(1), int32(6), int32(5))),
+			changed:  true,
+		},
+		"PositionalEmbedded": {
+			doc: types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(
+				types.MustMakeDocument("k", "x", "v", int32(1)),
+				types.MustMakeDocument("k", "y", "v", int32(1)),
+			)),
+			update: types.MustMakeDocument("$inc", types.MustMakeDocument("a.$.v", int32(1))),
+			filter: types.MustMakeDocument("a.k", "y"),
+			expected: types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(
+				types.MustMakeDocument("k", "x", "v", int32(1)),
+				types.MustMakeDocument("k", "y", "v", int32(2)),
+			)),
+			changed: true,
+		},
+		"PositionalNotFound": {
+			doc:    types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(int32(1))),
+			update: types.MustMakeDocument("$set", types.MustMakeDocument("a.$", int32(6))),
+			filter: types.MustMakeDocument("_id", int32(1)),
+			err:    "BadValue (2): The positional operator did not find the match needed from the query.",
+		},
+		"AllPositional": {
+			doc:      types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(int32(1), int32(2))),
+			update:   types.MustMakeDocument("$mul", types.MustMakeDocument("a.$[]", int32(10))),
+			expected: types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(int32(10), int32(20))),
+			changed:  true,
+		},
+		"FilteredPositional": {
+			doc: types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(
+				types.MustMakeDocument("g", int32(80), "b", types.MustNewArray(int32(1), int32(7))),
+				types.MustMakeDocument("g", int32(90), "b", types.MustNewArray(int32(2), int32(8))),
+			)),
+			update:       types.MustMakeDocument("$set", types.MustMakeDocument("a.$[e].b.$[n]", int32(0))),
+			arrayFilters: types.MustNewArray(types.MustMakeDocument("e.g", types.MustMakeDocument("$gte", int32(85))), types.MustMakeDocument("n", types.MustMakeDocument("$gt", int32(5)))),
+			expected: types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(
+				types.MustMakeDocument("g", int32(80), "b", types.MustNewArray(int32(1), int32(7))),
+				types.MustMakeDocument("g", int32(90), "b", types.MustNewArray(int32(2), int32(0))),
+			)),
+			changed: true,
+		},
+		"FilteredPositionalMissingFilter": {
+			doc:    types.MustMakeDocument("_id", int32(1), "a", types.MustNewArray(int32(1))),
+			update: types.MustMakeDocument("$set", types.MustMakeDocument("a.$[e]", int32(0))),
+			err:    "BadValue (2): No array filter found for identifier 'e' in path 'a.$[e]'",
+		},
+		"SetScalarPath": {
+			doc:    types.MustMakeDocument("_id", int32(1), "a", int32(1)),
+			update: types.MustMakeDocument("$set", types.MustMakeDocument("a.b", int32(2))),
+			err:    "PathNotViable (28): Cannot create field 'b' in element of type int32",
+		},
+		"SetID": {
+			doc:    types.MustMakeDocument("_id", int32(1)),
+			update: types.MustMakeDocument("$set", types.MustMakeDocument("_id", int32(2))),
+			err:    "ImmutableField (66): Performing an update on the path '_id' would modify the immutable field '_id'",
+		},
+	} {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			params := &UpdateParams{Filter: tc.filter, ArrayFilters: tc.arrayFilters, Insert: tc.insert}
+			changed, err := ApplyUpdate(&tc.doc, tc.update, params)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.changed, changed)
+			assert.Equal(t, tc.expected, tc.doc)
+		})
+	}
+}
+
+func TestApplyUpdateCurrentDate(t *testing.T) {
+	t.Parallel()
+
+	doc := types.MustMakeDocument("_id", int32(1))
+	update := types.MustMakeDocument("$currentDate", types.MustMakeDocument("a", true, "b.c", types.MustMakeDocument("$type", "date")))
+
+	changed, err := ApplyUpdate(&doc, update, new(UpdateParams))
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	a, err := doc.Get("a")
+	require.NoError(t, err)
+	assert.IsType(t, time.Time{}, a)
+	assert.WithinDuration(t, time.Now(), a.(time.Time), time.Minute)
+
+	c, ok := getUpdatePath(doc, []string{"b", "c"})
+	require.True(t, ok)
+	assert.Equal(t, a, c)
+}
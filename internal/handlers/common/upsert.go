@@ -0,0 +1,140 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"strings"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+)
+
+// NewUpsertDocument creates the document inserted by an upsert from the equality conditions of the filter,
+// i.e. {field: value}, {field: {$eq: value}} and the same within $and.
+// If the filter does not contain an _id, a new ObjectID is used. _id is always the first field.
+func NewUpsertDocument(filter types.Document) (types.Document, error) {
+	doc := types.MustMakeDocument()
+	if err := addEqualityFields(&doc, filter); err != nil {
+		return doc, err
+	}
+
+	return withID(doc, types.NewObjectID()), nil
+}
+
+// HasIDEquality returns true if the filter has an equality condition on _id,
+// so that the document inserted by an upsert has the _id of the filter.
+func HasIDEquality(filter types.Document) bool {
+	doc := types.MustMakeDocument()
+	if err := addEqualityFields(&doc, filter); err != nil {
+		return false
+	}
+
+	_, err := doc.Get("_id")
+	return err == nil
+}
+
+// addEqualityFields adds the fields of the equality conditions of the filter to the document.
+func addEqualityFields(doc *types.Document, filter types.Document) error {
+	for _, key := range filter.Keys() {
+		value := filter.Map()[key]
+
+		if strings.EqualFold(key, "$and") {
+			exprs, ok := value.(*types.Array)
+			if !ok {
+				return NewErrorMessage(ErrBadValue, "$and must be an array")
+			}
+
+			for i := 0; i < exprs.Len(); i++ {
+				if expr, ok := must(exprs.Get(i)).(types.Document); ok {
+					if err := addEqualityFields(doc, expr); err != nil {
+						return err
+					}
+				}
+			}
+			continue
+		}
+
+		if strings.HasPrefix(key, "$") {
+			continue
+		}
+
+		switch v := value.(type) {
+		case types.Document:
+			if len(v.Keys()) != 0 && strings.HasPrefix(v.Keys()[0], "$") {
+				eq, ok := v.Map()["$eq"]
+				if !ok {
+					continue
+				}
+				value = eq
+			}
+		case types.Regex:
+			continue
+		}
+
+		if err := setPath(doc, strings.Split(key, "."), value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// withID returns the document with _id as first field. The given id is only used if the document has no _id.
+func withID(doc types.Document, id any) types.Document {
+	if docID, err := doc.Get("_id"); err == nil {
+		id = docID
+	}
+
+	res := types.MustMakeDocument("_id", id)
+	for _, key := range doc.Keys() {
+		if key == "_id" {
+			continue
+		}
+
+		_ = res.Set(key, doc.Map()[key])
+	}
+
+	return res
+}
+
+// WithObjectID returns the document with a new ObjectID as first field if it has no _id.
+// Documents with an _id are returned unchanged.
+func WithObjectID(doc types.Document) types.Document {
+	if _, err := doc.Get("_id"); err == nil {
+		return doc
+	}
+
+	return withID(doc, types.NewObjectID())
+}
+
+// NewReplacementDocument returns the replacement document with the _id of the replaced document.
+// It returns an error if the replacement would change the _id.
+func NewReplacementDocument(replacement types.Document, id any) (types.Document, error) {
+	if replacementID, err := replacement.Get("_id"); err == nil && !EqualValues(replacementID, id) {
+		return replacement, NewErrorMessage(
+			ErrImmutableField, "After applying the update, the (immutable) field '_id' was found to have been altered to _id: %v", replacementID,
+		)
+	}
+
+	return withID(replacement, id), nil
+}
+
+// IsReplacement returns true if the update is a replacement document rather than update operators.
+// Mixing both returns an error.
+func IsReplacement(update types.Document) (bool, error) {
+	var operators, fields int
+	for _, key := range update.Keys() {
+		if strings.HasPrefix(key, "$") {
+			operators++
+		} else {
+			fields++
+		}
+	}
+
+	if operators != 0 && fields != 0 {
+		return false, NewErrorMessage(ErrBadValue, "the update document must either contain only update operators or only fields")
+	}
+
+	return operators == 0, nil
+}
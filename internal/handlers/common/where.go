@@ -21,87 +21,71 @@
 package common
 
 import (
-	"bytes"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"regexp/syntax"
 	"strconv"
 	"strings"
 
-	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/bson"
-
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
-	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
 )
 
 // CreateWhereClause creates the WHERE-clause of the SQL statement.
-func CreateWhereClause(filter types.Document) (sql string, err error) {
-	for i, key := range filter.Keys() {
-
-		if i == 0 {
-			sql += " WHERE "
-		} else {
-			sql += " AND "
-		}
-
-		value := filter.Map()[key]
-
-		// Stands for key-value SQL
-		var kvSQL string
-		kvSQL, err = wherePair(key, value)
-
-		if err != nil {
-			return
-		}
+// Values are bound as parameters: the clause contains ? for each of the returned arguments.
+// Every call compiles the filter with its own compiler, so it is safe for concurrent use.
+// Filters with operators SAP HANA cannot evaluate are rejected, see CreatePrefilterWhereClause.
+func CreateWhereClause(filter types.Document) (sql string, args []any, err error) {
+	sql, args, postFilter, err := createWhereClause(filter)
+	if err != nil {
+		return "", nil, err
+	}
 
-		sql += kvSQL
+	if postFilter != "" {
+		return "", nil, NewErrorMessage(ErrNotImplemented, "%s cannot be evaluated by SAP HANA", postFilter)
 	}
 
-	return
+	return sql, args, nil
 }
 
-// wherePair takes a {field: value} and converts it to SQL
-func wherePair(key string, value any) (kvSQL string, err error) {
-	if strings.HasPrefix(key, "$") { // {$: value}
-
-		kvSQL, err = logicExpression(key, value)
-		return
-
-	}
-
-	switch value := value.(type) {
-	case types.Document:
-		if strings.HasPrefix(value.Keys()[0], "$") { // {field: {$: value}}
-			kvSQL, err = fieldExpression(key, value)
-			return
-		}
+// CreatePrefilterWhereClause creates the WHERE-clause of the SQL statement like CreateWhereClause,
+// but conditions SAP HANA cannot evaluate, like $type or $expr, are replaced by conditions
+// which hold for every document they could match.
+// If exact is false, the clause selects a superset of the matching documents,
+// which have to be filtered with FilterDocument.
+func CreatePrefilterWhereClause(filter types.Document) (sql string, args []any, exact bool, err error) {
+	sql, args, postFilter, err := createWhereClause(filter)
+	if err != nil {
+		return "", nil, false, err
 	}
 
-	// vSQL: ValueSQL
-	var vSQL string
-	var sign string
-	vSQL, sign, err = whereValue(value)
+	return sql, args, postFilter == "", nil
+}
 
-	if err != nil {
-		return
-	}
+// createWhereClause compiles the filter. postFilter is the first operator SAP HANA cannot evaluate, if any.
+func createWhereClause(filter types.Document) (sql string, args []any, postFilter string, err error) {
+	var c whereCompiler
 
-	// kSQL: KeySQL
-	var kSQL string
-	kSQL, err = whereKey(key)
-	if err != nil {
+	expr, err := c.compileFilter(filter, "")
+	if err != nil || expr == nil {
 		return
 	}
 
-	kvSQL = kSQL + sign + vSQL
+	c.sql.WriteString(" WHERE ")
 
-	if isNor {
-		kvSQL = "(" + kvSQL + " AND " + kSQL + " IS SET)"
+	// the conditions of the filter are not enclosed in parentheses on the top level
+	if and, ok := expr.(andExpr); ok {
+		c.writeList(and, " AND ")
+	} else {
+		expr.writeSQL(&c)
 	}
 
-	return
+	return c.sql.String(), c.args, c.postFilter, nil
 }
 
-// whereKey prepares the key (field) for SQL
-func whereKey(key string) (kSQL string, err error) {
+// WhereKey prepares the key (field) for SQL
+func WhereKey(key string) (kSQL string, err error) {
 	if strings.Contains(key, ".") {
 		splitKey := strings.Split(key, ".")
 		var isInt bool
@@ -128,84 +112,64 @@ func whereKey(key string) (kSQL string, err error) {
 				kSQL += "."
 			}
 
-			kSQL += "\"" + k + "\""
+			kSQL += hana.QuoteIdentifier(k)
 
 			isInt = false
 
 		}
 	} else {
-		kSQL = "\"" + key + "\""
+		kSQL = hana.QuoteIdentifier(key)
 	}
 
 	return
 }
 
+// ObjectIDSQL returns the SQL of an ObjectID, which is stored as {"oid": <hex>}, and its argument.
+func ObjectIDSQL(id types.ObjectID) (string, []any) {
+	return `{"oid": ?}`, []any{hex.EncodeToString(id[:])}
+}
+
 // whereValue prepares the value for SQL
-func whereValue(value any) (vSQL string, sign string, err error) {
-	var args []any
+func whereValue(value any) (vSQL string, sign string, args []any, err error) {
 	switch value := value.(type) {
-	case int32, int64:
-		vSQL = "%d"
-		args = append(args, value)
-	case float64:
-		vSQL = "%f"
-		args = append(args, value)
-	case string:
-		vSQL = "'%s'"
+	case int32, int64, float64, string:
+		vSQL = "?"
 		args = append(args, value)
 	case bool:
-		vSQL = "to_json_boolean(%t)"
+		vSQL = "to_json_boolean(?)"
 		args = append(args, value)
 	case nil:
 		vSQL = "NULL"
 		sign = " IS "
 		return
-	case types.Regex:
-		vSQL, err = regex(value)
-		if err != nil {
-			return
-		}
-		sign = " LIKE "
-		return
 	case types.ObjectID:
-		var bOBJ []byte
-		bOBJ, err = bson.ObjectID(value).MarshalJSON()
+		vSQL, args = ObjectIDSQL(value)
+	case types.Document:
+		vSQL, args, err = whereDocument(value)
 		if err != nil {
 			return
 		}
-		oid := bytes.Replace(bOBJ, []byte{34}, []byte{39}, -1)
-		oid = bytes.Replace(oid, []byte{39}, []byte{34}, 2)
-		vSQL = "%s"
-		args = append(args, string(oid))
-
-	case types.Document:
-		vSQL = "%s"
-		var docValue string
-		docValue, err = whereDocument(value)
-		args = append(args, docValue)
 	default:
 		err = NewErrorMessage(ErrBadValue, "value %T not supported in filter", value)
 		return
 
 	}
 	sign = " = "
-	vSQL = fmt.Sprintf(vSQL, args...)
 
 	return
 }
 
 // whereDocument prepares a document for fx. value = {document}.
-func whereDocument(doc types.Document) (docSQL string, err error) {
+func whereDocument(doc types.Document) (docSQL string, args []any, err error) {
 	docSQL += "{"
 	var value any
-	var args []any
 	for i, key := range doc.Keys() {
 
 		if i != 0 {
 			docSQL += ", "
 		}
 
-		docSQL += "\"" + key + "\": "
+		docSQL += hana.QuoteIdentifier(key) + ": "
 
 		value, err = doc.Get(key)
 
@@ -214,48 +178,39 @@ func whereDocument(doc types.Document) (docSQL string, err error) {
 		}
 
 		switch value := value.(type) {
-		case int32, int64:
-			docSQL += "%d"
-			args = append(args, value)
-		case float64:
-			docSQL += "%f"
-			args = append(args, value)
-		case string:
-
-			docSQL += "'%s'"
+		case int32, int64, float64, string:
+			docSQL += "?"
 			args = append(args, value)
 		case bool:
-
-			docSQL += "to_json_boolean(%t)"
-
+			docSQL += "to_json_boolean(?)"
 			args = append(args, value)
 		case nil:
 			docSQL += " NULL "
 		case types.ObjectID:
-			docSQL += "%s"
-			var bOBJ []byte
-			bOBJ, err = bson.ObjectID(value).MarshalJSON()
-			oid := bytes.Replace(bOBJ, []byte{34}, []byte{39}, -1)
-			oid = bytes.Replace(oid, []byte{39}, []byte{34}, 2)
-			args = append(args, string(oid))
+			oidSQL, oidArgs := ObjectIDSQL(value)
+			docSQL += oidSQL
+			args = append(args, oidArgs...)
 		case *types.Array:
 			var sqlArray string
-
-			sqlArray, err = PrepareArrayForSQL(value)
+			var arrayArgs []any
+			sqlArray, arrayArgs, err = PrepareArrayForSQL(value)
+			if err != nil {
+				return
+			}
 
 			docSQL += sqlArray
+			args = append(args, arrayArgs...)
 
 		case types.Document:
-
-			docSQL += "%s"
-
 			var docValue string
-			docValue, err = whereDocument(value)
+			var docArgs []any
+			docValue, docArgs, err = whereDocument(value)
 			if err != nil {
 				return
 			}
 
-			args = append(args, docValue)
+			docSQL += docValue
+			args = append(args, docArgs...)
 
 		default:
 			err = NewErrorMessage(ErrBadValue, "the document used in filter contains a datatype not yet supported: %T", value)
@@ -263,15 +218,14 @@ func whereDocument(doc types.Document) (docSQL string, err error) {
 		}
 	}
 
-	docSQL = fmt.Sprintf(docSQL, args...) + "}"
+	docSQL += "}"
 
 	return
 }
 
 // PrepareArrayForSQL prepares an array which is inside of a document for SQL
-func PrepareArrayForSQL(a *types.Array) (sqlArray string, err error) {
+func PrepareArrayForSQL(a *types.Array) (sqlArray string, args []any, err error) {
 	var value any
-	var args []any
 	sqlArray += "["
 	for i := 0; i < a.Len(); i++ {
 		if i != 0 {
@@ -283,477 +237,703 @@ func PrepareArrayForSQL(a *types.Array) (sqlArray string, err error) {
 			return
 		}
 
+		var sql string
+		var sqlArgs []any
 		switch value := value.(type) {
 		case string, int32, int64, float64, types.ObjectID, nil, bool:
-			var sql string
-			sql, _, err = whereValue(value)
-			sqlArray += sql
+			sql, _, sqlArgs, err = whereValue(value)
 		case *types.Array:
-			var sql string
-			sql, err = PrepareArrayForSQL(value)
-			if err != nil {
-				return
-			}
-			sqlArray += "%s"
-			args = append(args, sql)
-
+			sql, sqlArgs, err = PrepareArrayForSQL(value)
 		case types.Document:
-
-			sqlArray += "%s"
-
-			var docValue string
-			docValue, err = whereDocument(value)
-			if err != nil {
-				return
-			}
-
-			args = append(args, docValue)
-
+			sql, sqlArgs, err = whereDocument(value)
 		default:
 			err = NewErrorMessage(ErrBadValue, "The array used in filter contains a datatype not yet supported: %T", value)
+		}
+		if err != nil {
 			return
 		}
+
+		sqlArray += sql
+		args = append(args, sqlArgs...)
 	}
 
 	sqlArray += "]"
-	sqlArray = fmt.Sprintf(sqlArray, args...)
 
 	return
 }
 
-var (
-	isNor      bool
-	norCounter int
-)
+// whereCompiler compiles a filter to SQL. The filter is first parsed into a tree of whereExpr,
+// which is then written as SQL. A compiler holds the state of a single compilation.
+type whereCompiler struct {
+	sql  strings.Builder
+	args []any
 
-// logicExpression converts expressions like $AND and $OR to the equivalent expressions in SQL.
-func logicExpression(key string, value any) (kvSQL string, err error) {
-	logicExprMap := map[string]string{
-		"$and": " AND ",
-		"$or":  " OR ",
-		"$nor": " AND NOT (",
-	}
+	// negations is the number of NOT enclosing the expression being written within the current FOR ANY.
+	negations int
 
-	lowerKey := strings.ToLower(key)
+	// inverted is true if the expression being written is enclosed in an odd number of NOT.
+	inverted bool
+
+	// postFilter is the first operator which has to be evaluated on the selected documents.
+	postFilter string
+}
 
-	var logicExpr string
-	var ok bool
-	if logicExpr, ok = logicExprMap[lowerKey]; !ok {
-		err = NewErrorMessage(ErrNotImplemented, "support for %s is not implemented yet", key)
-		if strings.EqualFold(key, "$not") {
-			err = fmt.Errorf("unknown top level: %s. If you are trying to negate an entire expression, use $nor", key)
+// elementField is the element of the array FOR ANY iterates over.
+var elementField = whereField{sql: `"element"`, element: true}
+
+// compileFilter parses the filter into an expression tree.
+// Fields are prefixed with prefix, which is "element." within $elemMatch.
+// It returns nil for an empty filter.
+func (c *whereCompiler) compileFilter(filter types.Document, prefix string) (whereExpr, error) {
+	var exprs andExpr
+	for _, key := range filter.Keys() {
+		value := filter.Map()[key]
+
+		var expr whereExpr
+		var err error
+		if strings.HasPrefix(key, "$") { // {$: value}
+			expr, err = c.compileLogic(key, value, prefix)
+		} else {
+			expr, err = c.compileField(key, value, prefix)
 		}
-		return
+		if err != nil {
+			return nil, err
+		}
+
+		exprs = append(exprs, expr)
 	}
 
-	var localIsNor bool
-	if strings.EqualFold(key, "$nor") {
-		localIsNor = true
-		isNor = true
-		norCounter++
+	return exprs.simplify(), nil
+}
+
+// compileLogic parses $and, $or and $nor.
+func (c *whereCompiler) compileLogic(key string, value any, prefix string) (whereExpr, error) {
+	lowerKey := strings.ToLower(key)
+	switch lowerKey {
+	case "$and", "$or", "$nor":
+	case "$expr", "$jsonschema":
+		return c.compilePostFilter(key), nil
+	case "$not":
+		return nil, fmt.Errorf("unknown top level: %s. If you are trying to negate an entire expression, use $nor", key)
+	default:
+		return nil, NewErrorMessage(ErrNotImplemented, "support for %s is not implemented yet", key)
 	}
 
-	kvSQL += "("
+	arr, ok := value.(*types.Array)
+	if !ok {
+		return nil, NewErrorMessage(ErrBadValue, "%s must be an array", lowerKey)
+	}
+	if arr.Len() == 0 {
+		return nil, NewErrorMessage(ErrBadValue, "$and/$or/$nor must be a nonempty array")
+	}
+	if arr.Len() < 2 && lowerKey != "$nor" {
+		return nil, fmt.Errorf("need minimum two expressions")
+	}
 
-	switch value := value.(type) {
-	case *types.Array:
-		if value.Len() < 2 && !isNor {
-			err = fmt.Errorf("need minimum two expressions")
-			return
+	exprs := make([]whereExpr, arr.Len())
+	for i := 0; i < arr.Len(); i++ {
+		doc, ok := must(arr.Get(i)).(types.Document)
+		if !ok {
+			return nil, NewErrorMessage(ErrBadValue, "%s entries need to be full objects", lowerKey)
 		}
-		var expr any
-		for i := 0; i < value.Len(); i++ {
 
-			expr, err = value.Get(i)
-			if err != nil {
-				return
-			}
-
-			switch expr := expr.(type) {
-			case types.Document:
+		expr, err := c.compileFilter(doc, prefix)
+		if err != nil {
+			return nil, err
+		}
+		if expr == nil { // {} matches every document
+			expr = constExpr(true)
+		}
 
-				if i == 0 && localIsNor {
-					kvSQL += " NOT ("
-				}
-				if i != 0 {
-					kvSQL += logicExpr
-				}
+		if lowerKey == "$nor" {
+			expr = notExpr{expr: expr}
+		}
+		exprs[i] = expr
+	}
 
-				var value any
-				var exprSQL string
-				for i, k := range expr.Keys() {
+	if lowerKey == "$or" {
+		return orExpr(exprs), nil
+	}
 
-					if i != 0 {
-						kvSQL += " AND "
-					}
+	return andExpr(exprs), nil
+}
 
-					value, err = expr.Get(k)
-					if err != nil {
-						return
-					}
-					exprSQL, err = wherePair(k, value)
-					if err != nil {
-						return
-					}
+// compileField parses {field: value} and {field: {$: value}}.
+func (c *whereCompiler) compileField(key string, value any, prefix string) (whereExpr, error) {
+	sql, err := WhereKey(prefix + key)
+	if err != nil {
+		return nil, err
+	}
+	field := whereField{path: key, sql: sql, element: prefix != ""}
 
-					kvSQL += exprSQL
+	if doc, ok := value.(types.Document); ok && len(doc.Keys()) != 0 && strings.HasPrefix(doc.Keys()[0], "$") {
+		return c.compileOperators(field, doc)
+	}
 
-				}
+	return c.compileEqual(field, value)
+}
 
-			default:
-				err = lazyerrors.Errorf("Found in array of logicExpression no document but instead the datatype: %T", value)
-				return
+// compileOperators parses the operators of {field: {$: value}}.
+func (c *whereCompiler) compileOperators(field whereField, doc types.Document) (whereExpr, error) {
+	var exprs andExpr
+	for _, op := range doc.Keys() {
+		value := doc.Map()[op]
+
+		var expr whereExpr
+		var err error
+		switch lowerOp := strings.ToLower(op); lowerOp {
+		case "$eq":
+			expr, err = c.compileEqual(field, value)
+		case "$ne":
+			if expr, err = c.compileEqual(field, value); err == nil {
+				expr = notExpr{expr: expr}
+			}
+		case "$gt", "$gte", "$lt", "$lte":
+			expr, err = c.compileCompare(field, lowerOp, value)
+		case "$exists":
+			exists, ok := value.(bool)
+			if !ok {
+				// TODO: allow $exists to be other datatypes than boolean
+				return nil, fmt.Errorf("$exists only works with boolean")
+			}
+			expr = existsExpr{field: field, exists: exists}
+		case "$in":
+			expr, err = c.compileIn(field, value)
+		case "$nin":
+			if expr, err = c.compileIn(field, value); err == nil {
+				expr = notExpr{expr: expr}
 			}
-			if localIsNor {
-				kvSQL += ")"
+		case "$size":
+			var vSQL string
+			var args []any
+			if vSQL, _, args, err = whereValue(value); err == nil {
+				expr = predicateExpr{field: field, left: "CARDINALITY(" + field.sql + ")", op: " = ", value: vSQL, args: args}
 			}
+		case "$all":
+			expr, err = c.compileAll(field, value)
+		case "$elemmatch":
+			expr, err = c.compileElemMatch(field, value)
+		case "$not":
+			expr, err = c.compileNot(field, value)
+		case "$regex":
+			options, _ := doc.Map()["$options"].(string)
+			expr, err = c.compileRegex(field, value, options)
+		case "$options":
+			if _, ok := doc.Map()["$regex"]; !ok {
+				return nil, NewErrorMessage(ErrBadValue, "$options needs a $regex")
+			}
+			continue
+		case "$type", "$mod":
+			expr = c.compilePostFilter(op)
+		default:
+			return nil, NewErrorMessage(ErrNotImplemented, "support for %s is not implemented yet", op)
+		}
+		if err != nil {
+			return nil, err
 		}
 
-	default:
-		err = NewErrorMessage(ErrBadValue, "%s must be an array", lowerKey)
-		return
-
+		exprs = append(exprs, expr)
 	}
 
-	kvSQL += ")"
+	return exprs.simplify(), nil
+}
 
-	if localIsNor {
-		norCounter--
-		if norCounter == 0 {
-			isNor = false
-		}
+// compilePostFilter returns the expression of an operator SAP HANA cannot evaluate.
+// The operator is evaluated by FilterDocument on the documents selected by the rest of the filter.
+func (c *whereCompiler) compilePostFilter(op string) whereExpr {
+	if c.postFilter == "" {
+		c.postFilter = op
 	}
-	return
+
+	return postFilterExpr{}
 }
 
-// fieldExpression converts expressions like $gt or $elemMatch to the equivalent expression in SQL.
-// Used for {field: {$: value}}.
-func fieldExpression(key string, value any) (kvSQL string, err error) {
-	fieldExprMap := map[string]string{
-		"$gt":        " > ",
-		"$gte":       " >= ",
-		"$lt":        " < ",
-		"$lte":       " <= ",
-		"$eq":        " = ",
-		"$ne":        " <> ",
-		"$exists":    " IS ",
-		"$size":      "CARDINALITY",
-		"$all":       "all",
-		"$elemmatch": "elemMatch",
-		"$not":       " NOT ",
-		"$regex":     " LIKE ",
-	}
-
-	var kSQL string
-	kSQL, err = whereKey(key)
+// compileEqual parses the equality of a field and a value.
+func (c *whereCompiler) compileEqual(field whereField, value any) (whereExpr, error) {
+	switch value := value.(type) {
+	case nil:
+		return nullExpr{field: field}, nil
+	case types.Regex:
+		return c.compileRegex(field, value, "")
+	}
+
+	vSQL, _, args, err := whereValue(value)
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	switch value := value.(type) {
-	case types.Document:
+	return predicateExpr{field: field, left: field.sql, op: " = ", value: vSQL, args: args}, nil
+}
 
-		var exprValue any
-		var vSQL string
-		for i, k := range value.Keys() {
+// compileCompare parses $gt, $gte, $lt and $lte.
+func (c *whereCompiler) compileCompare(field whereField, op string, value any) (whereExpr, error) {
+	if value == nil {
+		return nullExpr{field: field}, nil
+	}
 
-			if i != 0 {
-				kvSQL += " AND "
-			}
-			kvSQL += kSQL
+	vSQL, _, args, err := whereValue(value)
+	if err != nil {
+		return nil, err
+	}
 
-			lowerK := strings.ToLower(k)
+	compareOps := map[string]string{
+		"$gt":  " > ",
+		"$gte": " >= ",
+		"$lt":  " < ",
+		"$lte": " <= ",
+	}
 
-			fieldExpr, ok := fieldExprMap[lowerK]
-			if !ok {
-				err = NewErrorMessage(ErrNotImplemented, "support for %s is not implemented yet", k)
-				return
-			}
+	return predicateExpr{field: field, left: field.sql, op: compareOps[op], value: vSQL, args: args}, nil
+}
 
-			exprValue, err = value.Get(k)
-			if err != nil {
-				return
-			}
-			var sign string
-			if lowerK == "$exists" {
-				switch exprValue := exprValue.(type) {
-				case bool:
-					if exprValue {
-						vSQL = "SET"
-					} else {
-						vSQL = "UNSET"
-					}
-				default:
-					// TODO: allow $exists to be other datatypes than boolean
-					err = fmt.Errorf("$exists only works with boolean")
-					return
-				}
-			} else if lowerK == "$size" {
-				kvSQL = fieldExpr + "(" + kvSQL + ")"
-				vSQL, fieldExpr, err = whereValue(exprValue)
-				if err != nil {
-					return
-				}
-			} else if lowerK == "$all" || lowerK == "$elemmatch" {
-				kvSQL, err = filterArray(kvSQL, fieldExpr, exprValue)
-				if err != nil {
-					return
-				}
-				continue
-			} else if lowerK == "$not" {
-				var fieldSQL string
-				expr := value.Map()[k]
-				fieldSQL, err = fieldExpression(key, expr)
-				fieldSQL = "(" + fieldExpr + fieldSQL + " OR " + kSQL + " IS UNSET) "
-				if err != nil {
-					err = NewErrorMessage(ErrBadValue, "wrong use of $not")
-					return
-				}
+// compileRegex parses $regex and regular expressions used as values:
+// the field or a string in the array in the field matches the regular expression.
+func (c *whereCompiler) compileRegex(field whereField, value any, options string) (whereExpr, error) {
+	pattern, flags, err := regexFlags(value, options)
+	if err != nil {
+		return nil, err
+	}
 
-				kvSQL = fieldSQL
-				return
-			} else if lowerK == "$ne" {
-				kvSQL = "(" + kvSQL
-				vSQL, sign, err = whereValue(exprValue)
-				if err != nil {
-					return
-				}
-				if strings.EqualFold(sign, " IS ") {
-					fieldExpr = " IS NOT "
-				}
+	if !likeRegexprCompiles(pattern, flags) {
+		return c.compilePostFilter("$regex"), nil
+	}
 
-				vSQL += " OR " + kSQL + " IS UNSET)"
-			} else if lowerK == "$regex" {
-				vSQL, err = regex(exprValue)
-			} else {
-				vSQL, sign, err = whereValue(exprValue)
-				if err != nil {
-					return
-				}
+	vSQL := "?"
+	if flags != "" {
+		vSQL += " FLAG '" + flags + "'"
+	}
 
-				if strings.EqualFold(sign, " IS ") {
-					fieldExpr = sign
-				}
-			}
+	expr := predicateExpr{field: field, left: field.sql, op: " LIKE_REGEXPR ", value: vSQL, args: []any{pattern}}
+	if field.element {
+		return expr, nil
+	}
 
-			kvSQL += fieldExpr + vSQL
-			if isNor {
-				kvSQL = "(" + kvSQL + " AND " + kSQL + " IS SET)"
-			}
+	return orExpr{
+		expr,
+		anyExpr{
+			field: field,
+			expr:  predicateExpr{field: elementField, left: elementField.sql, op: " LIKE_REGEXPR ", value: vSQL, args: []any{pattern}},
+		},
+	}, nil
+}
 
+// compileNot parses $not, which negates operators or a regular expression.
+func (c *whereCompiler) compileNot(field whereField, value any) (whereExpr, error) {
+	var expr whereExpr
+	var err error
+	switch value := value.(type) {
+	case types.Document:
+		if len(value.Keys()) == 0 {
+			return nil, NewErrorMessage(ErrBadValue, "$not cannot be empty")
 		}
-
+		expr, err = c.compileOperators(field, value)
+	case types.Regex:
+		expr, err = c.compileRegex(field, value, "")
 	default:
-		err = NewErrorMessage(ErrBadValue, "In use of field expression a document was expected. Got instead: %T", value)
+		return nil, NewErrorMessage(ErrBadValue, "$not needs a regex or a document")
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return
+	return notExpr{expr: expr}, nil
 }
 
-// filterArray implements $all and $elemMatch using the FOR ANY
-func filterArray(field string, arrayOperator string, filters any) (kvSQL string, err error) {
-	switch filters := filters.(type) {
-	case types.Document:
-		if strings.EqualFold(arrayOperator, "all") {
-			err = NewErrorMessage(ErrBadValue, "$all needs an array")
-			return
-		}
-		i := 0
-		for f, v := range filters.Map() {
+// compileIn parses $in: the field or an element of the array in the field equals one of the values
+// or matches one of the regular expressions. null matches missing fields.
+func (c *whereCompiler) compileIn(field whereField, value any) (whereExpr, error) {
+	in, ok := value.(*types.Array)
+	if !ok {
+		return nil, NewErrorMessage(ErrBadValue, "$in needs an array")
+	}
 
-			if i != 0 {
-				kvSQL += " AND "
-			}
-			var doc types.Document
-			doc, err = types.MakeDocument([]any{f, v}...)
-			if err != nil {
-				return
+	var exprs orExpr
+	var list []string
+	var args []any
+	for i := 0; i < in.Len(); i++ {
+		var vSQL string
+		var vArgs []any
+		var err error
+		switch v := must(in.Get(i)).(type) {
+		case nil:
+			exprs = append(exprs, nullExpr{field: field})
+			continue
+		case types.Regex:
+			var expr whereExpr
+			if expr, err = c.compileRegex(field, v, ""); err != nil {
+				return nil, err
 			}
-			var sql string
-			if strings.Contains(doc.Keys()[0], "$") {
-				sql, err = wherePair("element", doc)
-
-				if strings.EqualFold(doc.Keys()[0], "$not") {
-					sqlSlice := strings.Split(sql, "OR")
-					sql = strings.Replace(sqlSlice[0], "(", "", 1)
-				}
-				if strings.Contains(sql, " IS SET") {
-					sqlSlice := strings.Split(sql, " AND ")
-					sql = strings.Replace(sqlSlice[0], "(", "", 1)
-				}
+			if regexExprs, ok := expr.(orExpr); ok {
+				exprs = append(exprs, regexExprs...)
 			} else {
-				var value any
-				element := "element." + doc.Keys()[0]
-				value, err = doc.Get(doc.Keys()[0])
-				if err != nil {
-					return
-				}
-
-				sql, err = wherePair(element, value)
-				if _, ok := value.(types.Document); ok {
-					if _, getErr := value.(types.Document).Get("$not"); getErr == nil {
-						replaceIndex := strings.LastIndex(sql, "UNSET")
-						sql = sql[:replaceIndex] + strings.Replace(sql[replaceIndex:], "UNSET", "NULL", 1)
-					}
-				}
-				if strings.Contains(sql, " IS SET") {
-					sqlSlice := strings.Split(sql, " AND ")
-					sql = strings.Replace(sqlSlice[0], "(", "", 1)
-				}
+				exprs = append(exprs, expr)
 			}
-
-			if err != nil {
-				return
+			continue
+		case types.Document:
+			if keys := v.Keys(); len(keys) != 0 && strings.HasPrefix(keys[0], "$") {
+				return nil, NewErrorMessage(ErrBadValue, "cannot nest $ under $in")
 			}
+			vSQL, vArgs, err = whereDocument(v)
+		case *types.Array:
+			vSQL, vArgs, err = PrepareArrayForSQL(v)
+		default:
+			vSQL, _, vArgs, err = whereValue(v)
+		}
+		if err != nil {
+			return nil, err
+		}
 
-			if i == 0 {
-				kvSQL += "FOR ANY \"element\" IN " + field + " SATISFIES "
-			}
-			kvSQL += sql
-			i++
+		list = append(list, vSQL)
+		args = append(args, vArgs...)
+	}
+
+	if len(list) != 0 {
+		listSQL := "(" + strings.Join(list, ", ") + ")"
+		inExprs := orExpr{predicateExpr{field: field, left: field.sql, op: " IN ", value: listSQL, args: args}}
+
+		// elements of arrays within arrays are not compared
+		if !field.element {
+			inExprs = append(inExprs, anyExpr{
+				field: field,
+				expr:  predicateExpr{field: elementField, left: elementField.sql, op: " IN ", value: listSQL, args: args},
+			})
 		}
 
-		kvSQL += " END "
+		exprs = append(inExprs, exprs...)
+	}
 
-	case *types.Array:
-		if strings.EqualFold(arrayOperator, "elemmatch") {
-			err = NewErrorMessage(ErrBadValue, "$elemMatch needs an object")
-			return
+	switch len(exprs) {
+	case 0:
+		return constExpr(false), nil
+	case 1:
+		return exprs[0], nil
+	default:
+		return exprs, nil
+	}
+}
+
+// compileAll parses $all: the array contains every value.
+func (c *whereCompiler) compileAll(field whereField, value any) (whereExpr, error) {
+	arr, ok := value.(*types.Array)
+	if !ok {
+		return nil, NewErrorMessage(ErrBadValue, "$all needs an array")
+	}
+	if arr.Len() == 0 {
+		return constExpr(false), nil
+	}
+
+	exprs := make(andExpr, arr.Len())
+	for i := 0; i < arr.Len(); i++ {
+		expr, err := c.compileEqual(elementField, must(arr.Get(i)))
+		if err != nil {
+			return nil, err
 		}
-		var value string
-		var v any
-		for i := 0; i < filters.Len(); i++ {
+		exprs[i] = anyExpr{field: field, expr: expr}
+	}
 
-			if i != 0 {
-				kvSQL += " AND "
-			}
-			v, err = filters.Get(i)
-			if err != nil {
-				return
-			}
-			value, _, err = whereValue(v)
-			if err != nil {
-				return
-			}
-			kvSQL += "FOR ANY \"element\" IN " + field + " SATISFIES \"element\" = " + value + " END "
+	return exprs.simplify(), nil
+}
+
+// compileElemMatch parses $elemMatch: an element of the array matches the filter.
+func (c *whereCompiler) compileElemMatch(field whereField, value any) (whereExpr, error) {
+	doc, ok := value.(types.Document)
+	if !ok {
+		return nil, NewErrorMessage(ErrBadValue, "$elemMatch needs an object")
+	}
+
+	var expr whereExpr
+	var err error
+	if keys := doc.Keys(); len(keys) != 0 && isFieldOperator(keys[0]) {
+		expr, err = c.compileOperators(elementField, doc)
+	} else {
+		expr, err = c.compileFilter(doc, "element.")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if expr == nil {
+		expr = constExpr(true)
+	}
+
+	return anyExpr{field: field, expr: expr}, nil
+}
+
+// isFieldOperator returns true if the key is an operator applied to a field, like $gt,
+// rather than an operator applied to the document, like $and or $expr.
+func isFieldOperator(key string) bool {
+	switch strings.ToLower(key) {
+	case "$and", "$or", "$nor", "$expr", "$jsonschema":
+		return false
+	}
+
+	return strings.HasPrefix(key, "$")
+}
+
+// writeList writes the expressions separated by sep.
+func (c *whereCompiler) writeList(exprs []whereExpr, sep string) {
+	for i, expr := range exprs {
+		if i != 0 {
+			c.sql.WriteString(sep)
 		}
-	default:
-		err = NewErrorMessage(ErrBadValue, "If $all: Expected array. If $elemMatch: Expected document. Got instead: %T", filters)
+		expr.writeSQL(c)
+	}
+}
+
+// writeGuarded writes an expression which is unknown for a missing field.
+// Under NOT it is combined with the condition that the field is set,
+// so that documents without the field are matched like MongoDB does.
+func (c *whereCompiler) writeGuarded(field whereField, write func()) {
+	if c.negations == 0 {
+		write()
 		return
 	}
 
-	return
+	c.sql.WriteString("(")
+	write()
+	c.sql.WriteString(" AND " + field.isSetSQL() + ")")
 }
 
-// regex converts $regex to the SQL equivalent regular expressions
-func regex(value any) (vSQL string, err error) {
-	if regex, ok := value.(types.Regex); ok {
-		value = regex.Pattern
-		if regex.Options != "" {
-			err = NewErrorMessage(ErrNotImplemented, "The use of $options with regular expressions is not supported")
-			return
-		}
+// whereExpr is a node of the expression tree of a filter.
+type whereExpr interface {
+	// writeSQL writes the SQL of the expression and adds the arguments of its placeholders.
+	writeSQL(c *whereCompiler)
+}
+
+// whereField is a field conditions are applied to.
+type whereField struct {
+	path    string // path in dot notation, relative to the element within $elemMatch
+	sql     string
+	element bool // the field is the element of FOR ANY or a field of it
+}
+
+// isSetSQL returns the SQL condition that the field is set.
+// Fields of the element of FOR ANY are compared to NULL instead.
+func (f whereField) isSetSQL() string {
+	if f.element {
+		return f.sql + " IS NOT NULL"
 	}
 
-	var escape bool
-	switch value := value.(type) {
-	case string:
-		if strings.Contains(value, "(?i)") || strings.Contains(value, "(?-i)") {
-			err = NewErrorMessage(ErrNotImplemented, "The use of (?i) and (?-i) with regular expressions is not supported")
-			return
-		}
+	return f.sql + " IS SET"
+}
 
-		var dot bool
-		for i, s := range value {
-			if i == 0 {
-				if s == '^' {
-					continue
-				}
-				if s == '.' {
-					dot = true
-					continue
-				}
-				if s == '%' || s == '_' {
-					vSQL += "%" + "^" + string(s)
-					escape = true
-					continue
-				}
-				vSQL += "%" + string(s)
-				continue
-			}
+// isUnsetSQL returns the SQL condition that the field is not set.
+func (f whereField) isUnsetSQL() string {
+	if f.element {
+		return f.sql + " IS NULL"
+	}
 
-			if dot && s != '*' && i == 1 {
-				vSQL += "%_"
-				if s != '.' {
-					dot = false
-				} else {
-					continue
-				}
-			}
+	return f.sql + " IS UNSET"
+}
 
-			if i == len(value)-1 {
-				if dot && s != '*' {
-					vSQL += "_"
-					if s == '.' {
-						vSQL += "_%"
-						continue
-					} else {
-						dot = false
-					}
-				}
-				if s == '$' {
-					continue
-				}
-				if s == '*' && dot {
-					vSQL += "%%"
-					continue
-				}
-				if s == '.' {
-					vSQL += "_%"
-					continue
-				}
-				if s == '%' || s == '_' {
-					vSQL += "^" + string(s) + "%"
-					escape = true
-					continue
-				}
-				vSQL += string(s) + "%"
-				continue
-			}
+// andExpr is the conjunction of expressions.
+type andExpr []whereExpr
 
-			if dot && s != '*' {
-				vSQL += "_"
-				if s == '.' {
-					continue
-				} else {
-					dot = false
-				}
-			}
+// simplify returns nil for no expression and the expression itself for a single one.
+func (e andExpr) simplify() whereExpr {
+	switch len(e) {
+	case 0:
+		return nil
+	case 1:
+		return e[0]
+	default:
+		return e
+	}
+}
 
-			if s == '.' {
-				dot = true
-				continue
-			} else if s == '*' && dot {
-				vSQL += "%"
-				dot = false
-				continue
-			} else if s == '%' || s == '_' {
-				vSQL += "^" + string(s)
-				escape = true
-				continue
+func (e andExpr) writeSQL(c *whereCompiler) {
+	c.sql.WriteString("(")
+	c.writeList(e, " AND ")
+	c.sql.WriteString(")")
+}
+
+// orExpr is the disjunction of expressions.
+type orExpr []whereExpr
+
+func (e orExpr) writeSQL(c *whereCompiler) {
+	c.sql.WriteString("(")
+	c.writeList(e, " OR ")
+	c.sql.WriteString(")")
+}
+
+// notExpr negates an expression.
+type notExpr struct {
+	expr whereExpr
+}
+
+func (e notExpr) writeSQL(c *whereCompiler) {
+	c.sql.WriteString("NOT (")
+	c.negations++
+	c.inverted = !c.inverted
+	e.expr.writeSQL(c)
+	c.inverted = !c.inverted
+	c.negations--
+	c.sql.WriteString(")")
+}
+
+// constExpr is always true or always false.
+type constExpr bool
+
+func (e constExpr) writeSQL(c *whereCompiler) {
+	if e {
+		c.sql.WriteString("1 = 1")
+	} else {
+		c.sql.WriteString("1 = 0")
+	}
+}
+
+// postFilterExpr is a condition SAP HANA cannot evaluate.
+// It is written as a condition which holds for every document the condition could match:
+// true, or false if it is negated.
+type postFilterExpr struct{}
+
+func (e postFilterExpr) writeSQL(c *whereCompiler) {
+	constExpr(!c.inverted).writeSQL(c)
+}
+
+// predicateExpr compares a field, like "field" > ?, or a value computed from it, like CARDINALITY("field") = ?.
+type predicateExpr struct {
+	field whereField
+	left  string // SQL of the left operand
+	op    string // like " = " or " LIKE "
+	value string // SQL of the right operand
+	args  []any
+}
+
+func (e predicateExpr) writeSQL(c *whereCompiler) {
+	c.writeGuarded(e.field, func() {
+		c.sql.WriteString(e.left + e.op + e.value)
+		c.args = append(c.args, e.args...)
+	})
+}
+
+// existsExpr is the condition that a field is set or not set.
+type existsExpr struct {
+	field  whereField
+	exists bool
+}
+
+func (e existsExpr) writeSQL(c *whereCompiler) {
+	if e.exists {
+		c.sql.WriteString(e.field.isSetSQL())
+	} else {
+		c.sql.WriteString(e.field.isUnsetSQL())
+	}
+}
+
+// nullExpr matches a field which is null or not set.
+type nullExpr struct {
+	field whereField
+}
+
+func (e nullExpr) writeSQL(c *whereCompiler) {
+	if e.field.element {
+		c.sql.WriteString(e.field.sql + " IS NULL")
+		return
+	}
+
+	c.sql.WriteString("(" + e.field.sql + " IS NULL OR " + e.field.isUnsetSQL() + ")")
+}
+
+// anyExpr matches an array with an element satisfying the expression, which refers to the element as "element".
+type anyExpr struct {
+	field whereField
+	expr  whereExpr
+}
+
+func (e anyExpr) writeSQL(c *whereCompiler) {
+	c.writeGuarded(e.field, func() {
+		c.sql.WriteString(`FOR ANY "element" IN ` + e.field.sql + " SATISFIES ")
+
+		// an element for which the condition is unknown does not satisfy it,
+		// so the condition is not affected by enclosing NOTs
+		negations := c.negations
+		c.negations = 0
+		e.expr.writeSQL(c)
+		c.negations = negations
+
+		c.sql.WriteString(" END")
+	})
+}
+
+// likeRegexprCompiles returns true if LIKE_REGEXPR can compile the pattern with the flags.
+// Other regular expressions are evaluated by FilterDocument, with the regular expressions of Go.
+func likeRegexprCompiles(pattern, flags string) bool {
+	// SAP HANA compiles patterns as C strings, which end at a NUL character
+	if strings.ContainsRune(pattern, 0) {
+		return false
+	}
+
+	// FLAG only takes i, m, s and x, while the option u of MongoDB is implied by Go
+	if strings.ContainsRune(flags, 'u') {
+		return false
+	}
+
+	// Unicode properties depend on the PCRE build of SAP HANA, Go supports them
+	var escaped bool
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			if r == 'p' || r == 'P' || r == 'X' {
+				return false
 			}
+			escaped = false
+		case r == '\\':
+			escaped = true
+		}
+	}
 
-			vSQL += string(s)
+	return true
+}
 
+// regexFlags returns the pattern of $regex and the flags of LIKE_REGEXPR for its options.
+// Like in FilterDocument, $options take precedence over the options of a regular expression.
+//
+// Both SAP HANA and MongoDB use PCRE, so the pattern is passed on as it is.
+// It is only checked for syntax errors: constructs Go does not know, like lookarounds
+// and backreferences, are left to SAP HANA. The option u is returned as a flag,
+// see likeRegexprCompiles.
+func regexFlags(value any, options string) (pattern string, flags string, err error) {
+	switch value := value.(type) {
+	case string:
+		pattern = value
+	case types.Regex:
+		pattern = value.Pattern
+		if options == "" {
+			options = value.Options
 		}
 	default:
-		err = NewErrorMessage(ErrBadValue, "Expected either a JavaScript regular expression objects (i.e. /pattern/) or string containing a pattern. Got instead type %T", value)
+		err = NewErrorMessage(ErrBadValue, "$regex has to be a string")
 		return
 	}
 
-	vSQL = "'" + vSQL + "'"
-	if escape {
-		vSQL += " ESCAPE '^' "
+	for _, o := range options {
+		switch o {
+		case 'i', 'm', 's', 'u', 'x':
+			if !strings.ContainsRune(flags, o) {
+				flags += string(o)
+			}
+		default:
+			err = NewErrorMessage(ErrRegexOptions, "invalid flag in regex options: %c", o)
+			return
+		}
+	}
+
+	goPattern := pattern
+	if strings.ContainsRune(flags, 'x') {
+		goPattern = stripExtendedRegex(goPattern)
+	}
+
+	if _, parseErr := syntax.Parse(goPattern, syntax.Perl); parseErr != nil {
+		var syntaxErr *syntax.Error
+		if errors.As(parseErr, &syntaxErr) {
+			switch syntaxErr.Code {
+			case syntax.ErrInvalidEscape, syntax.ErrInvalidNamedCapture, syntax.ErrInvalidPerlOp,
+				syntax.ErrInvalidRepeatOp, syntax.ErrInvalidRepeatSize:
+				return
+			}
+		}
+
+		err = NewErrorMessage(ErrBadValue, "Regular expression is invalid: %s", parseErr)
 	}
 
 	return
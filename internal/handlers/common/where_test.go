@@ -6,7 +6,9 @@ package common
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
@@ -28,28 +30,33 @@ func TestWhere(t *testing.T) {
 			"equal_document", types.MustMakeDocument("field", int32(123)),
 			"equal_float64", float64(123.123),
 			"equal_objId", types.ObjectID{98, 226, 189, 84, 81, 6, 131, 249, 192, 187, 13, 107},
-		), e: expectedWhereKey{sql: " WHERE \"equal_string\" = 'string' AND \"equal_int32\" = 1 AND \"equal_int64\" = 123123123123 AND \"equal_bool\" = to_json_boolean(true) AND " +
-			"\"equal_eq\" = 'equal' AND \"equal_document\" = {\"field\": 123} AND \"equal_float64\" = 123.123000 AND \"equal_objId\" = {\"oid\":'62e2bd54510683f9c0bb0d6b'}", err: nil}},
+		), e: expectedWhereKey{sql: " WHERE \"equal_string\" = ? AND \"equal_int32\" = ? AND \"equal_int64\" = ? AND \"equal_bool\" = to_json_boolean(?) AND " +
+			"\"equal_eq\" = ? AND \"equal_document\" = {\"field\": ?} AND \"equal_float64\" = ? AND \"equal_objId\" = {\"oid\": ?}",
+			args: []any{"string", int32(1), int64(123123123123), true, "equal", int32(123), float64(123.123), "62e2bd54510683f9c0bb0d6b"}, err: nil}},
 		{name: "where comparison test", r: types.MustMakeDocument("greaterThan_int32", types.MustMakeDocument("$gt", int32(12)),
 			"lessThan_int64", types.MustMakeDocument("$lt", int64(123123)),
-		), e: expectedWhereKey{sql: " WHERE \"greaterThan_int32\" > 12 AND \"lessThan_int64\" < 123123", err: nil}},
+		), e: expectedWhereKey{sql: " WHERE \"greaterThan_int32\" > ? AND \"lessThan_int64\" < ?", args: []any{int32(12), int64(123123)}, err: nil}},
 		{
 			name: "logic expression test", r: types.MustMakeDocument("$or", types.MustNewArray(types.MustMakeDocument("field", "new"), types.MustMakeDocument("field2", true))),
-			e: expectedWhereKey{sql: " WHERE (\"field\" = 'new' OR \"field2\" = to_json_boolean(true))", err: nil},
+			e: expectedWhereKey{sql: " WHERE (\"field\" = ? OR \"field2\" = to_json_boolean(?))", args: []any{"new", true}, err: nil},
+		},
+		{
+			name: "quoted field test", r: types.MustMakeDocument("fi\"eld", "' OR 1 = 1 --"),
+			e: expectedWhereKey{sql: " WHERE \"fi\"\"eld\" = ?", args: []any{"' OR 1 = 1 --"}, err: nil},
 		},
 		{
 			name: "double array index error", r: types.MustMakeDocument("array.1.2", int32(1)),
-			e: expectedWhereKey{sql: " WHERE ", err: fmt.Errorf("NotImplemented (238): not yet supporting indexing on an array inside of an array")},
+			e: expectedWhereKey{sql: "", err: fmt.Errorf("NotImplemented (238): not yet supporting indexing on an array inside of an array")},
 		},
 		{
 			name: "double array index error", r: types.MustMakeDocument("array.1", types.MustNewArray(int32(32))),
-			e: expectedWhereKey{sql: " WHERE ", err: fmt.Errorf("BadValue (2): value *types.Array not supported in filter")},
+			e: expectedWhereKey{sql: "", err: fmt.Errorf("BadValue (2): value *types.Array not supported in filter")},
 		},
 	}
 
 	for _, field := range whereTestCases {
 
-		sql, err := CreateWhereClause(field.r)
+		sql, args, err := CreateWhereClause(field.r)
 
 		if field.e.err != nil {
 			if !strings.EqualFold(sql, field.e.sql) || !strings.Contains(err.Error(), field.e.err.Error()) {
@@ -57,9 +64,9 @@ func TestWhere(t *testing.T) {
 					field.r, field.e.sql, field.e.err, sql, err)
 			}
 		} else {
-			if !strings.EqualFold(sql, field.e.sql) || err != field.e.err {
-				t.Errorf("%s: where(%v) FAILED. Expected sql = %s and err = %v got sql = %s and err = %v", field.name,
-					field.r, field.e.sql, field.e.err, sql, err)
+			if !strings.EqualFold(sql, field.e.sql) || !reflect.DeepEqual(args, field.e.args) || err != field.e.err {
+				t.Errorf("%s: where(%v) FAILED. Expected sql = %s, args = %v and err = %v got sql = %s, args = %v and err = %v", field.name,
+					field.r, field.e.sql, field.e.args, field.e.err, sql, args, err)
 			}
 		}
 
@@ -75,6 +82,7 @@ type testCaseWhereKey struct {
 type expectedWhereKey struct {
 	sql  string
 	sign string
+	args []any
 	err  error
 }
 
@@ -84,22 +92,23 @@ func TestWhereKey(t *testing.T) {
 		{name: "multiple fields test", r: "oneField.twoField.threeField", e: expectedWhereKey{sql: "\"oneField\".\"twoField\".\"threeField\"", err: nil}},
 		{name: "field with array index test", r: "array.0", e: expectedWhereKey{sql: "\"array\"[1]", err: nil}},
 		{name: "mix multiple fields and index test", r: "oneField.array.0.twoField", e: expectedWhereKey{sql: "\"oneField\".\"array\"[1].\"twoField\"", err: nil}},
+		{name: "field with quote test", r: "one\"Field.two", e: expectedWhereKey{sql: "\"one\"\"Field\".\"two\"", err: nil}},
 		{name: "field with negative array index error test", r: "array.-1", e: expectedWhereKey{sql: "", err: fmt.Errorf("negative array index is not allowed")}},
 		{name: "double array index error test", r: "array.0.1", e: expectedWhereKey{sql: "", err: fmt.Errorf("NotImplemented (238): not yet supporting indexing on an array inside of an array")}},
 	}
 
 	for _, field := range whereKeyTestCases {
 
-		sql, err := whereKey(field.r)
+		sql, err := WhereKey(field.r)
 
 		if field.e.err != nil {
 			if !strings.EqualFold(sql, field.e.sql) || !strings.Contains(err.Error(), field.e.err.Error()) {
-				t.Errorf("%s: whereKey(%s) FAILED. Expected sql = %s and err = %v got sql = %s and err = %v", field.name,
+				t.Errorf("%s: WhereKey(%s) FAILED. Expected sql = %s and err = %v got sql = %s and err = %v", field.name,
 					field.r, field.e.sql, field.e.err, sql, err)
 			}
 		} else {
 			if !strings.EqualFold(sql, field.e.sql) || err != field.e.err {
-				t.Errorf("%s: whereKey(%s) FAILED. Expected sql = %s and err = %v got sql = %s and err = %v", field.name,
+				t.Errorf("%s: WhereKey(%s) FAILED. Expected sql = %s and err = %v got sql = %s and err = %v", field.name,
 					field.r, field.e.sql, field.e.err, sql, err)
 			}
 		}
@@ -115,25 +124,15 @@ type testCaseWhereValue struct {
 
 func TestWhereValue(t *testing.T) {
 	whereValueTestCases := []testCaseWhereValue{
-		{name: "string test", r: "string", e: expectedWhereKey{sql: "'string'", sign: " = ", err: nil}},
-		{name: "int32 test", r: int32(123), e: expectedWhereKey{sql: "123", sign: " = ", err: nil}},
-		{name: "int32 test", r: int64(123), e: expectedWhereKey{sql: "123", sign: " = ", err: nil}},
-		{name: "float64 test", r: float64(123.123), e: expectedWhereKey{sql: "123.123000", sign: " = ", err: nil}},
-		{name: "boolean test", r: true, e: expectedWhereKey{sql: "to_json_boolean(true)", sign: " = ", err: nil}},
-		{name: "boolean test", r: true, e: expectedWhereKey{sql: "to_json_boolean(true)", sign: " = ", err: nil}},
+		{name: "string test", r: "string", e: expectedWhereKey{sql: "?", sign: " = ", args: []any{"string"}, err: nil}},
+		{name: "int32 test", r: int32(123), e: expectedWhereKey{sql: "?", sign: " = ", args: []any{int32(123)}, err: nil}},
+		{name: "int64 test", r: int64(123), e: expectedWhereKey{sql: "?", sign: " = ", args: []any{int64(123)}, err: nil}},
+		{name: "float64 test", r: float64(123.123), e: expectedWhereKey{sql: "?", sign: " = ", args: []any{float64(123.123)}, err: nil}},
+		{name: "boolean test", r: true, e: expectedWhereKey{sql: "to_json_boolean(?)", sign: " = ", args: []any{true}, err: nil}},
+		{name: "quote test", r: "' OR 1 = 1 --", e: expectedWhereKey{sql: "?", sign: " = ", args: []any{"' OR 1 = 1 --"}, err: nil}},
 		{name: "nil test", r: nil, e: expectedWhereKey{sql: "NULL", sign: " IS ", err: nil}},
-		{name: "regex no begin and end sign test", r: types.Regex{Pattern: "pattern"}, e: expectedWhereKey{sql: "'%Pattern%'", sign: " LIKE ", err: nil}},
-		{name: "regex with begin and end sign test", r: types.Regex{Pattern: "^pattern$"}, e: expectedWhereKey{sql: "'Pattern'", sign: " LIKE ", err: nil}},
-		{name: "regex with begin and end sign test", r: types.Regex{Pattern: "^pa_tt_ern$"}, e: expectedWhereKey{sql: "'Pa^_tt^_ern' ESCAPE '^' ", sign: " LIKE ", err: nil}},
-		{name: "regex everything test", r: types.Regex{Pattern: "^pa_t.t_er.*n$"}, e: expectedWhereKey{sql: "'Pa^_t_t^_er%n' ESCAPE '^' ", sign: " LIKE ", err: nil}},
-		{name: "regex many dots at beginning test", r: types.Regex{Pattern: "...pa_t.t_er.*n$"}, e: expectedWhereKey{sql: "'%___Pa^_t_t^_er%n' ESCAPE '^' ", sign: " LIKE ", err: nil}},
-		{name: "regex many dots at end test", r: types.Regex{Pattern: "pa_t.t_er.*n..."}, e: expectedWhereKey{sql: "'%Pa^_t_t^_er%n___%' ESCAPE '^' ", sign: " LIKE ", err: nil}},
-		{name: "regex many dots in middle test", r: types.Regex{Pattern: "pa_t...t_er.*n"}, e: expectedWhereKey{sql: "'%Pa^_t___t^_er%n%' ESCAPE '^' ", sign: " LIKE ", err: nil}},
-		{name: "regex use of escape at begin and end test", r: types.Regex{Pattern: "_pa_t...t_er.*n%"}, e: expectedWhereKey{sql: "'%^_Pa^_t___t^_er%n^%%' ESCAPE '^' ", sign: " LIKE ", err: nil}},
-		{name: "regex option error test", r: types.Regex{Pattern: "_pa_t...t_er.*n%", Options: "m"}, e: expectedWhereKey{sql: "", sign: "", err: fmt.Errorf("The use of $options with regular expressions is not supported")}},
-		{name: "regex (i?) error test", r: types.Regex{Pattern: "patt(?i)ern"}, e: expectedWhereKey{sql: "", sign: "", err: fmt.Errorf("The use of (?i) and (?-i) with regular expressions is not supported")}},
-		{name: "regex (?-i) error test", r: types.Regex{Pattern: "pat(?-i)tern"}, e: expectedWhereKey{sql: "", sign: "", err: fmt.Errorf("The use of (?i) and (?-i) with regular expressions is not supported")}},
-		{name: "ObjectID test", r: types.ObjectID{98, 226, 189, 84, 81, 6, 131, 249, 192, 187, 13, 107}, e: expectedWhereKey{sql: "{\"oid\":'62e2bd54510683f9c0bb0d6b'}", sign: " = ", err: nil}},
+		{name: "regex error test", r: types.Regex{Pattern: "pattern"}, e: expectedWhereKey{sql: "", sign: "", err: fmt.Errorf("BadValue (2): value types.Regex not supported in filter")}},
+		{name: "ObjectID test", r: types.ObjectID{98, 226, 189, 84, 81, 6, 131, 249, 192, 187, 13, 107}, e: expectedWhereKey{sql: "{\"oid\": ?}", sign: " = ", args: []any{"62e2bd54510683f9c0bb0d6b"}, err: nil}},
 		{
 			name: "document test", r: types.MustMakeDocument(
 				"bool", true,
@@ -142,24 +141,29 @@ func TestWhereValue(t *testing.T) {
 				"objectID", types.ObjectID{98, 226, 189, 84, 81, 6, 131, 249, 192, 187, 13, 107},
 				"string", "foo",
 				"null", nil),
-			e: expectedWhereKey{sql: "{\"bool\": to_json_boolean(true), \"int32\": 0, \"int64\": 223372036854775807, \"objectID\": {\"oid\":'62e2bd54510683f9c0bb0d6b'}, \"string\": 'foo', \"null\":  NULL }", sign: " = ", err: nil},
+			e: expectedWhereKey{
+				sql:  "{\"bool\": to_json_boolean(?), \"int32\": ?, \"int64\": ?, \"objectID\": {\"oid\": ?}, \"string\": ?, \"null\":  NULL }",
+				sign: " = ",
+				args: []any{true, int32(0), int64(223372036854775807), "62e2bd54510683f9c0bb0d6b", "foo"},
+				err:  nil,
+			},
 		},
 		{name: "type error test", r: int(34), e: expectedWhereKey{sql: "", sign: "", err: fmt.Errorf("BadValue (2): value int not supported in filter")}},
 	}
 
 	for _, field := range whereValueTestCases {
 
-		sql, sign, err := whereValue(field.r)
+		sql, sign, args, err := whereValue(field.r)
 
 		if field.e.err != nil {
 			if !strings.EqualFold(sql, field.e.sql) || !strings.Contains(err.Error(), field.e.err.Error()) || !strings.EqualFold(sign, field.e.sign) {
-				t.Errorf("%s: whereKey(%v) FAILED. Expected sql = %v, sign = %s and err = %v got sql = %s, sign = %s and err = %v", field.name,
+				t.Errorf("%s: WhereKey(%v) FAILED. Expected sql = %v, sign = %s and err = %v got sql = %s, sign = %s and err = %v", field.name,
 					field.r, field.e.sql, field.e.sign, field.e.err, sql, sign, err)
 			}
 		} else {
-			if !strings.EqualFold(sql, field.e.sql) || err != field.e.err || !strings.EqualFold(sign, field.e.sign) {
-				t.Errorf("%s: whereKey(%v) FAILED. Expected sql = %v, sign = %s and err = %v got sql = %s, sign = %s and err = %v", field.name,
-					field.r, field.e.sql, field.e.sign, field.e.err, sql, sign, err)
+			if !strings.EqualFold(sql, field.e.sql) || !reflect.DeepEqual(args, field.e.args) || err != field.e.err || !strings.EqualFold(sign, field.e.sign) {
+				t.Errorf("%s: WhereKey(%v) FAILED. Expected sql = %v, sign = %s, args = %v and err = %v got sql = %s, sign = %s, args = %v and err = %v", field.name,
+					field.r, field.e.sql, field.e.sign, field.e.args, field.e.err, sql, sign, args, err)
 			}
 		}
 
@@ -172,7 +176,15 @@ func TestWhereDocument(t *testing.T) {
 			name: "test document all data types", r: types.MustMakeDocument("int32", int32(0), "int64", int64(9090123123), "float64", float64(898.341123),
 				"string", "normal string", "bool", true, "nil", nil, "objID", types.ObjectID{98, 226, 189, 84, 81, 6, 131, 249, 192, 187, 13, 107},
 				"array", types.MustNewArray(int32(543), "string"), "document", types.MustMakeDocument("field", "name", "bool", true)),
-			e: expectedWhereKey{sql: "{\"int32\": 0, \"int64\": 9090123123, \"float64\": 898.341123, \"string\": 'normal string', \"bool\": to_json_boolean(true), \"nil\":  NULL , \"objID\": {\"oid\":'62e2bd54510683f9c0bb0d6b'}, \"array\": [543, 'string'], \"document\": {\"field\": 'name', \"bool\": to_json_boolean(true)}}", err: nil},
+			e: expectedWhereKey{
+				sql: "{\"int32\": ?, \"int64\": ?, \"float64\": ?, \"string\": ?, \"bool\": to_json_boolean(?), \"nil\":  NULL , \"objID\": {\"oid\": ?}, " +
+					"\"array\": [?, ?], \"document\": {\"field\": ?, \"bool\": to_json_boolean(?)}}",
+				args: []any{
+					int32(0), int64(9090123123), float64(898.341123), "normal string", true, "62e2bd54510683f9c0bb0d6b",
+					int32(543), "string", "name", true,
+				},
+				err: nil,
+			},
 		},
 		{
 			name: "not supported datatype test", r: types.MustMakeDocument("binary", types.Binary{Subtype: types.BinarySubtype(byte(12)), B: []byte("hello")}),
@@ -181,17 +193,17 @@ func TestWhereDocument(t *testing.T) {
 	}
 
 	for _, field := range whereDocumentTestCases {
-		docSQL, err := whereDocument(field.r)
+		docSQL, args, err := whereDocument(field.r)
 
 		if field.e.err != nil {
 			if !strings.EqualFold(docSQL, field.e.sql) || !strings.Contains(err.Error(), field.e.err.Error()) {
-				t.Errorf("%s: whereKey(%v) FAILED. Expected sql = %s and err = %v got sql = %s, sign = %s and err = %v", field.name,
+				t.Errorf("%s: WhereKey(%v) FAILED. Expected sql = %s and err = %v got sql = %s, sign = %s and err = %v", field.name,
 					field.r, field.e.sql, field.e.sign, field.e.err, docSQL, err)
 			}
 		} else {
-			if !strings.EqualFold(docSQL, field.e.sql) || err != field.e.err {
-				t.Errorf("%s: whereKey(%v) FAILED. Expected sql = %s and err = %v got sql = %s, sign = %s and err = %v", field.name,
-					field.r, field.e.sql, field.e.sign, field.e.err, docSQL, err)
+			if !strings.EqualFold(docSQL, field.e.sql) || !reflect.DeepEqual(args, field.e.args) || err != field.e.err {
+				t.Errorf("%s: WhereKey(%v) FAILED. Expected sql = %s, args = %v and err = %v got sql = %s, args = %v and err = %v", field.name,
+					field.r, field.e.sql, field.e.args, field.e.err, docSQL, args, err)
 			}
 		}
 
@@ -208,7 +220,11 @@ func TestPrepareArraySQL(t *testing.T) {
 	prepareArrayForSQLTestCases := []testCasePrepareArraySQL{
 		{
 			name: "all datatypes", r: types.MustNewArray(int32(12), int64(123123), "string", float64(321.321), types.ObjectID{98, 226, 189, 84, 81, 6, 131, 249, 192, 187, 13, 107}, nil, types.MustMakeDocument("field", int32(123)), false, types.MustNewArray(int32(123), "new_array")),
-			e: expectedWhereKey{sql: "[12, 123123, 'string', 321.321000, {\"oid\":'62e2bd54510683f9c0bb0d6b'}, NULL, {\"field\": 123}, to_json_boolean(false), [123, 'new_array']]", err: nil},
+			e: expectedWhereKey{
+				sql:  "[?, ?, ?, ?, {\"oid\": ?}, NULL, {\"field\": ?}, to_json_boolean(?), [?, ?]]",
+				args: []any{int32(12), int64(123123), "string", float64(321.321), "62e2bd54510683f9c0bb0d6b", int32(123), false, int32(123), "new_array"},
+				err:  nil,
+			},
 		},
 		{
 			name: "not support value test", r: types.MustNewArray(types.Binary{Subtype: types.BinarySubtype(byte(12)), B: []byte("hello")}),
@@ -217,17 +233,17 @@ func TestPrepareArraySQL(t *testing.T) {
 	}
 
 	for _, field := range prepareArrayForSQLTestCases {
-		sqlArray, err := PrepareArrayForSQL(field.r)
+		sqlArray, args, err := PrepareArrayForSQL(field.r)
 
 		if field.e.err != nil {
 			if !strings.EqualFold(sqlArray, field.e.sql) || !strings.Contains(err.Error(), field.e.err.Error()) {
-				t.Errorf("%s: whereKey(%v) FAILED. Expected sql = %s and err = %v got sql = %s, sign = %s and err = %v", field.name,
+				t.Errorf("%s: WhereKey(%v) FAILED. Expected sql = %s and err = %v got sql = %s, sign = %s and err = %v", field.name,
 					field.r, field.e.sql, field.e.sign, field.e.err, sqlArray, err)
 			}
 		} else {
-			if !strings.EqualFold(sqlArray, field.e.sql) || err != field.e.err {
-				t.Errorf("%s: whereKey(%v) FAILED. Expected sql = %s and err = %v got sql = %s, sign = %s and err = %v", field.name,
-					field.r, field.e.sql, field.e.sign, field.e.err, sqlArray, err)
+			if !strings.EqualFold(sqlArray, field.e.sql) || !reflect.DeepEqual(args, field.e.args) || err != field.e.err {
+				t.Errorf("%s: WhereKey(%v) FAILED. Expected sql = %s, args = %v and err = %v got sql = %s, args = %v and err = %v", field.name,
+					field.r, field.e.sql, field.e.args, field.e.err, sqlArray, args, err)
 			}
 		}
 	}
@@ -240,219 +256,722 @@ type testCaseExpression struct {
 	e    expectedWhereKey
 }
 
+// testExpressions checks the WHERE-clause of the filter {r1: r2} of each test case.
+func testExpressions(t *testing.T, testCases []testCaseExpression) {
+	t.Helper()
+
+	for _, field := range testCases {
+		sql, args, err := CreateWhereClause(types.MustMakeDocument(field.r1, field.r2))
+
+		if field.e.err != nil {
+			if err == nil || sql != "" || !strings.Contains(err.Error(), field.e.err.Error()) {
+				t.Errorf("%s: where(%s, %v) FAILED. Expected err = %v got sql = %s and err = %v", field.name,
+					field.r1, field.r2, field.e.err, sql, err)
+			}
+		} else {
+			if sql != " WHERE "+field.e.sql || !reflect.DeepEqual(args, field.e.args) || err != nil {
+				t.Errorf("%s: where(%s, %v) FAILED. Expected sql = %s and args = %v got sql = %s, args = %v and err = %v", field.name,
+					field.r1, field.r2, field.e.sql, field.e.args, sql, args, err)
+			}
+		}
+	}
+}
+
 func TestLogicExpression(t *testing.T) {
-	logicExpressionTestCases := []testCaseExpression{
+	testExpressions(t, []testCaseExpression{
 		{
 			name: "AND test", r1: "$and", r2: types.MustNewArray(types.MustMakeDocument("field1", int32(123)), types.MustMakeDocument("field2", "string")),
-			e: expectedWhereKey{sql: "(\"field1\" = 123 AND \"field2\" = 'string')", err: nil},
+			e: expectedWhereKey{sql: "\"field1\" = ? AND \"field2\" = ?", args: []any{int32(123), "string"}},
 		},
 		{
 			name: "OR test", r1: "$or", r2: types.MustNewArray(types.MustMakeDocument("field1", int32(123)), types.MustMakeDocument("field2", "string")),
-			e: expectedWhereKey{sql: "(\"field1\" = 123 OR \"field2\" = 'string')", err: nil},
+			e: expectedWhereKey{sql: "(\"field1\" = ? OR \"field2\" = ?)", args: []any{int32(123), "string"}},
+		},
+		{
+			name: "OR of ANDs test", r1: "$or", r2: types.MustNewArray(types.MustMakeDocument("field1", int32(1), "field2", int32(2)), types.MustMakeDocument("field3", int32(3))),
+			e: expectedWhereKey{sql: "((\"field1\" = ? AND \"field2\" = ?) OR \"field3\" = ?)", args: []any{int32(1), int32(2), int32(3)}},
 		},
 		{
 			name: "NOR test", r1: "$nor", r2: types.MustNewArray(types.MustMakeDocument("field1", int32(123)), types.MustMakeDocument("field2", "string")),
-			e: expectedWhereKey{sql: "( NOT ((\"field1\" = 123 AND \"field1\" IS SET)) AND NOT ((\"field2\" = 'string' AND \"field2\" IS SET)))", err: nil},
+			e: expectedWhereKey{sql: "NOT ((\"field1\" = ? AND \"field1\" IS SET)) AND NOT ((\"field2\" = ? AND \"field2\" IS SET))", args: []any{int32(123), "string"}},
 		},
 		{
 			name: "NOR with $elemMatch test", r1: "$nor", r2: types.MustNewArray(types.MustMakeDocument("array_field", types.MustMakeDocument("$elemMatch", types.MustMakeDocument("field", types.MustMakeDocument("new", "doc"))))),
-			e: expectedWhereKey{sql: "( NOT (FOR ANY \"element\" IN \"array_field\" SATISFIES \"element\".\"field\" = {\"new\": 'doc'} END ))", err: nil},
+			e: expectedWhereKey{
+				sql:  "NOT ((FOR ANY \"element\" IN \"array_field\" SATISFIES \"element\".\"field\" = {\"new\": ?} END AND \"array_field\" IS SET))",
+				args: []any{"doc"},
+			},
+		},
+		{
+			name: "NOR of NOR test", r1: "$nor", r2: types.MustNewArray(types.MustMakeDocument("$nor", types.MustNewArray(types.MustMakeDocument("field", int32(1))))),
+			e: expectedWhereKey{sql: "NOT ((NOT ((\"field\" = ? AND \"field\" IS SET))))", args: []any{int32(1)}},
+		},
+		{
+			name: "AND after NOR test", r1: "$and", r2: types.MustNewArray(
+				types.MustMakeDocument("$nor", types.MustNewArray(types.MustMakeDocument("field1", int32(1)))),
+				types.MustMakeDocument("field2", int32(2)),
+			),
+			e: expectedWhereKey{sql: "(NOT ((\"field1\" = ? AND \"field1\" IS SET))) AND \"field2\" = ?", args: []any{int32(1), int32(2)}},
+		},
+		{
+			name: "empty document in OR test", r1: "$or", r2: types.MustNewArray(types.MustMakeDocument(), types.MustMakeDocument("field", int32(1))),
+			e: expectedWhereKey{sql: "(1 = 1 OR \"field\" = ?)", args: []any{int32(1)}},
 		},
 		{
 			name: "not implemented expression", r1: "$text", r2: "Long text",
-			e: expectedWhereKey{sql: "", err: fmt.Errorf("support for $text is not implemented yet")},
+			e: expectedWhereKey{err: fmt.Errorf("support for $text is not implemented yet")},
 		},
 		{
 			name: "$not as top level error", r1: "$not", r2: types.MustMakeDocument("field", "string"),
-			e: expectedWhereKey{sql: "", err: fmt.Errorf("unknown top level: $not. If you are trying to negate an entire expression, use $nor")},
+			e: expectedWhereKey{err: fmt.Errorf("unknown top level: $not. If you are trying to negate an entire expression, use $nor")},
 		},
 		{
 			name: "only one expression in $and error test", r1: "$and", r2: types.MustNewArray(types.MustMakeDocument("field1", int32(123))),
-			e: expectedWhereKey{sql: "(", err: fmt.Errorf("need minimum two expressions")},
+			e: expectedWhereKey{err: fmt.Errorf("need minimum two expressions")},
+		},
+		{
+			name: "empty $nor error test", r1: "$nor", r2: types.MustNewArray(),
+			e: expectedWhereKey{err: NewErrorMessage(ErrBadValue, "$and/$or/$nor must be a nonempty array")},
 		},
 		{
 			name: "wrong type in array of expression error", r1: "$or", r2: types.MustNewArray("should have been document", "this one too"),
-			e: expectedWhereKey{sql: "(", err: fmt.Errorf("Found in array of logicExpression no document but instead the datatype:")},
+			e: expectedWhereKey{err: NewErrorMessage(ErrBadValue, "$or entries need to be full objects")},
 		},
 		{
 			name: "logicExpression not used with array error", r1: "$or", r2: "should have been array",
-			e: expectedWhereKey{sql: "(", err: NewErrorMessage(ErrBadValue, "$or must be an array")},
+			e: expectedWhereKey{err: NewErrorMessage(ErrBadValue, "$or must be an array")},
 		},
-	}
-
-	for _, field := range logicExpressionTestCases {
-		sql, err := logicExpression(field.r1, field.r2)
-		if field.e.err != nil {
-			if !strings.EqualFold(sql, field.e.sql) || !strings.Contains(err.Error(), field.e.err.Error()) {
-				t.Errorf("%s: logicExpression(%s, %v) FAILED. Expected sql = %s and err = %v got sql = %s and err = %v", field.name,
-					field.r1, field.r2, field.e.sql, field.e.err, sql, err)
-			}
-		} else {
-			if !strings.EqualFold(sql, field.e.sql) || err != field.e.err {
-				t.Errorf("%s: logicExpression(%s, %v) FAILED. Expected sql = %s and err = %v got sql = %s and err = %v", field.name,
-					field.r1, field.r2, field.e.sql, field.e.err, sql, err)
-			}
-		}
-	}
+	})
 }
 
 func TestFieldExpression(t *testing.T) {
-	fieldExpressionTestCases := []testCaseExpression{
+	testExpressions(t, []testCaseExpression{
 		{
 			name: "greater than test", r1: "field", r2: types.MustMakeDocument("$gt", int32(9)),
-			e: expectedWhereKey{sql: "\"field\" > 9", err: nil},
+			e: expectedWhereKey{sql: "\"field\" > ?", args: []any{int32(9)}},
 		},
 		{
 			name: "less than test", r1: "field", r2: types.MustMakeDocument("$lt", int32(9)),
-			e: expectedWhereKey{sql: "\"field\" < 9", err: nil},
+			e: expectedWhereKey{sql: "\"field\" < ?", args: []any{int32(9)}},
 		},
 		{
 			name: "greater than or equal test", r1: "field", r2: types.MustMakeDocument("$gte", int32(9)),
-			e: expectedWhereKey{sql: "\"field\" >= 9", err: nil},
+			e: expectedWhereKey{sql: "\"field\" >= ?", args: []any{int32(9)}},
 		},
 		{
 			name: "less than or equal test", r1: "field", r2: types.MustMakeDocument("$lte", int32(9)),
-			e: expectedWhereKey{sql: "\"field\" <= 9", err: nil},
+			e: expectedWhereKey{sql: "\"field\" <= ?", args: []any{int32(9)}},
+		},
+		{
+			name: "range test", r1: "field", r2: types.MustMakeDocument("$gt", int32(1), "$lt", int32(9)),
+			e: expectedWhereKey{sql: "\"field\" > ? AND \"field\" < ?", args: []any{int32(1), int32(9)}},
 		},
 		{
 			name: "equal test", r1: "field", r2: types.MustMakeDocument("$eq", int32(9)),
-			e: expectedWhereKey{sql: "\"field\" = 9", err: nil},
+			e: expectedWhereKey{sql: "\"field\" = ?", args: []any{int32(9)}},
+		},
+		{
+			name: "equal null test", r1: "field", r2: nil,
+			e: expectedWhereKey{sql: "(\"field\" IS NULL OR \"field\" IS UNSET)"},
 		},
 		{
 			name: "not equal test", r1: "field", r2: types.MustMakeDocument("$ne", int32(9)),
-			e: expectedWhereKey{sql: "(\"field\" <> 9 OR \"field\" IS UNSET)", err: nil},
+			e: expectedWhereKey{sql: "NOT ((\"field\" = ? AND \"field\" IS SET))", args: []any{int32(9)}},
+		},
+		{
+			name: "not equal null test", r1: "field", r2: types.MustMakeDocument("$ne", nil),
+			e: expectedWhereKey{sql: "NOT ((\"field\" IS NULL OR \"field\" IS UNSET))"},
 		},
 		{
 			name: "exists test", r1: "field", r2: types.MustMakeDocument("$exists", true),
-			e: expectedWhereKey{sql: "\"field\" IS SET", err: nil},
+			e: expectedWhereKey{sql: "\"field\" IS SET"},
 		},
 		{
 			name: "array size test", r1: "field", r2: types.MustMakeDocument("$size", int32(9)),
-			e: expectedWhereKey{sql: "CARDINALITY(\"field\") = 9", err: nil},
+			e: expectedWhereKey{sql: "CARDINALITY(\"field\") = ?", args: []any{int32(9)}},
 		},
 		{
 			name: "$all test", r1: "field", r2: types.MustMakeDocument("$all", types.MustNewArray(int32(9), "string")),
-			e: expectedWhereKey{sql: "FOR ANY \"element\" IN \"field\" SATISFIES \"element\" = 9 END  AND FOR ANY \"element\" IN \"field\" SATISFIES \"element\" = 'string' END ", err: nil},
+			e: expectedWhereKey{
+				sql:  "FOR ANY \"element\" IN \"field\" SATISFIES \"element\" = ? END AND FOR ANY \"element\" IN \"field\" SATISFIES \"element\" = ? END",
+				args: []any{int32(9), "string"},
+			},
+		},
+		{
+			name: "$all with nested field test", r1: "nested.field", r2: types.MustMakeDocument("$all", types.MustNewArray("field", float64(14.241234))),
+			e: expectedWhereKey{
+				sql:  "FOR ANY \"element\" IN \"nested\".\"field\" SATISFIES \"element\" = ? END AND FOR ANY \"element\" IN \"nested\".\"field\" SATISFIES \"element\" = ? END",
+				args: []any{"field", float64(14.241234)},
+			},
+		},
+		{
+			name: "$in test", r1: "field", r2: types.MustMakeDocument("$in", types.MustNewArray(int32(9), "string", types.MustNewArray(int32(1), int32(2)))),
+			e: expectedWhereKey{
+				sql:  "(\"field\" IN (?, ?, [?, ?]) OR FOR ANY \"element\" IN \"field\" SATISFIES \"element\" IN (?, ?, [?, ?]) END)",
+				args: []any{int32(9), "string", int32(1), int32(2), int32(9), "string", int32(1), int32(2)},
+			},
+		},
+		{
+			name: "$in with null and regex test", r1: "field", r2: types.MustMakeDocument("$in", types.MustNewArray(nil, types.Regex{Pattern: "^pattern"})),
+			e: expectedWhereKey{
+				sql:  "((\"field\" IS NULL OR \"field\" IS UNSET) OR \"field\" LIKE_REGEXPR ? OR FOR ANY \"element\" IN \"field\" SATISFIES \"element\" LIKE_REGEXPR ? END)",
+				args: []any{"^pattern", "^pattern"},
+			},
+		},
+		{
+			name: "empty $in test", r1: "field", r2: types.MustMakeDocument("$in", types.MustNewArray()),
+			e: expectedWhereKey{sql: "1 = 0"},
+		},
+		{
+			name: "$nin test", r1: "field", r2: types.MustMakeDocument("$nin", types.MustNewArray(int32(9))),
+			e: expectedWhereKey{
+				sql:  "NOT (((\"field\" IN (?) AND \"field\" IS SET) OR (FOR ANY \"element\" IN \"field\" SATISFIES \"element\" IN (?) END AND \"field\" IS SET)))",
+				args: []any{int32(9), int32(9)},
+			},
+		},
+		{
+			name: "$in within $elemMatch test", r1: "field", r2: types.MustMakeDocument("$elemMatch", types.MustMakeDocument("$in", types.MustNewArray(int32(9)))),
+			e: expectedWhereKey{sql: "FOR ANY \"element\" IN \"field\" SATISFIES \"element\" IN (?) END", args: []any{int32(9)}},
 		},
 		{
 			name: "$elemMatch test", r1: "field", r2: types.MustMakeDocument("$elemMatch", types.MustMakeDocument("$gt", int32(9))),
-			e: expectedWhereKey{sql: "FOR ANY \"element\" IN \"field\" SATISFIES \"element\" > 9 END ", err: nil},
+			e: expectedWhereKey{sql: "FOR ANY \"element\" IN \"field\" SATISFIES \"element\" > ? END", args: []any{int32(9)}},
+		},
+		{
+			name: "$elemMatch with field: value test", r1: "nested.field", r2: types.MustMakeDocument("$elemMatch", types.MustMakeDocument("field", float64(14.241234))),
+			e: expectedWhereKey{sql: "FOR ANY \"element\" IN \"nested\".\"field\" SATISFIES \"element\".\"field\" = ? END", args: []any{float64(14.241234)}},
+		},
+		{
+			name: "$elemMatch with $not test", r1: "field", r2: types.MustMakeDocument("$elemMatch", types.MustMakeDocument("$not", types.MustMakeDocument("$gte", int32(9)))),
+			e: expectedWhereKey{sql: "FOR ANY \"element\" IN \"field\" SATISFIES NOT ((\"element\" >= ? AND \"element\" IS NOT NULL)) END", args: []any{int32(9)}},
 		},
 		{
 			name: "not test", r1: "field", r2: types.MustMakeDocument("$not", types.MustMakeDocument("$gt", int32(9))),
-			e: expectedWhereKey{sql: "( NOT \"field\" > 9 OR \"field\" IS UNSET) ", err: nil},
+			e: expectedWhereKey{sql: "NOT ((\"field\" > ? AND \"field\" IS SET))", args: []any{int32(9)}},
 		},
 		{
 			name: "$regex test", r1: "field", r2: types.MustMakeDocument("$regex", "pattern"),
-			e: expectedWhereKey{sql: "\"field\" LIKE '%pattern%'", err: nil},
+			e: expectedWhereKey{
+				sql:  "(\"field\" LIKE_REGEXPR ? OR FOR ANY \"element\" IN \"field\" SATISFIES \"element\" LIKE_REGEXPR ? END)",
+				args: []any{"pattern", "pattern"},
+			},
+		},
+		{
+			name: "$regex with $options test", r1: "field", r2: types.MustMakeDocument("$regex", "^pat+ern$", "$options", "im"),
+			e: expectedWhereKey{
+				sql:  "(\"field\" LIKE_REGEXPR ? FLAG 'im' OR FOR ANY \"element\" IN \"field\" SATISFIES \"element\" LIKE_REGEXPR ? FLAG 'im' END)",
+				args: []any{"^pat+ern$", "^pat+ern$"},
+			},
+		},
+		{
+			name: "regex value with options test", r1: "field", r2: types.Regex{Pattern: "a|b", Options: "is"},
+			e: expectedWhereKey{
+				sql:  "(\"field\" LIKE_REGEXPR ? FLAG 'is' OR FOR ANY \"element\" IN \"field\" SATISFIES \"element\" LIKE_REGEXPR ? FLAG 'is' END)",
+				args: []any{"a|b", "a|b"},
+			},
+		},
+		{
+			name: "$not with regex test", r1: "field", r2: types.MustMakeDocument("$not", types.Regex{Pattern: "(?i)pattern"}),
+			e: expectedWhereKey{
+				sql:  "NOT (((\"field\" LIKE_REGEXPR ? AND \"field\" IS SET) OR (FOR ANY \"element\" IN \"field\" SATISFIES \"element\" LIKE_REGEXPR ? END AND \"field\" IS SET)))",
+				args: []any{"(?i)pattern", "(?i)pattern"},
+			},
+		},
+		{
+			name: "$regex within $elemMatch test", r1: "field", r2: types.MustMakeDocument("$elemMatch", types.MustMakeDocument("$regex", "^a", "$options", "x")),
+			e: expectedWhereKey{sql: "FOR ANY \"element\" IN \"field\" SATISFIES \"element\" LIKE_REGEXPR ? FLAG 'x' END", args: []any{"^a"}},
+		},
+		{
+			name: "$options without $regex error test", r1: "field", r2: types.MustMakeDocument("$options", "i"),
+			e: expectedWhereKey{err: NewErrorMessage(ErrBadValue, "$options needs a $regex")},
 		},
 		{
-			name: "fieldExpression not used with document error test", r1: "field", r2: "should have been a document",
-			e: expectedWhereKey{sql: "", err: fmt.Errorf("In use of field expression a document was expected. Got instead: string")},
+			name: "$regex with invalid option error test", r1: "field", r2: types.MustMakeDocument("$regex", "pattern", "$options", "g"),
+			e: expectedWhereKey{err: NewErrorMessage(ErrRegexOptions, "invalid flag in regex options: g")},
 		},
 		{
 			name: "$exists: false test", r1: "field", r2: types.MustMakeDocument("$exists", false),
-			e: expectedWhereKey{sql: "\"field\" IS UNSET", err: nil},
+			e: expectedWhereKey{sql: "\"field\" IS UNSET"},
 		},
 		{
 			name: "$exists not used with boolean error test", r1: "field", r2: types.MustMakeDocument("$exists", int32(1)),
-			e: expectedWhereKey{sql: "\"field\"", err: fmt.Errorf("$exists only works with boolean")},
+			e: expectedWhereKey{err: fmt.Errorf("$exists only works with boolean")},
+		},
+		{
+			name: "$not used with string error test", r1: "field", r2: types.MustMakeDocument("$not", "string"),
+			e: expectedWhereKey{err: NewErrorMessage(ErrBadValue, "$not needs a regex or a document")},
 		},
 		{
 			name: "not supported expression error test", r1: "field", r2: types.MustMakeDocument("$geoWithin", "not supported"),
-			e: expectedWhereKey{sql: "\"field\"", err: fmt.Errorf("support for $geoWithin is not implemented yet")},
+			e: expectedWhereKey{err: fmt.Errorf("support for $geoWithin is not implemented yet")},
 		},
-	}
-
-	for _, field := range fieldExpressionTestCases {
-		sql, err := fieldExpression(field.r1, field.r2)
+		{
+			name: "$in not used with array error test", r1: "field", r2: types.MustMakeDocument("$in", int32(9)),
+			e: expectedWhereKey{err: NewErrorMessage(ErrBadValue, "$in needs an array")},
+		},
+		{
+			name: "$in with operator error test", r1: "field", r2: types.MustMakeDocument("$in", types.MustNewArray(types.MustMakeDocument("$gt", int32(9)))),
+			e: expectedWhereKey{err: NewErrorMessage(ErrBadValue, "cannot nest $ under $in")},
+		},
+		{
+			name: "$all used with document error test", r1: "field", r2: types.MustMakeDocument("$all", types.MustMakeDocument("field", float64(14.241234))),
+			e: expectedWhereKey{err: fmt.Errorf("BadValue (2): $all needs an array")},
+		},
+		{
+			name: "$elemMatch used with array error test", r1: "field", r2: types.MustMakeDocument("$elemMatch", types.MustNewArray("$gte", int32(9))),
+			e: expectedWhereKey{err: fmt.Errorf("BadValue (2): $elemMatch needs an object")},
+		},
+	})
+}
 
-		if field.e.err != nil {
-			if !strings.EqualFold(sql, field.e.sql) || !strings.Contains(err.Error(), field.e.err.Error()) {
-				t.Errorf("%s: fieldExpression(%s, %v) FAILED. Expected sql = %s and err = %v got sql = %s and err = %v", field.name,
-					field.r1, field.r2, field.e.sql, field.e.err, sql, err)
-			}
-		} else {
-			if !strings.EqualFold(sql, field.e.sql) || err != field.e.err {
-				t.Errorf("%s: fieldExpression(%s, %v) FAILED. Expected sql = %s and err = %v got sql = %s and err = %v", field.name,
-					field.r1, field.r2, field.e.sql, field.e.err, sql, err)
+func TestRegexFlags(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		value   any
+		options string
+		pattern string
+		flags   string
+		err     error
+	}{
+		{name: "string", value: "^pa.t*ern$", pattern: "^pa.t*ern$"},
+		{name: "regular expression", value: types.Regex{Pattern: "pattern", Options: "iim"}, pattern: "pattern", flags: "im"},
+		{name: "$options take precedence", value: types.Regex{Pattern: "pattern", Options: "i"}, options: "s", pattern: "pattern", flags: "s"},
+		{name: "extended", value: "a b # comment", options: "x", pattern: "a b # comment", flags: "x"},
+		{name: "lookahead", value: "foo(?=bar)", pattern: "foo(?=bar)"},
+		{name: "backreference", value: "(a)\\1", pattern: "(a)\\1"},
+		{name: "unicode option", value: "pattern", options: "u", pattern: "pattern", flags: "u"},
+		{name: "invalid option", value: "pattern", options: "g", err: NewErrorMessage(ErrRegexOptions, "invalid flag in regex options: g")},
+		{name: "invalid pattern", value: "(pattern", err: NewErrorMessage(ErrBadValue, "Regular expression is invalid: error parsing regexp: missing closing ): `(pattern`")},
+		{name: "wrong type", value: int32(2), err: NewErrorMessage(ErrBadValue, "$regex has to be a string")},
+	} {
+		pattern, flags, err := regexFlags(tc.value, tc.options)
+		if tc.err != nil {
+			if err == nil || err.Error() != tc.err.Error() {
+				t.Errorf("%s: regexFlags(%v, %q) FAILED. Expected err = %v got err = %v", tc.name, tc.value, tc.options, tc.err, err)
 			}
+			continue
 		}
-	}
-}
 
-type testCaseFilterArray struct {
-	name string
-	r1   string
-	r2   string
-	r3   any
-	e    expectedWhereKey
+		if err != nil || pattern != tc.pattern || flags != tc.flags {
+			t.Errorf("%s: regexFlags(%v, %q) FAILED. Expected pattern = %s and flags = %s got pattern = %s, flags = %s and err = %v",
+				tc.name, tc.value, tc.options, tc.pattern, tc.flags, pattern, flags, err)
+		}
+	}
 }
 
-func TestFilterArray(t *testing.T) {
-	filterArrayTestCases := []testCaseFilterArray{
+func TestCreatePrefilterWhereClause(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		filter types.Document
+		sql    string
+		args   []any
+		exact  bool
+	}{
+		{
+			name:   "exact",
+			filter: types.MustMakeDocument("field", int32(1)),
+			sql:    " WHERE \"field\" = ?",
+			args:   []any{int32(1)},
+			exact:  true,
+		},
 		{
-			name: "$elemMatch with comparison test", r1: "\"nested\".\"field\"", r2: "elemMatch", r3: types.MustMakeDocument("$gte", int32(9)),
-			e: expectedWhereKey{sql: "FOR ANY \"element\" IN \"nested\".\"field\" SATISFIES \"element\" >= 9 END ", err: nil},
+			name:   "$type",
+			filter: types.MustMakeDocument("field", int32(1), "other", types.MustMakeDocument("$type", "string")),
+			sql:    " WHERE \"field\" = ? AND 1 = 1",
+			args:   []any{int32(1)},
 		},
 		{
-			name: "$elemMatch with field: value test", r1: "\"nested\".\"field\"", r2: "elemMatch", r3: types.MustMakeDocument("field", float64(14.241234)),
-			e: expectedWhereKey{sql: "FOR ANY \"element\" IN \"nested\".\"field\" SATISFIES \"element\".\"field\" = 14.241234 END ", err: nil},
+			name:   "$mod under $not",
+			filter: types.MustMakeDocument("field", types.MustMakeDocument("$not", types.MustMakeDocument("$mod", types.MustNewArray(int32(2), int32(0))))),
+			sql:    " WHERE NOT (1 = 0)",
 		},
 		{
-			name: "$all test", r1: "\"nested\".\"field\"", r2: "all", r3: types.MustNewArray("field", float64(14.241234)),
-			e: expectedWhereKey{sql: "FOR ANY \"element\" IN \"nested\".\"field\" SATISFIES \"element\" = 'field' END  AND FOR ANY \"element\" IN \"nested\".\"field\" SATISFIES \"element\" = 14.241234 END ", err: nil},
+			name: "$expr in $elemMatch under $nor",
+			filter: types.MustMakeDocument("$nor", types.MustNewArray(types.MustMakeDocument(
+				"array", types.MustMakeDocument("$elemMatch", types.MustMakeDocument("$expr", true)),
+			))),
+			sql: " WHERE NOT ((FOR ANY \"element\" IN \"array\" SATISFIES 1 = 0 END AND \"array\" IS SET))",
 		},
 		{
-			name: "not using array with $all error test", r1: "field", r2: "all", r3: "should have been array",
-			e: expectedWhereKey{sql: "", err: fmt.Errorf("If $all: Expected array. If $elemMatch: Expected document. Got instead: string")},
+			name:   "$regex with NUL character",
+			filter: types.MustMakeDocument("field", types.MustMakeDocument("$regex", "a\x00b")),
+			sql:    " WHERE 1 = 1",
 		},
 		{
-			name: "$all used with document error test", r1: "\"nested\".\"field\"", r2: "all", r3: types.MustMakeDocument("field", float64(14.241234)),
-			e: expectedWhereKey{sql: "", err: fmt.Errorf("BadValue (2): $all needs an array")},
+			name:   "$regex with unicode option",
+			filter: types.MustMakeDocument("field", types.MustMakeDocument("$regex", "^a", "$options", "iu")),
+			sql:    " WHERE 1 = 1",
 		},
 		{
-			name: "$elemMatch used with array error test", r1: "\"nested\".\"field\"", r2: "elemMatch", r3: types.MustNewArray("$gte", int32(9), "$lte", int64(11)),
-			e: expectedWhereKey{sql: "", err: fmt.Errorf("BadValue (2): $elemMatch needs an object")},
+			name:   "$regex with Unicode property",
+			filter: types.MustMakeDocument("field", types.Regex{Pattern: `^\p{Lu}`}),
+			sql:    " WHERE 1 = 1",
 		},
+		{
+			name:   "$regex with escaped backslash",
+			filter: types.MustMakeDocument("field", types.Regex{Pattern: `\\p`}),
+			sql:    " WHERE (\"field\" LIKE_REGEXPR ? OR FOR ANY \"element\" IN \"field\" SATISFIES \"element\" LIKE_REGEXPR ? END)",
+			args:   []any{`\\p`, `\\p`},
+			exact:  true,
+		},
+		{
+			name:   "$jsonSchema",
+			filter: types.MustMakeDocument("$jsonSchema", types.MustMakeDocument("required", types.MustNewArray("field"))),
+			sql:    " WHERE 1 = 1",
+		},
+	} {
+		sql, args, exact, err := CreatePrefilterWhereClause(tc.filter)
+		if err != nil || sql != tc.sql || !reflect.DeepEqual(args, tc.args) || exact != tc.exact {
+			t.Errorf("%s: CreatePrefilterWhereClause(%v) FAILED. Expected sql = %s, args = %v and exact = %t got sql = %s, args = %v, exact = %t and err = %v",
+				tc.name, tc.filter, tc.sql, tc.args, tc.exact, sql, args, exact, err)
+		}
+
+		if _, _, err = CreateWhereClause(tc.filter); tc.exact != (err == nil) {
+			t.Errorf("%s: where(%v) FAILED. Expected an error only for filters SAP HANA cannot evaluate, got err = %v", tc.name, tc.filter, err)
+		}
 	}
+}
 
-	for _, field := range filterArrayTestCases {
-		sql, err := filterArray(field.r1, field.r2, field.r3)
+func TestCreateWhereClauseConcurrently(t *testing.T) {
+	filters := []types.Document{
+		types.MustMakeDocument("$nor", types.MustNewArray(types.MustMakeDocument("field1", int32(1)), types.MustMakeDocument("field2", "string"))),
+		types.MustMakeDocument("field1", int32(1), "field2", types.MustMakeDocument("$gt", int32(2))),
+		types.MustMakeDocument("$or", types.MustNewArray(
+			types.MustMakeDocument("$nor", types.MustNewArray(types.MustMakeDocument("field1", int32(1)))),
+			types.MustMakeDocument("field2", types.MustMakeDocument("$elemMatch", types.MustMakeDocument("field", int32(3)))),
+		)),
+	}
 
-		if field.e.err != nil {
-			if !strings.EqualFold(sql, field.e.sql) || !strings.Contains(err.Error(), field.e.err.Error()) {
-				t.Errorf("%s: filterArray(%s, %s, %v) FAILED. Expected sql = %s and err = %v got sql = %s and err = %v", field.name,
-					field.r1, field.r2, field.r3, field.e.sql, field.e.err, sql, err)
+	expected := make([]string, len(filters))
+	for i, filter := range filters {
+		sql, _, err := CreateWhereClause(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected[i] = sql
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			filter := filters[i%len(filters)]
+			sql, _, err := CreateWhereClause(filter)
+			if err != nil || sql != expected[i%len(filters)] {
+				t.Errorf("where(%v) FAILED. Expected sql = %s got sql = %s and err = %v", filter, expected[i%len(filters)], sql, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// FuzzWhere checks that the compiled filter, evaluated the way SAP HANA evaluates its SQL,
+// matches the same documents as FilterDocument does.
+//
+// Documents have the number fields a and b and the string field s, which may be missing,
+// and filters compare fields only with values of their type.
+func FuzzWhere(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"\x01\x02\x03\x01\x01\x01",
+		"\x00\x01\x02\x01\x01\x05\x01\x02",
+		"\x03\x01\x02\x00\x02\x01\x00\x07\x01\x03\x02",
+		"\x01\x01\x01\x02\x04\x02\x00\x01\x02\x01\x00\x02",
+		"\x02\x03\x01\x01\x00\x03\x00\x01\x01\x01\x07\x01\x02\x00",
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		g := &filterGenerator{data: data}
+		doc := g.document()
+		filter := g.filter(0)
+
+		expected, err := FilterDocument(doc, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var c whereCompiler
+		expr, err := c.compileFilter(filter, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual := expr == nil || evalWhere(t, expr, doc, 0) == sqlTrue
+		if actual != expected {
+			sql, _, _ := CreateWhereClause(filter)
+			t.Errorf("filter %v on document %v: FilterDocument = %t, but%s = %t", filter, doc, expected, sql, actual)
+		}
+	})
+}
+
+// filterGenerator generates documents and filters from fuzzing input.
+type filterGenerator struct {
+	data []byte
+}
+
+// next returns a number in [0, n) read from the input, or 0 when the input is exhausted.
+func (g *filterGenerator) next(n int) int {
+	if len(g.data) == 0 {
+		return 0
+	}
+
+	b := g.data[0]
+	g.data = g.data[1:]
+
+	return int(b) % n
+}
+
+var generatorFields = []string{"a", "b", "s"}
+
+// value returns a value of the type of the field.
+func (g *filterGenerator) value(field string) any {
+	if field == "s" {
+		return []string{"", "x", "y"}[g.next(3)]
+	}
+
+	n := g.next(4)
+	switch g.next(3) {
+	case 0:
+		return int32(n)
+	case 1:
+		return int64(n)
+	default:
+		return float64(n) + 0.5
+	}
+}
+
+func (g *filterGenerator) document() types.Document {
+	var pairs []any
+	for _, field := range generatorFields {
+		if g.next(4) != 0 {
+			pairs = append(pairs, field, g.value(field))
+		}
+	}
+
+	return types.MustMakeDocument(pairs...)
+}
+
+func (g *filterGenerator) filter(depth int) types.Document {
+	var pairs []any
+	used := map[string]bool{}
+	for i := g.next(3); i >= 0; i-- {
+		var key string
+		var value any
+		if depth < 3 && g.next(4) == 0 {
+			key = []string{"$and", "$or", "$nor"}[g.next(3)]
+			n := 2
+			if key == "$nor" {
+				n = g.next(2) + 1
 			}
+			arr := types.MustNewArray()
+			for j := 0; j < n; j++ {
+				if err := arr.Append(g.filter(depth + 1)); err != nil {
+					panic(err)
+				}
+			}
+			value = arr
 		} else {
-			if !strings.EqualFold(sql, field.e.sql) || err != field.e.err {
-				t.Errorf("%s: filterArray(%s, %s, %v) FAILED. Expected sql = %s and err = %v got sql = %s and err = %v", field.name,
-					field.r1, field.r2, field.r3, field.e.sql, field.e.err, sql, err)
+			key = generatorFields[g.next(len(generatorFields))]
+			if g.next(3) == 0 {
+				value = g.equalValue(key)
+			} else {
+				value = g.operators(key, depth)
 			}
 		}
+
+		if used[key] {
+			continue
+		}
+		used[key] = true
+		pairs = append(pairs, key, value)
 	}
+
+	return types.MustMakeDocument(pairs...)
 }
 
-func TestRegex(t *testing.T) {
-	regexTestCases := []testCaseWhereValue{
-		{name: "test regex", r: "pattern", e: expectedWhereKey{sql: "'%pattern%'", err: nil}},
-		{name: "wrong value for $regex", r: int32(2), e: expectedWhereKey{sql: "", err: fmt.Errorf("Expected either a JavaScript regular expression objects (i.e. /pattern/) or string containing a pattern. Got instead type int32")}},
+// equalValue returns a value to compare the field with for equality, which may be null.
+func (g *filterGenerator) equalValue(field string) any {
+	if g.next(8) == 0 {
+		return nil
 	}
 
-	for _, field := range regexTestCases {
-		sql, err := regex(field.r)
+	return g.value(field)
+}
 
-		if field.e.err != nil {
-			if !strings.EqualFold(sql, field.e.sql) || !strings.Contains(err.Error(), field.e.err.Error()) {
-				t.Errorf("%s: where(%v) FAILED. Expected sql = %s and err = %v got sql = %s and err = %v", field.name,
-					field.r, field.e.sql, field.e.err, sql, err)
+func (g *filterGenerator) operators(field string, depth int) types.Document {
+	var pairs []any
+	used := map[string]bool{}
+	for i := g.next(2); i >= 0; i-- {
+		op := []string{"$eq", "$ne", "$gt", "$gte", "$lt", "$lte", "$exists", "$not", "$in", "$nin"}[g.next(10)]
+		if op == "$not" && depth >= 3 {
+			op = "$gt"
+		}
+		if used[op] {
+			continue
+		}
+		used[op] = true
+
+		var value any
+		switch op {
+		case "$eq", "$ne":
+			value = g.equalValue(field)
+		case "$exists":
+			value = g.next(2) == 0
+		case "$not":
+			value = g.operators(field, depth+1)
+		case "$in", "$nin":
+			arr := types.MustNewArray()
+			for j := g.next(4); j > 0; j-- {
+				if err := arr.Append(g.equalValue(field)); err != nil {
+					panic(err)
+				}
 			}
-		} else {
-			if !strings.EqualFold(sql, field.e.sql) || err != field.e.err {
-				t.Errorf("%s: where(%v) FAILED. Expected sql = %s and err = %v got sql = %s and err = %v", field.name,
-					field.r, field.e.sql, field.e.err, sql, err)
+			value = arr
+		default:
+			value = g.value(field)
+		}
+		pairs = append(pairs, op, value)
+	}
+
+	return types.MustMakeDocument(pairs...)
+}
+
+// sqlBool is a truth value of the three-valued logic of SQL.
+type sqlBool int
+
+const (
+	sqlFalse sqlBool = iota
+	sqlTrue
+	sqlUnknown
+)
+
+// evalWhere evaluates the expression on the document the way SAP HANA evaluates the SQL written for it:
+// comparisons with missing fields or values of other types are unknown.
+func evalWhere(t *testing.T, expr whereExpr, doc types.Document, negations int) sqlBool {
+	t.Helper()
+
+	switch expr := expr.(type) {
+	case andExpr:
+		res := sqlTrue
+		for _, e := range expr {
+			switch evalWhere(t, e, doc, negations) {
+			case sqlFalse:
+				return sqlFalse
+			case sqlUnknown:
+				res = sqlUnknown
 			}
 		}
+		return res
+
+	case orExpr:
+		res := sqlFalse
+		for _, e := range expr {
+			switch evalWhere(t, e, doc, negations) {
+			case sqlTrue:
+				return sqlTrue
+			case sqlUnknown:
+				res = sqlUnknown
+			}
+		}
+		return res
+
+	case notExpr:
+		switch evalWhere(t, expr.expr, doc, negations+1) {
+		case sqlTrue:
+			return sqlFalse
+		case sqlFalse:
+			return sqlTrue
+		default:
+			return sqlUnknown
+		}
+
+	case constExpr:
+		if expr {
+			return sqlTrue
+		}
+		return sqlFalse
+
+	case existsExpr:
+		_, ok := doc.Map()[expr.field.path]
+		if ok == expr.exists {
+			return sqlTrue
+		}
+		return sqlFalse
+
+	case nullExpr:
+		if v, ok := doc.Map()[expr.field.path]; !ok || v == nil {
+			return sqlTrue
+		}
+		return sqlFalse
+
+	case anyExpr:
+		// documents do not contain arrays
+		_, ok := doc.Map()[expr.field.path]
+		if !ok && negations == 0 {
+			return sqlUnknown
+		}
+		return sqlFalse
+
+	case predicateExpr:
+		v, ok := doc.Map()[expr.field.path]
+		if expr.op == " IN " {
+			res := sqlFalse
+			for _, arg := range expr.args {
+				if !ok || typeOrder(v) != typeOrder(arg) {
+					res = sqlUnknown
+					continue
+				}
+				if CompareValues(v, arg) == 0 {
+					return sqlTrue
+				}
+			}
+
+			if negations > 0 && !ok {
+				return sqlFalse
+			}
+			return res
+		}
+
+		if expr.left != expr.field.sql || expr.value != "?" || len(expr.args) != 1 {
+			t.Fatalf("predicate %s%s%s is not supported", expr.left, expr.op, expr.value)
+		}
+
+		res := sqlUnknown
+		if ok && typeOrder(v) == typeOrder(expr.args[0]) {
+			cmp := CompareValues(v, expr.args[0])
+			var matches bool
+			switch expr.op {
+			case " = ":
+				matches = cmp == 0
+			case " > ":
+				matches = cmp > 0
+			case " >= ":
+				matches = cmp >= 0
+			case " < ":
+				matches = cmp < 0
+			case " <= ":
+				matches = cmp <= 0
+			default:
+				t.Fatalf("operator %s is not supported", expr.op)
+			}
+			res = sqlFalse
+			if matches {
+				res = sqlTrue
+			}
+		}
+
+		// under NOT the predicate is written as (predicate AND field IS SET)
+		if negations > 0 && !ok {
+			return sqlFalse
+		}
+		return res
+
+	default:
+		t.Fatalf("expression %T is not supported", expr)
+		return sqlUnknown
 	}
 }
@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"errors"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+)
+
+// WriteErrors collects the errors of the single operations of insert, update and delete commands.
+type WriteErrors struct {
+	errs []any
+}
+
+// Append adds err as the write error of the operation with the given index.
+//
+// Only wire protocol errors are write errors. Any other error is returned,
+// it fails the whole command.
+func (we *WriteErrors) Append(err error, index int32) error {
+	var e *Error
+	if !errors.As(err, &e) {
+		return err
+	}
+
+	we.errs = append(we.errs, types.MustMakeDocument(
+		"index", index,
+		"code", int32(e.code),
+		"errmsg", e.err.Error(),
+	))
+
+	return nil
+}
+
+// Len returns the number of write errors.
+func (we *WriteErrors) Len() int {
+	return len(we.errs)
+}
+
+// SetTo sets the field writeErrors of the command reply if there are any write errors.
+func (we *WriteErrors) SetTo(reply *types.Document) error {
+	if len(we.errs) == 0 {
+		return nil
+	}
+
+	return reply.Set("writeErrors", types.MustNewArray(we.errs...))
+}
+
+// GetOrdered returns the ordered field of insert, update and delete commands.
+// If ordered is true, the command stops at the first write error.
+func GetOrdered(document types.Document) (bool, error) {
+	value, err := document.Get("ordered")
+	if err != nil {
+		return true, nil
+	}
+
+	ordered, ok := value.(bool)
+	if !ok {
+		return false, NewErrorMessage(
+			ErrTypeMismatch, "BSON field '%s.ordered' is the wrong type '%T', expected type 'bool'", document.Command(), value,
+		)
+	}
+
+	return ordered, nil
+}
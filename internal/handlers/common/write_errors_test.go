@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteErrors(t *testing.T) {
+	t.Parallel()
+
+	var we WriteErrors
+
+	reply := types.MustMakeDocument("n", int32(1))
+	require.NoError(t, we.SetTo(&reply))
+	assert.Equal(t, types.MustMakeDocument("n", int32(1)), reply)
+
+	require.NoError(t, we.Append(NewErrorMessage(ErrDuplicateKey, "E11000 duplicate key error"), 1))
+	require.NoError(t, we.Append(lazyerrors.Error(NewErrorMessage(ErrBadValue, "bad value")), 3))
+
+	internal := errors.New("connection reset")
+	assert.Equal(t, internal, we.Append(internal, 4))
+	assert.Equal(t, 2, we.Len())
+
+	require.NoError(t, we.SetTo(&reply))
+	expected := types.MustMakeDocument(
+		"n", int32(1),
+		"writeErrors", types.MustNewArray(
+			types.MustMakeDocument("index", int32(1), "code", int32(11000), "errmsg", "E11000 duplicate key error"),
+			types.MustMakeDocument("index", int32(3), "code", int32(2), "errmsg", "bad value"),
+		),
+	)
+	assert.Equal(t, expected, reply)
+}
+
+func TestGetOrdered(t *testing.T) {
+	t.Parallel()
+
+	ordered, err := GetOrdered(types.MustMakeDocument("insert", "test"))
+	require.NoError(t, err)
+	assert.True(t, ordered)
+
+	ordered, err = GetOrdered(types.MustMakeDocument("insert", "test", "ordered", false))
+	require.NoError(t, err)
+	assert.False(t, ordered)
+
+	_, err = GetOrdered(types.MustMakeDocument("insert", "test", "ordered", int32(1)))
+	assert.EqualError(t, err, "TypeMismatch (14): BSON field 'insert.ordered' is the wrong type 'int32', expected type 'bool'")
+}
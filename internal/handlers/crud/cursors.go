@@ -0,0 +1,432 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/bson"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+)
+
+const (
+	// DefaultCursorTimeout is the time after which an idle cursor is closed. Same as in MongoDB.
+	DefaultCursorTimeout = 10 * time.Minute
+
+	// defaultFirstBatchSize is the number of documents returned by find if no batchSize is given.
+	defaultFirstBatchSize = 101
+
+	// maxBatchLen is the maximum size of documents returned in one batch.
+	// Leaves some space for the rest of the reply document.
+	maxBatchLen = bson.MaxDocumentLen - 16*1024
+
+	// maxUserCursors is the maximum number of open cursors of a user reading from SAP HANA.
+	// Each of them holds a connection of the pool until it is exhausted or closed.
+	maxUserCursors = 16
+
+	// maxCursors is the maximum number of open cursors of all users reading from SAP HANA.
+	maxCursors = 256
+)
+
+// cursor represents an open result set of a find or aggregate command.
+// Documents are either read from rows or, if rows is nil, taken from docs.
+type cursor struct {
+	id              int64
+	ns              string
+	owner           string       // authenticated user who opened the cursor
+	txn             *transaction // in which the cursor was opened, nil if none
+	rows            *sql.Rows
+	docs            []types.Document
+	projection      types.Document // used if projection is an exclusion or the documents are post-filtered
+	exclusion       bool
+	postFilter      bool            // the documents of rows are filtered with filter
+	filter          types.Document  // which SAP HANA cannot evaluate completely
+	limit           int32           // of the post-filtered documents, 0 means no limit
+	returned        int32           // number of post-filtered documents returned so far
+	buffered        *types.Document // next document already read from rows
+	noCursorTimeout bool
+	inUse           bool
+	lastUsed        time.Time
+}
+
+// Cursors is a registry of open cursors shared by all client connections.
+type Cursors struct {
+	mu      sync.Mutex
+	cursors map[int64]*cursor
+	rand    *rand.Rand
+	timeout time.Duration
+	maxUser int // maximum number of cursors holding a connection per user
+	max     int // maximum number of cursors holding a connection in total
+	l       *zap.Logger
+}
+
+// NewCursors creates a new cursor registry. Idle cursors are closed after the given timeout.
+func NewCursors(timeout time.Duration, l *zap.Logger) *Cursors {
+	if timeout <= 0 {
+		timeout = DefaultCursorTimeout
+	}
+
+	return &Cursors{
+		cursors: make(map[int64]*cursor),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())), //nolint:gosec // cursor ids do not need to be cryptographically secure
+		timeout: timeout,
+		maxUser: maxUserCursors,
+		max:     maxCursors,
+		l:       l,
+	}
+}
+
+// Run closes idle cursors until ctx is canceled. Then all remaining cursors are closed.
+func (c *Cursors) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.timeout / 10)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.closeAll()
+			return
+		case now := <-ticker.C:
+			c.reap(now)
+		}
+	}
+}
+
+// reap closes all cursors which have been idle for longer than the timeout.
+func (c *Cursors) reap(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, cur := range c.cursors {
+		if cur.inUse || cur.noCursorTimeout || now.Sub(cur.lastUsed) < c.timeout {
+			continue
+		}
+
+		c.l.Debug("Closing idle cursor.", zap.Int64("id", id), zap.String("ns", cur.ns))
+		cur.close()
+		delete(c.cursors, id)
+	}
+}
+
+// closeAll closes all cursors.
+func (c *Cursors) closeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, cur := range c.cursors {
+		cur.close()
+		delete(c.cursors, id)
+	}
+}
+
+// add registers the cursor and sets its id.
+// If the cursor holds a connection and the user or all users reached their limit of such cursors,
+// the least recently used idle one of them is closed.
+func (c *Cursors) add(cur *cursor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cur.holdsConn() {
+		c.evict(cur.owner)
+	}
+
+	for {
+		id := c.rand.Int63()
+		if _, ok := c.cursors[id]; id != 0 && !ok {
+			cur.id = id
+			break
+		}
+	}
+
+	cur.lastUsed = time.Now()
+	c.cursors[cur.id] = cur
+}
+
+// evict closes the least recently used idle cursor holding a connection, of the given user if
+// the user reached the limit, otherwise of any user if all users reached theirs. It must be called with mu held.
+func (c *Cursors) evict(owner string) {
+	var user, all int
+	var userLRU, allLRU *cursor
+	for _, cur := range c.cursors {
+		if !cur.holdsConn() {
+			continue
+		}
+
+		all++
+		if cur.owner == owner {
+			user++
+		}
+
+		if cur.inUse {
+			continue
+		}
+
+		if allLRU == nil || cur.lastUsed.Before(allLRU.lastUsed) {
+			allLRU = cur
+		}
+
+		if cur.owner == owner && (userLRU == nil || cur.lastUsed.Before(userLRU.lastUsed)) {
+			userLRU = cur
+		}
+	}
+
+	var lru *cursor
+	switch {
+	case user >= c.maxUser:
+		lru = userLRU
+	case all >= c.max:
+		lru = allLRU
+	default:
+		return
+	}
+
+	// only cursors of running commands are left, their number is limited by the client connections
+	if lru == nil {
+		c.l.Warn("Too many open cursors, none of them idle.", zap.Int("user", user), zap.Int("all", all))
+		return
+	}
+
+	c.l.Debug("Closing least recently used cursor.", zap.Int64("id", lru.id), zap.String("ns", lru.ns))
+	lru.close()
+	delete(c.cursors, lru.id)
+}
+
+// checkout returns the cursor with the given id and marks it as being in use.
+// Only the user who opened the cursor may use it, and a cursor opened in a transaction
+// only within that transaction, which is checked out by the command. Its rows are closed once the transaction ends.
+func (c *Cursors) checkout(id int64, ns, owner string, txn *transaction) (*cursor, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cur, ok := c.cursors[id]
+	if !ok {
+		return nil, common.NewErrorMessage(common.ErrCursorNotFound, "cursor id %d not found", id)
+	}
+
+	if cur.ns != ns {
+		return nil, common.NewErrorMessage(
+			common.ErrUnauthorized, "Requested getMore on namespace '%s', but cursor belongs to a different namespace %s", ns, cur.ns,
+		)
+	}
+
+	if cur.owner != owner {
+		return nil, common.NewErrorMessage(common.ErrUnauthorized, "cursor id %d was not created by the authenticated user", id)
+	}
+
+	if cur.inUse {
+		return nil, common.NewErrorMessage(common.ErrCursorInUse, "cursor id %d is already in use", id)
+	}
+
+	if cur.txn != txn {
+		if cur.txn == nil {
+			return nil, common.NewErrorMessage(
+				common.ErrNotInTransaction, "Cannot run getMore on cursor %d, which was not created in a transaction, in a transaction", id,
+			)
+		}
+
+		// the transaction has ended or is not the one of the command
+		return nil, common.NewErrorMessage(
+			common.ErrNoSuchTransaction, "Cannot run getMore on cursor %d, which was created in transaction %d, outside of it", id, cur.txn.number,
+		)
+	}
+
+	cur.inUse = true
+	return cur, nil
+}
+
+// checkin returns the cursor to the registry after use.
+// If the cursor is exhausted, it is closed and removed.
+func (c *Cursors) checkin(cur *cursor, exhausted bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if exhausted {
+		cur.close()
+		delete(c.cursors, cur.id)
+		return
+	}
+
+	cur.inUse = false
+	cur.lastUsed = time.Now()
+}
+
+// kill closes the cursor with the given id and namespace. It returns false if no such cursor exists.
+// Only the user who opened the cursor may kill it.
+func (c *Cursors) kill(id int64, ns, owner string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cur, ok := c.cursors[id]
+	if !ok || cur.ns != ns {
+		return false, nil
+	}
+
+	if cur.owner != owner {
+		return false, common.NewErrorMessage(common.ErrUnauthorized, "not authorized to kill cursor id %d", id)
+	}
+
+	cur.close()
+	delete(c.cursors, id)
+
+	return true, nil
+}
+
+// openCursor returns the first batch of documents of the cursor. The cursor is registered
+// if documents are left, otherwise it is closed and its id stays 0.
+// A batchSize of 0 returns no documents but still opens the cursor.
+// The cursor belongs to the authenticated user and to the transaction of ctx.
+func (h *storage) openCursor(ctx context.Context, cur *cursor, batchSize int32, singleBatch bool) (*types.Array, error) {
+	docs := new(types.Array)
+	var exhausted bool
+	if batchSize != 0 {
+		var err error
+		if docs, exhausted, err = cur.nextBatch(batchSize); err != nil {
+			cur.close()
+			return nil, lazyerrors.Error(err)
+		}
+	}
+
+	if exhausted || singleBatch {
+		cur.close()
+		return docs, nil
+	}
+
+	cur.owner = common.Owner(ctx)
+	cur.txn, _ = ctx.Value(txKey{}).(*transaction)
+	h.cursors.add(cur)
+
+	return docs, nil
+}
+
+// next returns the next document of the cursor or nil if there are no documents left.
+func (cur *cursor) next() (*types.Document, error) {
+	if cur.rows != nil && cur.postFilter {
+		return cur.nextMatching()
+	}
+
+	if cur.rows != nil {
+		return nextRow(cur.rows)
+	}
+
+	if len(cur.docs) == 0 {
+		return nil, nil
+	}
+
+	doc := cur.docs[0]
+	cur.docs = cur.docs[1:]
+
+	return &doc, nil
+}
+
+// nextMatching returns the next document of rows matching the filter with an inclusion projection applied,
+// or nil if there are no documents left or the limit is reached.
+func (cur *cursor) nextMatching() (*types.Document, error) {
+	for cur.limit == 0 || cur.returned < cur.limit {
+		doc, err := nextRow(cur.rows)
+		if err != nil || doc == nil {
+			return doc, err
+		}
+
+		matches, err := common.FilterDocument(*doc, cur.filter)
+		if err != nil {
+			return nil, err
+		}
+		if !matches {
+			continue
+		}
+
+		cur.returned++
+
+		// exclusions are applied to the whole batch
+		if !cur.exclusion {
+			projected, err := common.ApplyProjection(*doc, cur.projection)
+			if err != nil {
+				return nil, err
+			}
+			doc = &projected
+		}
+
+		return doc, nil
+	}
+
+	return nil, nil
+}
+
+// holdsConn returns true if the cursor reads from rows outside of a transaction, so that it holds its own connection.
+// Rows of a transaction use the connection of the transaction.
+func (cur *cursor) holdsConn() bool {
+	return cur.rows != nil && cur.txn == nil
+}
+
+// close releases the resources held by the cursor.
+func (cur *cursor) close() {
+	if cur.rows != nil {
+		cur.rows.Close()
+	}
+
+	cur.docs = nil
+}
+
+// nextBatch reads the next batch of documents from the cursor.
+// A batchSize of 0 means no limit besides the maximum size of a batch.
+// exhausted is true if there are no more documents left.
+func (cur *cursor) nextBatch(batchSize int32) (batch *types.Array, exhausted bool, err error) {
+	batch = new(types.Array)
+
+	var size int
+	for batchSize == 0 || int32(batch.Len()) < batchSize {
+		doc := cur.buffered
+		cur.buffered = nil
+
+		if doc == nil {
+			if doc, err = cur.next(); err != nil {
+				return nil, false, lazyerrors.Error(err)
+			}
+			if doc == nil {
+				exhausted = true
+				break
+			}
+		}
+
+		var b []byte
+		if b, err = bson.MustConvertDocument(doc).MarshalBinary(); err != nil {
+			return nil, false, lazyerrors.Error(err)
+		}
+
+		// always return at least one document
+		if size += len(b); size > maxBatchLen && batch.Len() > 0 {
+			cur.buffered = doc
+			break
+		}
+
+		if err = batch.Append(*doc); err != nil {
+			return nil, false, lazyerrors.Error(err)
+		}
+	}
+
+	// look ahead so that a cursor without further documents is not kept open
+	if !exhausted && cur.buffered == nil {
+		if cur.buffered, err = cur.next(); err != nil {
+			return nil, false, lazyerrors.Error(err)
+		}
+		exhausted = cur.buffered == nil
+	}
+
+	if cur.exclusion {
+		if err = common.ProjectDocuments(batch, cur.projection); err != nil {
+			return nil, false, lazyerrors.Error(err)
+		}
+	}
+
+	return batch, exhausted, nil
+}
@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestCursorsLimit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	c := NewCursors(DefaultCursorTimeout, zaptest.NewLogger(t))
+	c.maxUser, c.max = 2, 3
+
+	start := time.Now()
+	var n int
+	open := func(owner string, txn *transaction) *cursor {
+		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"doc"}).AddRow("{}"))
+		rows, err := db.Query("SELECT")
+		require.NoError(t, err)
+
+		cur := &cursor{ns: "testdatabase.testcollection", owner: owner, txn: txn, rows: rows}
+		c.add(cur)
+
+		// the order of use must not depend on the resolution of the clock
+		n++
+		cur.lastUsed = start.Add(time.Duration(n) * time.Second)
+
+		return cur
+	}
+
+	a1 := open("a", nil)
+	a2 := open("a", nil)
+	a3 := open("a", nil)
+	assert.NotContains(t, c.cursors, a1.id, "the least recently used cursor of the user is closed")
+	assert.Contains(t, c.cursors, a2.id)
+	assert.Contains(t, c.cursors, a3.id)
+
+	b1 := open("b", nil)
+	assert.Len(t, c.cursors, 3)
+
+	b2 := open("b", nil)
+	assert.NotContains(t, c.cursors, a2.id, "the least recently used cursor of all users is closed")
+	assert.Contains(t, c.cursors, b1.id)
+	assert.Contains(t, c.cursors, b2.id)
+
+	_, err = c.checkout(a3.id, a3.ns, "a", nil)
+	require.NoError(t, err)
+
+	open("c", nil)
+	assert.Contains(t, c.cursors, a3.id, "cursors in use are not closed")
+	assert.NotContains(t, c.cursors, b1.id)
+
+	open("c", &transaction{})
+	assert.Len(t, c.cursors, 4, "cursors of transactions use the connection of the transaction")
+
+	c.closeAll()
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,103 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"context"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+)
+
+// lockMatching locks the documents selected by the SELECT * statement selectSQL within the transaction q
+// and returns those which still match the filter once they are locked, all of them if multi is true,
+// otherwise only the first one.
+//
+// The WHERE clause of selectSQL is created by common.CreatePrefilterWhereClause. If it is exact, the selected
+// documents are locked by selectSQL, and only the first one if only one document is needed. Otherwise it selects
+// a superset of the matching documents, which is not locked but filtered with common.FilterDocument,
+// and only the matching documents are locked; if only one document is needed, the selection stops at the first one.
+// If a concurrent write changed the first document to no longer match before it was locked, it is selected again.
+func lockMatching(
+	ctx context.Context, q querier, table, selectSQL string, args []any, filter types.Document, multi, exact bool,
+) ([]types.Document, error) {
+	sql := selectSQL
+	if exact {
+		if !multi {
+			sql += " LIMIT 1"
+		}
+		sql += " FOR UPDATE"
+	}
+
+	var docs []types.Document
+	for attempt := 0; ; attempt++ {
+		selected, err := selectMatching(ctx, q, sql, args, filter, !exact, multi)
+		if err != nil {
+			return nil, err
+		}
+
+		// a document selected in the version before a concurrent write is read again once it is locked
+		docs = docs[:0]
+		for _, doc := range selected {
+			current, err := lockDocument(ctx, q, table, doc.Map()["_id"], filter)
+			if err != nil {
+				return nil, err
+			}
+			if current == nil {
+				continue
+			}
+
+			docs = append(docs, *current)
+		}
+
+		// the first document is selected again if it no longer matches, all others have been selected already
+		if multi || len(docs) == len(selected) {
+			return docs, nil
+		}
+
+		if attempt == maxWriteConflictRetries {
+			return nil, errWriteConflict
+		}
+	}
+}
+
+// selectMatching returns the documents selected by sql, filtered with common.FilterDocument if postFilter is true.
+// If multi is false, it stops reading at the first one.
+func selectMatching(
+	ctx context.Context, q querier, sql string, args []any, filter types.Document, postFilter, multi bool,
+) ([]types.Document, error) {
+	rows, err := q.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+	defer rows.Close()
+
+	var docs []types.Document
+	for {
+		doc, err := nextRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		if doc == nil {
+			return docs, nil
+		}
+
+		if postFilter {
+			matches, err := common.FilterDocument(*doc, filter)
+			if err != nil {
+				return nil, err
+			}
+			if !matches {
+				continue
+			}
+		}
+
+		docs = append(docs, *doc)
+		if !multi {
+			return docs, nil
+		}
+	}
+}
@@ -0,0 +1,564 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/fjson"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+)
+
+// MsgAggregate runs an aggregation pipeline on a collection and returns a cursor to the result.
+// The leading stages of the pipeline are translated to SQL where possible,
+// the remaining stages are processed in memory.
+func (h *storage) MsgAggregate(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	unimplementedFields := []string{
+		"explain",
+		"bypassDocumentValidation",
+		"readConcern",
+		"collation",
+		"hint",
+		"comment",
+		"writeConcern",
+		"let",
+	}
+
+	document, err := msg.Document()
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+	if err := common.Unimplemented(&document, unimplementedFields...); err != nil {
+		return nil, err
+	}
+
+	common.Ignored(&document, h.l, "allowDiskUse", "maxTimeMS")
+
+	ctx, done, err := h.startCommand(ctx, document)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	m := document.Map()
+
+	collection, ok := m["aggregate"].(string)
+	if !ok {
+		return nil, common.NewErrorMessage(common.ErrNotImplemented, "aggregate is only supported on collections")
+	}
+	db := m["$db"].(string)
+
+	pipeline, ok := m["pipeline"].(*types.Array)
+	if !ok {
+		return nil, common.NewErrorMessage(common.ErrBadValue, "'pipeline' option must be specified as an array")
+	}
+
+	stages, err := common.ParsePipeline(pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	cursorDoc, ok := m["cursor"].(types.Document)
+	if !ok {
+		return nil, common.NewErrorMessage(common.ErrBadValue, "The 'cursor' option is required, except for aggregate with the explain argument")
+	}
+
+	batchSize := int32(defaultFirstBatchSize)
+	if _, ok := cursorDoc.Map()["batchSize"]; ok {
+		if batchSize, err = getBatchSize(cursorDoc.Map()); err != nil {
+			return nil, err
+		}
+	}
+
+	table, err := hana.Table(db, collection)
+	if err != nil {
+		return nil, err
+	}
+
+	q, rest := pushdownStages(stages)
+
+	rows, err := h.querier(ctx).QueryContext(ctx, q.sql(table), q.whereArgs...)
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	cur := &cursor{
+		ns: db + "." + collection,
+	}
+
+	var docs []types.Document
+	switch {
+	case q.group != nil:
+		docs, err = readGroupRows(rows, q.group)
+	case q.countField != "":
+		docs, err = readCountRows(rows, q.countField)
+	case len(rest) == 0:
+		cur.rows = rows
+	default:
+		docs, err = readAllRows(rows)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cur.rows == nil {
+		if docs, err = common.ProcessStages(docs, rest); err != nil {
+			return nil, err
+		}
+		cur.docs = docs
+	}
+
+	firstBatch, err := h.openCursor(ctx, cur, batchSize, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var reply wire.OpMsg
+	err = reply.SetSections(wire.OpMsgSection{
+		Documents: []types.Document{types.MustMakeDocument(
+			"cursor", types.MustMakeDocument(
+				"firstBatch", firstBatch,
+				"id", cur.id,
+				"ns", cur.ns,
+			),
+			"ok", float64(1),
+		)},
+	})
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	return &reply, nil
+}
+
+// aggregateQuery holds the stages of a pipeline which are executed by SAP HANA.
+type aggregateQuery struct {
+	filters    []any
+	where      string
+	whereArgs  []any
+	orderBy    string
+	projection string
+	limit      int64
+	skip       int64
+	group      *groupQuery
+	countField string
+}
+
+// groupQuery holds a $group stage which is executed with GROUP BY.
+type groupQuery struct {
+	key          string // empty if _id is null
+	columns      []string
+	fields       []string
+	accumulators []common.Accumulator
+}
+
+// pushdownStages translates the leading stages of the pipeline to SQL.
+// It stops at the first stage which cannot be translated and returns it together with all following stages.
+func pushdownStages(stages []types.Document) (q *aggregateQuery, rest []types.Document) {
+	q = new(aggregateQuery)
+
+	rest = []types.Document{}
+	for i, stage := range stages {
+		if !q.push(stage) {
+			rest = stages[i:]
+			break
+		}
+	}
+
+	// OFFSET requires a LIMIT
+	if q.skip > 0 && q.limit == 0 {
+		rest = append([]types.Document{types.MustMakeDocument("$skip", q.skip)}, rest...)
+		q.skip = 0
+	}
+
+	return
+}
+
+// push adds the stage to the query. It returns false if the stage cannot be translated
+// or cannot be combined with the already translated stages.
+func (q *aggregateQuery) push(stage types.Document) bool {
+	name := stage.Command()
+	value := stage.Map()[name]
+
+	// only $limit may follow a $skip without limit, otherwise the skip could not be applied in the right order
+	if q.group != nil || q.countField != "" || (q.skip > 0 && q.limit == 0 && name != "$limit") {
+		return false
+	}
+
+	switch name {
+	case "$match":
+		filter, ok := value.(types.Document)
+		if !ok || q.limit > 0 || q.projection != "" {
+			return false
+		}
+
+		filters := append(q.filters, filter)
+
+		where, whereArgs, err := common.CreateWhereClause(combineFilters(filters))
+		if err != nil {
+			return false
+		}
+
+		q.filters = filters
+		q.where = where
+		q.whereArgs = whereArgs
+
+	case "$sort":
+		sortDoc, ok := value.(types.Document)
+		if !ok || len(sortDoc.Keys()) == 0 || q.orderBy != "" || q.limit > 0 || q.projection != "" {
+			return false
+		}
+
+		orderBy, err := createOrderByStmt(map[string]any{"sort": sortDoc})
+		if err != nil {
+			return false
+		}
+
+		q.orderBy = orderBy
+
+	case "$skip":
+		skip, ok := aggregateInt(value)
+		if !ok || skip < 0 || q.limit > 0 {
+			return false
+		}
+
+		q.skip += skip
+
+	case "$limit":
+		limit, ok := aggregateInt(value)
+		if !ok || limit <= 0 {
+			return false
+		}
+
+		if q.limit == 0 || limit < q.limit {
+			q.limit = limit
+		}
+
+	case "$project":
+		projection, ok := value.(types.Document)
+		if !ok || q.projection != "" || !isSimpleInclusion(projection) {
+			return false
+		}
+
+		projectionSQL, exclusion, err := common.Projection(projection)
+		if err != nil || exclusion {
+			return false
+		}
+
+		q.projection = projectionSQL
+
+	case "$count":
+		field, err := common.CountField(value)
+		if err != nil || q.limit > 0 || q.projection != "" {
+			return false
+		}
+
+		q.countField = field
+
+	case "$group":
+		spec, ok := value.(types.Document)
+		if !ok || q.limit > 0 || q.projection != "" {
+			return false
+		}
+
+		group, ok := newGroupQuery(spec)
+		if !ok {
+			return false
+		}
+
+		// the order of the documents does not change the result of the supported accumulators
+		q.orderBy = ""
+		q.group = group
+
+	default:
+		return false
+	}
+
+	return true
+}
+
+// sql returns the SELECT statement of the query on the table. The arguments of its placeholders are whereArgs.
+func (q *aggregateQuery) sql(table string) string {
+	selectSQL := "*"
+	switch {
+	case q.group != nil:
+		selectSQL = strings.Join(q.group.columns, ", ")
+	case q.countField != "":
+		selectSQL = "COUNT(*)"
+	case q.projection != "":
+		selectSQL = q.projection
+	}
+
+	sql := fmt.Sprintf("SELECT %s FROM %s", selectSQL, table) + q.where
+
+	if q.group != nil {
+		if q.group.key != "" {
+			sql += " GROUP BY " + q.group.key
+		} else {
+			// without HAVING an empty collection would result in one group
+			sql += " HAVING COUNT(*) > 0"
+		}
+	}
+
+	sql += q.orderBy
+
+	if q.limit > 0 {
+		sql += fmt.Sprintf(" LIMIT %d", q.limit)
+		if q.skip > 0 {
+			sql += fmt.Sprintf(" OFFSET %d", q.skip)
+		}
+	}
+
+	return sql
+}
+
+// combineFilters combines the filters of consecutive $match stages.
+func combineFilters(filters []any) types.Document {
+	if len(filters) == 1 {
+		return filters[0].(types.Document)
+	}
+
+	return types.MustMakeDocument("$and", types.MustNewArray(filters...))
+}
+
+// isSimpleInclusion returns true if the projection only includes top level fields.
+// Computed fields and exclusions are processed in memory.
+func isSimpleInclusion(projection types.Document) bool {
+	if len(projection.Keys()) == 0 {
+		return false
+	}
+
+	for _, key := range projection.Keys() {
+		if strings.Contains(key, ".") || strings.HasPrefix(key, "$") {
+			return false
+		}
+
+		switch value := projection.Map()[key].(type) {
+		case bool:
+			if !value && key != "_id" {
+				return false
+			}
+		case int32, int64, float64:
+			if n, ok := aggregateInt(value); key != "_id" && (!ok || n == 0) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// newGroupQuery translates a $group stage to SQL. ok is false if the _id or any accumulator is not supported.
+// Supported are a null _id or a field path as _id and $sum, $avg, $min, $max of field paths, {$sum: 1} and $count.
+func newGroupQuery(spec types.Document) (g *groupQuery, ok bool) {
+	g = new(groupQuery)
+
+	idExpr, ok := spec.Map()["_id"]
+	if !ok {
+		return nil, false
+	}
+
+	switch id := idExpr.(type) {
+	case nil:
+	case string:
+		if !isFieldPath(id) {
+			return nil, false
+		}
+		g.key = fieldSQL(strings.TrimPrefix(id, "$"))
+		g.columns = append(g.columns, g.key)
+	default:
+		return nil, false
+	}
+
+	for _, field := range spec.Keys() {
+		if field == "_id" {
+			continue
+		}
+
+		acc, err := common.ParseAccumulator(field, spec.Map()[field])
+		if err != nil {
+			return nil, false
+		}
+
+		var column string
+		switch acc.Operator {
+		case "$count":
+			column = "COUNT(*)"
+		case "$sum", "$avg", "$min", "$max":
+			if n, ok := aggregateInt(acc.Expr); ok && n == 1 && acc.Operator == "$sum" {
+				column = "COUNT(*)"
+				break
+			}
+
+			path, ok := acc.Expr.(string)
+			if !ok || !isFieldPath(path) {
+				return nil, false
+			}
+			column = strings.ToUpper(strings.TrimPrefix(acc.Operator, "$")) + "(" + fieldSQL(strings.TrimPrefix(path, "$")) + ")"
+		default:
+			return nil, false
+		}
+
+		g.columns = append(g.columns, column)
+		g.fields = append(g.fields, field)
+		g.accumulators = append(g.accumulators, acc)
+	}
+
+	if len(g.columns) == 0 {
+		g.columns = append(g.columns, "COUNT(*)")
+	}
+
+	return g, true
+}
+
+// isFieldPath returns true for field paths like "$field.nested".
+func isFieldPath(s string) bool {
+	return strings.HasPrefix(s, "$") && !strings.HasPrefix(s, "$$") && len(s) > 1
+}
+
+// fieldSQL returns the SQL for a field given in dot notation.
+func fieldSQL(path string) string {
+	parts := strings.Split(path, ".")
+	for i, part := range parts {
+		parts[i] = hana.QuoteIdentifier(part)
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// aggregateInt returns the integer value of a number.
+func aggregateInt(value any) (int64, bool) {
+	switch value := value.(type) {
+	case int32:
+		return int64(value), true
+	case int64:
+		return value, true
+	case float64:
+		if value != float64(int64(value)) {
+			return 0, false
+		}
+		return int64(value), true
+	default:
+		return 0, false
+	}
+}
+
+// readGroupRows converts the result of a GROUP BY query to documents.
+func readGroupRows(rows *sql.Rows, g *groupQuery) ([]types.Document, error) {
+	defer rows.Close()
+
+	docs := []types.Document{}
+	for rows.Next() {
+		values := make([][]byte, len(g.columns))
+		dest := make([]any, len(values))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, lazyerrors.Error(err)
+		}
+
+		var id any
+		if g.key != "" {
+			id = columnValue(values[0])
+			values = values[1:]
+		}
+
+		doc := types.MustMakeDocument("_id", id)
+		for i, field := range g.fields {
+			value := columnValue(values[i])
+
+			switch g.accumulators[i].Operator {
+			case "$sum", "$count":
+				if value == nil {
+					value = int32(0)
+				}
+			case "$avg":
+				switch v := value.(type) {
+				case int32:
+					value = float64(v)
+				case int64:
+					value = float64(v)
+				}
+			}
+
+			if err := doc.Set(field, value); err != nil {
+				return nil, lazyerrors.Error(err)
+			}
+		}
+
+		docs = append(docs, doc)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	return docs, nil
+}
+
+// columnValue decodes the JSON value of a column. Values which are not valid JSON are returned as string.
+func columnValue(b []byte) any {
+	if b == nil {
+		return nil
+	}
+
+	value, err := fjson.Unmarshal(b)
+	if err != nil {
+		return string(b)
+	}
+
+	return value
+}
+
+// readCountRows converts the result of a COUNT(*) query to the result of a $count stage.
+func readCountRows(rows *sql.Rows, field string) ([]types.Document, error) {
+	defer rows.Close()
+
+	var count int32
+	for rows.Next() {
+		if err := rows.Scan(&count); err != nil {
+			return nil, lazyerrors.Error(err)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	if count == 0 {
+		return []types.Document{}, nil
+	}
+
+	return []types.Document{types.MustMakeDocument(field, count)}, nil
+}
+
+// readAllRows reads all documents for processing the remaining stages in memory.
+func readAllRows(rows *sql.Rows) ([]types.Document, error) {
+	defer rows.Close()
+
+	docs := []types.Document{}
+	for {
+		doc, err := nextRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		if doc == nil {
+			return docs, nil
+		}
+
+		docs = append(docs, *doc)
+	}
+}
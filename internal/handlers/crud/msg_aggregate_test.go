@@ -0,0 +1,139 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"testing"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMsgAggregate(t *testing.T) {
+	ctx, storage, mock, err := setupTestUtil(t)
+	require.NoError(t, err)
+
+	aggregate := func(t *testing.T, pipeline *types.Array) (types.Document, error) {
+		t.Helper()
+
+		var reqMsg wire.OpMsg
+		err := reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{types.MustMakeDocument(
+				"aggregate", "testCollection",
+				"pipeline", pipeline,
+				"cursor", types.MustMakeDocument(),
+				"$db", "testDatabase",
+			)},
+		})
+		require.NoError(t, err)
+
+		msg, err := storage.MsgAggregate(ctx, &reqMsg)
+		if err != nil {
+			return types.Document{}, err
+		}
+
+		return msg.Document()
+	}
+
+	expectedBatch := func(docs ...any) types.Document {
+		return types.MustMakeDocument(
+			"cursor", types.MustMakeDocument(
+				"firstBatch", types.MustNewArray(docs...),
+				"id", int64(0),
+				"ns", "testDatabase.testCollection",
+			),
+			"ok", float64(1),
+		)
+	}
+
+	t.Run("match, sort, skip, limit and project in SQL", func(t *testing.T) {
+		rows := mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": 123, "item": "test"}`))
+		mock.ExpectQuery(`SELECT {"_id": "_id", "item": "item"} FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" = ? ORDER BY "qty"  DESC LIMIT 5 OFFSET 10`).
+			WithArgs("test").
+			WillReturnRows(rows)
+
+		actual, err := aggregate(t, types.MustNewArray(
+			types.MustMakeDocument("$match", types.MustMakeDocument("item", "test")),
+			types.MustMakeDocument("$sort", types.MustMakeDocument("qty", int32(-1))),
+			types.MustMakeDocument("$skip", int32(10)),
+			types.MustMakeDocument("$limit", int32(5)),
+			types.MustMakeDocument("$project", types.MustMakeDocument("item", int32(1))),
+		))
+		require.NoError(t, err)
+		assert.Equal(t, expectedBatch(types.MustMakeDocument("_id", int32(123), "item", "test")), actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("group in SQL", func(t *testing.T) {
+		rows := mock.NewRows([]string{"item", "sum", "avg", "count"}).
+			AddRow([]byte(`"a"`), []byte("20"), []byte("5"), int64(4)).
+			AddRow(nil, nil, nil, int64(1))
+		mock.ExpectQuery(`SELECT "item", SUM("qty"), AVG("price"), COUNT(*) FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "qty" > ? GROUP BY "item"`).
+			WithArgs(1).
+			WillReturnRows(rows)
+
+		actual, err := aggregate(t, types.MustNewArray(
+			types.MustMakeDocument("$match", types.MustMakeDocument("qty", types.MustMakeDocument("$gt", int32(1)))),
+			types.MustMakeDocument("$sort", types.MustMakeDocument("qty", int32(1))),
+			types.MustMakeDocument("$group", types.MustMakeDocument(
+				"_id", "$item",
+				"total", types.MustMakeDocument("$sum", "$qty"),
+				"avg", types.MustMakeDocument("$avg", "$price"),
+				"n", types.MustMakeDocument("$sum", int32(1)),
+			)),
+			types.MustMakeDocument("$sort", types.MustMakeDocument("_id", int32(-1))),
+		))
+		require.NoError(t, err)
+		assert.Equal(t, expectedBatch(
+			types.MustMakeDocument("_id", "a", "total", int32(20), "avg", float64(5), "n", int32(4)),
+			types.MustMakeDocument("_id", nil, "total", int32(0), "avg", nil, "n", int32(1)),
+		), actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("count in SQL", func(t *testing.T) {
+		rows := mock.NewRows([]string{"count"}).AddRow(3)
+		mock.ExpectQuery(`SELECT COUNT(*) FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" = ?`).WithArgs("test").WillReturnRows(rows)
+
+		actual, err := aggregate(t, types.MustNewArray(
+			types.MustMakeDocument("$match", types.MustMakeDocument("item", "test")),
+			types.MustMakeDocument("$count", "total"),
+		))
+		require.NoError(t, err)
+		assert.Equal(t, expectedBatch(types.MustMakeDocument("total", int32(3))), actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("in memory after the first stage not supported by SQL", func(t *testing.T) {
+		rows := mock.NewRows([]string{"document"}).
+			AddRow([]byte(`{"_id": 1, "item": "a", "tags": ["x"]}`)).
+			AddRow([]byte(`{"_id": 2, "item": "b", "tags": ["x", "y"]}`)).
+			AddRow([]byte(`{"_id": 3, "item": "c", "tags": ["y"]}`))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" IS SET`).WillReturnRows(rows)
+
+		actual, err := aggregate(t, types.MustNewArray(
+			types.MustMakeDocument("$match", types.MustMakeDocument("item", types.MustMakeDocument("$exists", true))),
+			types.MustMakeDocument("$skip", int32(1)),
+			types.MustMakeDocument("$project", types.MustMakeDocument("_id", int32(0), "name", "$item", "tags", int32(1))),
+			types.MustMakeDocument("$match", types.MustMakeDocument("tags", "x")),
+		))
+		require.NoError(t, err)
+		assert.Equal(t, expectedBatch(types.MustMakeDocument("name", "b", "tags", types.MustNewArray("x", "y"))), actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("invalid stage", func(t *testing.T) {
+		_, err := aggregate(t, types.MustNewArray(
+			types.MustMakeDocument("$match", types.MustMakeDocument(), "$limit", int32(1)),
+		))
+		assert.EqualError(t, err, "BadValue (2): A pipeline stage specification object must contain exactly one field.")
+	})
+}
@@ -20,19 +20,98 @@ package crud
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
 )
 
-// MsgCreateIndexes creates indexes. (IS NOT IMPLEMENTED YET)
+// MsgCreateIndexes creates indexes on the paths of a collection with CREATE INDEX.
+// Indexes which already exist are skipped.
 func (h *storage) MsgCreateIndexes(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	document, err := msg.Document()
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	if err := common.Unimplemented(&document, "commitQuorum", "comment"); err != nil {
+		return nil, err
+	}
+
+	common.Ignored(&document, h.l, "writeConcern", "maxTimeMS")
+
+	if err := notInTransaction(document, "createIndexes"); err != nil {
+		return nil, err
+	}
+
+	m := document.Map()
+	collection := m[document.Keys()[0]].(string)
+	db := m["$db"].(string)
+
+	specs, ok := m["indexes"].(*types.Array)
+	if !ok {
+		return nil, common.NewErrorMessage(
+			common.ErrTypeMismatch, "BSON field 'createIndexes.indexes' is the wrong type '%T', expected type 'array'", m["indexes"],
+		)
+	}
+
+	if specs.Len() == 0 {
+		return nil, common.NewErrorMessage(common.ErrBadValue, "Must specify at least one index to create")
+	}
+
+	indexes, err := h.collectionIndexes(ctx, db, collection)
+	if err != nil {
+		return nil, err
+	}
+
+	before := len(indexes)
+	for i := 0; i < specs.Len(); i++ {
+		spec, err := specs.Get(i)
+		if err != nil {
+			return nil, lazyerrors.Error(err)
+		}
+
+		index, err := parseIndexSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		exists, err := indexExists(indexes, index)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			continue
+		}
+
+		if err = h.hanaPool.CreateIndex(ctx, db, collection, index); err != nil {
+			return nil, common.CheckDuplicateKey(err, db, collection, types.Document{})
+		}
+
+		indexes = append(indexes, index)
+	}
+
+	res := types.MustMakeDocument(
+		"numIndexesBefore", int32(before),
+		"numIndexesAfter", int32(len(indexes)),
+		"createdCollectionAutomatically", false,
+	)
+	if before == len(indexes) {
+		if err = res.Set("note", "all indexes already exist"); err != nil {
+			return nil, lazyerrors.Error(err)
+		}
+	}
+	if err = res.Set("ok", float64(1)); err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
 	var reply wire.OpMsg
-	err := reply.SetSections(wire.OpMsgSection{
-		Documents: []types.Document{types.MustMakeDocument(
-			"ok", float64(1),
-		)},
+	err = reply.SetSections(wire.OpMsgSection{
+		Documents: []types.Document{res},
 	})
 	if err != nil {
 		return nil, lazyerrors.Error(err)
@@ -40,3 +119,160 @@ func (h *storage) MsgCreateIndexes(ctx context.Context, msg *wire.OpMsg) (*wire.
 
 	return &reply, nil
 }
+
+// collectionIndexes returns the indexes of the collection. The _id index comes first.
+// Collections which were not written since _id uniqueness is enforced by an index may not have it yet.
+func (h *storage) collectionIndexes(ctx context.Context, db, collection string) ([]hana.Index, error) {
+	indexes, err := h.hanaPool.Indexes(ctx, db, collection)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]hana.Index, 0, len(indexes))
+	for _, index := range indexes {
+		if index.Name == hana.IDIndexName {
+			res = append([]hana.Index{index}, res...)
+			continue
+		}
+		res = append(res, index)
+	}
+
+	return res, nil
+}
+
+// parseIndexSpec validates the specification of an index given to createIndexes.
+func parseIndexSpec(spec any) (index hana.Index, err error) {
+	doc, ok := spec.(types.Document)
+	if !ok {
+		err = common.NewErrorMessage(common.ErrTypeMismatch, "The field 'indexes' must be an array of objects, but found %T", spec)
+		return
+	}
+
+	m := doc.Map()
+
+	key, ok := m["key"].(types.Document)
+	if !ok || len(key.Keys()) == 0 {
+		err = common.NewErrorMessage(common.ErrCannotCreateIndex, "Index keys cannot be an empty field")
+		return
+	}
+
+	var names []string
+	for _, field := range key.Keys() {
+		var order int32
+		switch value := key.Map()[field].(type) {
+		case int32:
+			order = value
+		case int64:
+			order = int32(value)
+		case float64:
+			order = int32(value)
+		case string:
+			err = common.NewErrorMessage(common.ErrNotImplemented, "index type %q is not implemented yet", value)
+			return
+		default:
+			err = common.NewErrorMessage(
+				common.ErrCannotCreateIndex, "Values in v:2 index key pattern cannot be of type %T. Only numbers > 0, numbers < 0, and strings are allowed.", value,
+			)
+			return
+		}
+
+		switch {
+		case order > 0:
+			order = 1
+		case order < 0:
+			order = -1
+		default:
+			err = common.NewErrorMessage(common.ErrCannotCreateIndex, "Values in the index key pattern can't be 0")
+			return
+		}
+
+		if field == "" || strings.HasPrefix(field, "$") {
+			err = common.NewErrorMessage(common.ErrCannotCreateIndex, "Index key contains an illegal field name: %q", field)
+			return
+		}
+
+		index.Keys = append(index.Keys, hana.IndexKey{Field: field, Order: order})
+		names = append(names, fmt.Sprintf("%s_%d", field, order))
+	}
+
+	index.Name = strings.Join(names, "_")
+	if name, exists := m["name"]; exists {
+		if index.Name, ok = name.(string); !ok || index.Name == "" {
+			err = common.NewErrorMessage(common.ErrCannotCreateIndex, "The index name must be a non-empty string")
+			return
+		}
+	}
+
+	if strings.Contains(index.Name, "\"") {
+		err = common.NewErrorMessage(common.ErrCannotCreateIndex, "The index name cannot contain '\"': %s", index.Name)
+		return
+	}
+
+	for _, option := range doc.Keys() {
+		switch option {
+		case "key", "name", "v", "background":
+		case "unique":
+			index.Unique, _ = m["unique"].(bool)
+		default:
+			err = common.NewErrorMessage(common.ErrNotImplemented, "index option %s is not implemented yet", option)
+			return
+		}
+	}
+
+	return
+}
+
+// indexExists returns true if an identical index exists. An index with the same name or the same keys
+// but a different specification is an error.
+func indexExists(indexes []hana.Index, index hana.Index) (bool, error) {
+	for _, existing := range indexes {
+		sameKeys := equalIndexKeys(existing.Keys, index.Keys)
+
+		switch {
+		case existing.Name == index.Name && sameKeys && (existing.Unique == index.Unique || existing.Name == hana.IDIndexName):
+			return true, nil
+		case existing.Name == index.Name:
+			return false, common.NewErrorMessage(
+				common.ErrIndexKeySpecsConflict, "An existing index has the same name as the requested index but a different specification: %s", index.Name,
+			)
+		case sameKeys:
+			return false, common.NewErrorMessage(common.ErrIndexOptionsConflict, "Index already exists with a different name: %s", existing.Name)
+		}
+	}
+
+	return false, nil
+}
+
+// equalIndexKeys returns true if both indexes have the same fields in the same order.
+func equalIndexKeys(a, b []hana.IndexKey) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// indexDocument returns the description of the index as returned by listIndexes.
+func indexDocument(index hana.Index) types.Document {
+	key := types.MustMakeDocument()
+	for _, k := range index.Keys {
+		_ = key.Set(k.Field, k.Order)
+	}
+
+	doc := types.MustMakeDocument(
+		"v", int32(2),
+		"key", key,
+		"name", index.Name,
+	)
+	if index.Unique && index.Name != hana.IDIndexName {
+		_ = doc.Set("unique", true)
+	}
+
+	return doc
+}
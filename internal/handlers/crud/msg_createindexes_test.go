@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"database/sql/driver"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// indexesQuery is the beginning of the query reading the indexes of a collection.
+const indexesQuery = "SELECT i.INDEX_NAME, i.CONSTRAINT, c.COLUMN_NAME, c.ASCENDING_ORDER FROM SYS.INDEXES i"
+
+// indexRows returns the rows of the indexes query for the _id index and the given index rows.
+func indexRows(mock sqlmock.Sqlmock, rows ...[]any) *sqlmock.Rows {
+	res := mock.NewRows([]string{"INDEX_NAME", "CONSTRAINT", "COLUMN_NAME", "ASCENDING_ORDER"}).
+		AddRow("TESTCOLLECTION._id_", "UNIQUE", "\"_id\"", "TRUE")
+	for _, row := range rows {
+		values := make([]driver.Value, len(row))
+		for i, v := range row {
+			values[i] = v
+		}
+		res.AddRow(values...)
+	}
+
+	return res
+}
+
+func TestMsgCreateIndexes(t *testing.T) {
+	ctx, storage, mock, err := setupTestUtil(t)
+	require.NoError(t, err)
+
+	createIndexes := func(t *testing.T, indexes ...any) (types.Document, error) {
+		t.Helper()
+
+		var reqMsg wire.OpMsg
+		err := reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{types.MustMakeDocument(
+				"createIndexes", "testCollection",
+				"indexes", types.MustNewArray(indexes...),
+				"$db", "testDatabase",
+			)},
+		})
+		require.NoError(t, err)
+
+		msg, err := storage.MsgCreateIndexes(ctx, &reqMsg)
+		if err != nil {
+			return types.Document{}, err
+		}
+
+		return msg.Document()
+	}
+
+	t.Run("create", func(t *testing.T) {
+		mock.ExpectQuery(indexesQuery).WithArgs("TESTDATABASE", "TESTCOLLECTION").WillReturnRows(indexRows(mock))
+		mock.ExpectExec(`CREATE INDEX "TESTDATABASE"."TESTCOLLECTION.a_1_b.c_-1" ON "TESTDATABASE"."TESTCOLLECTION"("a", "b"."c" DESC)`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`CREATE UNIQUE INDEX "TESTDATABASE"."TESTCOLLECTION.email" ON "TESTDATABASE"."TESTCOLLECTION"("email")`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		actual, err := createIndexes(t,
+			types.MustMakeDocument("key", types.MustMakeDocument("a", int32(1), "b.c", float64(-1))),
+			types.MustMakeDocument("key", types.MustMakeDocument("email", int32(1)), "name", "email", "unique", true),
+		)
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"numIndexesBefore", int32(1),
+			"numIndexesAfter", int32(3),
+			"createdCollectionAutomatically", false,
+			"ok", float64(1),
+		)
+		assert.Equal(t, expected, actual)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("already exists", func(t *testing.T) {
+		mock.ExpectQuery(indexesQuery).WithArgs("TESTDATABASE", "TESTCOLLECTION").
+			WillReturnRows(indexRows(mock, []any{"TESTCOLLECTION.a_1", nil, "\"a\"", "TRUE"}))
+
+		actual, err := createIndexes(t,
+			types.MustMakeDocument("key", types.MustMakeDocument("_id", int32(1)), "name", "_id_"),
+			types.MustMakeDocument("key", types.MustMakeDocument("a", int32(1))),
+		)
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"numIndexesBefore", int32(2),
+			"numIndexesAfter", int32(2),
+			"createdCollectionAutomatically", false,
+			"note", "all indexes already exist",
+			"ok", float64(1),
+		)
+		assert.Equal(t, expected, actual)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("conflicts", func(t *testing.T) {
+		mock.ExpectQuery(indexesQuery).WithArgs("TESTDATABASE", "TESTCOLLECTION").
+			WillReturnRows(indexRows(mock, []any{"TESTCOLLECTION.a_1", nil, "\"a\"", "TRUE"}))
+
+		_, err := createIndexes(t, types.MustMakeDocument("key", types.MustMakeDocument("b", int32(1)), "name", "a_1"))
+		assert.EqualError(t, err, "IndexKeySpecsConflict (86): An existing index has the same name as the requested index but a different specification: a_1")
+
+		mock.ExpectQuery(indexesQuery).WithArgs("TESTDATABASE", "TESTCOLLECTION").
+			WillReturnRows(indexRows(mock, []any{"TESTCOLLECTION.a_1", nil, "\"a\"", "TRUE"}))
+
+		_, err = createIndexes(t, types.MustMakeDocument("key", types.MustMakeDocument("a", int32(1)), "name", "other"))
+		assert.EqualError(t, err, "IndexOptionsConflict (85): Index already exists with a different name: a_1")
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("invalid specs", func(t *testing.T) {
+		_, err := createIndexes(t)
+		assert.EqualError(t, err, "BadValue (2): Must specify at least one index to create")
+
+		for _, tc := range []struct {
+			spec types.Document
+			err  string
+		}{{
+			spec: types.MustMakeDocument("key", types.MustMakeDocument("a", int32(0))),
+			err:  "CannotCreateIndex (67): Values in the index key pattern can't be 0",
+		}, {
+			spec: types.MustMakeDocument("key", types.MustMakeDocument("a", "text")),
+			err:  "NotImplemented (238): index type \"text\" is not implemented yet",
+		}, {
+			spec: types.MustMakeDocument("key", types.MustMakeDocument("a", int32(1)), "sparse", true),
+			err:  "NotImplemented (238): index option sparse is not implemented yet",
+		}} {
+			_, err := parseIndexSpec(tc.spec)
+			assert.EqualError(t, err, tc.err)
+		}
+	})
+}
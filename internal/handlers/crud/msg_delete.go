@@ -22,9 +22,12 @@ package crud
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/fjson"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
@@ -33,6 +36,11 @@ import (
 
 // MsgDelete deletes document(s).
 func (h *storage) MsgDelete(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	return h.retryableWrite(ctx, msg, h.msgDelete)
+}
+
+// msgDelete runs the delete command, within the transaction of a retryable write if it is one.
+func (h *storage) msgDelete(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
 	document, err := msg.Document()
 	if err != nil {
 		return nil, lazyerrors.Error(err)
@@ -41,7 +49,17 @@ func (h *storage) MsgDelete(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg,
 	if err := common.Unimplemented(&document, "let", "writeConcern"); err != nil {
 		return nil, err
 	}
-	common.Ignored(&document, h.l, "ordered")
+
+	ordered, err := common.GetOrdered(document)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, done, err := h.startCommand(ctx, document)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 
 	m := document.Map()
 
@@ -51,91 +69,182 @@ func (h *storage) MsgDelete(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg,
 	docs, _ := m["deletes"].(*types.Array)
 
 	var deleted int32
+	var writeErrors common.WriteErrors
 	for i := 0; i < docs.Len(); i++ {
-
 		doc, err := docs.Get(i)
 		if err != nil {
 			return nil, lazyerrors.Error(err)
 		}
-		check := doc.(types.Document)
-		if err := common.Unimplemented(&check, "collation", "hint"); err != nil {
-			return nil, err
+
+		n, err := h.deleteStatement(ctx, db, collection, doc.(types.Document))
+		if err != nil {
+			if err = writeErrors.Append(err, int32(i)); err != nil {
+				return nil, err
+			}
+
+			if ordered {
+				break
+			}
+
+			continue
 		}
 
-		d := doc.(types.Document).Map()
+		deleted += n
+	}
+
+	res := types.MustMakeDocument(
+		"n", deleted,
+	)
+	if err = writeErrors.SetTo(&res); err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+	if err = res.Set("ok", float64(1)); err != nil {
+		return nil, lazyerrors.Error(err)
+	}
 
-		sql := fmt.Sprintf(`DELETE FROM %s.%s`, db, collection)
+	var reply wire.OpMsg
+	err = reply.SetSections(wire.OpMsgSection{
+		Documents: []types.Document{res},
+	})
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
 
-		limit, _ := d["limit"].(int32)
+	return &reply, nil
+}
 
-		var delSQL string
-		var args []any
-		if limit != 0 { // if deleteOne()
-			qSQL := fmt.Sprintf("SELECT {\"_id\": \"_id\"} FROM %s.%s", db, collection)
+// deleteStatement executes a single delete statement of the delete command.
+// It returns the number of deleted documents.
+func (h *storage) deleteStatement(ctx context.Context, db, collection string, statement types.Document) (int32, error) {
+	if err := common.Unimplemented(&statement, "collation", "hint"); err != nil {
+		return 0, err
+	}
 
-			whereSQL, err := common.CreateWhereClause(d["q"].(types.Document))
-			if err != nil {
-				return nil, err
-			}
+	d := statement.Map()
 
-			qSQL += whereSQL + " LIMIT 1"
+	table, err := hana.Table(db, collection)
+	if err != nil {
+		return 0, err
+	}
 
-			row := h.hanaPool.QueryRowContext(ctx, qSQL)
+	limit, _ := d["limit"].(int32)
 
-			var objectID []byte
-			err = row.Scan(&objectID)
-			if err != nil {
-				err = nil
-				continue
-			}
+	filter := d["q"].(types.Document)
 
-			id, err := fjson.Unmarshal(objectID)
-			if err != nil {
-				return nil, err
-			}
+	whereSQL, args, exact, err := common.CreatePrefilterWhereClause(filter)
+	if err != nil {
+		return 0, err
+	}
 
-			deleteId, err := getUpdateValue(id.(types.Document).Map()["_id"])
-			if err != nil {
-				return nil, err
-			}
+	if !exact {
+		return h.deleteMatching(ctx, db, collection, table, whereSQL, args, filter, limit == 0)
+	}
 
-			args = append(args, deleteId)
-			delSQL = " WHERE \"_id\" = %s"
+	if limit != 0 { // if deleteOne()
+		return h.deleteOne(ctx, table, whereSQL, args)
+	}
 
-		} else { // if deleteMany()
-			delSQL, err = common.CreateWhereClause(d["q"].(types.Document))
-			if err != nil {
-				return nil, lazyerrors.Error(err)
-			}
-		}
+	tag, err := h.querier(ctx).ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s`, table)+whereSQL, args...)
+	if err != nil {
+		return 0, deleteError(err)
+	}
 
-		sql += delSQL
+	rowsaffected, err := tag.RowsAffected()
+	if err != nil {
+		return 0, lazyerrors.Error(err)
+	}
 
-		sqlExec := fmt.Sprintf(sql, args...)
-		tag, err := h.hanaPool.ExecContext(ctx, sqlExec)
-		if err != nil {
-			// TODO check error code
-			return nil, common.NewErrorMessage(common.ErrNamespaceNotFound, "MsgDelete: ns not found: %w", err)
-		}
+	return int32(rowsaffected), nil
+}
 
-		rowsaffected, err := tag.RowsAffected()
-		if err != nil {
-			return nil, lazyerrors.Error(err)
-		}
+// deleteOne deletes the first document selected by the exact where clause.
+// The document is locked while its _id is selected and deleted by _id within the same transaction.
+// It returns the number of deleted documents.
+func (h *storage) deleteOne(ctx context.Context, table, whereSQL string, args []any) (int32, error) {
+	tx, err := h.beginTx(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
 
-		deleted += int32(rowsaffected)
+	selectSQL := fmt.Sprintf("SELECT {\"_id\": \"_id\"} FROM %s", table) + whereSQL + " LIMIT 1 FOR UPDATE"
+	row := tx.QueryRowContext(ctx, selectSQL, args...)
+
+	var objectID []byte
+	err = row.Scan(&objectID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// nothing to delete
+		return 0, nil
+	}
+	if err != nil {
+		return 0, deleteError(err)
 	}
 
-	var reply wire.OpMsg
-	err = reply.SetSections(wire.OpMsgSection{
-		Documents: []types.Document{types.MustMakeDocument(
-			"n", deleted,
-			"ok", float64(1),
-		)},
-	})
+	id, err := fjson.Unmarshal(objectID)
 	if err != nil {
-		return nil, lazyerrors.Error(err)
+		return 0, err
 	}
 
-	return &reply, nil
+	whereSQL, args, err = idWhereClause(id.(types.Document).Map()["_id"])
+	if err != nil {
+		return 0, err
+	}
+
+	tag, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s`, table)+whereSQL, args...)
+	if err != nil {
+		return 0, deleteError(err)
+	}
+
+	rowsaffected, err := tag.RowsAffected()
+	if err != nil {
+		return 0, lazyerrors.Error(err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, lazyerrors.Error(err)
+	}
+
+	return int32(rowsaffected), nil
+}
+
+// deleteError returns the error of a statement of the delete command. A missing privilege is returned
+// as a command error with the code Unauthorized by common.ProtocolError, any other error as NamespaceNotFound.
+func deleteError(err error) error {
+	if hana.IsInsufficientPrivilege(err) {
+		return lazyerrors.Error(err)
+	}
+
+	return common.NewErrorMessage(common.ErrNamespaceNotFound, "MsgDelete: ns not found: %w", err)
+}
+
+// deleteMatching deletes the documents matching a filter SAP HANA cannot evaluate completely.
+// The documents selected by the where clause are locked and filtered with common.FilterDocument,
+// and the matching ones are deleted by _id within the same transaction.
+// It returns the number of deleted documents.
+func (h *storage) deleteMatching(
+	ctx context.Context, db, collection, table, whereSQL string, args []any, filter types.Document, multi bool,
+) (int32, error) {
+	tx, err := h.beginTx(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	selectSQL := fmt.Sprintf("SELECT * FROM %s", table) + whereSQL
+	docs, err := lockMatching(ctx, tx, table, selectSQL, args, filter, multi, false)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, doc := range docs {
+		if err = deleteDocument(ctx, tx, db, collection, doc.Map()["_id"]); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, lazyerrors.Error(err)
+	}
+
+	return int32(len(docs)), nil
 }
@@ -6,6 +6,7 @@ package crud
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
 
@@ -50,14 +51,20 @@ func setupTestUtil(t *testing.T) (context.Context, common.Storage, sqlmock.Sqlmo
 	}
 
 	hPool := hana.Hpool{
-		db,
+		DB: db,
 	}
 
 	ctx := testutil.Ctx(t)
 
 	l := zaptest.NewLogger(t)
 
-	storage := NewStorage(&hPool, l)
+	storage := NewStorage(&NewStorageOpts{
+		HanaPool:        &hPool,
+		Logger:          l,
+		Cursors:         NewCursors(DefaultCursorTimeout, l),
+		Sessions:        NewSessions(DefaultTransactionTimeout, l),
+		InsertBatchSize: 2,
+	})
 
 	return ctx, storage, mock, err
 }
@@ -66,7 +73,7 @@ func TestMsgDelete(t *testing.T) {
 	ctx, storage, mock, err := setupTestUtil(t)
 	require.NoError(t, err)
 	t.Run("deleteMany", func(t *testing.T) {
-		mock.ExpectExec("DELETE FROM testDatabase.testCollection WHERE \"item\" = 'test'").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`DELETE FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" = ?`).WithArgs("test").WillReturnResult(sqlmock.NewResult(1, 1))
 
 		deleteReq := types.MustMakeDocument(
 			"delete", "testCollection",
@@ -104,11 +111,93 @@ func TestMsgDelete(t *testing.T) {
 		}
 	})
 
+	t.Run("insufficient privilege", func(t *testing.T) {
+		mock.ExpectExec(`DELETE FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" = ?`).WithArgs("test").
+			WillReturnError(errors.New("SQL Error 258 - insufficient privilege: Detailed info for this error can be found with guid"))
+
+		deleteReq := types.MustMakeDocument(
+			"delete", "testCollection",
+			"deletes", types.MustNewArray(
+				types.MustMakeDocument(
+					"q", types.MustMakeDocument(
+						"item", "test",
+					),
+					"limit", float64(0),
+				),
+			),
+			"ordered", true,
+			"$db", "testDatabase",
+		)
+
+		var reqMsg wire.OpMsg
+		err = reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{deleteReq},
+		})
+		require.NoError(t, err)
+
+		_, err := storage.MsgDelete(ctx, &reqMsg)
+		require.Error(t, err)
+
+		protoErr, ok := common.ProtocolError(err)
+		require.True(t, ok)
+		assert.Equal(t, int32(common.ErrUnauthorized), protoErr.Document().Map()["code"])
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("deleteMany with post-filtered $expr", func(t *testing.T) {
+		docRows := mock.NewRows([]string{"document"}).
+			AddRow([]byte(`{"_id": 1, "spent": 10, "budget": 5}`)).
+			AddRow([]byte(`{"_id": 2, "spent": 5, "budget": 10}`))
+		// the matching documents are locked and deleted by _id in one transaction
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE 1 = 1`).WillReturnRows(docRows)
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ? AND 1 = 1 FOR UPDATE`).WithArgs(1).
+			WillReturnRows(mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": 1, "spent": 10, "budget": 5}`)))
+		mock.ExpectExec(`DELETE FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ?`).WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		deleteReq := types.MustMakeDocument(
+			"delete", "testCollection",
+			"deletes", types.MustNewArray(
+				types.MustMakeDocument(
+					"q", types.MustMakeDocument(
+						"$expr", types.MustMakeDocument("$gt", types.MustNewArray("$spent", "$budget")),
+					),
+					"limit", float64(0),
+				),
+			),
+			"ordered", true,
+			"$db", "testDatabase",
+		)
+
+		var reqMsg wire.OpMsg
+		err = reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{deleteReq},
+		})
+		require.NoError(t, err)
+
+		msg, err := storage.MsgDelete(ctx, &reqMsg)
+		require.NoError(t, err)
+
+		actual, _ := msg.Document()
+		assert.Equal(t, types.MustMakeDocument("n", int32(1), "ok", float64(1)), actual)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
 	t.Run("deleteOne", func(t *testing.T) {
 		idRow := mock.NewRows([]string{"_id"}).AddRow("{\"_id\": 123}")
 
-		mock.ExpectQuery("SELECT {\"_id\": \"_id\"} FROM testDatabase.testCollection WHERE \"item\" = 'test' LIMIT 1").WillReturnRows(idRow)
-		mock.ExpectExec("DELETE FROM testDatabase.testCollection WHERE \"_id\" = 123").WillReturnResult(sqlmock.NewResult(1, 1))
+		// the document is locked while its _id is selected and deleted in one transaction
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT {"_id": "_id"} FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" = ? LIMIT 1 FOR UPDATE`).WithArgs("test").WillReturnRows(idRow)
+		mock.ExpectExec(`DELETE FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ?`).WithArgs(123).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
 
 		deleteReq := types.MustMakeDocument(
 			"delete", "testCollection",
@@ -145,4 +234,72 @@ func TestMsgDelete(t *testing.T) {
 			t.Errorf("there were unfulfilled expectations: %s", err)
 		}
 	})
+
+	t.Run("deleteOne without matching document", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT {"_id": "_id"} FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" = ? LIMIT 1 FOR UPDATE`).
+			WithArgs("none").
+			WillReturnRows(mock.NewRows([]string{"_id"}))
+		mock.ExpectRollback()
+
+		deleteReq := types.MustMakeDocument(
+			"delete", "testCollection",
+			"deletes", types.MustNewArray(
+				types.MustMakeDocument(
+					"q", types.MustMakeDocument("item", "none"),
+					"limit", int32(1),
+				),
+			),
+			"$db", "testDatabase",
+		)
+
+		var reqMsg wire.OpMsg
+		err = reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{deleteReq},
+		})
+		require.NoError(t, err)
+
+		msg, err := storage.MsgDelete(ctx, &reqMsg)
+		require.NoError(t, err)
+
+		actual, err := msg.Document()
+		require.NoError(t, err)
+		assert.Equal(t, types.MustMakeDocument("n", int32(0), "ok", float64(1)), actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("deleteOne with failing select", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT {"_id": "_id"} FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" = ? LIMIT 1 FOR UPDATE`).
+			WithArgs("test").
+			WillReturnError(errors.New("SQL Error 258 - insufficient privilege: Detailed info for this error can be found with guid"))
+		mock.ExpectRollback()
+
+		deleteReq := types.MustMakeDocument(
+			"delete", "testCollection",
+			"deletes", types.MustNewArray(
+				types.MustMakeDocument(
+					"q", types.MustMakeDocument("item", "test"),
+					"limit", int32(1),
+				),
+			),
+			"$db", "testDatabase",
+		)
+
+		var reqMsg wire.OpMsg
+		err = reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{deleteReq},
+		})
+		require.NoError(t, err)
+
+		_, err := storage.MsgDelete(ctx, &reqMsg)
+		require.Error(t, err)
+
+		protoErr, ok := common.ProtocolError(err)
+		require.True(t, ok)
+		assert.Equal(t, int32(common.ErrUnauthorized), protoErr.Document().Map()["code"])
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
 }
@@ -0,0 +1,193 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+)
+
+// MsgDistinct returns the distinct values of a field of the documents matching the query.
+// SELECT DISTINCT of the top-level field is executed by SAP HANA. The rest of a dotted path
+// is looked up afterwards, so that arrays of subdocuments on the path are traversed, and array values are unwound.
+func (h *storage) MsgDistinct(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	document, err := msg.Document()
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	if err := common.Unimplemented(&document, "collation", "hint", "readConcern", "comment"); err != nil {
+		return nil, err
+	}
+
+	ctx, done, err := h.startCommand(ctx, document)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	m := document.Map()
+	collection := m[document.Keys()[0]].(string)
+	db := m["$db"].(string)
+
+	key, ok := m["key"].(string)
+	if !ok {
+		return nil, common.NewErrorMessage(common.ErrTypeMismatch, "BSON field 'distinct.key' is the wrong type '%T', expected type 'string'", m["key"])
+	}
+
+	if key == "" || strings.HasPrefix(key, "$") {
+		return nil, common.NewErrorMessage(common.ErrBadValue, "FieldPath field names may not start with '$' or be empty")
+	}
+
+	var query types.Document
+	if value, exists := m["query"]; exists && value != nil {
+		if query, ok = value.(types.Document); !ok {
+			return nil, common.NewErrorMessage(common.ErrTypeMismatch, "BSON field 'distinct.query' is the wrong type '%T', expected type 'object'", value)
+		}
+	}
+
+	table, err := hana.Table(db, collection)
+	if err != nil {
+		return nil, err
+	}
+
+	whereSQL, args, exact, err := common.CreatePrefilterWhereClause(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// SAP HANA does not traverse arrays on a path, so only the top-level field is selected
+	field, path, _ := strings.Cut(key, ".")
+
+	keySQL, err := common.WhereKey(field)
+	if err != nil {
+		return nil, err
+	}
+
+	// documents without the field are not taken into account, explicit null values are
+	if whereSQL == "" {
+		whereSQL = " WHERE " + keySQL + " IS SET"
+	} else {
+		whereSQL = " WHERE (" + strings.TrimPrefix(whereSQL, " WHERE ") + ") AND " + keySQL + " IS SET"
+	}
+
+	var values []any
+	seen := make(map[string]struct{})
+
+	// documents selected by a query SAP HANA cannot evaluate completely are filtered while they are read
+	if !exact {
+		rows, err := h.querier(ctx).QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s", table)+whereSQL, args...)
+		if err != nil {
+			return nil, lazyerrors.Error(err)
+		}
+		defer rows.Close()
+
+		for {
+			doc, err := nextRow(rows)
+			if err != nil {
+				return nil, err
+			}
+			if doc == nil {
+				break
+			}
+
+			matches, err := common.FilterDocument(*doc, query)
+			if err != nil {
+				return nil, err
+			}
+			if !matches {
+				continue
+			}
+
+			for _, v := range common.LookupValues(*doc, key) {
+				values = appendDistinct(values, seen, v)
+			}
+		}
+	} else {
+		rows, err := h.querier(ctx).QueryContext(ctx, fmt.Sprintf("SELECT DISTINCT %s FROM %s", keySQL, table)+whereSQL, args...)
+		if err != nil {
+			return nil, lazyerrors.Error(err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var b []byte
+			if err = rows.Scan(&b); err != nil {
+				return nil, lazyerrors.Error(err)
+			}
+
+			value := columnValue(b)
+			if path == "" {
+				values = appendDistinct(values, seen, value)
+				continue
+			}
+
+			for _, v := range common.LookupValues(value, path) {
+				values = appendDistinct(values, seen, v)
+			}
+		}
+		if err = rows.Err(); err != nil {
+			return nil, lazyerrors.Error(err)
+		}
+	}
+
+	sort.SliceStable(values, func(i, j int) bool {
+		return common.CompareValues(values[i], values[j]) < 0
+	})
+
+	arr := types.MakeArray(len(values))
+	for _, value := range values {
+		if err = arr.Append(value); err != nil {
+			return nil, lazyerrors.Error(err)
+		}
+	}
+
+	var reply wire.OpMsg
+	err = reply.SetSections(wire.OpMsgSection{
+		Documents: []types.Document{types.MustMakeDocument(
+			"values", arr,
+			"ok", float64(1),
+		)},
+	})
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	return &reply, nil
+}
+
+// appendDistinct appends the value if it is not contained yet. Arrays are unwound one level like MongoDB does.
+func appendDistinct(values []any, seen map[string]struct{}, value any) []any {
+	arr, ok := value.(*types.Array)
+	if !ok {
+		return addDistinct(values, seen, value)
+	}
+
+	for i := 0; i < arr.Len(); i++ {
+		elem, _ := arr.Get(i)
+		values = addDistinct(values, seen, elem)
+	}
+
+	return values
+}
+
+// addDistinct appends the value if no equal value is contained yet. seen holds the keys of the contained values.
+func addDistinct(values []any, seen map[string]struct{}, value any) []any {
+	key := common.ValueKey(value)
+	if _, ok := seen[key]; ok {
+		return values
+	}
+
+	seen[key] = struct{}{}
+	return append(values, value)
+}
@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"testing"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMsgDistinct(t *testing.T) {
+	ctx, storage, mock, err := setupTestUtil(t)
+	require.NoError(t, err)
+
+	distinct := func(t *testing.T, pairs ...any) (types.Document, error) {
+		t.Helper()
+
+		pairs = append([]any{"distinct", "testCollection"}, pairs...)
+		pairs = append(pairs, "$db", "testDatabase")
+
+		var reqMsg wire.OpMsg
+		err := reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{types.MustMakeDocument(pairs...)},
+		})
+		require.NoError(t, err)
+
+		msg, err := storage.MsgDistinct(ctx, &reqMsg)
+		if err != nil {
+			return types.Document{}, err
+		}
+
+		return msg.Document()
+	}
+
+	t.Run("unwinds arrays", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT DISTINCT "tags" FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "tags" IS SET`).
+			WillReturnRows(mock.NewRows([]string{"tags"}).
+				AddRow([]byte(`"b"`)).
+				AddRow([]byte(`["a", "b", ["c"]]`)).
+				AddRow(nil))
+
+		actual, err := distinct(t, "key", "tags")
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"values", types.MustNewArray(nil, "a", "b", types.MustNewArray("c")),
+			"ok", float64(1),
+		)
+		assert.Equal(t, expected, actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("query and dotted path", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT DISTINCT "size" FROM "TESTDATABASE"."TESTCOLLECTION" WHERE ("item" = ?) AND "size" IS SET`).
+			WithArgs("test").
+			WillReturnRows(mock.NewRows([]string{"size"}).
+				AddRow([]byte(`{"h": 2, "w": 1}`)).
+				AddRow([]byte(`{"h": 1}`)).
+				AddRow([]byte(`{"w": 3}`)).
+				AddRow([]byte(`{"h": 2.0}`)))
+
+		actual, err := distinct(t, "key", "size.h", "query", types.MustMakeDocument("item", "test"))
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"values", types.MustNewArray(int32(1), int32(2)),
+			"ok", float64(1),
+		)
+		assert.Equal(t, expected, actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("dotted path through arrays", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT DISTINCT "a" FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "a" IS SET`).
+			WillReturnRows(mock.NewRows([]string{"a"}).
+				AddRow([]byte(`[{"b": 1}, {"b": 2}]`)).
+				AddRow([]byte(`[{"b": [2, 3]}, {"c": 4}, 5]`)).
+				AddRow([]byte(`{"b": 1.0}`)))
+
+		actual, err := distinct(t, "key", "a.b")
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"values", types.MustNewArray(int32(1), int32(2), int32(3)),
+			"ok", float64(1),
+		)
+		assert.Equal(t, expected, actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("post-filtered query", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE (1 = 1) AND "size" IS SET`).
+			WillReturnRows(mock.NewRows([]string{"document"}).
+				AddRow([]byte(`{"_id": 1, "size": {"h": 2}, "qty": 5}`)).
+				AddRow([]byte(`{"_id": 2, "size": {"h": 3}, "qty": "5"}`)).
+				AddRow([]byte(`{"_id": 3, "size": [{"h": 1}, {"h": 2}], "qty": 6}`)))
+
+		actual, err := distinct(t, "key", "size.h", "query", types.MustMakeDocument("qty", types.MustMakeDocument("$type", "int")))
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"values", types.MustNewArray(int32(1), int32(2)),
+			"ok", float64(1),
+		)
+		assert.Equal(t, expected, actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		_, err := distinct(t, "key", int32(1))
+		assert.EqualError(t, err, "TypeMismatch (14): BSON field 'distinct.key' is the wrong type 'int32', expected type 'string'")
+
+		_, err = distinct(t, "key", "$a")
+		assert.EqualError(t, err, "BadValue (2): FieldPath field names may not start with '$' or be empty")
+	})
+}
@@ -0,0 +1,138 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"context"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+)
+
+// MsgDropIndexes drops indexes of a collection given by name, by key or "*" for all indexes besides _id.
+func (h *storage) MsgDropIndexes(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	document, err := msg.Document()
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	if err := common.Unimplemented(&document, "comment"); err != nil {
+		return nil, err
+	}
+
+	common.Ignored(&document, h.l, "writeConcern")
+
+	if err := notInTransaction(document, "dropIndexes"); err != nil {
+		return nil, err
+	}
+
+	m := document.Map()
+	collection := m[document.Keys()[0]].(string)
+	db := m["$db"].(string)
+
+	indexes, err := h.collectionIndexes(ctx, db, collection)
+	if err != nil {
+		return nil, err
+	}
+
+	drop, err := indexesToDrop(indexes, m["index"])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, index := range drop {
+		if err = h.hanaPool.DropIndex(ctx, db, collection, index); err != nil {
+			return nil, err
+		}
+	}
+
+	var reply wire.OpMsg
+	err = reply.SetSections(wire.OpMsgSection{
+		Documents: []types.Document{types.MustMakeDocument(
+			"nIndexesWas", int32(len(indexes)),
+			"ok", float64(1),
+		)},
+	})
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	return &reply, nil
+}
+
+// indexesToDrop returns the indexes selected by the index parameter of dropIndexes.
+func indexesToDrop(indexes []hana.Index, param any) ([]hana.Index, error) {
+	var names []string
+	switch param := param.(type) {
+	case string:
+		if param == "*" {
+			return indexes[1:], nil
+		}
+		names = []string{param}
+
+	case *types.Array:
+		for i := 0; i < param.Len(); i++ {
+			value, err := param.Get(i)
+			if err != nil {
+				return nil, lazyerrors.Error(err)
+			}
+
+			name, ok := value.(string)
+			if !ok {
+				return nil, common.NewErrorMessage(common.ErrTypeMismatch, "dropIndexes requires an array of index names")
+			}
+			if name == "*" {
+				return nil, common.NewErrorMessage(common.ErrBadValue, "'*' is not allowed in an array of index names")
+			}
+			names = append(names, name)
+		}
+
+	case types.Document:
+		key, err := parseIndexSpec(types.MustMakeDocument("key", param))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, index := range indexes {
+			if equalIndexKeys(index.Keys, key.Keys) {
+				names = []string{index.Name}
+				break
+			}
+		}
+		if names == nil {
+			return nil, common.NewErrorMessage(common.ErrIndexNotFound, "can't find index with key: %v", param)
+		}
+
+	default:
+		return nil, common.NewErrorMessage(
+			common.ErrTypeMismatch, "BSON field 'dropIndexes.index' is the wrong type '%T', expected types '[string, object]'", param,
+		)
+	}
+
+	var res []hana.Index
+	for _, name := range names {
+		if name == hana.IDIndexName {
+			return nil, common.NewErrorMessage(common.ErrInvalidOptions, "cannot drop _id index")
+		}
+
+		var found bool
+		for _, index := range indexes {
+			if index.Name == name {
+				res = append(res, index)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, common.NewErrorMessage(common.ErrIndexNotFound, "index not found with name [%s]", name)
+		}
+	}
+
+	return res, nil
+}
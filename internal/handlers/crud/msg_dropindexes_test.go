@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMsgDropIndexes(t *testing.T) {
+	ctx, storage, mock, err := setupTestUtil(t)
+	require.NoError(t, err)
+
+	expectIndexes := func() {
+		mock.ExpectQuery(indexesQuery).WithArgs("TESTDATABASE", "TESTCOLLECTION").
+			WillReturnRows(indexRows(mock,
+				[]any{"TESTCOLLECTION.a_1", nil, "\"a\"", "TRUE"},
+				[]any{"TESTCOLLECTION.b_-1", nil, "\"b\"", "FALSE"},
+			))
+	}
+
+	dropIndexes := func(t *testing.T, index any) (types.Document, error) {
+		t.Helper()
+
+		var reqMsg wire.OpMsg
+		err := reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{types.MustMakeDocument(
+				"dropIndexes", "testCollection",
+				"index", index,
+				"$db", "testDatabase",
+			)},
+		})
+		require.NoError(t, err)
+
+		msg, err := storage.MsgDropIndexes(ctx, &reqMsg)
+		if err != nil {
+			return types.Document{}, err
+		}
+
+		return msg.Document()
+	}
+
+	expected := types.MustMakeDocument(
+		"nIndexesWas", int32(3),
+		"ok", float64(1),
+	)
+
+	t.Run("by name", func(t *testing.T) {
+		expectIndexes()
+		mock.ExpectExec(`DROP INDEX "TESTDATABASE"."TESTCOLLECTION.a_1"`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		actual, err := dropIndexes(t, "a_1")
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("by key", func(t *testing.T) {
+		expectIndexes()
+		mock.ExpectExec(`DROP INDEX "TESTDATABASE"."TESTCOLLECTION.b_-1"`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		actual, err := dropIndexes(t, types.MustMakeDocument("b", int32(-1)))
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("all", func(t *testing.T) {
+		expectIndexes()
+		mock.ExpectExec(`DROP INDEX "TESTDATABASE"."TESTCOLLECTION.a_1"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`DROP INDEX "TESTDATABASE"."TESTCOLLECTION.b_-1"`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		actual, err := dropIndexes(t, "*")
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		expectIndexes()
+		_, err := dropIndexes(t, "_id_")
+		assert.EqualError(t, err, "InvalidOptions (72): cannot drop _id index")
+
+		expectIndexes()
+		_, err = dropIndexes(t, types.MustNewArray("a_1", "c_1"))
+		assert.EqualError(t, err, "IndexNotFound (27): index not found with name [c_1]")
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -26,6 +26,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
 
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
@@ -36,6 +37,7 @@ import (
 type locatCtx struct {
 	exclusion  bool
 	filter     types.Document
+	postFilter bool // the filter is applied to the documents selected by SAP HANA
 	db         string
 	collection string
 }
@@ -49,7 +51,6 @@ func (h *storage) MsgFindOrCount(ctx context.Context, msg *wire.OpMsg) (*wire.Op
 		"showRecordId",
 		"tailable",
 		"oplogReplay",
-		"noCursorTimeout",
 		"awaitData",
 		"allowPartialResults",
 		"collation",
@@ -70,7 +71,13 @@ func (h *storage) MsgFindOrCount(ctx context.Context, msg *wire.OpMsg) (*wire.Op
 		return nil, err
 	}
 
-	common.Ignored(&document, h.l, "singleBatch", "allowDiskUse", "batchSize")
+	common.Ignored(&document, h.l, "allowDiskUse")
+
+	ctx, done, err := h.startCommand(ctx, document)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 
 	docMap := document.Map()
 	if isPrintShardingStatus(docMap) {
@@ -79,10 +86,6 @@ func (h *storage) MsgFindOrCount(ctx context.Context, msg *wire.OpMsg) (*wire.Op
 
 	var localCtx locatCtx
 	localCtx.db = docMap["$db"].(string)
-	sql, err := createSqlStmt(docMap, &localCtx)
-	if err != nil {
-		return nil, err
-	}
 
 	// A workaround which allows connecting and using the basics of some GUI's
 	// TODO: Implement this for real.
@@ -103,7 +106,7 @@ func (h *storage) MsgFindOrCount(ctx context.Context, msg *wire.OpMsg) (*wire.Op
 				return nil, err
 			}
 			return resp, nil
-		} else if localCtx.collection == "system.version" {
+		} else if collection == "system.version" {
 			resp := &wire.OpMsg{}
 			err = resp.SetSections(wire.OpMsgSection{
 				Documents: []types.Document{types.MustMakeDocument(
@@ -125,24 +128,40 @@ func (h *storage) MsgFindOrCount(ctx context.Context, msg *wire.OpMsg) (*wire.Op
 		}
 	}
 
-	rows, err := h.hanaPool.QueryContext(ctx, sql)
+	sql, args, err := h.createSqlStmt(ctx, docMap, &localCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := h.querier(ctx).QueryContext(ctx, sql, args...)
 	if err != nil {
 		return nil, lazyerrors.Error(err)
 	}
 
-	return createResponse(docMap, rows, &localCtx)
+	return h.createResponse(ctx, docMap, rows, &localCtx)
 }
 
-func createSqlStmt(docMap map[string]any, ctx *locatCtx) (sql string, err error) {
-	sql, err = createSqlBaseStmt(docMap, ctx)
+func (h *storage) createSqlStmt(ctx context.Context, docMap map[string]any, localCtx *locatCtx) (sql string, args []any, err error) {
+	sql, err = createSqlBaseStmt(docMap, localCtx)
+	if err != nil {
+		return
+	}
+
+	table, err := hana.Table(localCtx.db, localCtx.collection)
 	if err != nil {
 		return
 	}
 
-	whereStmt, err := common.CreateWhereClause(ctx.filter)
+	whereStmt, args, exact, err := common.CreatePrefilterWhereClause(localCtx.filter)
 	if err != nil {
 		return
 	}
+
+	// the whole documents are selected to filter them, the limit is applied to the filtered documents
+	if !exact {
+		localCtx.postFilter = true
+		sql = fmt.Sprintf("SELECT * FROM %s", table)
+	}
 	sql += whereStmt
 
 	orderBystmt, err := createOrderByStmt(docMap)
@@ -155,7 +174,9 @@ func createSqlStmt(docMap map[string]any, ctx *locatCtx) (sql string, err error)
 	if err != nil {
 		return
 	}
-	sql += limitStmt
+	if !localCtx.postFilter {
+		sql += limitStmt
+	}
 
 	return
 }
@@ -174,11 +195,21 @@ func createSqlBaseStmt(docMap map[string]any, ctx *locatCtx) (sql string, err er
 
 		ctx.collection = docMap["find"].(string)
 		ctx.filter, _ = docMap["filter"].(types.Document)
-		sql = fmt.Sprintf(`SELECT %s FROM %s.%s`, projectionSQL, ctx.db, ctx.collection)
+
+		var table string
+		if table, err = hana.Table(ctx.db, ctx.collection); err != nil {
+			return
+		}
+		sql = fmt.Sprintf(`SELECT %s FROM %s`, projectionSQL, table)
 	} else { // enters here if count
 		ctx.collection = docMap["count"].(string)
 		ctx.filter, _ = docMap["query"].(types.Document)
-		sql = fmt.Sprintf(`SELECT COUNT(*) FROM %s.%s`, ctx.db, ctx.collection)
+
+		var table string
+		if table, err = hana.Table(ctx.db, ctx.collection); err != nil {
+			return
+		}
+		sql = fmt.Sprintf(`SELECT COUNT(*) FROM %s`, table)
 	}
 	return
 }
@@ -199,13 +230,13 @@ func createOrderByStmt(docMap map[string]any) (sql string, err error) {
 				sql += " "
 				for j, s := range split {
 					if (len(split) - 1) == j {
-						sql += "\"" + s + "\""
+						sql += hana.QuoteIdentifier(s)
 					} else {
-						sql += "\"" + s + "\"."
+						sql += hana.QuoteIdentifier(s) + "."
 					}
 				}
 			} else {
-				sql += "\"" + sortKey + "\" "
+				sql += hana.QuoteIdentifier(sortKey) + " "
 			}
 
 			order, ok := sortMap[sortKey].(int32)
@@ -241,40 +272,45 @@ func createLimitStmt(docMap map[string]any) (sql string, err error) {
 	return
 }
 
-func createResponse(docMap map[string]any, rows *sql.Rows, localCtx *locatCtx) (resp *wire.OpMsg, err error) {
+func (h *storage) createResponse(ctx context.Context, docMap map[string]any, rows *sql.Rows, localCtx *locatCtx) (resp *wire.OpMsg, err error) {
 	resp = &wire.OpMsg{}
 	_, isFindOp := docMap["find"].(string)
-	defer rows.Close()
-	if isFindOp { //nolint:nestif // FIXME: I have no idead to fix this lint
-		var docs types.Array
-		var aDoc *types.Document
-
-		for {
-			aDoc, err = nextRow(rows)
-			if err != nil {
-				return nil, lazyerrors.Error(err)
-			} else if aDoc == nil {
-				break
+	if isFindOp {
+		batchSize := int32(defaultFirstBatchSize)
+		if _, ok := docMap["batchSize"]; ok {
+			if batchSize, err = getBatchSize(docMap); err != nil {
+				rows.Close()
+				return nil, err
 			}
+		}
 
-			if err = docs.Append(*aDoc); err != nil {
-				return nil, lazyerrors.Error(err)
-			}
+		cur := &cursor{
+			ns:        localCtx.db + "." + localCtx.collection,
+			rows:      rows,
+			exclusion: localCtx.exclusion,
 		}
+		cur.projection, _ = docMap["projection"].(types.Document)
+		cur.noCursorTimeout, _ = docMap["noCursorTimeout"].(bool)
 
-		if localCtx.exclusion {
-			err = common.ProjectDocuments(&docs, docMap["projection"].(types.Document))
-			if err != nil {
-				return nil, lazyerrors.Error(err)
-			}
+		if localCtx.postFilter {
+			cur.postFilter = true
+			cur.filter = localCtx.filter
+			cur.limit, _ = docMap["limit"].(int32)
+		}
+
+		singleBatch, _ := docMap["singleBatch"].(bool)
+
+		var docs *types.Array
+		if docs, err = h.openCursor(ctx, cur, batchSize, singleBatch); err != nil {
+			return nil, err
 		}
 
 		err = resp.SetSections(wire.OpMsgSection{
 			Documents: []types.Document{types.MustMakeDocument(
 				"cursor", types.MustMakeDocument(
-					"firstBatch", &docs,
-					"id", int64(0), // TODO
-					"ns", localCtx.db+"."+localCtx.collection,
+					"firstBatch", docs,
+					"id", cur.id,
+					"ns", cur.ns,
 				),
 				"ok", float64(1),
 			)},
@@ -283,11 +319,19 @@ func createResponse(docMap map[string]any, rows *sql.Rows, localCtx *locatCtx) (
 			return nil, lazyerrors.Error(err)
 		}
 	} else {
+		defer rows.Close()
+
 		var count int32
-		for rows.Next() {
-			err = rows.Scan(&count)
-			if err != nil {
-				return nil, lazyerrors.Error(err)
+		if localCtx.postFilter {
+			if count, err = countMatching(rows, localCtx.filter); err != nil {
+				return nil, err
+			}
+		} else {
+			for rows.Next() {
+				err = rows.Scan(&count)
+				if err != nil {
+					return nil, lazyerrors.Error(err)
+				}
 			}
 		}
 
@@ -301,6 +345,31 @@ func createResponse(docMap map[string]any, rows *sql.Rows, localCtx *locatCtx) (
 	return
 }
 
+// getBatchSize returns the batchSize of find and getMore. 0 means no limit.
+func getBatchSize(docMap map[string]any) (batchSize int32, err error) {
+	switch value := docMap["batchSize"].(type) {
+	case int32:
+		batchSize = value
+	case int64:
+		batchSize = int32(value)
+	case float64:
+		if !anyIsInt(value) {
+			err = common.NewErrorMessage(common.ErrBadValue, "batchSize must be an integer")
+			return
+		}
+		batchSize = int32(value)
+	default:
+		err = common.NewErrorMessage(common.ErrBadValue, "batchSize must be a number, not %T", value)
+		return
+	}
+
+	if batchSize < 0 {
+		err = common.NewErrorMessage(common.ErrBadValue, "BatchSize value must be non-negative, but received: %d", batchSize)
+	}
+
+	return
+}
+
 // Checks if command PrintShardingStatus is being used.
 func isPrintShardingStatus(docMap map[string]any) bool {
 	if docMap["find"] == "shards" && docMap["$db"] == "config" {
@@ -323,3 +392,25 @@ func anyIsInt(n any) (ok bool) {
 	}
 	return ok
 }
+
+// countMatching returns the number of documents of rows matching the filter.
+func countMatching(rows *sql.Rows, filter types.Document) (int32, error) {
+	var count int32
+	for {
+		doc, err := nextRow(rows)
+		if err != nil {
+			return 0, err
+		}
+		if doc == nil {
+			return count, nil
+		}
+
+		matches, err := common.FilterDocument(*doc, filter)
+		if err != nil {
+			return 0, err
+		}
+		if matches {
+			count++
+		}
+	}
+}
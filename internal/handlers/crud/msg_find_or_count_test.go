@@ -5,8 +5,10 @@
 package crud
 
 import (
+	"fmt"
 	"testing"
 
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
 	"github.com/stretchr/testify/assert"
@@ -18,7 +20,7 @@ func TestMsgFindOrCound(t *testing.T) {
 	require.NoError(t, err)
 	t.Run("find documents", func(t *testing.T) {
 		docRow := mock.NewRows([]string{"document"}).AddRow([]byte{123, 34, 95, 105, 100, 34, 58, 32, 49, 50, 51, 44, 32, 34, 105, 116, 101, 109, 34, 58, 32, 34, 116, 101, 115, 116, 34, 125})
-		mock.ExpectQuery("SELECT * FROM testDatabase.testCollection").WillReturnRows(docRow)
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION"`).WillReturnRows(docRow)
 
 		deleteReq := types.MustMakeDocument(
 			"find", "testCollection",
@@ -57,9 +59,164 @@ func TestMsgFindOrCound(t *testing.T) {
 		}
 	})
 
+	t.Run("find documents with post-filtered $type", func(t *testing.T) {
+		docRows := mock.NewRows([]string{"document"}).
+			AddRow([]byte(`{"_id": 1, "item": "test", "qty": 5}`)).
+			AddRow([]byte(`{"_id": 2, "item": "test", "qty": "5"}`))
+		// the documents are filtered while the cursor reads them
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" = ? AND 1 = 1`).WithArgs("test").WillReturnRows(docRows)
+
+		findReq := types.MustMakeDocument(
+			"find", "testCollection",
+			"filter", types.MustMakeDocument("item", "test", "qty", types.MustMakeDocument("$type", "int")),
+			"$db", "testDatabase",
+		)
+
+		var reqMsg wire.OpMsg
+		err = reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{findReq},
+		})
+		require.NoError(t, err)
+
+		msg, err := storage.MsgFindOrCount(ctx, &reqMsg)
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"cursor", types.MustMakeDocument(
+				"firstBatch", types.MustNewArray(
+					types.MustMakeDocument("_id", int32(1), "item", "test", "qty", int32(5)),
+				),
+				"id", int64(0),
+				"ns", "testDatabase.testCollection",
+			),
+			"ok", float64(1),
+		)
+
+		actual, _ := msg.Document()
+		assert.Equal(t, expected, actual)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("find documents with post-filtered $type, limit and projection", func(t *testing.T) {
+		// the limit and the projection are applied to the filtered documents
+		docRows := mock.NewRows([]string{"document"}).
+			AddRow([]byte(`{"_id": 1, "item": "a", "qty": "5"}`)).
+			AddRow([]byte(`{"_id": 2, "item": "b", "qty": 5}`)).
+			AddRow([]byte(`{"_id": 3, "item": "c", "qty": 6}`))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE 1 = 1`).WillReturnRows(docRows)
+
+		findReq := types.MustMakeDocument(
+			"find", "testCollection",
+			"filter", types.MustMakeDocument("qty", types.MustMakeDocument("$type", "int")),
+			"projection", types.MustMakeDocument("item", int32(1)),
+			"limit", int32(1),
+			"$db", "testDatabase",
+		)
+
+		var reqMsg wire.OpMsg
+		err = reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{findReq},
+		})
+		require.NoError(t, err)
+
+		msg, err := storage.MsgFindOrCount(ctx, &reqMsg)
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"cursor", types.MustMakeDocument(
+				"firstBatch", types.MustNewArray(
+					types.MustMakeDocument("_id", int32(2), "item", "b"),
+				),
+				"id", int64(0),
+				"ns", "testDatabase.testCollection",
+			),
+			"ok", float64(1),
+		)
+
+		actual, _ := msg.Document()
+		assert.Equal(t, expected, actual)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("count with post-filtered $expr", func(t *testing.T) {
+		docRows := mock.NewRows([]string{"document"}).
+			AddRow([]byte(`{"_id": 1, "spent": 10, "budget": 5}`)).
+			AddRow([]byte(`{"_id": 2, "spent": 5, "budget": 10}`))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE 1 = 1`).WillReturnRows(docRows)
+
+		countReq := types.MustMakeDocument(
+			"count", "testCollection",
+			"query", types.MustMakeDocument(
+				"$expr", types.MustMakeDocument("$gt", types.MustNewArray("$spent", "$budget")),
+			),
+			"$db", "testDatabase",
+		)
+
+		var reqMsg wire.OpMsg
+		err = reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{countReq},
+		})
+		require.NoError(t, err)
+
+		msg, err := storage.MsgFindOrCount(ctx, &reqMsg)
+		require.NoError(t, err)
+
+		actual, _ := msg.Document()
+		assert.Equal(t, types.MustMakeDocument("n", int32(1), "ok", float64(1)), actual)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("find documents with case-insensitive $regex", func(t *testing.T) {
+		docRow := mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": 1, "item": "Test"}`))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE ("item" LIKE_REGEXPR ? FLAG 'i' OR FOR ANY "element" IN "item" SATISFIES "element" LIKE_REGEXPR ? FLAG 'i' END)`).
+			WithArgs("^te", "^te").WillReturnRows(docRow)
+
+		findReq := types.MustMakeDocument(
+			"find", "testCollection",
+			"filter", types.MustMakeDocument("item", types.MustMakeDocument("$regex", "^te", "$options", "i")),
+			"$db", "testDatabase",
+		)
+
+		var reqMsg wire.OpMsg
+		err = reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{findReq},
+		})
+		require.NoError(t, err)
+
+		msg, err := storage.MsgFindOrCount(ctx, &reqMsg)
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"cursor", types.MustMakeDocument(
+				"firstBatch", types.MustNewArray(
+					types.MustMakeDocument("_id", int32(1), "item", "Test"),
+				),
+				"id", int64(0),
+				"ns", "testDatabase.testCollection",
+			),
+			"ok", float64(1),
+		)
+
+		actual, _ := msg.Document()
+		assert.Equal(t, expected, actual)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
 	t.Run("count", func(t *testing.T) {
 		countRow := mock.NewRows([]string{"count"}).AddRow(3)
-		mock.ExpectQuery("SELECT COUNT(*) FROM testDatabase.testCollection").WillReturnRows(countRow)
+		mock.ExpectQuery(`SELECT COUNT(*) FROM "TESTDATABASE"."TESTCOLLECTION"`).WillReturnRows(countRow)
 
 		deleteReq := types.MustMakeDocument(
 			"count", "testCollection",
@@ -91,7 +248,7 @@ func TestMsgFindOrCound(t *testing.T) {
 
 	t.Run("find documents with where, order by, limit, and projection", func(t *testing.T) {
 		idRow := mock.NewRows([]string{"document"}).AddRow([]byte{123, 34, 95, 105, 100, 34, 58, 32, 49, 50, 51, 125})
-		mock.ExpectQuery("SELECT {\"_id\": \"_id\"} FROM testDatabase.testCollection WHERE \"item\" = 'test' ORDER BY  \"phone\".\"number\" ASC LIMIT 1").WillReturnRows(idRow)
+		mock.ExpectQuery(`SELECT {"_id": "_id"} FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" = ? ORDER BY  "phone"."number" ASC LIMIT 1`).WithArgs("test").WillReturnRows(idRow)
 
 		deleteReq := types.MustMakeDocument(
 			"find", "testCollection",
@@ -137,4 +294,133 @@ func TestMsgFindOrCound(t *testing.T) {
 			t.Errorf("there were unfulfilled expectations: %s", err)
 		}
 	})
+
+	t.Run("find with batchSize, getMore and killCursors", func(t *testing.T) {
+		docRows := mock.NewRows([]string{"document"}).
+			AddRow([]byte(`{"_id": 1}`)).
+			AddRow([]byte(`{"_id": 2}`)).
+			AddRow([]byte(`{"_id": 3}`))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION"`).WillReturnRows(docRows)
+
+		findReq := types.MustMakeDocument(
+			"find", "testCollection",
+			"filter", types.MustMakeDocument(),
+			"batchSize", int32(1),
+			"$db", "testDatabase",
+		)
+
+		var reqMsg wire.OpMsg
+		err = reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{findReq},
+		})
+		require.NoError(t, err)
+
+		msg, err := storage.MsgFindOrCount(ctx, &reqMsg)
+		require.NoError(t, err)
+
+		actual, err := msg.Document()
+		require.NoError(t, err)
+
+		cursor, err := actual.Get("cursor")
+		require.NoError(t, err)
+		id := cursor.(types.Document).Map()["id"].(int64)
+		assert.NotEqual(t, int64(0), id)
+		assert.Equal(t, types.MustNewArray(types.MustMakeDocument("_id", int32(1))), cursor.(types.Document).Map()["firstBatch"])
+
+		getMoreReq := types.MustMakeDocument(
+			"getMore", id,
+			"collection", "testCollection",
+			"batchSize", int32(1),
+			"$db", "testDatabase",
+		)
+
+		err = reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{getMoreReq},
+		})
+		require.NoError(t, err)
+
+		msg, err = storage.MsgGetMore(ctx, &reqMsg)
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"cursor", types.MustMakeDocument(
+				"nextBatch", types.MustNewArray(
+					types.MustMakeDocument("_id", int32(2)),
+				),
+				"id", id,
+				"ns", "testDatabase.testCollection",
+			),
+			"ok", float64(1),
+		)
+
+		actual, _ = msg.Document()
+		assert.Equal(t, expected, actual)
+
+		otherCtx := common.WithOwner(ctx, "other@admin")
+		_, err = storage.MsgGetMore(otherCtx, &reqMsg)
+		assert.EqualError(t, err, fmt.Sprintf("Unauthorized (13): cursor id %d was not created by the authenticated user", id))
+
+		killReq := types.MustMakeDocument(
+			"killCursors", "testCollection",
+			"cursors", types.MustNewArray(id),
+			"$db", "testDatabase",
+		)
+
+		err = reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{killReq},
+		})
+		require.NoError(t, err)
+
+		_, err = storage.MsgKillCursors(otherCtx, &reqMsg)
+		assert.EqualError(t, err, fmt.Sprintf("Unauthorized (13): not authorized to kill cursor id %d", id))
+
+		err = reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{types.MustMakeDocument(
+				"killCursors", int32(1),
+				"cursors", types.MustNewArray(id),
+				"$db", "testDatabase",
+			)},
+		})
+		require.NoError(t, err)
+
+		_, err = storage.MsgKillCursors(ctx, &reqMsg)
+		assert.EqualError(t, err, "BadValue (2): killCursors must be of type string, not int32")
+
+		killReq = types.MustMakeDocument(
+			"killCursors", "testCollection",
+			"cursors", types.MustNewArray(id, int64(42)),
+			"$db", "testDatabase",
+		)
+
+		err = reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{killReq},
+		})
+		require.NoError(t, err)
+
+		msg, err = storage.MsgKillCursors(ctx, &reqMsg)
+		require.NoError(t, err)
+
+		expected = types.MustMakeDocument(
+			"cursorsKilled", types.MustNewArray(id),
+			"cursorsNotFound", types.MustNewArray(int64(42)),
+			"cursorsAlive", new(types.Array),
+			"cursorsUnknown", new(types.Array),
+			"ok", float64(1),
+		)
+
+		actual, _ = msg.Document()
+		assert.Equal(t, expected, actual)
+
+		err = reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{getMoreReq},
+		})
+		require.NoError(t, err)
+
+		_, err = storage.MsgGetMore(ctx, &reqMsg)
+		assert.EqualError(t, err, fmt.Sprintf("CursorNotFound (43): cursor id %d not found", id))
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
 }
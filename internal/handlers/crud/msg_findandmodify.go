@@ -0,0 +1,496 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/bson"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+)
+
+// querier is implemented by *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// findAndModifyParams holds the parameters of the findAndModify command.
+type findAndModifyParams struct {
+	db, collection string
+	query          types.Document
+	sort           types.Document
+	fields         types.Document
+	update         types.Document
+	arrayFilters   *types.Array
+	hasUpdate      bool
+	remove         bool
+	returnNew      bool
+	upsert         bool
+}
+
+// MsgFindAndModify modifies or removes the first document matching the query and returns it.
+// Selecting and modifying the document runs in one transaction.
+func (h *storage) MsgFindAndModify(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	return h.retryableWrite(ctx, msg, h.msgFindAndModify)
+}
+
+// msgFindAndModify runs the findAndModify command, within the transaction of a retryable write if it is one.
+func (h *storage) msgFindAndModify(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	unimplementedFields := []string{
+		"bypassDocumentValidation",
+		"writeConcern",
+		"collation",
+		"hint",
+		"comment",
+		"let",
+	}
+
+	document, err := msg.Document()
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+	if err := common.Unimplemented(&document, unimplementedFields...); err != nil {
+		return nil, err
+	}
+
+	common.Ignored(&document, h.l, "maxTimeMS")
+
+	ctx, done, err := h.startCommand(ctx, document)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	params, err := getFindAndModifyParams(document)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := h.beginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	value, lastErrorObject, err := findAndModify(ctx, tx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	if value != nil && len(params.fields.Keys()) != 0 {
+		var projected types.Document
+		if projected, err = common.ApplyProjection(*value, params.fields); err != nil {
+			return nil, err
+		}
+		value = &projected
+	}
+
+	var valueAny any
+	if value != nil {
+		valueAny = *value
+	}
+
+	var reply wire.OpMsg
+	err = reply.SetSections(wire.OpMsgSection{
+		Documents: []types.Document{types.MustMakeDocument(
+			"lastErrorObject", lastErrorObject,
+			"value", valueAny,
+			"ok", float64(1),
+		)},
+	})
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	return &reply, nil
+}
+
+// getFindAndModifyParams validates the findAndModify command.
+func getFindAndModifyParams(document types.Document) (params findAndModifyParams, err error) {
+	m := document.Map()
+
+	// the command name is case insensitive, older drivers send findandmodify
+	command := document.Keys()[0]
+
+	var ok bool
+	if params.collection, ok = m[command].(string); !ok {
+		err = common.NewErrorMessage(common.ErrBadValue, "collection name has invalid type %T", m[command])
+		return
+	}
+	params.db = m["$db"].(string)
+
+	for _, field := range []string{"query", "sort", "fields"} {
+		if value, exists := m[field]; exists && value != nil {
+			if _, ok := value.(types.Document); !ok {
+				err = common.NewErrorMessage(common.ErrBadValue, "'%s' field must be of BSON type object", field)
+				return
+			}
+		}
+	}
+
+	params.query, _ = m["query"].(types.Document)
+	params.sort, _ = m["sort"].(types.Document)
+	params.fields, _ = m["fields"].(types.Document)
+	params.remove, _ = m["remove"].(bool)
+	params.returnNew, _ = m["new"].(bool)
+	params.upsert, _ = m["upsert"].(bool)
+
+	if value, exists := m["arrayFilters"]; exists {
+		if params.arrayFilters, ok = value.(*types.Array); !ok {
+			err = common.NewErrorMessage(common.ErrBadValue, "arrayFilters must be an array")
+			return
+		}
+	}
+
+	if update, exists := m["update"]; exists && update != nil {
+		if params.update, ok = update.(types.Document); !ok {
+			err = common.NewErrorMessage(common.ErrNotImplemented, "update with type %T is not implemented yet", update)
+			return
+		}
+		params.hasUpdate = true
+	}
+
+	switch {
+	case params.remove && params.hasUpdate:
+		err = common.NewErrorMessage(common.ErrBadValue, "Cannot specify both an update and remove=true")
+	case !params.remove && !params.hasUpdate:
+		err = common.NewErrorMessage(common.ErrBadValue, "Either an update or remove=true must be specified")
+	case params.remove && params.returnNew:
+		err = common.NewErrorMessage(common.ErrBadValue, "Cannot specify both new=true and remove=true; 'remove' always returns the deleted document")
+	case params.remove && params.upsert:
+		err = common.NewErrorMessage(common.ErrBadValue, "Cannot specify both upsert=true and remove=true")
+	}
+
+	return
+}
+
+// findAndModify selects the first document matching the query and modifies or removes it.
+// It returns the document before or after the modification and the lastErrorObject.
+func findAndModify(ctx context.Context, q querier, params findAndModifyParams) (value *types.Document, lastErrorObject types.Document, err error) {
+	table, err := hana.Table(params.db, params.collection)
+	if err != nil {
+		return
+	}
+
+	whereSQL, whereArgs, exact, err := common.CreatePrefilterWhereClause(params.query)
+	if err != nil {
+		return
+	}
+
+	orderBySQL, err := createOrderByStmt(map[string]any{"sort": params.sort})
+	if err != nil {
+		return
+	}
+
+	// with an equality condition on _id, the unique index on _id keeps concurrent upserts
+	// from inserting the same document twice, otherwise the table lock does
+	idEquality := common.HasIDEquality(params.query)
+	if params.upsert && !idEquality {
+		if err = lockTable(ctx, q, table); err != nil {
+			return
+		}
+	}
+
+	// concurrent findAndModify commands, like workers taking jobs from a queue, must not select the same document
+	selectSQL := fmt.Sprintf("SELECT * FROM %s", table) + whereSQL + orderBySQL
+
+	var docs []types.Document
+	for retried := false; ; retried = true {
+		if docs, err = lockMatching(ctx, q, table, selectSQL, whereArgs, params.query, false, exact); err != nil || len(docs) != 0 {
+			break
+		}
+
+		if !params.upsert {
+			lastErrorObject = types.MustMakeDocument("n", int32(0))
+			if params.hasUpdate {
+				lastErrorObject = types.MustMakeDocument("n", int32(0), "updatedExisting", false)
+			}
+			return
+		}
+
+		var id any
+		value, id, err = upsertDocument(ctx, q, params.db, params.collection, params.query, params.update, params.arrayFilters)
+
+		// a concurrent upsert inserted the document after it was not found, so it is selected again
+		var conflict *upsertConflictError
+		if errors.As(err, &conflict) {
+			if idEquality && !retried {
+				continue
+			}
+			err = conflict.err
+		}
+		if err != nil {
+			return
+		}
+
+		lastErrorObject = types.MustMakeDocument("n", int32(1), "updatedExisting", false, "upserted", id)
+		if !params.returnNew {
+			value = nil
+		}
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	value = &docs[0]
+	id := value.Map()["_id"]
+
+	if params.remove {
+		if err = deleteDocument(ctx, q, params.db, params.collection, id); err != nil {
+			return
+		}
+
+		lastErrorObject = types.MustMakeDocument("n", int32(1))
+		return
+	}
+
+	updateParams := &common.UpdateParams{Filter: params.query, ArrayFilters: params.arrayFilters}
+	newValue, err := modifyDocument(ctx, q, params.db, params.collection, *value, params.update, updateParams)
+	if err != nil {
+		return
+	}
+
+	lastErrorObject = types.MustMakeDocument("n", int32(1), "updatedExisting", true)
+	if params.returnNew {
+		value = newValue
+	}
+
+	return
+}
+
+// selectOne returns the first document of the query or nil if there is none.
+func selectOne(ctx context.Context, q querier, sql string, args ...any) (*types.Document, error) {
+	rows, err := q.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+	defer rows.Close()
+
+	return nextRow(rows)
+}
+
+// maxWriteConflictRetries is how often a document is selected again if it was changed by a concurrent write
+// after it was selected and before it was locked.
+const maxWriteConflictRetries = 10
+
+// errWriteConflict is returned if a document could not be locked in its selected version.
+var errWriteConflict = common.NewErrorMessage(
+	common.ErrWriteConflict,
+	"WriteConflict error: this operation conflicted with another operation. Please retry your operation or multi-document transaction.",
+)
+
+// lockDocument locks the document with the given _id for the rest of the transaction and returns it
+// if it still matches the filter, or nil if a concurrent write changed or removed it in the meantime.
+// The document is read again after it is locked, so that it is not modified based on an outdated version.
+func lockDocument(ctx context.Context, q querier, table string, id any, filter types.Document) (*types.Document, error) {
+	lockFilter := types.MustMakeDocument("$and", types.MustNewArray(types.MustMakeDocument("_id", id), filter))
+	whereSQL, whereArgs, exact, err := common.CreatePrefilterWhereClause(lockFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := selectOne(ctx, q, fmt.Sprintf("SELECT * FROM %s", table)+whereSQL+" FOR UPDATE", whereArgs...)
+	if err != nil || doc == nil || exact {
+		return doc, err
+	}
+
+	matches, err := common.FilterDocument(*doc, filter)
+	if err != nil || !matches {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// lockTable locks the table exclusively for the rest of the transaction. Upserts without an equality condition
+// on _id lock it, so that concurrent upserts with the same query do not insert the document twice.
+func lockTable(ctx context.Context, q querier, table string) error {
+	if _, err := q.ExecContext(ctx, fmt.Sprintf("LOCK TABLE %s IN EXCLUSIVE MODE", table)); err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	return nil
+}
+
+// idWhereClause returns the WHERE clause selecting the document with the given _id.
+func idWhereClause(id any) (string, []any, error) {
+	return common.CreateWhereClause(types.MustMakeDocument("_id", id))
+}
+
+// insertDocument inserts the document.
+func insertDocument(ctx context.Context, q querier, db, collection string, doc types.Document) error {
+	b, err := bson.MustConvertDocument(doc).MarshalJSONHANA()
+	if err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	table, err := hana.Table(db, collection)
+	if err != nil {
+		return err
+	}
+
+	sql := fmt.Sprintf("INSERT INTO %s VALUES (?)", table)
+	if _, err = q.ExecContext(ctx, sql, b); err != nil {
+		return common.CheckDuplicateKey(lazyerrors.Error(err), db, collection, doc)
+	}
+
+	return nil
+}
+
+// deleteDocument deletes the document with the given _id, which is locked by the transaction.
+func deleteDocument(ctx context.Context, q querier, db, collection string, id any) error {
+	table, err := hana.Table(db, collection)
+	if err != nil {
+		return err
+	}
+
+	whereSQL, whereArgs, err := idWhereClause(id)
+	if err != nil {
+		return err
+	}
+
+	sql := fmt.Sprintf("DELETE FROM %s", table) + whereSQL
+	res, err := q.ExecContext(ctx, sql, whereArgs...)
+	if err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	return checkWritten(res)
+}
+
+// checkWritten returns errWriteConflict if the statement writing a locked document did not affect it.
+func checkWritten(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	if n == 0 {
+		return errWriteConflict
+	}
+
+	return nil
+}
+
+// replaceDocument replaces the document with the given _id, which is locked by the transaction.
+func replaceDocument(ctx context.Context, q querier, db, collection string, id any, doc types.Document) error {
+	if err := deleteDocument(ctx, q, db, collection, id); err != nil {
+		return err
+	}
+
+	return insertDocument(ctx, q, db, collection, doc)
+}
+
+// modifyDocument applies the update operators or the replacement to the given document
+// and returns the updated document.
+func modifyDocument(
+	ctx context.Context, q querier, db, collection string, current, updateDoc types.Document, params *common.UpdateParams,
+) (*types.Document, error) {
+	id := current.Map()["_id"]
+
+	replacement, err := common.IsReplacement(updateDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	if replacement || isReadModifyWrite(updateDoc) || params.ArrayFilters != nil {
+		doc, changed, err := applyUpdateDocument(current, updateDoc, params)
+		if err != nil {
+			return nil, err
+		}
+
+		if changed {
+			if err = replaceDocument(ctx, q, db, collection, id, doc); err != nil {
+				return nil, err
+			}
+		}
+
+		return &doc, nil
+	}
+
+	table, err := hana.Table(db, collection)
+	if err != nil {
+		return nil, err
+	}
+
+	updateSQL, updateArgs, _, _, err := update(updateDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	whereSQL, whereArgs, err := idWhereClause(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// only $setOnInsert does not change existing documents
+	if updateSQL != "" {
+		sql := fmt.Sprintf("UPDATE %s", table) + updateSQL + whereSQL
+		res, err := q.ExecContext(ctx, sql, append(updateArgs, whereArgs...)...)
+		if err != nil {
+			return nil, common.CheckDuplicateKey(lazyerrors.Error(err), db, collection, current)
+		}
+
+		if err = checkWritten(res); err != nil {
+			return nil, err
+		}
+	}
+
+	return selectOne(ctx, q, fmt.Sprintf("SELECT * FROM %s", table)+whereSQL, whereArgs...)
+}
+
+// upsertDocument inserts a new document created from the equality conditions of the query and the update.
+// It returns the inserted document and its _id.
+func upsertDocument(
+	ctx context.Context, q querier, db, collection string, query, updateDoc types.Document, arrayFilters *types.Array,
+) (*types.Document, any, error) {
+	doc, err := newUpsertDocument(query, updateDoc, arrayFilters)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = insertDocument(ctx, q, db, collection, doc); err != nil {
+		var protoErr *common.Error
+		if errors.As(err, &protoErr) && protoErr.Code() == common.ErrDuplicateKey {
+			return nil, nil, &upsertConflictError{err: err}
+		}
+
+		return nil, nil, err
+	}
+
+	return &doc, doc.Map()["_id"], nil
+}
+
+// upsertConflictError is returned by upsertDocument if the inserted document violates a unique index.
+// If the query has an equality condition on _id, a concurrent upsert may have inserted the document
+// after it was not found, so that it matches now.
+type upsertConflictError struct {
+	err error // duplicate key error
+}
+
+// Error implements error interface.
+func (e *upsertConflictError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap implements standard error unwrapping interface.
+func (e *upsertConflictError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,336 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMsgFindAndModify(t *testing.T) {
+	ctx, storage, mock, err := setupTestUtil(t)
+	require.NoError(t, err)
+
+	findAndModify := func(t *testing.T, pairs ...any) (types.Document, error) {
+		t.Helper()
+
+		pairs = append([]any{"findAndModify", "testCollection"}, pairs...)
+		pairs = append(pairs, "$db", "testDatabase")
+
+		var reqMsg wire.OpMsg
+		err := reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{types.MustMakeDocument(pairs...)},
+		})
+		require.NoError(t, err)
+
+		msg, err := storage.MsgFindAndModify(ctx, &reqMsg)
+		if err != nil {
+			return types.Document{}, err
+		}
+
+		return msg.Document()
+	}
+
+	t.Run("update and return new document", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" = ? ORDER BY "qty"  DESC LIMIT 1 FOR UPDATE`).
+			WithArgs("test").
+			WillReturnRows(mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": 1, "item": "test", "qty": 5}`)))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ? AND "item" = ? FOR UPDATE`).
+			WithArgs(1, "test").
+			WillReturnRows(mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": 1, "item": "test", "qty": 5}`)))
+		mock.ExpectExec(`UPDATE "TESTDATABASE"."TESTCOLLECTION" SET "qty" = ? WHERE "_id" = ?`).
+			WithArgs(6, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ?`).
+			WithArgs(1).
+			WillReturnRows(mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": 1, "item": "test", "qty": 6}`)))
+		mock.ExpectCommit()
+
+		actual, err := findAndModify(t,
+			"query", types.MustMakeDocument("item", "test"),
+			"sort", types.MustMakeDocument("qty", int32(-1)),
+			"update", types.MustMakeDocument("$set", types.MustMakeDocument("qty", int32(6))),
+			"new", true,
+			"fields", types.MustMakeDocument("item", int32(0)),
+		)
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"lastErrorObject", types.MustMakeDocument("n", int32(1), "updatedExisting", true),
+			"value", types.MustMakeDocument("_id", int32(1), "qty", int32(6)),
+			"ok", float64(1),
+		)
+		assert.Equal(t, expected, actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" = ? LIMIT 1 FOR UPDATE`).
+			WithArgs("test").
+			WillReturnRows(mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": 1, "item": "test"}`)))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ? AND "item" = ? FOR UPDATE`).
+			WithArgs(1, "test").
+			WillReturnRows(mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": 1, "item": "test"}`)))
+		mock.ExpectExec(`DELETE FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ?`).
+			WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		actual, err := findAndModify(t,
+			"query", types.MustMakeDocument("item", "test"),
+			"remove", true,
+		)
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"lastErrorObject", types.MustMakeDocument("n", int32(1)),
+			"value", types.MustMakeDocument("_id", int32(1), "item", "test"),
+			"ok", float64(1),
+		)
+		assert.Equal(t, expected, actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("replace and return old document", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" = ? LIMIT 1 FOR UPDATE`).
+			WithArgs("test").
+			WillReturnRows(mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": 1, "item": "test"}`)))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ? AND "item" = ? FOR UPDATE`).
+			WithArgs(1, "test").
+			WillReturnRows(mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": 1, "item": "test"}`)))
+		mock.ExpectExec(`DELETE FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ?`).
+			WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).
+			WithArgs([]byte(`{"_id":1,"item":"replaced"}`)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		actual, err := findAndModify(t,
+			"query", types.MustMakeDocument("item", "test"),
+			"update", types.MustMakeDocument("item", "replaced"),
+		)
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"lastErrorObject", types.MustMakeDocument("n", int32(1), "updatedExisting", true),
+			"value", types.MustMakeDocument("_id", int32(1), "item", "test"),
+			"ok", float64(1),
+		)
+		assert.Equal(t, expected, actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("upsert", func(t *testing.T) {
+		// the unique index on _id keeps concurrent upserts from inserting the document twice
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ? LIMIT 1 FOR UPDATE`).
+			WithArgs("counter").
+			WillReturnRows(mock.NewRows([]string{"document"}))
+		mock.ExpectExec(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).
+			WithArgs([]byte(`{"_id":"counter","seq":1}`)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		actual, err := findAndModify(t,
+			"query", types.MustMakeDocument("_id", "counter"),
+			"update", types.MustMakeDocument("$set", types.MustMakeDocument("seq", int32(1))),
+			"upsert", true,
+			"new", true,
+		)
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"lastErrorObject", types.MustMakeDocument("n", int32(1), "updatedExisting", false, "upserted", "counter"),
+			"value", types.MustMakeDocument("_id", "counter", "seq", int32(1)),
+			"ok", float64(1),
+		)
+		assert.Equal(t, expected, actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("upsert inserted by a concurrent command", func(t *testing.T) {
+		// the document inserted after it was not found is selected again
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ? LIMIT 1 FOR UPDATE`).
+			WithArgs("counter").
+			WillReturnRows(mock.NewRows([]string{"document"}))
+		mock.ExpectExec(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).
+			WithArgs([]byte(`{"_id":"counter","seq":2}`)).
+			WillReturnError(errors.New("SQL Error 301 - unique constraint violated: Table(TESTCOLLECTION), Index(TESTCOLLECTION._id_)"))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ? LIMIT 1 FOR UPDATE`).
+			WithArgs("counter").
+			WillReturnRows(mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": "counter", "seq": 1}`)))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ? AND "_id" = ? FOR UPDATE`).
+			WithArgs("counter", "counter").
+			WillReturnRows(mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": "counter", "seq": 1}`)))
+		mock.ExpectExec(`UPDATE "TESTDATABASE"."TESTCOLLECTION" SET "seq" = ? WHERE "_id" = ?`).
+			WithArgs(2, "counter").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ?`).
+			WithArgs("counter").
+			WillReturnRows(mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": "counter", "seq": 2}`)))
+		mock.ExpectCommit()
+
+		actual, err := findAndModify(t,
+			"query", types.MustMakeDocument("_id", "counter"),
+			"update", types.MustMakeDocument("$set", types.MustMakeDocument("seq", int32(2))),
+			"upsert", true,
+			"new", true,
+		)
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"lastErrorObject", types.MustMakeDocument("n", int32(1), "updatedExisting", true),
+			"value", types.MustMakeDocument("_id", "counter", "seq", int32(2)),
+			"ok", float64(1),
+		)
+		assert.Equal(t, expected, actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("document taken by a concurrent command", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "state" = ? LIMIT 1 FOR UPDATE`).
+			WithArgs("queued").
+			WillReturnRows(mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": 1, "state": "queued"}`)))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ? AND "state" = ? FOR UPDATE`).
+			WithArgs(1, "queued").
+			WillReturnRows(mock.NewRows([]string{"document"}))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "state" = ? LIMIT 1 FOR UPDATE`).
+			WithArgs("queued").
+			WillReturnRows(mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": 2, "state": "queued"}`)))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ? AND "state" = ? FOR UPDATE`).
+			WithArgs(2, "queued").
+			WillReturnRows(mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": 2, "state": "queued"}`)))
+		mock.ExpectExec(`UPDATE "TESTDATABASE"."TESTCOLLECTION" SET "state" = ? WHERE "_id" = ?`).
+			WithArgs("running", 2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ?`).
+			WithArgs(2).
+			WillReturnRows(mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": 2, "state": "running"}`)))
+		mock.ExpectCommit()
+
+		actual, err := findAndModify(t,
+			"query", types.MustMakeDocument("state", "queued"),
+			"update", types.MustMakeDocument("$set", types.MustMakeDocument("state", "running")),
+			"new", true,
+		)
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"lastErrorObject", types.MustMakeDocument("n", int32(1), "updatedExisting", true),
+			"value", types.MustMakeDocument("_id", int32(2), "state", "running"),
+			"ok", float64(1),
+		)
+		assert.Equal(t, expected, actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("post-filtered document taken by a concurrent command", func(t *testing.T) {
+		// the candidates are not locked, only the first matching one is
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE 1 = 1`).
+			WillReturnRows(mock.NewRows([]string{"document"}).
+				AddRow([]byte(`{"_id": 1, "spent": 5, "budget": 10}`)).
+				AddRow([]byte(`{"_id": 2, "spent": 10, "budget": 5}`)).
+				AddRow([]byte(`{"_id": 3, "spent": 20, "budget": 5}`)))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ? AND 1 = 1 FOR UPDATE`).
+			WithArgs(2).
+			WillReturnRows(mock.NewRows([]string{"document"}))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE 1 = 1`).
+			WillReturnRows(mock.NewRows([]string{"document"}).
+				AddRow([]byte(`{"_id": 1, "spent": 5, "budget": 10}`)).
+				AddRow([]byte(`{"_id": 3, "spent": 20, "budget": 5}`)))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ? AND 1 = 1 FOR UPDATE`).
+			WithArgs(3).
+			WillReturnRows(mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": 3, "spent": 20, "budget": 5}`)))
+		mock.ExpectExec(`DELETE FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ?`).
+			WithArgs(3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		actual, err := findAndModify(t,
+			"query", types.MustMakeDocument("$expr", types.MustMakeDocument("$gt", types.MustNewArray("$spent", "$budget"))),
+			"remove", true,
+		)
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"lastErrorObject", types.MustMakeDocument("n", int32(1)),
+			"value", types.MustMakeDocument("_id", int32(3), "spent", int32(20), "budget", int32(5)),
+			"ok", float64(1),
+		)
+		assert.Equal(t, expected, actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("no document found", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" = ? LIMIT 1 FOR UPDATE`).
+			WithArgs("none").
+			WillReturnRows(mock.NewRows([]string{"document"}))
+		mock.ExpectCommit()
+
+		actual, err := findAndModify(t,
+			"query", types.MustMakeDocument("item", "none"),
+			"update", types.MustMakeDocument("$set", types.MustMakeDocument("qty", int32(1))),
+		)
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"lastErrorObject", types.MustMakeDocument("n", int32(0), "updatedExisting", false),
+			"value", nil,
+			"ok", float64(1),
+		)
+		assert.Equal(t, expected, actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("update error rolls back", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" LIMIT 1 FOR UPDATE`).
+			WillReturnRows(mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": 1, "item": "test"}`)))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ? AND 1 = 1 FOR UPDATE`).
+			WithArgs(1).
+			WillReturnRows(mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": 1, "item": "test"}`)))
+		mock.ExpectRollback()
+
+		_, err := findAndModify(t,
+			"update", types.MustMakeDocument("_id", int32(2)),
+		)
+		assert.EqualError(t, err, "ImmutableField (66): After applying the update, the (immutable) field '_id' was found to have been altered to _id: 2")
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		_, err := findAndModify(t, "query", types.MustMakeDocument())
+		assert.EqualError(t, err, "BadValue (2): Either an update or remove=true must be specified")
+
+		_, err = findAndModify(t, "remove", true, "new", true)
+		assert.EqualError(t, err, "BadValue (2): Cannot specify both new=true and remove=true; 'remove' always returns the deleted document")
+
+		_, err = findAndModify(t, "remove", true, "update", types.MustMakeDocument())
+		assert.EqualError(t, err, "BadValue (2): Cannot specify both an update and remove=true")
+	})
+}
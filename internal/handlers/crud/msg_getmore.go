@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"context"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+)
+
+// MsgGetMore returns the next batch of documents of a cursor opened by find.
+// A cursor opened in a transaction is read within the transaction, which is checked out like for other commands.
+func (h *storage) MsgGetMore(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	document, err := msg.Document()
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	if err := common.Unimplemented(&document, "comment"); err != nil {
+		return nil, err
+	}
+
+	common.Ignored(&document, h.l, "maxTimeMS")
+
+	m := document.Map()
+
+	id, ok := m["getMore"].(int64)
+	if !ok {
+		return nil, common.NewErrorMessage(common.ErrBadValue, "getMore must be of type long, not %T", m["getMore"])
+	}
+
+	collection, ok := m["collection"].(string)
+	if !ok {
+		return nil, common.NewErrorMessage(common.ErrBadValue, "collection must be of type string, not %T", m["collection"])
+	}
+
+	db := m["$db"].(string)
+
+	var batchSize int32
+	if _, ok := m["batchSize"]; ok {
+		if batchSize, err = getBatchSize(m); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, done, err := h.startCommand(ctx, document)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	txn, _ := ctx.Value(txKey{}).(*transaction)
+
+	cur, err := h.cursors.checkout(id, db+"."+collection, common.Owner(ctx), txn)
+	if err != nil {
+		return nil, err
+	}
+
+	docs, exhausted, err := cur.nextBatch(batchSize)
+	if err != nil {
+		h.cursors.checkin(cur, true)
+		return nil, lazyerrors.Error(err)
+	}
+
+	h.cursors.checkin(cur, exhausted)
+
+	if exhausted {
+		id = 0
+	}
+
+	var reply wire.OpMsg
+	err = reply.SetSections(wire.OpMsgSection{
+		Documents: []types.Document{types.MustMakeDocument(
+			"cursor", types.MustMakeDocument(
+				"nextBatch", docs,
+				"id", id,
+				"ns", cur.ns,
+			),
+			"ok", float64(1),
+		)},
+	})
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	return &reply, nil
+}
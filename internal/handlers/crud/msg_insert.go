@@ -25,6 +25,7 @@ import (
 	"fmt"
 
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/bson"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
@@ -33,6 +34,11 @@ import (
 
 // MsgInsert inserts a document or documents into a collection.
 func (h *storage) MsgInsert(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	return h.retryableWrite(ctx, msg, h.msgInsert)
+}
+
+// msgInsert runs the insert command, within the transaction of a retryable write if it is one.
+func (h *storage) msgInsert(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
 	document, err := msg.Document()
 	if err != nil {
 		return nil, lazyerrors.Error(err)
@@ -43,7 +49,16 @@ func (h *storage) MsgInsert(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg,
 		return nil, err
 	}
 
-	common.Ignored(&document, h.l, "ordered")
+	ordered, err := common.GetOrdered(document)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, done, err := h.startCommand(ctx, document)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 
 	m := document.Map()
 
@@ -53,43 +68,56 @@ func (h *storage) MsgInsert(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg,
 	docs, _ := m["documents"].(*types.Array)
 
 	var inserted int32
-	for i := 0; i < docs.Len(); i++ {
-		doc, err := docs.Get(i)
-		if err != nil {
-			return nil, lazyerrors.Error(err)
+	var writeErrors common.WriteErrors
+	for start := 0; start < docs.Len(); start += h.insertBatchSize {
+		end := start + h.insertBatchSize
+		if end > docs.Len() {
+			end = docs.Len()
 		}
 
-		d := doc.(types.Document)
+		batch := make([]types.Document, 0, end-start)
+		for i := start; i < end; i++ {
+			doc, err := docs.Get(i)
+			if err != nil {
+				return nil, lazyerrors.Error(err)
+			}
 
-		var unique bool
-		var errMsg error
-		if unique, errMsg, err = common.IsIdUnique(d.Map()["_id"], db, collection, ctx, h.hanaPool); err != nil {
-			return nil, err
+			// like MongoDB, a missing _id is generated by the server
+			batch = append(batch, common.WithObjectID(doc.(types.Document)))
 		}
-		if !unique {
-			err = errMsg
-			return nil, err
-		}
-
-		sql := fmt.Sprintf("INSERT INTO %s.%s VALUES ($1)", db, collection)
 
-		b, err := bson.MustConvertDocument(d).MarshalJSONHANA()
+		n, failed, err := h.insertBatch(ctx, db, collection, batch, start, ordered, &writeErrors)
 		if err != nil {
-			return nil, err
+			// a transaction is rolled back as a whole, otherwise the documents of earlier batches are committed already
+			if inTransaction(ctx) {
+				return nil, err
+			}
+
+			protoErr, _ := common.ProtocolError(err)
+			if err = writeErrors.Append(protoErr, int32(failed)); err != nil {
+				return nil, lazyerrors.Error(err)
+			}
 		}
 
-		if _, err = h.hanaPool.ExecContext(ctx, sql, b); err != nil {
-			return nil, err
+		inserted += n
+		if err != nil || ordered && writeErrors.Len() != 0 {
+			break
 		}
-		inserted++
+	}
+
+	res := types.MustMakeDocument(
+		"n", inserted,
+	)
+	if err = writeErrors.SetTo(&res); err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+	if err = res.Set("ok", float64(1)); err != nil {
+		return nil, lazyerrors.Error(err)
 	}
 
 	var reply wire.OpMsg
 	err = reply.SetSections(wire.OpMsgSection{
-		Documents: []types.Document{types.MustMakeDocument(
-			"n", inserted,
-			"ok", float64(1),
-		)},
+		Documents: []types.Document{res},
 	})
 	if err != nil {
 		return nil, lazyerrors.Error(err)
@@ -97,3 +125,89 @@ func (h *storage) MsgInsert(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg,
 
 	return &reply, nil
 }
+
+// insertBatch inserts the documents in one transaction with one prepared INSERT statement,
+// which is executed for all documents at once with the bulk binding of the driver.
+// If a document violates a unique index, SAP HANA only rolls back the failed statement and the documents are inserted one by one,
+// so that errors of single documents are added to writeErrors with their index in the command.
+// offset is the index of the first document. If ordered is true, the batch stops at the first write error.
+//
+// It returns the number of inserted documents. For any other error, failed is the index in the command
+// of the document the error belongs to. Outside of transactions, the documents inserted before are committed.
+func (h *storage) insertBatch(
+	ctx context.Context, db, collection string, docs []types.Document, offset int, ordered bool, writeErrors *common.WriteErrors,
+) (inserted int32, failed int, err error) {
+	table, err := hana.Table(db, collection)
+	if err != nil {
+		return 0, offset, err
+	}
+
+	values := make([]any, len(docs))
+	for i, doc := range docs {
+		if values[i], err = bson.MustConvertDocument(doc).MarshalJSONHANA(); err != nil {
+			return 0, offset + i, lazyerrors.Error(err)
+		}
+	}
+
+	tx, err := h.beginTx(ctx)
+	if err != nil {
+		return 0, offset, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s VALUES (?)", table))
+	if err != nil {
+		return 0, offset, lazyerrors.Error(err)
+	}
+	defer stmt.Close()
+
+	// uniqueness of _id is enforced by the unique index of the collection;
+	// the driver executes the statement once for each document when given a multiple of its parameters
+	if _, err = stmt.ExecContext(ctx, values...); err == nil {
+		if err = tx.Commit(); err != nil {
+			return 0, offset, lazyerrors.Error(err)
+		}
+
+		return int32(len(docs)), 0, nil
+	}
+
+	for i, doc := range docs {
+		// a single document already failed with the error of the batch
+		if len(docs) > 1 {
+			_, err = stmt.ExecContext(ctx, values[i])
+		}
+
+		if err == nil {
+			inserted++
+			continue
+		}
+
+		if _, ok := hana.UniqueViolation(err); !ok {
+			// a transaction is rolled back as a whole, otherwise the documents inserted before are kept
+			if inTransaction(ctx) {
+				return 0, offset + i, lazyerrors.Error(err)
+			}
+
+			if commitErr := tx.Commit(); commitErr != nil {
+				return 0, offset, lazyerrors.Error(commitErr)
+			}
+
+			return inserted, offset + i, lazyerrors.Error(err)
+		}
+
+		err = common.CheckDuplicateKey(lazyerrors.Error(err), db, collection, doc)
+		if err = writeErrors.Append(err, int32(offset+i)); err != nil {
+			return 0, offset + i, err
+		}
+
+		if ordered {
+			break
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, offset, lazyerrors.Error(err)
+	}
+
+	return inserted, 0, nil
+}
@@ -6,6 +6,8 @@ package crud
 
 import (
 	"database/sql/driver"
+	"errors"
+	"regexp"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
@@ -19,11 +21,12 @@ func TestMsgInsert(t *testing.T) {
 	ctx, storage, mock, err := setupTestUtil(t)
 	require.NoError(t, err)
 	t.Run("insert a document", func(t *testing.T) {
-		idRow := mock.NewRows([]string{"_id"})
 		args := []driver.Value{[]byte{123, 34, 95, 105, 100, 34, 58, 49, 50, 51, 44, 34, 105, 116, 101, 109, 34, 58, 34, 116, 101, 115, 116, 34, 125}}
 
-		mock.ExpectQuery("SELECT _id FROM testDatabase.testCollection  WHERE \"_id\" = 123").WillReturnRows(idRow)
-		mock.ExpectExec("INSERT INTO testDatabase.testCollection VALUES ($1)").WithArgs(args...).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectBegin()
+		mock.ExpectPrepare(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).ExpectExec().
+			WithArgs(args...).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
 
 		insertReq := types.MustMakeDocument(
 			"insert", "testCollection",
@@ -60,9 +63,10 @@ func TestMsgInsert(t *testing.T) {
 	})
 
 	t.Run("insert a document. Not unique id", func(t *testing.T) {
-		idRow := mock.NewRows([]string{"_id"}).AddRow(123)
-
-		mock.ExpectQuery("SELECT _id FROM testDatabase.testCollection  WHERE \"_id\" = 123").WillReturnRows(idRow)
+		mock.ExpectBegin()
+		mock.ExpectPrepare(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).ExpectExec().
+			WillReturnError(errors.New("SQL Error 301 - unique constraint violated: Table(TESTCOLLECTION), Index(TESTCOLLECTION._id_)"))
+		mock.ExpectCommit()
 
 		insertReq := types.MustMakeDocument(
 			"insert", "testCollection",
@@ -83,11 +87,201 @@ func TestMsgInsert(t *testing.T) {
 		require.NoError(t, err)
 
 		msg, err := storage.MsgInsert(ctx, &reqMsg)
-		assert.Nil(t, msg)
-		assert.EqualError(t, err, `E11000 duplicate key error collection: testDatabase.testCollection index: _id_ dup key: { _id: 123 }`)
+		require.NoError(t, err)
+
+		actual, err := msg.Document()
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"n", int32(0),
+			"writeErrors", types.MustNewArray(types.MustMakeDocument(
+				"index", int32(0),
+				"code", int32(11000),
+				"errmsg", "E11000 duplicate key error collection: testDatabase.testCollection index: _id_ dup key: { _id: 123 }",
+			)),
+			"ok", float64(1),
+		)
+		assert.Equal(t, expected, actual)
 
 		if err := mock.ExpectationsWereMet(); err != nil {
 			t.Errorf("there were unfulfilled expectations: %s", err)
 		}
 	})
+	for _, ordered := range []bool{true, false} {
+		ordered := ordered
+		name := "ordered"
+		if !ordered {
+			name = "unordered"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			duplicate := errors.New("SQL Error 301 - unique constraint violated: Table(TESTCOLLECTION), Index(TESTCOLLECTION._id_)")
+
+			// the batch size of the test storage is 2
+			mock.ExpectBegin()
+			insert := mock.ExpectPrepare(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`)
+			insert.ExpectExec().WithArgs([]byte(`{"_id":1}`), []byte(`{"_id":1}`)).WillReturnError(duplicate)
+			// the documents are inserted one by one to find the failed one
+			insert.ExpectExec().WithArgs([]byte(`{"_id":1}`)).WillReturnResult(sqlmock.NewResult(1, 1))
+			insert.ExpectExec().WithArgs([]byte(`{"_id":1}`)).WillReturnError(duplicate)
+			mock.ExpectCommit()
+
+			n := int32(1)
+			if !ordered {
+				mock.ExpectBegin()
+				mock.ExpectPrepare(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).ExpectExec().
+					WithArgs([]byte(`{"_id":2}`)).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+				n = 2
+			}
+
+			var reqMsg wire.OpMsg
+			err := reqMsg.SetSections(wire.OpMsgSection{
+				Documents: []types.Document{types.MustMakeDocument(
+					"insert", "testCollection",
+					"documents", types.MustNewArray(
+						types.MustMakeDocument("_id", int32(1)),
+						types.MustMakeDocument("_id", int32(1)),
+						types.MustMakeDocument("_id", int32(2)),
+					),
+					"ordered", ordered,
+					"$db", "testDatabase",
+				)},
+			})
+			require.NoError(t, err)
+
+			msg, err := storage.MsgInsert(ctx, &reqMsg)
+			require.NoError(t, err)
+
+			actual, err := msg.Document()
+			require.NoError(t, err)
+
+			expected := types.MustMakeDocument(
+				"n", n,
+				"writeErrors", types.MustNewArray(types.MustMakeDocument(
+					"index", int32(1),
+					"code", int32(11000),
+					"errmsg", "E11000 duplicate key error collection: testDatabase.testCollection index: _id_ dup key: { _id: 1 }",
+				)),
+				"ok", float64(1),
+			)
+			assert.Equal(t, expected, actual)
+
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	t.Run("batches", func(t *testing.T) {
+		// the batch size of the test storage is 2
+		mock.ExpectBegin()
+		mock.ExpectPrepare(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).ExpectExec().
+			WithArgs([]byte(`{"_id":1}`), []byte(`{"_id":2}`)).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectPrepare(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).ExpectExec().
+			WithArgs([]byte(`{"_id":3}`)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		var reqMsg wire.OpMsg
+		err := reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{types.MustMakeDocument(
+				"insert", "testCollection",
+				"documents", types.MustNewArray(
+					types.MustMakeDocument("_id", int32(1)),
+					types.MustMakeDocument("_id", int32(2)),
+					types.MustMakeDocument("_id", int32(3)),
+				),
+				"$db", "testDatabase",
+			)},
+		})
+		require.NoError(t, err)
+
+		msg, err := storage.MsgInsert(ctx, &reqMsg)
+		require.NoError(t, err)
+
+		actual, err := msg.Document()
+		require.NoError(t, err)
+		assert.Equal(t, types.MustMakeDocument("n", int32(3), "ok", float64(1)), actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("failed batch", func(t *testing.T) {
+		// the batch size of the test storage is 2
+		mock.ExpectBegin()
+		mock.ExpectPrepare(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).ExpectExec().
+			WithArgs([]byte(`{"_id":1}`), []byte(`{"_id":2}`)).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectPrepare(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).ExpectExec().
+			WithArgs([]byte(`{"_id":3}`)).WillReturnError(errors.New("SQL Error 258 - insufficient privilege: Not authorized"))
+		mock.ExpectCommit()
+
+		var reqMsg wire.OpMsg
+		err := reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{types.MustMakeDocument(
+				"insert", "testCollection",
+				"documents", types.MustNewArray(
+					types.MustMakeDocument("_id", int32(1)),
+					types.MustMakeDocument("_id", int32(2)),
+					types.MustMakeDocument("_id", int32(3)),
+				),
+				"ordered", false,
+				"$db", "testDatabase",
+			)},
+		})
+		require.NoError(t, err)
+
+		msg, err := storage.MsgInsert(ctx, &reqMsg)
+		require.NoError(t, err)
+
+		// the documents of the first batch are committed and counted
+		actual, err := msg.Document()
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), actual.Map()["n"])
+
+		writeErrors := actual.Map()["writeErrors"].(*types.Array)
+		require.Equal(t, 1, writeErrors.Len())
+		writeError, err := writeErrors.Get(0)
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), writeError.(types.Document).Map()["index"])
+		assert.Equal(t, int32(13), writeError.(types.Document).Map()["code"])
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("generates _id", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectPrepare(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).ExpectExec().
+			WithArgs(generatedID{}).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		var reqMsg wire.OpMsg
+		err := reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{types.MustMakeDocument(
+				"insert", "testCollection",
+				"documents", types.MustNewArray(types.MustMakeDocument("item", "test")),
+				"$db", "testDatabase",
+			)},
+		})
+		require.NoError(t, err)
+
+		msg, err := storage.MsgInsert(ctx, &reqMsg)
+		require.NoError(t, err)
+
+		actual, err := msg.Document()
+		require.NoError(t, err)
+		assert.Equal(t, types.MustMakeDocument("n", int32(1), "ok", float64(1)), actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// generatedID matches an inserted document which starts with a generated ObjectID.
+type generatedID struct{}
+
+// Match implements sqlmock.Argument.
+func (generatedID) Match(v driver.Value) bool {
+	b, ok := v.([]byte)
+	return ok && regexp.MustCompile(`^\{"_id":\{"oid":"[0-9a-f]{24}"\},"item":"test"\}$`).Match(b)
 }
@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"context"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+)
+
+// MsgKillCursors closes the given cursors of a collection.
+func (h *storage) MsgKillCursors(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	document, err := msg.Document()
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	if err := common.Unimplemented(&document, "comment"); err != nil {
+		return nil, err
+	}
+
+	m := document.Map()
+
+	collection, ok := m["killCursors"].(string)
+	if !ok {
+		return nil, common.NewErrorMessage(common.ErrBadValue, "killCursors must be of type string, not %T", m["killCursors"])
+	}
+
+	ns := m["$db"].(string) + "." + collection
+
+	ids, ok := m["cursors"].(*types.Array)
+	if !ok {
+		return nil, common.NewErrorMessage(common.ErrBadValue, "cursors must be an array, not %T", m["cursors"])
+	}
+
+	killed := new(types.Array)
+	notFound := new(types.Array)
+	for i := 0; i < ids.Len(); i++ {
+		v, err := ids.Get(i)
+		if err != nil {
+			return nil, lazyerrors.Error(err)
+		}
+
+		id, ok := v.(int64)
+		if !ok {
+			return nil, common.NewErrorMessage(common.ErrBadValue, "cursor ids must be of type long, not %T", v)
+		}
+
+		var found bool
+		if found, err = h.cursors.kill(id, ns, common.Owner(ctx)); err != nil {
+			return nil, err
+		}
+
+		if found {
+			err = killed.Append(id)
+		} else {
+			err = notFound.Append(id)
+		}
+		if err != nil {
+			return nil, lazyerrors.Error(err)
+		}
+	}
+
+	var reply wire.OpMsg
+	err = reply.SetSections(wire.OpMsgSection{
+		Documents: []types.Document{types.MustMakeDocument(
+			"cursorsKilled", killed,
+			"cursorsNotFound", notFound,
+			"cursorsAlive", new(types.Array),
+			"cursorsUnknown", new(types.Array),
+			"ok", float64(1),
+		)},
+	})
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	return &reply, nil
+}
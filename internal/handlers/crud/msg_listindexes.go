@@ -0,0 +1,74 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"context"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+)
+
+// MsgListIndexes returns a cursor to the indexes of a collection.
+func (h *storage) MsgListIndexes(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	document, err := msg.Document()
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	if err := common.Unimplemented(&document, "comment"); err != nil {
+		return nil, err
+	}
+
+	m := document.Map()
+	collection := m[document.Keys()[0]].(string)
+	db := m["$db"].(string)
+
+	batchSize := int32(defaultFirstBatchSize)
+	if cursorDoc, ok := m["cursor"].(types.Document); ok {
+		if _, ok := cursorDoc.Map()["batchSize"]; ok {
+			if batchSize, err = getBatchSize(cursorDoc.Map()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	indexes, err := h.collectionIndexes(ctx, db, collection)
+	if err != nil {
+		return nil, err
+	}
+
+	cur := &cursor{
+		ns:   db + "." + collection,
+		docs: make([]types.Document, len(indexes)),
+	}
+	for i, index := range indexes {
+		cur.docs[i] = indexDocument(index)
+	}
+
+	firstBatch, err := h.openCursor(ctx, cur, batchSize, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var reply wire.OpMsg
+	err = reply.SetSections(wire.OpMsgSection{
+		Documents: []types.Document{types.MustMakeDocument(
+			"cursor", types.MustMakeDocument(
+				"firstBatch", firstBatch,
+				"id", cur.id,
+				"ns", cur.ns,
+			),
+			"ok", float64(1),
+		)},
+	})
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	return &reply, nil
+}
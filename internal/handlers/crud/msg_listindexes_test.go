@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"testing"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMsgListIndexes(t *testing.T) {
+	ctx, storage, mock, err := setupTestUtil(t)
+	require.NoError(t, err)
+
+	mock.ExpectQuery(indexesQuery).WithArgs("TESTDATABASE", "TESTCOLLECTION").
+		WillReturnRows(indexRows(mock,
+			[]any{"TESTCOLLECTION.email", "UNIQUE", "\"email\"", "TRUE"},
+			[]any{"TESTCOLLECTION.a_1_b_-1", nil, "\"a\"", "TRUE"},
+			[]any{"TESTCOLLECTION.a_1_b_-1", nil, "\"b\"", "FALSE"},
+		))
+
+	var reqMsg wire.OpMsg
+	err = reqMsg.SetSections(wire.OpMsgSection{
+		Documents: []types.Document{types.MustMakeDocument(
+			"listIndexes", "testCollection",
+			"$db", "testDatabase",
+		)},
+	})
+	require.NoError(t, err)
+
+	msg, err := storage.MsgListIndexes(ctx, &reqMsg)
+	require.NoError(t, err)
+
+	actual, err := msg.Document()
+	require.NoError(t, err)
+
+	expected := types.MustMakeDocument(
+		"cursor", types.MustMakeDocument(
+			"firstBatch", types.MustNewArray(
+				types.MustMakeDocument("v", int32(2), "key", types.MustMakeDocument("_id", int32(1)), "name", "_id_"),
+				types.MustMakeDocument("v", int32(2), "key", types.MustMakeDocument("email", int32(1)), "name", "email", "unique", true),
+				types.MustMakeDocument("v", int32(2), "key", types.MustMakeDocument("a", int32(1), "b", int32(-1)), "name", "a_1_b_-1"),
+			),
+			"id", int64(0),
+			"ns", "testDatabase.testCollection",
+		),
+		"ok", float64(1),
+	)
+	assert.Equal(t, expected, actual)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	// a collection created before _id uniqueness was enforced by an index has no _id index until it is written
+	mock.ExpectQuery(indexesQuery).WithArgs("TESTDATABASE", "TESTCOLLECTION").
+		WillReturnRows(mock.NewRows([]string{"INDEX_NAME", "CONSTRAINT", "COLUMN_NAME", "ASCENDING_ORDER"}))
+
+	msg, err = storage.MsgListIndexes(ctx, &reqMsg)
+	require.NoError(t, err)
+
+	actual, err = msg.Document()
+	require.NoError(t, err)
+
+	firstBatch, err := actual.GetByPath("cursor", "firstBatch")
+	require.NoError(t, err)
+	assert.Equal(t, types.MustNewArray(), firstBatch)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+)
+
+// MsgStartSession starts a new logical session with a random UUID as id.
+func (h *storage) MsgStartSession(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	// version 4 and variant bits of a random UUID
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+
+	h.sessions.refresh(common.Owner(ctx), []string{hex.EncodeToString(id)})
+
+	var reply wire.OpMsg
+	err := reply.SetSections(wire.OpMsgSection{
+		Documents: []types.Document{types.MustMakeDocument(
+			"id", types.MustMakeDocument(
+				"id", types.Binary{Subtype: types.BinaryUUID, B: id},
+			),
+			"timeoutMinutes", int32(SessionTimeout/time.Minute),
+			"ok", float64(1),
+		)},
+	})
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	return &reply, nil
+}
+
+// MsgEndSessions ends the given sessions of the authenticated user and aborts their transactions.
+func (h *storage) MsgEndSessions(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	owner := common.Owner(ctx)
+	return h.sessionsCommand(msg, func(ids []string) {
+		h.sessions.end(owner, ids)
+	})
+}
+
+// MsgRefreshSessions marks the given sessions of the authenticated user as used, so that they do not expire.
+func (h *storage) MsgRefreshSessions(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	owner := common.Owner(ctx)
+	return h.sessionsCommand(msg, func(ids []string) {
+		h.sessions.refresh(owner, ids)
+	})
+}
+
+// MsgKillSessions ends the given sessions of the authenticated user and aborts their transactions.
+// An empty array kills all sessions of the authenticated user, or all sessions if authentication is disabled.
+func (h *storage) MsgKillSessions(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	owner := common.Owner(ctx)
+	return h.sessionsCommand(msg, func(ids []string) {
+		if len(ids) == 0 {
+			h.sessions.killOwned(owner)
+			return
+		}
+
+		h.sessions.end(owner, ids)
+	})
+}
+
+// sessionsCommand calls f with the ids of the array of sessions given as command value.
+func (h *storage) sessionsCommand(msg *wire.OpMsg, f func(ids []string)) (*wire.OpMsg, error) {
+	document, err := msg.Document()
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	command := document.Keys()[0]
+	sessions, ok := document.Map()[command].(*types.Array)
+	if !ok {
+		return nil, common.NewErrorMessage(
+			common.ErrTypeMismatch, "BSON field '%s.%s' is the wrong type '%T', expected type 'array'", command, command, document.Map()[command],
+		)
+	}
+
+	ids := make([]string, sessions.Len())
+	for i := range ids {
+		lsid, err := sessions.Get(i)
+		if err != nil {
+			return nil, lazyerrors.Error(err)
+		}
+
+		if ids[i], err = parseSessionID(lsid, command); err != nil {
+			return nil, err
+		}
+	}
+
+	f(ids)
+
+	var reply wire.OpMsg
+	err = reply.SetSections(wire.OpMsgSection{
+		Documents: []types.Document{types.MustMakeDocument(
+			"ok", float64(1),
+		)},
+	})
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	return &reply, nil
+}
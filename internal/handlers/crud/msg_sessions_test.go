@@ -0,0 +1,161 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sessionsMsg returns a message of a command which is given an array of the sessions with the given ids.
+func sessionsMsg(t *testing.T, command string, ids ...byte) *wire.OpMsg {
+	t.Helper()
+
+	sessions := new(types.Array)
+	for _, id := range ids {
+		require.NoError(t, sessions.Append(types.MustMakeDocument("id", types.Binary{Subtype: types.BinaryUUID, B: []byte{id}})))
+	}
+
+	var msg wire.OpMsg
+	require.NoError(t, msg.SetSections(wire.OpMsgSection{
+		Documents: []types.Document{types.MustMakeDocument(command, sessions, "$db", "admin")},
+	}))
+
+	return &msg
+}
+
+func TestMsgSessions(t *testing.T) {
+	ctx, s, mock, err := setupTestUtil(t)
+	require.NoError(t, err)
+	storage := s.(*storage)
+	sessions := storage.sessions
+
+	startTransaction := func(id byte) {
+		mock.ExpectBegin()
+		mock.ExpectExec("SAVEPOINT MONGODB_COMMAND").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectPrepare(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).ExpectExec().
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("RELEASE SAVEPOINT MONGODB_COMMAND").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		insert := types.MustMakeDocument(
+			"insert", "testCollection",
+			"documents", types.MustNewArray(types.MustMakeDocument("_id", int32(1))),
+		)
+		_, err := storage.MsgInsert(ctx, transactionMsg(t, insert, id, 1, true))
+		require.NoError(t, err)
+	}
+
+	t.Run("startSession", func(t *testing.T) {
+		var reqMsg wire.OpMsg
+		err := reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{types.MustMakeDocument("startSession", int32(1), "$db", "admin")},
+		})
+		require.NoError(t, err)
+
+		msg, err := storage.MsgStartSession(ctx, &reqMsg)
+		require.NoError(t, err)
+
+		actual, err := msg.Document()
+		require.NoError(t, err)
+		assert.Equal(t, int32(30), actual.Map()["timeoutMinutes"])
+
+		id, err := parseSessionID(actual.Map()["id"], "id")
+		require.NoError(t, err)
+		assert.Len(t, id, 32)
+		assert.Contains(t, sessions.sessions, id)
+	})
+
+	t.Run("endSessions", func(t *testing.T) {
+		startTransaction(1)
+		mock.ExpectRollback()
+
+		_, err := storage.MsgEndSessions(ctx, sessionsMsg(t, "endSessions", 1, 2))
+		require.NoError(t, err)
+		assert.NotContains(t, sessions.sessions, "01")
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("refreshSessions", func(t *testing.T) {
+		_, err := storage.MsgRefreshSessions(ctx, sessionsMsg(t, "refreshSessions", 3))
+		require.NoError(t, err)
+		assert.Contains(t, sessions.sessions, "03")
+	})
+
+	t.Run("killSessions", func(t *testing.T) {
+		startTransaction(4)
+		mock.ExpectRollback()
+
+		_, err := storage.MsgKillSessions(ctx, sessionsMsg(t, "killSessions"))
+		require.NoError(t, err)
+		assert.Empty(t, sessions.sessions)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("sessions of other users", func(t *testing.T) {
+		alice := common.WithOwner(ctx, "alice@admin")
+		bob := common.WithOwner(ctx, "bob@admin")
+
+		// the same lsid of two users are two sessions
+		_, err := storage.MsgRefreshSessions(alice, sessionsMsg(t, "refreshSessions", 7))
+		require.NoError(t, err)
+		_, err = storage.MsgRefreshSessions(bob, sessionsMsg(t, "refreshSessions", 7, 8))
+		require.NoError(t, err)
+		assert.Len(t, sessions.sessions, 3)
+
+		_, err = storage.MsgEndSessions(bob, sessionsMsg(t, "endSessions", 7))
+		require.NoError(t, err)
+		assert.Contains(t, sessions.sessions, sessionKey("alice@admin", "07"))
+		assert.NotContains(t, sessions.sessions, sessionKey("bob@admin", "07"))
+
+		_, err = storage.MsgKillSessions(alice, sessionsMsg(t, "killSessions"))
+		require.NoError(t, err)
+		assert.Len(t, sessions.sessions, 1)
+		assert.Contains(t, sessions.sessions, sessionKey("bob@admin", "08"))
+
+		_, err = storage.MsgKillSessions(bob, sessionsMsg(t, "killSessions"))
+		require.NoError(t, err)
+		assert.Empty(t, sessions.sessions)
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		startTransaction(5)
+		_, err := storage.MsgRefreshSessions(ctx, sessionsMsg(t, "refreshSessions", 6))
+		require.NoError(t, err)
+
+		mock.ExpectRollback()
+		sessions.reap(time.Now().Add(DefaultTransactionTimeout))
+		require.Contains(t, sessions.sessions, "05")
+		assert.Nil(t, sessions.sessions["05"].txn)
+		assert.Contains(t, sessions.sessions, "06")
+
+		sessions.reap(time.Now().Add(SessionTimeout))
+		assert.Empty(t, sessions.sessions)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("wrong type", func(t *testing.T) {
+		var reqMsg wire.OpMsg
+		err := reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{types.MustMakeDocument("endSessions", int32(1), "$db", "admin")},
+		})
+		require.NoError(t, err)
+
+		_, err = storage.MsgEndSessions(ctx, &reqMsg)
+		expected := common.NewErrorMessage(
+			common.ErrTypeMismatch, "BSON field 'endSessions.endSessions' is the wrong type 'int32', expected type 'array'",
+		)
+		assert.Equal(t, expected, err)
+	})
+}
@@ -0,0 +1,66 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"context"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+)
+
+// MsgCommitTransaction commits the transaction of a session.
+func (h *storage) MsgCommitTransaction(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	return h.endTransaction(ctx, msg, true)
+}
+
+// MsgAbortTransaction rolls back the transaction of a session.
+func (h *storage) MsgAbortTransaction(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	return h.endTransaction(ctx, msg, false)
+}
+
+// endTransaction commits or rolls back the transaction given by lsid and txnNumber of the command.
+func (h *storage) endTransaction(ctx context.Context, msg *wire.OpMsg, commit bool) (*wire.OpMsg, error) {
+	document, err := msg.Document()
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	if err := common.Unimplemented(&document, "comment"); err != nil {
+		return nil, err
+	}
+
+	common.Ignored(&document, h.l, "writeConcern", "recoveryToken")
+
+	params, err := getTransactionParams(document)
+	if err != nil {
+		return nil, err
+	}
+	if params == nil || params.start {
+		return nil, common.NewErrorMessage(
+			common.ErrInvalidOptions, "%s must be run with lsid, txnNumber and autocommit: false", document.Keys()[0],
+		)
+	}
+
+	params.owner = common.Owner(ctx)
+
+	if err = h.sessions.endTransaction(params, commit); err != nil {
+		return nil, err
+	}
+
+	var reply wire.OpMsg
+	err = reply.SetSections(wire.OpMsgSection{
+		Documents: []types.Document{types.MustMakeDocument(
+			"ok", float64(1),
+		)},
+	})
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	return &reply, nil
+}
@@ -0,0 +1,301 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withHanaPool returns a copy of the storage which uses the given pool, like the storage of another connection.
+func withHanaPool(s common.Storage, hanaPool *hana.Hpool) common.Storage {
+	res := *s.(*storage)
+	res.hanaPool = hanaPool
+	return &res
+}
+
+// transactionMsg returns a message of a command which is part of transaction txnNumber of session id.
+func transactionMsg(t *testing.T, command types.Document, id byte, txnNumber int64, start bool) *wire.OpMsg {
+	t.Helper()
+
+	require.NoError(t, command.Set("lsid", types.MustMakeDocument("id", types.Binary{Subtype: types.BinaryUUID, B: []byte{id}})))
+	require.NoError(t, command.Set("txnNumber", txnNumber))
+	if start {
+		require.NoError(t, command.Set("startTransaction", true))
+	}
+	require.NoError(t, command.Set("autocommit", false))
+	require.NoError(t, command.Set("$db", "testDatabase"))
+
+	var msg wire.OpMsg
+	require.NoError(t, msg.SetSections(wire.OpMsgSection{Documents: []types.Document{command}}))
+
+	return &msg
+}
+
+func TestMsgTransaction(t *testing.T) {
+	ctx, storage, mock, err := setupTestUtil(t)
+	require.NoError(t, err)
+
+	insert := func() types.Document {
+		return types.MustMakeDocument(
+			"insert", "testCollection",
+			"documents", types.MustNewArray(types.MustMakeDocument("_id", int32(1))),
+		)
+	}
+	ok := types.MustMakeDocument("ok", float64(1))
+
+	t.Run("commit", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("SAVEPOINT MONGODB_COMMAND").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectPrepare(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).ExpectExec().
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("RELEASE SAVEPOINT MONGODB_COMMAND").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`DELETE FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ?`).WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		_, err := storage.MsgInsert(ctx, transactionMsg(t, insert(), 1, 1, true))
+		require.NoError(t, err)
+
+		deleteReq := types.MustMakeDocument(
+			"delete", "testCollection",
+			"deletes", types.MustNewArray(types.MustMakeDocument("q", types.MustMakeDocument("_id", int32(1)), "limit", int32(0))),
+		)
+		_, err = storage.MsgDelete(ctx, transactionMsg(t, deleteReq, 1, 1, false))
+		require.NoError(t, err)
+
+		msg, err := storage.MsgCommitTransaction(ctx, transactionMsg(t, types.MustMakeDocument("commitTransaction", int32(1)), 1, 1, false))
+		require.NoError(t, err)
+		actual, _ := msg.Document()
+		assert.Equal(t, ok, actual)
+
+		// a retried commit succeeds without committing again
+		msg, err = storage.MsgCommitTransaction(ctx, transactionMsg(t, types.MustMakeDocument("commitTransaction", int32(1)), 1, 1, false))
+		require.NoError(t, err)
+		actual, _ = msg.Document()
+		assert.Equal(t, ok, actual)
+
+		_, err = storage.MsgAbortTransaction(ctx, transactionMsg(t, types.MustMakeDocument("abortTransaction", int32(1)), 1, 1, false))
+		expected := common.NewErrorMessage(common.ErrNoSuchTransaction, "Given transaction number 1 does not match any in-progress transactions")
+		assert.Equal(t, expected, err)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("SAVEPOINT MONGODB_COMMAND").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectPrepare(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).ExpectExec().
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("RELEASE SAVEPOINT MONGODB_COMMAND").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		_, err := storage.MsgInsert(ctx, transactionMsg(t, insert(), 2, 1, true))
+		require.NoError(t, err)
+
+		msg, err := storage.MsgAbortTransaction(ctx, transactionMsg(t, types.MustMakeDocument("abortTransaction", int32(1)), 2, 1, false))
+		require.NoError(t, err)
+		actual, _ := msg.Document()
+		assert.Equal(t, ok, actual)
+
+		_, err = storage.MsgCommitTransaction(ctx, transactionMsg(t, types.MustMakeDocument("commitTransaction", int32(1)), 2, 1, false))
+		expected := common.NewErrorMessage(common.ErrNoSuchTransaction, "Given transaction number 1 does not match any in-progress transactions")
+		assert.Equal(t, expected, err)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("new transaction aborts older one", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("SAVEPOINT MONGODB_COMMAND").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectPrepare(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).ExpectExec().
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("RELEASE SAVEPOINT MONGODB_COMMAND").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectExec("SAVEPOINT MONGODB_COMMAND").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectPrepare(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).ExpectExec().
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("RELEASE SAVEPOINT MONGODB_COMMAND").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		_, err := storage.MsgInsert(ctx, transactionMsg(t, insert(), 3, 1, true))
+		require.NoError(t, err)
+		_, err = storage.MsgInsert(ctx, transactionMsg(t, insert(), 3, 2, true))
+		require.NoError(t, err)
+
+		_, err = storage.MsgInsert(ctx, transactionMsg(t, insert(), 3, 1, false))
+		expected := common.NewErrorMessage(
+			common.ErrTransactionTooOld, "Cannot start transaction 1 on session 03 because a newer transaction 2 has already started",
+		)
+		assert.Equal(t, expected, err)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("failed command rolls back to savepoint", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("SAVEPOINT MONGODB_COMMAND").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectPrepare(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).ExpectExec().WillReturnError(assert.AnError)
+		mock.ExpectExec("ROLLBACK TO SAVEPOINT MONGODB_COMMAND").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		_, err := storage.MsgInsert(ctx, transactionMsg(t, insert(), 4, 1, true))
+		require.Error(t, err)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("failed begin", func(t *testing.T) {
+		mock.ExpectBegin().WillReturnError(assert.AnError)
+
+		_, err := storage.MsgInsert(ctx, transactionMsg(t, insert(), 9, 1, true))
+		require.Error(t, err)
+
+		// the reserved transaction is removed from the session
+		_, err = storage.MsgInsert(ctx, transactionMsg(t, insert(), 9, 1, false))
+		expected := common.NewErrorMessage(common.ErrNoSuchTransaction, "Given transaction number 1 does not match any in-progress transactions")
+		assert.Equal(t, expected, err)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unknown transaction", func(t *testing.T) {
+		_, err := storage.MsgInsert(ctx, transactionMsg(t, insert(), 5, 1, false))
+		expected := common.NewErrorMessage(common.ErrNoSuchTransaction, "Given transaction number 1 does not match any in-progress transactions")
+		assert.Equal(t, expected, err)
+	})
+
+	t.Run("transaction of another user", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("SAVEPOINT MONGODB_COMMAND").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectPrepare(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).ExpectExec().
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("RELEASE SAVEPOINT MONGODB_COMMAND").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		_, err := storage.MsgInsert(ctx, transactionMsg(t, insert(), 7, 1, true))
+		require.NoError(t, err)
+
+		// the connection of another SAP HANA user
+		userDB, _, err := sqlmock.New()
+		require.NoError(t, err)
+		defer userDB.Close()
+
+		other := withHanaPool(storage, &hana.Hpool{DB: userDB})
+		_, err = other.MsgInsert(ctx, transactionMsg(t, insert(), 7, 1, false))
+		expected := common.NewErrorMessage(common.ErrUnauthorized, "Transaction 1 of session 07 was started by another user")
+		assert.Equal(t, expected, err)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("cursor of a transaction", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION"`).WillReturnRows(
+			sqlmock.NewRows([]string{"document"}).
+				AddRow([]byte(`{"_id": 1}`)).
+				AddRow([]byte(`{"_id": 2}`)).
+				AddRow([]byte(`{"_id": 3}`)),
+		)
+		mock.ExpectCommit()
+
+		find := types.MustMakeDocument("find", "testCollection", "filter", types.MustMakeDocument(), "batchSize", int32(1))
+		msg, err := storage.MsgFindOrCount(ctx, transactionMsg(t, find, 8, 1, true))
+		require.NoError(t, err)
+		actual, _ := msg.Document()
+		id := actual.Map()["cursor"].(types.Document).Map()["id"].(int64)
+
+		getMore := func() types.Document {
+			return types.MustMakeDocument("getMore", id, "collection", "testCollection", "batchSize", int32(1))
+		}
+
+		// the cursor is only read within its transaction
+		var getMoreMsg wire.OpMsg
+		command := getMore()
+		require.NoError(t, command.Set("$db", "testDatabase"))
+		require.NoError(t, getMoreMsg.SetSections(wire.OpMsgSection{Documents: []types.Document{command}}))
+		_, err = storage.MsgGetMore(ctx, &getMoreMsg)
+		expected := common.NewErrorMessage(
+			common.ErrNoSuchTransaction, "Cannot run getMore on cursor %d, which was created in transaction 1, outside of it", id,
+		)
+		assert.Equal(t, expected, err)
+
+		msg, err = storage.MsgGetMore(ctx, transactionMsg(t, getMore(), 8, 1, false))
+		require.NoError(t, err)
+		actual, _ = msg.Document()
+		assert.Equal(t, types.MustNewArray(types.MustMakeDocument("_id", int32(2))), actual.Map()["cursor"].(types.Document).Map()["nextBatch"])
+
+		_, err = storage.MsgCommitTransaction(ctx, transactionMsg(t, types.MustMakeDocument("commitTransaction", int32(1)), 8, 1, false))
+		require.NoError(t, err)
+
+		// the rows are closed with the transaction
+		_, err = storage.MsgGetMore(ctx, transactionMsg(t, getMore(), 8, 1, false))
+		expected = common.NewErrorMessage(common.ErrNoSuchTransaction, "Given transaction number 1 does not match any in-progress transactions")
+		assert.Equal(t, expected, err)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("createIndexes", func(t *testing.T) {
+		createIndexes := types.MustMakeDocument(
+			"createIndexes", "testCollection",
+			"indexes", types.MustNewArray(types.MustMakeDocument("key", types.MustMakeDocument("a", int32(1)))),
+		)
+		_, err := storage.MsgCreateIndexes(ctx, transactionMsg(t, createIndexes, 6, 1, true))
+		expected := common.NewErrorMessage(common.ErrNotInTransaction, "Cannot run 'createIndexes' in a multi-document transaction.")
+		assert.Equal(t, expected, err)
+	})
+}
+
+func TestGetTransactionParams(t *testing.T) {
+	t.Parallel()
+
+	lsid := types.MustMakeDocument("id", types.Binary{Subtype: types.BinaryUUID, B: []byte{0xab, 0xcd}})
+
+	for name, tc := range map[string]struct {
+		document types.Document
+		expected *transactionParams
+		err      error
+	}{
+		"NoTransaction": {
+			document: types.MustMakeDocument("find", "test", "lsid", lsid),
+		},
+		"Start": {
+			document: types.MustMakeDocument("find", "test", "lsid", lsid, "txnNumber", int64(3), "startTransaction", true, "autocommit", false),
+			expected: &transactionParams{sessionID: "abcd", txnNumber: 3, start: true},
+		},
+		"Continue": {
+			document: types.MustMakeDocument("find", "test", "lsid", lsid, "txnNumber", int64(3), "autocommit", false),
+			expected: &transactionParams{sessionID: "abcd", txnNumber: 3},
+		},
+		"Autocommit": {
+			document: types.MustMakeDocument("find", "test", "lsid", lsid, "txnNumber", int64(3), "autocommit", true),
+			err:      common.NewErrorMessage(common.ErrInvalidOptions, "autocommit field can only be specified as false"),
+		},
+		"NoSession": {
+			document: types.MustMakeDocument("find", "test", "txnNumber", int64(3), "autocommit", false),
+			err:      common.NewErrorMessage(common.ErrInvalidOptions, "Transaction numbers are only allowed in a session"),
+		},
+		"NoTxnNumber": {
+			document: types.MustMakeDocument("find", "test", "lsid", lsid, "autocommit", false),
+			err: common.NewErrorMessage(
+				common.ErrInvalidOptions, "'autocommit' field requires a transaction number to also be specified",
+			),
+		},
+	} {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := getTransactionParams(tc.document)
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
@@ -21,15 +21,15 @@
 package crud
 
 import (
-	"bytes"
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 
-	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/bson"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/fjson"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
@@ -38,16 +38,19 @@ import (
 
 // MsgUpdate modifies an existing document or documents in a collection.
 func (h *storage) MsgUpdate(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	return h.retryableWrite(ctx, msg, h.msgUpdate)
+}
+
+// msgUpdate runs the update command, within the transaction of a retryable write if it is one.
+func (h *storage) msgUpdate(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
 	document, err := msg.Document()
 	if err != nil {
 		return nil, lazyerrors.Error(err)
 	}
 
 	unimplementedFields := []string{
-		"upsert",
 		"writeConcern",
 		"collation",
-		"arrayFilter",
 		"hint",
 		"commented",
 		"bypassDocumentValidation",
@@ -57,132 +60,438 @@ func (h *storage) MsgUpdate(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg,
 		return nil, err
 	}
 
-	common.Ignored(&document, h.l, "ordered")
+	ordered, err := common.GetOrdered(document)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, done, err := h.startCommand(ctx, document)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 
 	m := document.Map()
 	collection := m["update"].(string)
 	db := m["$db"].(string)
 	docs, _ := m["updates"].(*types.Array)
 
-	var selected, updated, matched int32
+	var selected, updated int32
+	var writeErrors common.WriteErrors
+	upserted := new(types.Array)
 	for i := 0; i < docs.Len(); i++ {
 		doc, err := docs.Get(i)
 		if err != nil {
 			return nil, lazyerrors.Error(err)
 		}
 
-		docM := doc.(types.Document).Map()
+		result, err := h.updateStatement(ctx, db, collection, doc.(types.Document))
+		if err != nil {
+			if err = writeErrors.Append(err, int32(i)); err != nil {
+				return nil, err
+			}
+
+			if ordered {
+				break
+			}
+
+			continue
+		}
+
+		if result.upserted {
+			if err = upserted.Append(types.MustMakeDocument("index", int32(i), "_id", result.upsertedID)); err != nil {
+				return nil, lazyerrors.Error(err)
+			}
+		}
+
+		selected += result.matched
+		updated += result.modified
+	}
+
+	res := types.MustMakeDocument(
+		"n", selected,
+		"nModified", updated,
+	)
+	if upserted.Len() != 0 {
+		if err = res.Set("upserted", upserted); err != nil {
+			return nil, lazyerrors.Error(err)
+		}
+	}
+	if err = writeErrors.SetTo(&res); err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+	if err = res.Set("ok", float64(1)); err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	var reply wire.OpMsg
+	err = reply.SetSections(wire.OpMsgSection{
+		Documents: []types.Document{res},
+	})
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	return &reply, nil
+}
+
+// updateResult is the result of a single update statement.
+type updateResult struct {
+	matched    int32
+	modified   int32
+	upserted   bool
+	upsertedID any
+}
+
+// updateStatement executes a single update statement of the update command in one transaction,
+// so that documents are not changed by concurrent writes between being counted, read and written.
+func (h *storage) updateStatement(ctx context.Context, db, collection string, statement types.Document) (*updateResult, error) {
+	tx, err := h.beginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := updateDocuments(ctx, tx, db, collection, statement)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	return res, nil
+}
 
-		whereSQL, err := common.CreateWhereClause(docM["q"].(types.Document))
+// updateDocuments executes a single update statement within the transaction q.
+func updateDocuments(ctx context.Context, q querier, db, collection string, statement types.Document) (*updateResult, error) {
+	docM := statement.Map()
+
+	var arrayFilters *types.Array
+	if value, ok := docM["arrayFilters"]; ok {
+		if arrayFilters, ok = value.(*types.Array); !ok {
+			return nil, common.NewErrorMessage(common.ErrBadValue, "arrayFilters must be an array")
+		}
+	}
+
+	updateDoc, ok := docM["u"].(types.Document)
+	if !ok {
+		return nil, common.NewErrorMessage(common.ErrNotImplemented, "update with type %T is not implemented yet", docM["u"])
+	}
+
+	replacement, err := common.IsReplacement(updateDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	if replacement && docM["multi"] == true {
+		return nil, common.NewErrorMessage(common.ErrFailedToParse, "multi update is not supported for replacement-style update")
+	}
+
+	table, err := hana.Table(db, collection)
+	if err != nil {
+		return nil, err
+	}
+
+	query := docM["q"].(types.Document)
+	params := &common.UpdateParams{Filter: query, ArrayFilters: arrayFilters}
+	multi, upsert := docM["multi"] == true, docM["upsert"] == true
+
+	// with an equality condition on _id, the unique index on _id keeps concurrent upserts
+	// from inserting the same document twice, otherwise the table lock does
+	idEquality := common.HasIDEquality(query)
+	if upsert && !idEquality {
+		if err = lockTable(ctx, q, table); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := updateMatching(ctx, q, db, collection, table, updateDoc, params, replacement, multi, upsert)
+
+	// a concurrent upsert inserted the document after it was not found, so it is matched now
+	var conflict *upsertConflictError
+	if errors.As(err, &conflict) && idEquality {
+		res, err = updateMatching(ctx, q, db, collection, table, updateDoc, params, replacement, multi, upsert)
+	}
+	if errors.As(err, &conflict) {
+		return nil, conflict.err
+	}
+
+	return res, err
+}
+
+// updateMatching updates the documents matching params.Filter within the transaction q,
+// or inserts a new document if none matches and upsert is true.
+func updateMatching(
+	ctx context.Context, q querier, db, collection, table string, updateDoc types.Document, params *common.UpdateParams,
+	replacement, multi, upsert bool,
+) (*updateResult, error) {
+	query, arrayFilters := params.Filter, params.ArrayFilters
+
+	whereSQL, whereArgs, exact, err := common.CreatePrefilterWhereClause(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var res updateResult
+
+	if exact {
+		// Get amount of documents that fits the filter. MatchCount
+		countSQL := fmt.Sprintf("SELECT count(*) FROM %s", table) + whereSQL
+		countRow := q.QueryRowContext(ctx, countSQL, whereArgs...)
+
+		err = countRow.Scan(&res.matched)
+		if err != nil {
+			return nil, lazyerrors.Error(err)
+		}
+
+		// updateOne matches one document of all matching ones
+		if res.matched > 1 && !multi {
+			res.matched = 1
+		}
+	} else {
+		// the documents matching a filter SAP HANA cannot evaluate are only known once they are read
+		res.matched, res.modified, err = updateReadModifyWrite(ctx, q, db, collection, whereSQL, whereArgs, false, updateDoc, params, multi)
+		if err != nil || res.matched > 0 || !upsert {
+			return &res, err
+		}
+	}
+
+	if res.matched == 0 && upsert {
+		_, id, err := upsertDocument(ctx, q, db, collection, query, updateDoc, arrayFilters)
 		if err != nil {
 			return nil, err
 		}
-		// notWhereSQL makes sure we do not update documents which do not need an update
-		updateSQL, notWhereSQL, err := update(docM["u"].(types.Document))
+
+		return &updateResult{matched: 1, upserted: true, upsertedID: id}, nil
+	}
+
+	// replacements are always read-modify-write, an identical replacement does not count as modified
+	if replacement || isReadModifyWrite(updateDoc) || arrayFilters != nil {
+		res.matched, res.modified, err = updateReadModifyWrite(ctx, q, db, collection, whereSQL, whereArgs, true, updateDoc, params, multi)
 		if err != nil {
 			return nil, err
 		}
 
-		// Get amount of documents that fits the filter. MatchCount
-		countSQL := fmt.Sprintf("SELECT count(*) FROM %s.%s", db, collection) + whereSQL
-		countRow := h.hanaPool.QueryRowContext(ctx, countSQL)
+		return &res, nil
+	}
+
+	// notWhereSQL makes sure we do not update documents which do not need an update
+	updateSQL, updateArgs, notWhereSQL, notWhereArgs, err := update(updateDoc)
+	if err != nil {
+		return nil, err
+	}
 
-		err = countRow.Scan(&matched)
+	// only $setOnInsert which has no effect on existing documents
+	if updateSQL == "" {
+		return &res, nil
+	}
+
+	if !multi { // If updateOne()
+
+		// We get the _id of the one document to update.
+		selectSQL := fmt.Sprintf("SELECT {\"_id\": \"_id\"} FROM %s", table)
+		selectSQL += whereSQL + notWhereSQL + " LIMIT 1 FOR UPDATE"
+		row := q.QueryRowContext(ctx, selectSQL, append(whereArgs, notWhereArgs...)...)
+
+		var objectID []byte
+
+		err = row.Scan(&objectID)
+		if errors.Is(err, sql.ErrNoRows) {
+			// all matching documents are up to date already
+			return &res, nil
+		}
 		if err != nil {
 			return nil, lazyerrors.Error(err)
 		}
 
-		var args []any
-		if docM["multi"] != true { // If updateOne()
+		id, err := fjson.Unmarshal(objectID)
+		if err != nil {
+			return nil, err
+		}
 
-			// We get the _id of the one document to update.
-			sql := fmt.Sprintf("SELECT {\"_id\": \"_id\"} FROM %s.%s", db, collection)
-			sql += whereSQL + notWhereSQL + " LIMIT 1"
-			row := h.hanaPool.QueryRowContext(ctx, sql)
+		// the document is only updated if a concurrent write did not change it to no longer match the filter
+		idFilter := types.MustMakeDocument("$and", types.MustNewArray(types.MustMakeDocument("_id", id.(types.Document).Map()["_id"]), query))
+		whereSQL, whereArgs, err = common.CreateWhereClause(idFilter)
+		if err != nil {
+			return nil, err
+		}
 
-			var objectID []byte
+		notWhereSQL = ""
+		notWhereArgs = nil
+	}
 
-			err = row.Scan(&objectID)
-			if err != nil {
-				selected += matched
-				err = nil
-				continue
-			}
+	sql := fmt.Sprintf("UPDATE %s ", table)
 
-			id, err := fjson.Unmarshal(objectID)
-			if err != nil {
-				return nil, err
+	sql += updateSQL + " " + whereSQL + notWhereSQL
+
+	// the arguments follow the order of their placeholders
+	args := append(append(updateArgs, whereArgs...), notWhereArgs...)
+
+	tag, err := q.ExecContext(ctx, sql, args...)
+	if err != nil {
+		return nil, common.CheckDuplicateKey(err, db, collection, types.Document{})
+	}
+
+	// Set modifiedCount
+	rowsaffected, err := tag.RowsAffected()
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	res.modified = int32(rowsaffected)
+
+	return &res, nil
+}
+
+// readModifyWriteOperators are the update operators which can not be translated to SQL.
+var readModifyWriteOperators = []string{
+	"$inc", "$mul", "$min", "$max", "$rename", "$currentDate",
+	"$push", "$addToSet", "$pop", "$pull", "$pullAll",
+}
+
+// isReadModifyWrite returns true if the update is applied in memory. That is the case for operators
+// which can not be translated to SQL, for positional operators and for $set with array values.
+func isReadModifyWrite(updateDoc types.Document) bool {
+	for _, op := range readModifyWriteOperators {
+		if _, ok := updateDoc.Map()[op]; ok {
+			return true
+		}
+	}
+
+	for _, op := range []string{"$set", "$setOnInsert", "$unset"} {
+		fields, ok := updateDoc.Map()[op].(types.Document)
+		if !ok {
+			continue
+		}
+
+		for _, key := range fields.Keys() {
+			if strings.Contains(key, ".$") || containsArray(fields.Map()[key]) {
+				return true
 			}
+		}
+	}
 
-			updateId, err := getUpdateValue(id.(types.Document).Map()["_id"])
-			if err != nil {
-				return nil, err
+	return false
+}
+
+// containsArray returns true if the value is or contains an array.
+func containsArray(value any) bool {
+	switch value := value.(type) {
+	case *types.Array:
+		return true
+	case types.Document:
+		for _, key := range value.Keys() {
+			if containsArray(value.Map()[key]) {
+				return true
 			}
+		}
+	}
 
-			whereSQL = "WHERE \"_id\" = %s"
-			var emptySlice []any
-			args = append(emptySlice, updateId)
-			notWhereSQL = ""
+	return false
+}
+
+// applyUpdateDocument applies the update operators or the replacement to a copy of the document.
+// It returns the updated document and true if it differs from the given document.
+func applyUpdateDocument(doc, updateDoc types.Document, params *common.UpdateParams) (types.Document, bool, error) {
+	replacement, err := common.IsReplacement(updateDoc)
+	if err != nil {
+		return doc, false, err
+	}
+
+	if replacement {
+		res, err := common.NewReplacementDocument(updateDoc, doc.Map()["_id"])
+		if err != nil {
+			return doc, false, err
 		}
 
-		sql := fmt.Sprintf("UPDATE %s.%s ", db, collection)
+		return res, !common.EqualValues(doc, res), nil
+	}
+
+	res := common.DeepCopyDocument(doc)
+	changed, err := common.ApplyUpdate(&res, updateDoc, params)
+	if err != nil {
+		return doc, false, err
+	}
+
+	return res, changed, nil
+}
+
+// updateReadModifyWrite updates the documents matching the where clause by reading them, applying the update
+// or the replacement in memory and writing back the changed documents by _id within the transaction q.
+// The documents are locked while they are read, so that concurrent updates of the same documents are applied
+// one after another instead of overwriting each other. If the where clause is not exact, the selected documents
+// are filtered with params.Filter.
+// It returns the number of matched and of modified documents.
+func updateReadModifyWrite(
+	ctx context.Context, q querier, db, collection, whereSQL string, whereArgs []any, exact bool,
+	updateDoc types.Document, params *common.UpdateParams, multi bool,
+) (matched, modified int32, err error) {
+	table, err := hana.Table(db, collection)
+	if err != nil {
+		return 0, 0, err
+	}
 
-		sql += updateSQL + " " + fmt.Sprintf(whereSQL, args...) + notWhereSQL
+	selectSQL := fmt.Sprintf("SELECT * FROM %s", table) + whereSQL
+	docs, err := lockMatching(ctx, q, table, selectSQL, whereArgs, params.Filter, multi, exact)
+	if err != nil {
+		return 0, 0, err
+	}
 
-		tag, err := h.hanaPool.ExecContext(ctx, sql)
+	for _, doc := range docs {
+		updated, changed, err := applyUpdateDocument(doc, updateDoc, params)
 		if err != nil {
-			return nil, err
+			return 0, 0, err
 		}
 
-		// Set modifiedCount
-		if docM["multi"] != true {
-			updated += 1
-			selected += matched
-		} else {
-			rowsaffected, _ := tag.RowsAffected()
+		if !changed {
+			continue
+		}
 
-			updated += int32(rowsaffected)
-			selected += matched
+		if err = replaceDocument(ctx, q, db, collection, doc.Map()["_id"], updated); err != nil {
+			return 0, 0, err
 		}
+		modified++
 	}
 
-	var reply wire.OpMsg
-	err = reply.SetSections(wire.OpMsgSection{
-		Documents: []types.Document{types.MustMakeDocument(
-			"n", selected,
-			"nModified", updated,
-			"ok", float64(1),
-		)},
-	})
+	return int32(len(docs)), modified, nil
+}
+
+// newUpsertDocument creates the document inserted by an upsert. It consists of the equality conditions of the query
+// with the update operators including $setOnInsert applied, or of the replacement document.
+// If neither contains an _id, a new ObjectID is used.
+func newUpsertDocument(query, updateDoc types.Document, arrayFilters *types.Array) (types.Document, error) {
+	doc, err := common.NewUpsertDocument(query)
 	if err != nil {
-		return nil, lazyerrors.Error(err)
+		return doc, err
 	}
 
-	return &reply, nil
+	replacement, err := common.IsReplacement(updateDoc)
+	if err != nil {
+		return doc, err
+	}
+
+	if !replacement {
+		_, err = common.ApplyUpdate(&doc, updateDoc, &common.UpdateParams{Filter: query, ArrayFilters: arrayFilters, Insert: true})
+		return doc, err
+	}
+
+	id := doc.Map()["_id"]
+
+	// without _id in the query, the _id of the replacement is used
+	if replacementID, err := updateDoc.Get("_id"); err == nil {
+		if _, err = query.Get("_id"); err != nil {
+			id = replacementID
+		}
+	}
+
+	return common.NewReplacementDocument(updateDoc, id)
 }
 
-// update creates needed SQL parts for SQL update statement
-func update(updateDoc types.Document) (updateSQL string, notWhereSQL string, err error) {
+// update creates needed SQL parts for SQL update statement and the arguments of their placeholders.
+func update(updateDoc types.Document) (updateSQL string, updateArgs []any, notWhereSQL string, notWhereArgs []any, err error) {
 	uninmplementedFields := []string{
-		"$currentDate",
-		"$inc",
-		"$min",
-		"$max",
-		"$mul",
-		"$rename",
-		"$setOnInsert",
-		"$",
-		"$[]",
-		"$[<identifier>]",
-		"$addToSet",
-		"$pop",
-		"$pull",
-		"$push",
-		"$pullAll",
-		"$each",
-		"$position",
-		"$slice",
-		"$sort",
 		"$bit",
 		"$addFields",
 		"$project",
@@ -200,7 +509,7 @@ func update(updateDoc types.Document) (updateSQL string, notWhereSQL string, err
 	var setDoc types.Document
 	var ok bool
 	if setDoc, ok = updateMap["$set"].(types.Document); ok {
-		updateSQL, isUnsetSQL, err = createSetandUnsetSqlStmnt(setDoc, true)
+		updateSQL, isUnsetSQL, updateArgs, err = createSetandUnsetSqlStmnt(setDoc, true)
 		if err != nil {
 			return
 		}
@@ -208,13 +517,13 @@ func update(updateDoc types.Document) (updateSQL string, notWhereSQL string, err
 
 	var unSetSQL, isSetSQL string
 	if unSetDoc, ok := updateMap["$unset"].(types.Document); ok {
-		if unSetSQL, isSetSQL, err = createSetandUnsetSqlStmnt(unSetDoc, false); err != nil {
+		if unSetSQL, isSetSQL, _, err = createSetandUnsetSqlStmnt(unSetDoc, false); err != nil {
 			return
 		}
 	}
 
 	if isUnsetSQL != "" && isSetSQL != "" { // If both setting and unsetting fields
-		notWhereSQL, err = common.CreateWhereClause(setDoc)
+		notWhereSQL, notWhereArgs, err = common.CreateWhereClause(setDoc)
 		if err != nil {
 			if strings.Contains(err.Error(), "value *types.Array not supported in filter") {
 				err = common.NewErrorMessage(common.ErrNotImplemented, "cannot update a field with array")
@@ -226,7 +535,7 @@ func update(updateDoc types.Document) (updateSQL string, notWhereSQL string, err
 		notWhereSQL = " AND ( NOT ( " + strings.Replace(notWhereSQL, "WHERE", "", 1) + ") OR (" + isUnsetSQL + " ) OR ( " + isSetSQL + " ))"
 		updateSQL += ", " + unSetSQL
 	} else if isUnsetSQL != "" { // If only setting fields
-		notWhereSQL, err = common.CreateWhereClause(setDoc)
+		notWhereSQL, notWhereArgs, err = common.CreateWhereClause(setDoc)
 		if err != nil {
 			if strings.Contains(err.Error(), "value *types.Array not supported in filter") {
 				err = common.NewErrorMessage(common.ErrNotImplemented, "cannot update a field with array")
@@ -238,6 +547,8 @@ func update(updateDoc types.Document) (updateSQL string, notWhereSQL string, err
 	} else if isSetSQL != "" { // If only unsetting fields
 		notWhereSQL = " AND ( " + isSetSQL + " )"
 		updateSQL = unSetSQL
+	} else if _, ok := updateMap["$setOnInsert"]; ok { // If only setting fields on insert
+		return
 	} else {
 		err = common.NewErrorMessage(common.ErrCommandNotFound, "no such command: replaceOne")
 		return
@@ -246,7 +557,7 @@ func update(updateDoc types.Document) (updateSQL string, notWhereSQL string, err
 	return
 }
 
-func createSetandUnsetSqlStmnt(doc types.Document, set bool) (updateSQL string, isSetOrUnsetSQL string, err error) {
+func createSetandUnsetSqlStmnt(doc types.Document, set bool) (updateSQL string, isSetOrUnsetSQL string, args []any, err error) {
 	if set {
 		updateSQL = " SET "
 	} else {
@@ -254,6 +565,7 @@ func createSetandUnsetSqlStmnt(doc types.Document, set bool) (updateSQL string,
 	}
 
 	var updateValue string
+	var valueArgs []any
 	for i, key := range doc.Keys() {
 		var value any
 		if set {
@@ -277,11 +589,12 @@ func createSetandUnsetSqlStmnt(doc types.Document, set bool) (updateSQL string,
 		}
 
 		if set {
-			updateValue, err = getUpdateValue(value)
+			updateValue, valueArgs, err = getUpdateValue(value)
 			if err != nil {
 				return
 			}
 			updateSQL += updateKey + " = " + updateValue
+			args = append(args, valueArgs...)
 			isSetOrUnsetSQL += updateKey + " IS UNSET"
 		} else {
 			updateSQL += updateKey
@@ -314,83 +627,53 @@ func getUpdateKey(key string) (updateKey string, err error) {
 				updateKey += "."
 			}
 
-			updateKey += "\"" + k + "\""
+			updateKey += hana.QuoteIdentifier(k)
 
 			isInt = false
 
 		}
 	} else {
-		updateKey = "\"" + key + "\""
+		updateKey = hana.QuoteIdentifier(key)
 	}
 
 	return
 }
 
 // getUpdateValue prepares the value for SQL statement
-func getUpdateValue(value any) (updateValue string, err error) {
-	var updateArgs []any
+func getUpdateValue(value any) (updateValue string, args []any, err error) {
 	switch value := value.(type) {
-	case string:
-		updateValue += "'%s'"
-		updateArgs = append(updateArgs, value)
-	case int64:
-		updateValue += "%d"
-		updateArgs = append(updateArgs, value)
-	case int32:
-		updateValue += "%d"
-		updateArgs = append(updateArgs, value)
-	case float64:
-		updateValue += "%f"
-		updateArgs = append(updateArgs, value)
+	case string, int64, int32, float64:
+		updateValue = "?"
+		args = append(args, value)
 	case nil:
-		updateValue += "NULL"
-		return
+		updateValue = "NULL"
 	case bool:
-		updateValue += "to_json_boolean(%t)"
-		updateArgs = append(updateArgs, value)
+		updateValue = "to_json_boolean(?)"
+		args = append(args, value)
 	case *types.Array:
-		updateValue, err = common.PrepareArrayForSQL(value)
-		if err != nil {
-			return
-		}
+		updateValue, args, err = common.PrepareArrayForSQL(value)
 	case types.Document:
-		updateValue += "%s"
-		var argDoc string
-		argDoc, err = updateDocument(value)
-		if err != nil {
-			return
-		}
-
-		updateArgs = append(updateArgs, argDoc)
+		updateValue, args, err = updateDocument(value)
 	case types.ObjectID:
-		updateValue += "%s"
-		var bOBJ []byte
-		if bOBJ, err = bson.ObjectID(value).MarshalJSON(); err != nil {
-			err = lazyerrors.Errorf("scalar: %w", err)
-		}
-		oid := bytes.Replace(bOBJ, []byte{34}, []byte{39}, -1)
-		oid = bytes.Replace(oid, []byte{39}, []byte{34}, 2)
-		updateArgs = append(updateArgs, string(oid))
+		updateValue, args = common.ObjectIDSQL(value)
 	default:
 		err = lazyerrors.Errorf("Value: %T is not supported for update", value)
 	}
 
-	updateValue = fmt.Sprintf(updateValue, updateArgs...)
 	return
 }
 
 // updateDocument prepares a document for being used as value for updating a field
-func updateDocument(doc types.Document) (docSQL string, err error) {
+func updateDocument(doc types.Document) (docSQL string, args []any, err error) {
 	docSQL += "{"
 	var value any
-	var args []any
 	for i, key := range doc.Keys() {
 
 		if i != 0 {
 			docSQL += ", "
 		}
 
-		docSQL += "\"" + key + "\": "
+		docSQL += hana.QuoteIdentifier(key) + ": "
 
 		value, err = doc.Get(key)
 
@@ -398,55 +681,34 @@ func updateDocument(doc types.Document) (docSQL string, err error) {
 			return
 		}
 
+		var valueSQL string
+		var valueArgs []any
 		switch value := value.(type) {
-		case int32, int64:
-			docSQL += "%d"
-			args = append(args, value)
-		case float64:
-			docSQL += "%f"
-			args = append(args, value)
-		case string:
-
-			docSQL += "'%s'"
-			args = append(args, value)
+		case int32, int64, float64, string:
+			valueSQL = "?"
+			valueArgs = []any{value}
 		case bool:
-
-			docSQL += "to_json_boolean(%t)"
-			args = append(args, value)
+			valueSQL = "to_json_boolean(?)"
+			valueArgs = []any{value}
 		case nil:
-			docSQL += " NULL "
+			valueSQL = " NULL "
 		case *types.Array:
-			var arraySQL string
-			arraySQL, err = common.PrepareArrayForSQL(value)
-			docSQL += arraySQL
-			if err != nil {
-				return
-			}
+			valueSQL, valueArgs, err = common.PrepareArrayForSQL(value)
 		case types.ObjectID:
-			docSQL += "%s"
-			var bOBJ []byte
-			bOBJ, err = bson.ObjectID(value).MarshalJSON()
-			oid := bytes.Replace(bOBJ, []byte{34}, []byte{39}, -1)
-			oid = bytes.Replace(oid, []byte{39}, []byte{34}, 2)
-			args = append(args, string(oid))
+			valueSQL, valueArgs = common.ObjectIDSQL(value)
 		case types.Document:
-
-			docSQL += "%s"
-
-			var docValue string
-			docValue, err = updateDocument(value)
-			if err != nil {
-				return
-			}
-
-			args = append(args, docValue)
-
+			valueSQL, valueArgs, err = updateDocument(value)
 		default:
 			err = common.NewErrorMessage(common.ErrBadValue, "%T is not supported within an object for filtering", value)
+		}
+		if err != nil {
 			return
 		}
+
+		docSQL += valueSQL
+		args = append(args, valueArgs...)
 	}
 
-	docSQL = fmt.Sprintf(docSQL, args...) + "}"
+	docSQL += "}"
 	return
 }
@@ -5,6 +5,7 @@
 package crud
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
@@ -20,8 +21,10 @@ func TestMsgUpdate(t *testing.T) {
 	t.Run("updateMany", func(t *testing.T) {
 		row := mock.NewRows([]string{"count"}).AddRow(1)
 
-		mock.ExpectQuery("SELECT count(*) FROM testDatabase.testCollection WHERE \"item\" = 'test'").WillReturnRows(row)
-		mock.ExpectExec("UPDATE testDatabase.testCollection  SET \"item\" = 'new test'  WHERE \"item\" = 'test' AND ( NOT (   \"item\" = 'new test') OR (\"item\" IS UNSET )) ").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT count(*) FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" = ?`).WithArgs("test").WillReturnRows(row)
+		mock.ExpectExec(`UPDATE "TESTDATABASE"."TESTCOLLECTION"  SET "item" = ?  WHERE "item" = ? AND ( NOT (   "item" = ?) OR ("item" IS UNSET )) `).WithArgs("new test", "test", "new test").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
 
 		updateReq := types.MustMakeDocument(
 			"update", "testCollection",
@@ -66,12 +69,15 @@ func TestMsgUpdate(t *testing.T) {
 	})
 
 	t.Run("updateOne", func(t *testing.T) {
-		countRow := sqlmock.NewRows([]string{"count"}).AddRow(1)
+		// only one of the two matching documents is updated
+		countRow := sqlmock.NewRows([]string{"count"}).AddRow(2)
 		idRow := sqlmock.NewRows([]string{"_id"}).AddRow("{\"_id\": 123}")
 
-		mock.ExpectQuery("SELECT count(*) FROM testDatabase.testCollection WHERE \"item\" = 'test'").WillReturnRows(countRow)
-		mock.ExpectQuery("SELECT {\"_id\": \"_id\"} FROM testDatabase.testCollection WHERE \"item\" = 'test' AND ( NOT (   \"item\" = 'new test') OR (\"item\" IS UNSET )) ").WillReturnRows(idRow)
-		mock.ExpectExec("UPDATE testDatabase.testCollection  SET \"item\" = 'new test' WHERE \"_id\" = 123").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT count(*) FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" = ?`).WithArgs("test").WillReturnRows(countRow)
+		mock.ExpectQuery(`SELECT {"_id": "_id"} FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" = ? AND ( NOT (   "item" = ?) OR ("item" IS UNSET ))  LIMIT 1 FOR UPDATE`).WithArgs("test", "new test").WillReturnRows(idRow)
+		mock.ExpectExec(`UPDATE "TESTDATABASE"."TESTCOLLECTION"  SET "item" = ?  WHERE "_id" = ? AND "item" = ?`).WithArgs("new test", 123, "test").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
 
 		updateReq := types.MustMakeDocument(
 			"update", "testCollection",
@@ -114,75 +120,513 @@ func TestMsgUpdate(t *testing.T) {
 		}
 	})
 
+	t.Run("updateOne with failing select", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT count(*) FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" = ?`).WithArgs("test").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(`SELECT {"_id": "_id"} FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" = ?`).
+			WillReturnError(errors.New("SQL Error 131 - transaction rolled back by lock wait timeout"))
+		mock.ExpectRollback()
+
+		updateReq := types.MustMakeDocument(
+			"update", "testCollection",
+			"updates", types.MustNewArray(types.MustMakeDocument(
+				"q", types.MustMakeDocument("item", "test"),
+				"u", types.MustMakeDocument("$set", types.MustMakeDocument("item", "new test")),
+			)),
+			"$db", "testDatabase",
+		)
+
+		var reqMsg wire.OpMsg
+		require.NoError(t, reqMsg.SetSections(wire.OpMsgSection{Documents: []types.Document{updateReq}}))
+
+		_, err := storage.MsgUpdate(ctx, &reqMsg)
+		require.ErrorContains(t, err, "lock wait timeout")
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("upsert", func(t *testing.T) {
+		// only the upsert without _id in the query locks the collection
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT count(*) FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ?`).
+			WithArgs("abc").
+			WillReturnRows(mock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).
+			WithArgs([]byte(`{"_id":"abc","item":"new","qty":1}`)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(`LOCK TABLE "TESTDATABASE"."TESTCOLLECTION" IN EXCLUSIVE MODE`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT count(*) FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" = ?`).
+			WithArgs("test").
+			WillReturnRows(mock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectCommit()
+
+		updateReq := types.MustMakeDocument(
+			"update", "testCollection",
+			"updates", types.MustNewArray(
+				types.MustMakeDocument(
+					"q", types.MustMakeDocument("_id", "abc"),
+					"u", types.MustMakeDocument(
+						"$set", types.MustMakeDocument("item", "new"),
+						"$setOnInsert", types.MustMakeDocument("qty", int32(1)),
+					),
+					"upsert", true,
+				),
+				types.MustMakeDocument(
+					"q", types.MustMakeDocument("item", "test"),
+					"u", types.MustMakeDocument("$setOnInsert", types.MustMakeDocument("qty", int32(1))),
+					"upsert", true,
+					"multi", true,
+				),
+			),
+			"$db", "testDatabase",
+		)
+
+		var reqMsg wire.OpMsg
+		err = reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{updateReq},
+		})
+		require.NoError(t, err)
+
+		msg, err := storage.MsgUpdate(ctx, &reqMsg)
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"n", int32(3),
+			"nModified", int32(0),
+			"upserted", types.MustNewArray(types.MustMakeDocument("index", int32(0), "_id", "abc")),
+			"ok", float64(1),
+		)
+
+		actual, err := msg.Document()
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("upsert inserted by a concurrent command", func(t *testing.T) {
+		// the document inserted after it was not found is updated
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT count(*) FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ?`).
+			WithArgs("abc").
+			WillReturnRows(mock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).
+			WithArgs([]byte(`{"_id":"abc","item":"new"}`)).
+			WillReturnError(errors.New("SQL Error 301 - unique constraint violated: Table(TESTCOLLECTION), Index(TESTCOLLECTION._id_)"))
+		mock.ExpectQuery(`SELECT count(*) FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ?`).
+			WithArgs("abc").
+			WillReturnRows(mock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(`SELECT {"_id": "_id"} FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ? AND ( NOT (   "item" = ?) OR ("item" IS UNSET ))  LIMIT 1 FOR UPDATE`).
+			WithArgs("abc", "new").
+			WillReturnRows(mock.NewRows([]string{"_id"}).AddRow(`{"_id": "abc"}`))
+		mock.ExpectExec(`UPDATE "TESTDATABASE"."TESTCOLLECTION"  SET "item" = ?  WHERE "_id" = ? AND "_id" = ?`).
+			WithArgs("new", "abc", "abc").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		updateReq := types.MustMakeDocument(
+			"update", "testCollection",
+			"updates", types.MustNewArray(
+				types.MustMakeDocument(
+					"q", types.MustMakeDocument("_id", "abc"),
+					"u", types.MustMakeDocument("$set", types.MustMakeDocument("item", "new")),
+					"upsert", true,
+				),
+			),
+			"$db", "testDatabase",
+		)
+
+		var reqMsg wire.OpMsg
+		err = reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{updateReq},
+		})
+		require.NoError(t, err)
+
+		msg, err := storage.MsgUpdate(ctx, &reqMsg)
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"n", int32(1),
+			"nModified", int32(1),
+			"ok", float64(1),
+		)
+
+		actual, err := msg.Document()
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("min only modifies changed documents", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT count(*) FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" = ?`).
+			WithArgs("test").
+			WillReturnRows(mock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" = ? FOR UPDATE`).
+			WithArgs("test").
+			WillReturnRows(mock.NewRows([]string{"document"}).
+				AddRow([]byte(`{"_id": 1, "item": "test", "qty": 5}`)).
+				AddRow([]byte(`{"_id": 2, "item": "test", "qty": 7}`)))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ? AND "item" = ? FOR UPDATE`).
+			WithArgs(1, "test").
+			WillReturnRows(mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": 1, "item": "test", "qty": 5}`)))
+		// a concurrent update changed the document before it was locked
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ? AND "item" = ? FOR UPDATE`).
+			WithArgs(2, "test").
+			WillReturnRows(mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": 2, "item": "test", "qty": 8}`)))
+		mock.ExpectExec(`DELETE FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ?`).
+			WithArgs(2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).
+			WithArgs([]byte(`{"_id":2,"item":"test","qty":5}`)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		updateReq := types.MustMakeDocument(
+			"update", "testCollection",
+			"updates", types.MustNewArray(
+				types.MustMakeDocument(
+					"q", types.MustMakeDocument("item", "test"),
+					"u", types.MustMakeDocument("$min", types.MustMakeDocument("qty", int32(5))),
+					"multi", true,
+				),
+			),
+			"$db", "testDatabase",
+		)
+
+		var reqMsg wire.OpMsg
+		err = reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{updateReq},
+		})
+		require.NoError(t, err)
+
+		msg, err := storage.MsgUpdate(ctx, &reqMsg)
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"n", int32(2),
+			"nModified", int32(1),
+			"ok", float64(1),
+		)
+
+		actual, err := msg.Document()
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("updateOne with post-filtered $expr", func(t *testing.T) {
+		// the first matching document is found in memory and locked within the same transaction
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE 1 = 1`).
+			WillReturnRows(mock.NewRows([]string{"document"}).
+				AddRow([]byte(`{"_id": 1, "spent": 5, "budget": 10}`)).
+				AddRow([]byte(`{"_id": 2, "spent": 10, "budget": 5}`)).
+				AddRow([]byte(`{"_id": 3, "spent": 20, "budget": 5}`)))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ? AND 1 = 1 FOR UPDATE`).
+			WithArgs(2).
+			WillReturnRows(mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": 2, "spent": 10, "budget": 5}`)))
+		mock.ExpectExec(`DELETE FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ?`).
+			WithArgs(2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).
+			WithArgs([]byte(`{"_id":2,"spent":10,"budget":5,"over":true}`)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		updateReq := types.MustMakeDocument(
+			"update", "testCollection",
+			"updates", types.MustNewArray(
+				types.MustMakeDocument(
+					"q", types.MustMakeDocument(
+						"$expr", types.MustMakeDocument("$gt", types.MustNewArray("$spent", "$budget")),
+					),
+					"u", types.MustMakeDocument("$set", types.MustMakeDocument("over", true)),
+				),
+			),
+			"$db", "testDatabase",
+		)
+
+		var reqMsg wire.OpMsg
+		err = reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{updateReq},
+		})
+		require.NoError(t, err)
+
+		msg, err := storage.MsgUpdate(ctx, &reqMsg)
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"n", int32(1),
+			"nModified", int32(1),
+			"ok", float64(1),
+		)
+
+		actual, err := msg.Document()
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("array filters", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT count(*) FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ?`).
+			WithArgs(1).
+			WillReturnRows(mock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ? LIMIT 1 FOR UPDATE`).
+			WithArgs(1).
+			WillReturnRows(mock.NewRows([]string{"document"}).
+				AddRow([]byte(`{"_id": 1, "grades": [{"grade": 80, "tags": []}, {"grade": 90, "tags": ["a"]}]}`)))
+		mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ? AND "_id" = ? FOR UPDATE`).
+			WithArgs(1, 1).
+			WillReturnRows(mock.NewRows([]string{"document"}).
+				AddRow([]byte(`{"_id": 1, "grades": [{"grade": 80, "tags": []}, {"grade": 90, "tags": ["a"]}]}`)))
+		mock.ExpectExec(`DELETE FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ?`).
+			WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).
+			WithArgs([]byte(`{"_id":1,"grades":[{"grade":80,"tags":[]},{"grade":90,"tags":["a","b"]}]}`)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		updateReq := types.MustMakeDocument(
+			"update", "testCollection",
+			"updates", types.MustNewArray(
+				types.MustMakeDocument(
+					"q", types.MustMakeDocument("_id", int32(1)),
+					"u", types.MustMakeDocument("$addToSet", types.MustMakeDocument(
+						"grades.$[g].tags", types.MustMakeDocument("$each", types.MustNewArray("a", "b")),
+					)),
+					"arrayFilters", types.MustNewArray(
+						types.MustMakeDocument("g.grade", types.MustMakeDocument("$gte", int32(85))),
+					),
+				),
+			),
+			"$db", "testDatabase",
+		)
+
+		var reqMsg wire.OpMsg
+		err = reqMsg.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{updateReq},
+		})
+		require.NoError(t, err)
+
+		msg, err := storage.MsgUpdate(ctx, &reqMsg)
+		require.NoError(t, err)
+
+		expected := types.MustMakeDocument(
+			"n", int32(1),
+			"nModified", int32(1),
+			"ok", float64(1),
+		)
+
+		actual, err := msg.Document()
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("replacement", func(t *testing.T) {
+		replaceOne := func(t *testing.T, replacement types.Document, multi bool) (types.Document, error) {
+			t.Helper()
+
+			updateReq := types.MustMakeDocument(
+				"update", "testCollection",
+				"updates", types.MustNewArray(
+					types.MustMakeDocument(
+						"q", types.MustMakeDocument("item", "test"),
+						"u", replacement,
+						"multi", multi,
+					),
+				),
+				"$db", "testDatabase",
+			)
+
+			var reqMsg wire.OpMsg
+			err := reqMsg.SetSections(wire.OpMsgSection{
+				Documents: []types.Document{updateReq},
+			})
+			require.NoError(t, err)
+
+			msg, err := storage.MsgUpdate(ctx, &reqMsg)
+			if err != nil {
+				return types.Document{}, err
+			}
+
+			return msg.Document()
+		}
+
+		expectSelect := func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT count(*) FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" = ?`).
+				WithArgs("test").
+				WillReturnRows(mock.NewRows([]string{"count"}).AddRow(1))
+			mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "item" = ? LIMIT 1 FOR UPDATE`).
+				WithArgs("test").
+				WillReturnRows(mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": 1, "item": "test", "qty": 5}`)))
+			mock.ExpectQuery(`SELECT * FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ? AND "item" = ? FOR UPDATE`).
+				WithArgs(1, "test").
+				WillReturnRows(mock.NewRows([]string{"document"}).AddRow([]byte(`{"_id": 1, "item": "test", "qty": 5}`)))
+		}
+
+		expectSelect()
+		mock.ExpectExec(`DELETE FROM "TESTDATABASE"."TESTCOLLECTION" WHERE "_id" = ?`).
+			WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).
+			WithArgs([]byte(`{"_id":1,"item":"replaced"}`)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		actual, err := replaceOne(t, types.MustMakeDocument("item", "replaced"), false)
+		require.NoError(t, err)
+		assert.Equal(t, types.MustMakeDocument("n", int32(1), "nModified", int32(1), "ok", float64(1)), actual)
+
+		expectSelect()
+		mock.ExpectCommit()
+
+		actual, err = replaceOne(t, types.MustMakeDocument("_id", int32(1), "item", "test", "qty", int32(5)), false)
+		require.NoError(t, err)
+		assert.Equal(t, types.MustMakeDocument("n", int32(1), "nModified", int32(0), "ok", float64(1)), actual)
+
+		expectSelect()
+		mock.ExpectRollback()
+
+		actual, err = replaceOne(t, types.MustMakeDocument("_id", int32(2), "item", "test"), false)
+		require.NoError(t, err)
+		expected := types.MustMakeDocument(
+			"n", int32(0),
+			"nModified", int32(0),
+			"writeErrors", types.MustNewArray(types.MustMakeDocument(
+				"index", int32(0),
+				"code", int32(66),
+				"errmsg", "After applying the update, the (immutable) field '_id' was found to have been altered to _id: 2",
+			)),
+			"ok", float64(1),
+		)
+		assert.Equal(t, expected, actual)
+
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		actual, err = replaceOne(t, types.MustMakeDocument("item", "replaced"), true)
+		require.NoError(t, err)
+		expected = types.MustMakeDocument(
+			"n", int32(0),
+			"nModified", int32(0),
+			"writeErrors", types.MustNewArray(types.MustMakeDocument(
+				"index", int32(0),
+				"code", int32(9),
+				"errmsg", "multi update is not supported for replacement-style update",
+			)),
+			"ok", float64(1),
+		)
+		assert.Equal(t, expected, actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
 	t.Run("set fields with supported and unsupported values", func(t *testing.T) {
 		t.Parallel()
 
-		updateSQL, notWhereSQL, err := update(types.MustMakeDocument("$set", types.MustMakeDocument("str_value", "value", "int32_value", int32(123), "int64_value", int64(223372036854775807), "float64_value", 64534.12432, "bool_value", true, "objID_value", types.ObjectID{98, 226, 189, 84, 81, 6, 131, 249, 192, 187, 13, 107}, "document_value", types.MustMakeDocument("string", "value", "int32", int32(2), "int64", int64(4543654563), "float", float64(543245.2245), "bool", true, "array", types.MustNewArray(int32(1), "2"), "nested_docu", types.MustMakeDocument("inside", "array"), "objID", types.ObjectID{98, 226, 189, 84, 81, 6, 131, 249, 192, 187, 13, 107}, "null", nil), "null_value", nil, "nested.field", "value", "nested.field.array.2", int32(12))))
+		updateSQL, updateArgs, notWhereSQL, notWhereArgs, err := update(types.MustMakeDocument("$set", types.MustMakeDocument("str_value", "value", "int32_value", int32(123), "int64_value", int64(223372036854775807), "float64_value", 64534.12432, "bool_value", true, "objID_value", types.ObjectID{98, 226, 189, 84, 81, 6, 131, 249, 192, 187, 13, 107}, "document_value", types.MustMakeDocument("string", "value", "int32", int32(2), "int64", int64(4543654563), "float", float64(543245.2245), "bool", true, "array", types.MustNewArray(int32(1), "2"), "nested_docu", types.MustMakeDocument("inside", "array"), "objID", types.ObjectID{98, 226, 189, 84, 81, 6, 131, 249, 192, 187, 13, 107}, "null", nil), "null_value", nil, "nested.field", "value", "nested.field.array.2", int32(12))))
 
-		assert.Equal(t, " SET \"str_value\" = 'value', \"int32_value\" = 123, \"int64_value\" = 223372036854775807, \"float64_value\" = 64534.124320, \"bool_value\" = to_json_boolean(true), \"objID_value\" = {\"oid\":'62e2bd54510683f9c0bb0d6b'}, \"document_value\" = {\"string\": 'value', \"int32\": 2, \"int64\": 4543654563, \"float\": 543245.224500, \"bool\": to_json_boolean(true), \"array\": [1, '2'], \"nested_docu\": {\"inside\": 'array'}, \"objID\": {\"oid\":'62e2bd54510683f9c0bb0d6b'}, \"null\":  NULL }, \"null_value\" = NULL, \"nested\".\"field\" = 'value', \"nested\".\"field\".\"array\"[3] = 12", updateSQL)
-		assert.Equal(t, " AND ( NOT (   \"str_value\" = 'value' AND \"int32_value\" = 123 AND \"int64_value\" = 223372036854775807 AND \"float64_value\" = 64534.124320 AND \"bool_value\" = to_json_boolean(true) AND \"objID_value\" = {\"oid\":'62e2bd54510683f9c0bb0d6b'} AND \"document_value\" = {\"string\": 'value', \"int32\": 2, \"int64\": 4543654563, \"float\": 543245.224500, \"bool\": to_json_boolean(true), \"array\": [1, '2'], \"nested_docu\": {\"inside\": 'array'}, \"objID\": {\"oid\":'62e2bd54510683f9c0bb0d6b'}, \"null\":  NULL } AND \"null_value\" IS NULL AND \"nested\".\"field\" = 'value' AND \"nested\".\"field\".\"array\"[3] = 12) OR (\"str_value\" IS UNSET OR \"int32_value\" IS UNSET OR \"int64_value\" IS UNSET OR \"float64_value\" IS UNSET OR \"bool_value\" IS UNSET OR \"objID_value\" IS UNSET OR \"document_value\" IS UNSET OR \"null_value\" IS UNSET OR \"nested\".\"field\" IS UNSET OR \"nested\".\"field\".\"array\"[3] IS UNSET )) ", notWhereSQL)
+		assert.Equal(t, ` SET "str_value" = ?, "int32_value" = ?, "int64_value" = ?, "float64_value" = ?, "bool_value" = to_json_boolean(?), "objID_value" = {"oid": ?}, "document_value" = {"string": ?, "int32": ?, "int64": ?, "float": ?, "bool": to_json_boolean(?), "array": [?, ?], "nested_docu": {"inside": ?}, "objID": {"oid": ?}, "null":  NULL }, "null_value" = NULL, "nested"."field" = ?, "nested"."field"."array"[3] = ?`, updateSQL)
+		expectedArgs := []any{
+			"value", int32(123), int64(223372036854775807), 64534.12432, true, "62e2bd54510683f9c0bb0d6b",
+			"value", int32(2), int64(4543654563), 543245.2245, true, int32(1), "2", "array", "62e2bd54510683f9c0bb0d6b",
+			"value", int32(12),
+		}
+		assert.Equal(t, expectedArgs, updateArgs)
+		assert.Equal(t, ` AND ( NOT (   "str_value" = ? AND "int32_value" = ? AND "int64_value" = ? AND "float64_value" = ? AND "bool_value" = to_json_boolean(?) AND "objID_value" = {"oid": ?} AND "document_value" = {"string": ?, "int32": ?, "int64": ?, "float": ?, "bool": to_json_boolean(?), "array": [?, ?], "nested_docu": {"inside": ?}, "objID": {"oid": ?}, "null":  NULL } AND ("null_value" IS NULL OR "null_value" IS UNSET) AND "nested"."field" = ? AND "nested"."field"."array"[3] = ?) OR ("str_value" IS UNSET OR "int32_value" IS UNSET OR "int64_value" IS UNSET OR "float64_value" IS UNSET OR "bool_value" IS UNSET OR "objID_value" IS UNSET OR "document_value" IS UNSET OR "null_value" IS UNSET OR "nested"."field" IS UNSET OR "nested"."field"."array"[3] IS UNSET )) `, notWhereSQL)
+		assert.Equal(t, expectedArgs, notWhereArgs)
 		assert.Nil(t, err)
 
-		updateSQL, notWhereSQL, err = update(types.MustMakeDocument("$set", types.MustMakeDocument("array", types.MustNewArray(int32(1), "2"))))
+		updateSQL, updateArgs, notWhereSQL, notWhereArgs, err = update(types.MustMakeDocument("$set", types.MustMakeDocument("array", types.MustNewArray(int32(1), "2"))))
 
-		assert.Equal(t, " SET \"array\" = [1, '2']", updateSQL)
-		assert.Equal(t, " WHERE ", notWhereSQL)
+		assert.Equal(t, ` SET "array" = [?, ?]`, updateSQL)
+		assert.Equal(t, []any{int32(1), "2"}, updateArgs)
+		assert.Empty(t, notWhereSQL)
+		assert.Empty(t, notWhereArgs)
 		assert.EqualError(t, err, "NotImplemented (238): cannot update a field with array")
 
-		updateSQL, notWhereSQL, err = update(types.MustMakeDocument("$set", types.MustMakeDocument("_id", types.ObjectID{98, 226, 189, 84, 81, 6, 131, 249, 192, 187, 13, 107})))
+		updateSQL, updateArgs, notWhereSQL, notWhereArgs, err = update(types.MustMakeDocument("$set", types.MustMakeDocument("_id", types.ObjectID{98, 226, 189, 84, 81, 6, 131, 249, 192, 187, 13, 107})))
 
 		assert.Equal(t, " SET ", updateSQL)
+		assert.Empty(t, updateArgs)
 		assert.Equal(t, "", notWhereSQL)
+		assert.Empty(t, notWhereArgs)
 		assert.EqualError(t, err, `performing an update on the path '_id' would modify the immutable field '_id'`)
 
-		updateSQL, notWhereSQL, err = update(types.MustMakeDocument("$set", types.MustMakeDocument("array.2.3", types.ObjectID{98, 226, 189, 84, 81, 6, 131, 249, 192, 187, 13, 107})))
+		updateSQL, updateArgs, notWhereSQL, notWhereArgs, err = update(types.MustMakeDocument("$set", types.MustMakeDocument("array.2.3", types.ObjectID{98, 226, 189, 84, 81, 6, 131, 249, 192, 187, 13, 107})))
 
 		assert.Equal(t, " SET ", updateSQL)
+		assert.Empty(t, updateArgs)
 		assert.Equal(t, "", notWhereSQL)
+		assert.Empty(t, notWhereArgs)
 		assert.ErrorContains(t, err, "NotImplemented (238): not yet supporting indexing on an array inside of an array")
 
-		updateSQL, notWhereSQL, err = update(types.MustMakeDocument("$set", types.MustMakeDocument("unsupported value", types.Binary{Subtype: types.BinarySubtype(byte(12)), B: []byte("hello")})))
+		updateSQL, updateArgs, notWhereSQL, notWhereArgs, err = update(types.MustMakeDocument("$set", types.MustMakeDocument("unsupported value", types.Binary{Subtype: types.BinarySubtype(byte(12)), B: []byte("hello")})))
 
 		assert.Equal(t, " SET ", updateSQL)
+		assert.Empty(t, updateArgs)
 		assert.Equal(t, "", notWhereSQL)
+		assert.Empty(t, notWhereArgs)
 		assert.ErrorContains(t, err, "Value: types.Binary is not supported for update")
 	})
 
 	t.Run("unset fields with supported and unsupported values", func(t *testing.T) {
 		t.Parallel()
 
-		updateSQL, notWhereSQL, err := update(types.MustMakeDocument("$unset", types.MustMakeDocument("field1", "", "field2", int32(123))))
+		updateSQL, updateArgs, notWhereSQL, notWhereArgs, err := update(types.MustMakeDocument("$unset", types.MustMakeDocument("field1", "", "field2", int32(123))))
 
-		assert.Equal(t, " UNSET \"field1\", \"field2\"", updateSQL)
-		assert.Equal(t, " AND ( \"field1\" IS SET OR \"field2\" IS SET )", notWhereSQL)
+		assert.Equal(t, ` UNSET "field1", "field2"`, updateSQL)
+		assert.Empty(t, updateArgs)
+		assert.Equal(t, ` AND ( "field1" IS SET OR "field2" IS SET )`, notWhereSQL)
+		assert.Empty(t, notWhereArgs)
 		assert.Nil(t, err)
 
-		updateSQL, notWhereSQL, err = update(types.MustMakeDocument("$unset", types.MustMakeDocument("_id", "")))
+		updateSQL, updateArgs, notWhereSQL, notWhereArgs, err = update(types.MustMakeDocument("$unset", types.MustMakeDocument("_id", "")))
 
 		assert.Equal(t, "", updateSQL)
+		assert.Empty(t, updateArgs)
 		assert.Equal(t, "", notWhereSQL)
+		assert.Empty(t, notWhereArgs)
 		assert.EqualError(t, err, `performing an update on the path '_id' would modify the immutable field '_id'`)
 	})
 
 	t.Run("unset and unset fields with supported and unsupported values", func(t *testing.T) {
 		t.Parallel()
 
-		updateSQL, notWhereSQL, err := update(types.MustMakeDocument("$unset", types.MustMakeDocument("field1", "", "field2", int32(123)), "$set", types.MustMakeDocument("field3", int32(123))))
+		updateSQL, updateArgs, notWhereSQL, notWhereArgs, err := update(types.MustMakeDocument("$unset", types.MustMakeDocument("field1", "", "field2", int32(123)), "$set", types.MustMakeDocument("field3", int32(123))))
 
-		assert.Equal(t, " SET \"field3\" = 123,  UNSET \"field1\", \"field2\"", updateSQL)
-		assert.Equal(t, " AND ( NOT (   \"field3\" = 123) OR (\"field3\" IS UNSET ) OR ( \"field1\" IS SET OR \"field2\" IS SET ))", notWhereSQL)
+		assert.Equal(t, ` SET "field3" = ?,  UNSET "field1", "field2"`, updateSQL)
+		assert.Equal(t, []any{int32(123)}, updateArgs)
+		assert.Equal(t, ` AND ( NOT (   "field3" = ?) OR ("field3" IS UNSET ) OR ( "field1" IS SET OR "field2" IS SET ))`, notWhereSQL)
+		assert.Equal(t, []any{int32(123)}, notWhereArgs)
 		assert.Nil(t, err)
 
-		updateSQL, notWhereSQL, err = update(types.MustMakeDocument("$unset", types.MustMakeDocument("_id", ""), "$set", types.MustMakeDocument("field", "value")))
+		updateSQL, updateArgs, notWhereSQL, notWhereArgs, err = update(types.MustMakeDocument("$unset", types.MustMakeDocument("_id", ""), "$set", types.MustMakeDocument("field", "value")))
 
-		assert.Equal(t, " SET \"field\" = 'value'", updateSQL)
+		assert.Equal(t, ` SET "field" = ?`, updateSQL)
+		assert.Equal(t, []any{"value"}, updateArgs)
 		assert.Equal(t, "", notWhereSQL)
+		assert.Empty(t, notWhereArgs)
 		assert.EqualError(t, err, `performing an update on the path '_id' would modify the immutable field '_id'`)
 
-		updateSQL, notWhereSQL, err = update(types.MustMakeDocument("$unset", types.MustMakeDocument("field1", ""), "$set", types.MustMakeDocument("array", types.MustNewArray(int32(1), "2"))))
+		updateSQL, updateArgs, notWhereSQL, notWhereArgs, err = update(types.MustMakeDocument("$unset", types.MustMakeDocument("field1", ""), "$set", types.MustMakeDocument("array", types.MustNewArray(int32(1), "2"))))
 
-		assert.Equal(t, " SET \"array\" = [1, '2']", updateSQL)
-		assert.Equal(t, " WHERE ", notWhereSQL)
+		assert.Equal(t, ` SET "array" = [?, ?]`, updateSQL)
+		assert.Equal(t, []any{int32(1), "2"}, updateArgs)
+		assert.Empty(t, notWhereSQL)
+		assert.Empty(t, notWhereArgs)
 		assert.EqualError(t, err, "NotImplemented (238): cannot update a field with array")
 	})
 }
@@ -0,0 +1,147 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/bson"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+)
+
+// retryableWrite runs the write command f. A command with lsid and txnNumber outside of a transaction
+// is a retryable write: f runs in one SAP HANA transaction which also records the reply,
+// and a retried command returns the recorded reply without running f again.
+func (h *storage) retryableWrite(
+	ctx context.Context, msg *wire.OpMsg, f func(context.Context, *wire.OpMsg) (*wire.OpMsg, error),
+) (*wire.OpMsg, error) {
+	document, err := msg.Document()
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	params, err := getRetryableWriteParams(document)
+	if err != nil {
+		return nil, err
+	}
+	if params == nil {
+		return f(ctx, msg)
+	}
+
+	params.owner = common.Owner(ctx)
+
+	if err = h.sessions.createRetryableWritesTable(ctx, h.hanaPool); err != nil {
+		return nil, err
+	}
+
+	txn, err := h.sessions.checkoutRetryable(h.hanaPool, params)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = context.WithValue(ctx, txKey{}, txn)
+
+	reply, err := recordedReply(ctx, txn.tx, params)
+	if err != nil || reply != nil {
+		// nothing is written for a retried write
+		_ = h.sessions.finish(params, txn, false)
+		return reply, err
+	}
+
+	reply, err = f(ctx, msg)
+	if err == nil {
+		err = recordReply(ctx, txn.tx, params, reply)
+	}
+	if err != nil {
+		_ = h.sessions.finish(params, txn, false)
+		return nil, err
+	}
+
+	if err = h.sessions.finish(params, txn, true); err != nil {
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+// getRetryableWriteParams returns the session and the transaction number of a retryable write
+// or nil if the command is not one.
+func getRetryableWriteParams(document types.Document) (*transactionParams, error) {
+	if _, ok := document.Map()["autocommit"]; ok {
+		return nil, nil
+	}
+
+	txnNumber, ok, err := getTxnNumber(document)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	sessionID, err := getSessionID(document)
+	if err != nil {
+		return nil, err
+	}
+	if sessionID == "" {
+		return nil, common.NewErrorMessage(common.ErrInvalidOptions, "Transaction numbers are only allowed in a session")
+	}
+
+	return &transactionParams{sessionID: sessionID, txnNumber: txnNumber}, nil
+}
+
+// recordedReply returns the recorded reply of the retryable write or nil if it has not been recorded.
+func recordedReply(ctx context.Context, q querier, params *transactionParams) (*wire.OpMsg, error) {
+	var b []byte
+	query := "SELECT REPLY FROM " + hana.RetryableWritesTable + " WHERE LSID = $1 AND TXN_NUMBER = $2"
+	if err := q.QueryRowContext(ctx, query, sessionKey(params.owner, params.sessionID), params.txnNumber).Scan(&b); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, lazyerrors.Error(err)
+	}
+
+	var doc bson.Document
+	if err := doc.ReadFrom(bufio.NewReader(bytes.NewReader(b))); err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	d, err := types.ConvertDocument(&doc)
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	var reply wire.OpMsg
+	if err = reply.SetSections(wire.OpMsgSection{Documents: []types.Document{d}}); err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	return &reply, nil
+}
+
+// recordReply records the reply of the retryable write.
+func recordReply(ctx context.Context, q querier, params *transactionParams, reply *wire.OpMsg) error {
+	doc, err := reply.Document()
+	if err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	b, err := bson.MustConvertDocument(doc).MarshalBinary()
+	if err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	query := "INSERT INTO " + hana.RetryableWritesTable + " VALUES ($1, $2, $3, CURRENT_UTCTIMESTAMP)"
+	if _, err = q.ExecContext(ctx, query, sessionKey(params.owner, params.sessionID), params.txnNumber, b); err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/bson"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// retryableInsertMsg returns an insert of one document as retryable write txnNumber of session id.
+func retryableInsertMsg(t *testing.T, id byte, txnNumber int64) *wire.OpMsg {
+	t.Helper()
+
+	var msg wire.OpMsg
+	err := msg.SetSections(wire.OpMsgSection{
+		Documents: []types.Document{types.MustMakeDocument(
+			"insert", "testCollection",
+			"documents", types.MustNewArray(types.MustMakeDocument("_id", int32(1))),
+			"lsid", types.MustMakeDocument("id", types.Binary{Subtype: types.BinaryUUID, B: []byte{id}}),
+			"txnNumber", txnNumber,
+			"$db", "testDatabase",
+		)},
+	})
+	require.NoError(t, err)
+
+	return &msg
+}
+
+func TestRetryableWrite(t *testing.T) {
+	ctx, storage, mock, err := setupTestUtil(t)
+	require.NoError(t, err)
+
+	expected := types.MustMakeDocument(
+		"n", int32(1),
+		"ok", float64(1),
+	)
+	recorded, err := bson.MustConvertDocument(expected).MarshalBinary()
+	require.NoError(t, err)
+
+	selectReply := "SELECT REPLY FROM MONGODB_RETRYABLE_WRITES WHERE LSID = $1 AND TXN_NUMBER = $2"
+
+	t.Run("first attempt is executed and recorded", func(t *testing.T) {
+		mock.ExpectQuery("SELECT COUNT(*) FROM SYS.TABLES WHERE SCHEMA_NAME = CURRENT_SCHEMA").
+			WillReturnRows(sqlmock.NewRows([]string{"COUNT"}).AddRow(1))
+		mock.ExpectBegin()
+		mock.ExpectQuery(selectReply).WithArgs("01", int64(1)).WillReturnError(sql.ErrNoRows)
+		mock.ExpectExec("SAVEPOINT MONGODB_COMMAND").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectPrepare(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).ExpectExec().
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("RELEASE SAVEPOINT MONGODB_COMMAND").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO MONGODB_RETRYABLE_WRITES VALUES ($1, $2, $3, CURRENT_UTCTIMESTAMP)").
+			WithArgs("01", int64(1), recorded).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		msg, err := storage.MsgInsert(ctx, retryableInsertMsg(t, 1, 1))
+		require.NoError(t, err)
+
+		actual, err := msg.Document()
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("retry returns recorded reply", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(selectReply).WithArgs("01", int64(1)).
+			WillReturnRows(sqlmock.NewRows([]string{"REPLY"}).AddRow(recorded))
+		mock.ExpectRollback()
+
+		msg, err := storage.MsgInsert(ctx, retryableInsertMsg(t, 1, 1))
+		require.NoError(t, err)
+
+		actual, err := msg.Document()
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("failed write is not recorded", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(selectReply).WithArgs("01", int64(2)).WillReturnError(sql.ErrNoRows)
+		mock.ExpectExec("SAVEPOINT MONGODB_COMMAND").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectPrepare(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).ExpectExec().WillReturnError(assert.AnError)
+		mock.ExpectExec("ROLLBACK TO SAVEPOINT MONGODB_COMMAND").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		_, err := storage.MsgInsert(ctx, retryableInsertMsg(t, 1, 2))
+		require.Error(t, err)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("older txnNumber", func(t *testing.T) {
+		_, err := storage.MsgInsert(ctx, retryableInsertMsg(t, 1, 1))
+		expected := common.NewErrorMessage(
+			common.ErrTransactionTooOld,
+			"Retryable write with txnNumber 1 is prohibited on session 01 because a newer retryable write with txnNumber 2 has already started",
+		)
+		assert.Equal(t, expected, err)
+	})
+
+	t.Run("session of another user", func(t *testing.T) {
+		// the same lsid of another user is another session with its own recorded writes
+		key := sessionKey("other@admin", "01")
+		mock.ExpectBegin()
+		mock.ExpectQuery(selectReply).WithArgs(key, int64(1)).WillReturnError(sql.ErrNoRows)
+		mock.ExpectExec("SAVEPOINT MONGODB_COMMAND").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectPrepare(`INSERT INTO "TESTDATABASE"."TESTCOLLECTION" VALUES (?)`).ExpectExec().
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("RELEASE SAVEPOINT MONGODB_COMMAND").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO MONGODB_RETRYABLE_WRITES VALUES ($1, $2, $3, CURRENT_UTCTIMESTAMP)").
+			WithArgs(key, int64(1), recorded).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		_, err := storage.MsgInsert(common.WithOwner(ctx, "other@admin"), retryableInsertMsg(t, 1, 1))
+		require.NoError(t, err)
+		assert.Len(t, key, 64)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,766 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package crud
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+)
+
+const (
+	// DefaultTransactionTimeout is the time after which an idle transaction is aborted.
+	// Same as transactionLifetimeLimitSeconds of MongoDB.
+	DefaultTransactionTimeout = time.Minute
+
+	// SessionTimeout is the time after which an idle logical session expires.
+	// Advertised to clients as logicalSessionTimeoutMinutes, same as in MongoDB.
+	SessionTimeout = 30 * time.Minute
+
+	// beginTimeout is the time after which beginning a SAP HANA transaction fails.
+	beginTimeout = 30 * time.Second
+
+	// commandSavepoint is the savepoint set before each command within a transaction,
+	// so that a failing command does not leave partial changes in the transaction.
+	commandSavepoint = "MONGODB_COMMAND"
+)
+
+// session is a logical session of a client. Drivers send its id as lsid with every command.
+type session struct {
+	owner     string       // authenticated user which started the session, empty if authentication is disabled
+	txnNumber int64        // highest transaction number used in the session, -1 if none
+	committed int64        // number of the last committed transaction, -1 if none
+	txn       *transaction // open transaction, nil if none
+	lastUsed  time.Time
+}
+
+// transaction is a multi-statement transaction of a logical session. It pins one SAP HANA transaction.
+type transaction struct {
+	number   int64
+	tx       *sql.Tx // nil while it is begun
+	db       *sql.DB // pool of the SAP HANA user which began the transaction
+	inUse    bool
+	killed   bool // the session was ended while a command was running, rolled back on checkin
+	lastUsed time.Time
+}
+
+// transactionParams holds the fields of a command which is part of a transaction.
+type transactionParams struct {
+	owner     string // authenticated user running the command
+	sessionID string
+	txnNumber int64
+	start     bool
+}
+
+// sessionKey returns the key of the session in the registry and in the table of retryable writes.
+// Like the uid of a MongoDB logical session id, the authenticated user is part of it,
+// so that clients cannot use or end the sessions of other users by sending their lsid.
+// The key is at most 64 characters long.
+func sessionKey(owner, id string) string {
+	if owner == "" {
+		return id
+	}
+
+	sum := sha256.Sum256([]byte(owner + "\x00" + id))
+	return hex.EncodeToString(sum[:])
+}
+
+// Sessions is a registry of logical sessions and their transactions shared by all client connections.
+type Sessions struct {
+	mu       sync.Mutex
+	sessions map[string]*session // by sessionKey
+	timeout  time.Duration       // of transactions
+	l        *zap.Logger
+
+	// pools of the SAP HANA users in whose current schema the table of retryable writes exists
+	tableMu sync.Mutex
+	tables  map[*sql.DB]*hana.Hpool
+}
+
+// NewSessions creates a new session registry. Idle transactions are aborted after the given timeout,
+// idle sessions expire after SessionTimeout.
+func NewSessions(timeout time.Duration, l *zap.Logger) *Sessions {
+	if timeout <= 0 {
+		timeout = DefaultTransactionTimeout
+	}
+
+	return &Sessions{
+		sessions: make(map[string]*session),
+		timeout:  timeout,
+		l:        l,
+		tables:   make(map[*sql.DB]*hana.Hpool),
+	}
+}
+
+// Run aborts idle transactions, removes expired sessions and deletes the retryable writes
+// of expired sessions until ctx is canceled. Then all remaining transactions are aborted.
+func (s *Sessions) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.timeout / 10)
+	defer ticker.Stop()
+
+	writesTicker := time.NewTicker(SessionTimeout / 10)
+	defer writesTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.killAll()
+			return
+		case now := <-ticker.C:
+			s.reap(now)
+		case <-writesTicker.C:
+			s.deleteExpiredWrites(ctx)
+		}
+	}
+}
+
+// deleteExpiredWrites deletes the recorded retryable writes which are older than SessionTimeout.
+func (s *Sessions) deleteExpiredWrites(ctx context.Context) {
+	s.tableMu.Lock()
+	pools := make([]*hana.Hpool, 0, len(s.tables))
+	for _, hanaPool := range s.tables {
+		pools = append(pools, hanaPool)
+	}
+	s.tableMu.Unlock()
+
+	for _, hanaPool := range pools {
+		if err := hanaPool.DeleteExpiredRetryableWrites(ctx, SessionTimeout); err != nil {
+			s.l.Warn("Failed to delete expired retryable writes.", zap.Error(err))
+		}
+	}
+}
+
+// createRetryableWritesTable creates the table of retryable writes in the current schema
+// of the SAP HANA user of the pool on first use.
+func (s *Sessions) createRetryableWritesTable(ctx context.Context, hanaPool *hana.Hpool) error {
+	s.tableMu.Lock()
+	defer s.tableMu.Unlock()
+
+	if _, ok := s.tables[hanaPool.DB]; ok {
+		return nil
+	}
+
+	if err := hanaPool.CreateRetryableWritesTable(ctx); err != nil {
+		return err
+	}
+
+	s.tables[hanaPool.DB] = &hana.Hpool{DB: hanaPool.DB}
+
+	return nil
+}
+
+// reap aborts all transactions which have been idle for longer than the timeout
+// and removes all sessions which have been idle for longer than SessionTimeout.
+func (s *Sessions) reap(now time.Time) {
+	var aborted []*sql.Tx
+
+	s.mu.Lock()
+
+	for id, sess := range s.sessions {
+		if txn := sess.txn; txn != nil && !txn.inUse && now.Sub(txn.lastUsed) >= s.timeout {
+			s.l.Debug("Aborting idle transaction.", zap.String("session", id), zap.Int64("txnNumber", txn.number))
+			aborted = append(aborted, txn.tx)
+			sess.txn = nil
+		}
+
+		if sess.txn == nil && now.Sub(sess.lastUsed) >= SessionTimeout {
+			s.l.Debug("Removing expired session.", zap.String("session", id))
+			delete(s.sessions, id)
+		}
+	}
+
+	s.mu.Unlock()
+
+	rollback(aborted)
+}
+
+// killAll ends all sessions and aborts their transactions.
+func (s *Sessions) killAll() {
+	var aborted []*sql.Tx
+
+	s.mu.Lock()
+	for key := range s.sessions {
+		aborted = s.kill(key, aborted)
+	}
+	s.mu.Unlock()
+
+	rollback(aborted)
+}
+
+// killOwned ends all sessions of the owner and aborts their transactions.
+func (s *Sessions) killOwned(owner string) {
+	var aborted []*sql.Tx
+
+	s.mu.Lock()
+	for key, sess := range s.sessions {
+		if sess.owner == owner {
+			aborted = s.kill(key, aborted)
+		}
+	}
+	s.mu.Unlock()
+
+	rollback(aborted)
+}
+
+// kill ends the session with the given key and appends its transaction to aborted,
+// which the caller rolls back after releasing the lock. A transaction running a command is aborted on checkin.
+// It must be called with the lock held.
+func (s *Sessions) kill(key string, aborted []*sql.Tx) []*sql.Tx {
+	sess, ok := s.sessions[key]
+	if !ok {
+		return aborted
+	}
+
+	if txn := sess.txn; txn != nil {
+		if txn.inUse {
+			txn.killed = true
+		} else {
+			aborted = append(aborted, txn.tx)
+		}
+	}
+
+	delete(s.sessions, key)
+
+	return aborted
+}
+
+// rollback rolls back the transactions taken from the registry.
+// It is called without the lock, so that a slow SAP HANA connection does not block other sessions.
+func rollback(aborted []*sql.Tx) {
+	for _, tx := range aborted {
+		_ = tx.Rollback()
+	}
+}
+
+// end ends the given sessions of the owner.
+func (s *Sessions) end(owner string, ids []string) {
+	var aborted []*sql.Tx
+
+	s.mu.Lock()
+	for _, id := range ids {
+		aborted = s.kill(sessionKey(owner, id), aborted)
+	}
+	s.mu.Unlock()
+
+	rollback(aborted)
+}
+
+// refresh marks the given sessions of the owner as used, so that they do not expire. Unknown sessions are started.
+func (s *Sessions) refresh(owner string, ids []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		s.touch(owner, id)
+	}
+}
+
+// touch returns the session of the owner with the given id and marks it as used.
+// The session is started if it does not exist. It must be called with the lock held.
+func (s *Sessions) touch(owner, id string) *session {
+	key := sessionKey(owner, id)
+	sess, ok := s.sessions[key]
+	if !ok {
+		sess = &session{owner: owner, txnNumber: -1, committed: -1}
+		s.sessions[key] = sess
+	}
+
+	sess.lastUsed = time.Now()
+
+	return sess
+}
+
+// checkout returns the transaction of the command and marks it as being in use.
+// If the command starts the transaction, an SAP HANA transaction is begun and an older transaction of the session is aborted.
+func (s *Sessions) checkout(hanaPool *hana.Hpool, params *transactionParams) (*transaction, error) {
+	s.mu.Lock()
+
+	txn, aborted, err := s.checkoutLocked(hanaPool, params)
+	s.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if txn.tx != nil {
+		return txn, nil
+	}
+
+	return s.begin(hanaPool, txn, aborted)
+}
+
+// checkoutLocked returns the transaction of the command for checkout. If the command starts the transaction,
+// it returns a new transaction which still has to be begun and the aborted older transaction, if any.
+// It must be called with the lock held.
+func (s *Sessions) checkoutLocked(hanaPool *hana.Hpool, params *transactionParams) (*transaction, *sql.Tx, error) {
+	sess := s.touch(params.owner, params.sessionID)
+	txn := sess.txn
+
+	if sess.txnNumber > params.txnNumber {
+		return nil, nil, common.NewErrorMessage(
+			common.ErrTransactionTooOld,
+			"Cannot start transaction %d on session %s because a newer transaction %d has already started",
+			params.txnNumber, params.sessionID, sess.txnNumber,
+		)
+	}
+
+	if !params.start {
+		if txn == nil || txn.number != params.txnNumber {
+			return nil, nil, common.NewErrorMessage(
+				common.ErrNoSuchTransaction, "Given transaction number %d does not match any in-progress transactions", params.txnNumber,
+			)
+		}
+
+		if txn.db != hanaPool.DB {
+			return nil, nil, common.NewErrorMessage(
+				common.ErrUnauthorized, "Transaction %d of session %s was started by another user", params.txnNumber, params.sessionID,
+			)
+		}
+
+		if txn.inUse {
+			return nil, nil, common.NewErrorMessage(
+				common.ErrConflictingOperation, "Cannot run a command in transaction %d while another command of it is running", params.txnNumber,
+			)
+		}
+
+		txn.inUse = true
+		return txn, nil, nil
+	}
+
+	if sess.txnNumber == params.txnNumber {
+		return nil, nil, common.NewErrorMessage(
+			common.ErrConflictingOperation, "Transaction %d has already been started", params.txnNumber,
+		)
+	}
+
+	var aborted *sql.Tx
+	if txn != nil {
+		if txn.inUse {
+			return nil, nil, common.NewErrorMessage(
+				common.ErrConflictingOperation, "Cannot start transaction %d while transaction %d is running a command", params.txnNumber, txn.number,
+			)
+		}
+
+		aborted = txn.tx
+	}
+
+	return s.reserve(hanaPool, sess, params.txnNumber), aborted, nil
+}
+
+// checkoutRetryable begins the transaction of a retryable write and marks it as being in use.
+// A retried write has the same transaction number as the last write of the session.
+func (s *Sessions) checkoutRetryable(hanaPool *hana.Hpool, params *transactionParams) (*transaction, error) {
+	s.mu.Lock()
+
+	sess := s.touch(params.owner, params.sessionID)
+
+	if sess.txnNumber > params.txnNumber {
+		s.mu.Unlock()
+		return nil, common.NewErrorMessage(
+			common.ErrTransactionTooOld,
+			"Retryable write with txnNumber %d is prohibited on session %s because a newer retryable write with txnNumber %d has already started",
+			params.txnNumber, params.sessionID, sess.txnNumber,
+		)
+	}
+
+	var aborted *sql.Tx
+	if txn := sess.txn; txn != nil {
+		if txn.inUse {
+			s.mu.Unlock()
+			return nil, common.NewErrorMessage(
+				common.ErrConflictingOperation, "Cannot run retryable write %d while transaction %d is running a command", params.txnNumber, txn.number,
+			)
+		}
+
+		aborted = txn.tx
+	}
+
+	txn := s.reserve(hanaPool, sess, params.txnNumber)
+	s.mu.Unlock()
+
+	return s.begin(hanaPool, txn, aborted)
+}
+
+// reserve replaces the transaction of the session by a new one, which is marked as being in use
+// until it is begun. It must be called with the lock held.
+func (s *Sessions) reserve(hanaPool *hana.Hpool, sess *session, txnNumber int64) *transaction {
+	txn := &transaction{
+		number: txnNumber,
+		db:     hanaPool.DB,
+		inUse:  true,
+	}
+	sess.txn = txn
+	sess.txnNumber = txnNumber
+
+	return txn
+}
+
+// begin rolls back the aborted older transaction of the session, if any, and begins the reserved transaction
+// as SAP HANA transaction with the pool of the client. It is called without the lock.
+func (s *Sessions) begin(hanaPool *hana.Hpool, txn *transaction, aborted *sql.Tx) (*transaction, error) {
+	if aborted != nil {
+		_ = aborted.Rollback()
+	}
+
+	tx, err := beginWithTimeout(hanaPool)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		// the session may have been ended meanwhile
+		s.removeTransaction(txn)
+		return nil, err
+	}
+
+	if txn.killed {
+		_ = tx.Rollback()
+		return nil, common.NewErrorMessage(common.ErrNoSuchTransaction, "Transaction %d has been aborted by ending the session", txn.number)
+	}
+
+	txn.tx = tx
+
+	return txn, nil
+}
+
+// removeTransaction removes the transaction from its session, if it still belongs to one.
+// It must be called with the lock held.
+func (s *Sessions) removeTransaction(txn *transaction) {
+	for _, sess := range s.sessions {
+		if sess.txn == txn {
+			sess.txn = nil
+			return
+		}
+	}
+}
+
+// beginWithTimeout begins a SAP HANA transaction which fails after beginTimeout.
+// The transaction outlives the command and the connection, so it must not be bound to the context of the command,
+// and its context must not be canceled once it is begun.
+func beginWithTimeout(hanaPool *hana.Hpool) (*sql.Tx, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	timer := time.AfterFunc(beginTimeout, cancel)
+
+	tx, err := hanaPool.BeginTx(ctx, nil)
+
+	if !timer.Stop() {
+		if err == nil {
+			_ = tx.Rollback()
+		}
+
+		return nil, lazyerrors.Errorf("beginning the transaction timed out after %s", beginTimeout)
+	}
+
+	if err != nil {
+		cancel()
+		return nil, lazyerrors.Error(err)
+	}
+
+	return tx, nil
+}
+
+// checkin returns the transaction to the registry after a command.
+func (s *Sessions) checkin(txn *transaction) {
+	s.mu.Lock()
+
+	if txn.killed {
+		s.mu.Unlock()
+		_ = txn.tx.Rollback()
+		return
+	}
+
+	txn.inUse = false
+	txn.lastUsed = time.Now()
+
+	s.mu.Unlock()
+}
+
+// endTransaction removes the transaction with the given number from its session
+// and commits or rolls back its SAP HANA transaction. Committing the last committed transaction
+// of the session again succeeds, so that drivers can retry commitTransaction.
+func (s *Sessions) endTransaction(params *transactionParams, commit bool) error {
+	s.mu.Lock()
+
+	sess := s.touch(params.owner, params.sessionID)
+	txn := sess.txn
+	if txn == nil || txn.number != params.txnNumber {
+		committed := commit && sess.committed == params.txnNumber && sess.txnNumber == params.txnNumber
+		s.mu.Unlock()
+
+		if committed {
+			return nil
+		}
+
+		return common.NewErrorMessage(
+			common.ErrNoSuchTransaction, "Given transaction number %d does not match any in-progress transactions", params.txnNumber,
+		)
+	}
+
+	if txn.inUse {
+		s.mu.Unlock()
+		return common.NewErrorMessage(
+			common.ErrConflictingOperation, "Cannot end transaction %d while it is running a command", params.txnNumber,
+		)
+	}
+
+	sess.txn = nil
+	s.mu.Unlock()
+
+	if err := txn.end(commit); err != nil {
+		return err
+	}
+
+	if commit {
+		s.mu.Lock()
+		sess.committed = txn.number
+		s.mu.Unlock()
+	}
+
+	return nil
+}
+
+// finish removes the transaction, which is checked out, from its session and commits or rolls it back.
+func (s *Sessions) finish(params *transactionParams, txn *transaction, commit bool) error {
+	s.mu.Lock()
+
+	if txn.killed {
+		s.mu.Unlock()
+		_ = txn.tx.Rollback()
+		return common.NewErrorMessage(common.ErrNoSuchTransaction, "Transaction %d has been aborted by ending the session", txn.number)
+	}
+
+	if sess := s.sessions[sessionKey(params.owner, params.sessionID)]; sess != nil && sess.txn == txn {
+		sess.txn = nil
+	}
+	s.mu.Unlock()
+
+	return txn.end(commit)
+}
+
+// end commits or rolls back the SAP HANA transaction.
+func (txn *transaction) end(commit bool) error {
+	var err error
+	if commit {
+		err = txn.tx.Commit()
+	} else {
+		err = txn.tx.Rollback()
+	}
+
+	if err != nil {
+		return lazyerrors.Error(err)
+	}
+
+	return nil
+}
+
+// getTransactionParams returns the transaction fields of the command or nil if the command is not part of a transaction.
+func getTransactionParams(document types.Document) (*transactionParams, error) {
+	m := document.Map()
+
+	autocommit, ok := m["autocommit"]
+	if !ok {
+		return nil, nil
+	}
+
+	if autocommit != false {
+		return nil, common.NewErrorMessage(common.ErrInvalidOptions, "autocommit field can only be specified as false")
+	}
+
+	sessionID, err := getSessionID(document)
+	if err != nil {
+		return nil, err
+	}
+	if sessionID == "" {
+		return nil, common.NewErrorMessage(common.ErrInvalidOptions, "Transaction numbers are only allowed in a session")
+	}
+
+	params := &transactionParams{sessionID: sessionID}
+
+	if params.txnNumber, ok, err = getTxnNumber(document); err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, common.NewErrorMessage(common.ErrInvalidOptions, "'autocommit' field requires a transaction number to also be specified")
+	}
+
+	if start, ok := m["startTransaction"]; ok {
+		if start != true {
+			return nil, common.NewErrorMessage(common.ErrInvalidOptions, "startTransaction field can only be specified as true")
+		}
+		params.start = true
+	}
+
+	return params, nil
+}
+
+// getTxnNumber returns the transaction number of the command. ok is false if the command has none.
+func getTxnNumber(document types.Document) (txnNumber int64, ok bool, err error) {
+	switch value := document.Map()["txnNumber"].(type) {
+	case int64:
+		return value, true, nil
+	case int32:
+		return int64(value), true, nil
+	case nil:
+		return 0, false, nil
+	default:
+		return 0, false, common.NewErrorMessage(
+			common.ErrTypeMismatch, "BSON field 'OperationSessionInfo.txnNumber' is the wrong type '%T', expected type 'long'", value,
+		)
+	}
+}
+
+// getSessionID returns the id of the logical session of the command or an empty string if it has none.
+func getSessionID(document types.Document) (string, error) {
+	lsid, ok := document.Map()["lsid"]
+	if !ok {
+		return "", nil
+	}
+
+	return parseSessionID(lsid, "OperationSessionInfo.lsid")
+}
+
+// parseSessionID returns the id of a logical session given as {id: UUID}. field is used in error messages.
+func parseSessionID(lsid any, field string) (string, error) {
+	doc, ok := lsid.(types.Document)
+	if !ok {
+		return "", common.NewErrorMessage(
+			common.ErrTypeMismatch, "BSON field '%s' is the wrong type '%T', expected type 'object'", field, lsid,
+		)
+	}
+
+	id, ok := doc.Map()["id"].(types.Binary)
+	if !ok {
+		return "", common.NewErrorMessage(common.ErrTypeMismatch, "BSON field 'LogicalSessionId.id' must be of type binData")
+	}
+
+	return hex.EncodeToString(id.B), nil
+}
+
+// txKey is the context key of the transaction of a command.
+type txKey struct{}
+
+// startCommand returns the context for a CRUD command and marks the session of the command as used.
+// If the command is part of a transaction, the context carries it and the returned function,
+// which must be called after the command, returns the transaction to the registry.
+func (h *storage) startCommand(ctx context.Context, document types.Document) (context.Context, func(), error) {
+	params, err := getTransactionParams(document)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if params == nil {
+		sessionID, err := getSessionID(document)
+		if err != nil {
+			return nil, nil, err
+		}
+		if sessionID != "" {
+			h.sessions.refresh(common.Owner(ctx), []string{sessionID})
+		}
+
+		return ctx, func() {}, nil
+	}
+
+	params.owner = common.Owner(ctx)
+
+	txn, err := h.sessions.checkout(h.hanaPool, params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return context.WithValue(ctx, txKey{}, txn), func() { h.sessions.checkin(txn) }, nil
+}
+
+// notInTransaction returns an error if the command is part of a transaction.
+// Used by commands which cannot be rolled back, like DDL statements.
+func notInTransaction(document types.Document, command string) error {
+	params, err := getTransactionParams(document)
+	if err != nil {
+		return err
+	}
+	if params != nil {
+		return common.NewErrorMessage(common.ErrNotInTransaction, "Cannot run '%s' in a multi-document transaction.", command)
+	}
+
+	return nil
+}
+
+// inTransaction returns true if the command is part of a transaction or a retryable write.
+func inTransaction(ctx context.Context) bool {
+	_, ok := ctx.Value(txKey{}).(*transaction)
+	return ok
+}
+
+// querier returns the transaction of the command or the pool if the command is not part of a transaction.
+func (h *storage) querier(ctx context.Context) querier {
+	if txn, ok := ctx.Value(txKey{}).(*transaction); ok {
+		return txn.tx
+	}
+
+	return h.hanaPool
+}
+
+// commandTx is the transaction of a single command. Within a multi-statement transaction it is
+// a savepoint of that transaction, so that committing it is left to commitTransaction.
+type commandTx struct {
+	querier
+	tx        *sql.Tx // nil within a multi-statement transaction
+	savepoint bool
+	done      bool
+}
+
+// beginTx begins the transaction of a single command.
+func (h *storage) beginTx(ctx context.Context) (*commandTx, error) {
+	if txn, ok := ctx.Value(txKey{}).(*transaction); ok {
+		if _, err := txn.tx.ExecContext(ctx, "SAVEPOINT "+commandSavepoint); err != nil {
+			return nil, lazyerrors.Error(err)
+		}
+
+		return &commandTx{querier: txn.tx, savepoint: true}, nil
+	}
+
+	tx, err := h.hanaPool.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	return &commandTx{querier: tx, tx: tx}, nil
+}
+
+// Commit commits the transaction or releases the savepoint.
+func (t *commandTx) Commit() error {
+	t.done = true
+
+	if t.savepoint {
+		_, err := t.ExecContext(context.Background(), "RELEASE SAVEPOINT "+commandSavepoint)
+		return err
+	}
+
+	return t.tx.Commit()
+}
+
+// Rollback rolls back the transaction or to the savepoint. It does nothing after Commit.
+func (t *commandTx) Rollback() error {
+	if t.done {
+		return nil
+	}
+	t.done = true
+
+	if t.savepoint {
+		_, err := t.ExecContext(context.Background(), "ROLLBACK TO SAVEPOINT "+commandSavepoint)
+		return err
+	}
+
+	return t.tx.Rollback()
+}
@@ -28,14 +28,50 @@ import (
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
 )
 
+const (
+	// DefaultInsertBatchSize is the number of documents inserted in one transaction by insert.
+	DefaultInsertBatchSize = 1000
+
+	// MaxInsertBatchSize is the largest batch size, the bulk size of the driver,
+	// so that a batch is sent to SAP HANA at once.
+	MaxInsertBatchSize = 10000
+)
+
 type storage struct {
-	hanaPool *hana.Hpool
-	l        *zap.Logger
+	hanaPool        *hana.Hpool
+	l               *zap.Logger
+	cursors         *Cursors
+	sessions        *Sessions
+	insertBatchSize int
 }
 
-func NewStorage(hanaPool *hana.Hpool, l *zap.Logger) common.Storage {
+// NewStorageOpts represents options for NewStorage.
+type NewStorageOpts struct {
+	HanaPool *hana.Hpool
+	Logger   *zap.Logger
+	Cursors  *Cursors
+	Sessions *Sessions
+
+	// InsertBatchSize is the number of documents inserted in one transaction,
+	// DefaultInsertBatchSize is used if it is not positive. It is limited to MaxInsertBatchSize.
+	InsertBatchSize int
+}
+
+// NewStorage creates a new storage.
+func NewStorage(opts *NewStorageOpts) common.Storage {
+	insertBatchSize := opts.InsertBatchSize
+	if insertBatchSize <= 0 {
+		insertBatchSize = DefaultInsertBatchSize
+	}
+	if insertBatchSize > MaxInsertBatchSize {
+		insertBatchSize = MaxInsertBatchSize
+	}
+
 	return &storage{
-		hanaPool: hanaPool,
-		l:        l,
+		hanaPool:        opts.HanaPool,
+		l:               opts.Logger,
+		cursors:         opts.Cursors,
+		sessions:        opts.Sessions,
+		insertBatchSize: insertBatchSize,
 	}
 }
@@ -22,6 +22,8 @@ package handlers
 
 import (
 	"context"
+	"crypto/x509"
+	"database/sql"
 	"fmt"
 	"strings"
 	"sync/atomic"
@@ -30,6 +32,7 @@ import (
 
 	"go.uber.org/zap"
 
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/auth"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
@@ -43,7 +46,20 @@ type Handler struct {
 	l             *zap.Logger
 	crud          common.Storage
 	metrics       *Metrics
+	connectionID  int32
 	lastRequestID int32
+
+	// authenticator is nil if authentication is disabled
+	authenticator *auth.Authenticator
+	conversation  *auth.Conversation
+	user          string
+	userDB        string
+
+	// userPool is the pool of the SAP HANA user authenticated with PLAIN, connectDB the pool replaced by it
+	userPool  *hana.Hpool
+	connectDB *sql.DB
+
+	peerCertificate func() *x509.Certificate
 }
 
 type NewOpts struct {
@@ -52,6 +68,16 @@ type NewOpts struct {
 	CrudStorage common.Storage
 	Metrics     *Metrics
 	PeerAddr    string
+
+	// ConnectionID identifies the client connection, returned as connectionId by hello.
+	ConnectionID int32
+
+	// Authenticator verifies the credentials of clients. Authentication is disabled if it is nil.
+	Authenticator *auth.Authenticator
+
+	// PeerCertificate returns the verified certificate of the client, or nil if there is none.
+	// It is nil for connections without TLS.
+	PeerCertificate func() *x509.Certificate
 }
 
 func New(opts *NewOpts) *Handler {
@@ -62,9 +88,19 @@ func New(opts *NewOpts) *Handler {
 		crud:     opts.CrudStorage,
 		metrics:  opts.Metrics,
 		peerAddr: opts.PeerAddr,
+
+		connectionID: opts.ConnectionID,
+
+		authenticator:   opts.Authenticator,
+		peerCertificate: opts.PeerCertificate,
 	}
 }
 
+// Close releases the pool of the SAP HANA user authenticated with PLAIN once the client connection is closed.
+func (h *Handler) Close() {
+	h.releaseUserPool()
+}
+
 // Handle handles the message.
 //
 // Message handlers should:
@@ -156,6 +192,10 @@ func (h *Handler) handleOpMsg(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg
 
 	h.metrics.requests.WithLabelValues(wire.OP_MSG.String(), cmd).Inc()
 
+	if !h.authenticated(cmd) {
+		return nil, common.NewErrorMessage(common.ErrUnauthorized, "command %s requires authentication", cmd)
+	}
+
 	if cmd == "listcommands" {
 		return SupportedCommands(ctx, msg)
 	}
@@ -169,12 +209,36 @@ func (h *Handler) handleOpMsg(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg
 		if err != nil {
 			return nil, err
 		}
-		return cmd.storageHandler(storage, ctx, msg)
+		return cmd.storageHandler(storage, common.WithOwner(ctx, h.owner()), msg)
 	}
 
 	return nil, common.NewErrorMessage(common.ErrCommandNotFound, "no such command: '%s'", cmd)
 }
 
+// authenticated returns true if the client may run the command.
+// Unauthenticated clients may only run the commands of the handshake and of the authentication.
+func (h *Handler) authenticated(cmd string) bool {
+	if h.authenticator == nil || h.user != "" {
+		return true
+	}
+
+	switch cmd {
+	case "hello", "ismaster", "ping", "saslstart", "saslcontinue", "authenticate":
+		return true
+	default:
+		return false
+	}
+}
+
+// owner returns the authenticated user as owner of cursors and sessions, or "" if the client is not authenticated.
+func (h *Handler) owner() string {
+	if h.user == "" {
+		return ""
+	}
+
+	return h.user + "@" + h.userDB
+}
+
 func (h *Handler) handleOpQuery(ctx context.Context, query *wire.OpQuery) (*wire.OpReply, error) {
 	cmd := query.Query.Command()
 	h.metrics.requests.WithLabelValues(wire.OP_QUERY.String(), cmd).Inc()
@@ -203,11 +267,18 @@ func (h *Handler) msgStorage(ctx context.Context, msg *wire.OpMsg) (common.Stora
 	m := document.Map()
 	command := document.Command()
 
-	if command == "createindexes" {
+	switch command {
+	case "getmore", "killcursors", "committransaction", "aborttransaction",
+		"startsession", "endsessions", "refreshsessions", "killsessions":
 		return h.crud, nil
+	case "aggregate":
+		// aggregate: 1 runs a database level pipeline which is handled as not implemented by MsgAggregate
+		if _, ok := m[command].(string); !ok {
+			return h.crud, nil
+		}
 	}
 
-	collection := m[command].(string)
+	collection := m[document.Keys()[0]].(string)
 	db := m["$db"].(string)
 
 	var jsonbTableExist bool
@@ -232,7 +303,7 @@ func (h *Handler) msgStorage(ctx context.Context, msg *wire.OpMsg) (common.Stora
 	}
 
 	switch command {
-	case "delete", "find", "count":
+	case "aggregate", "delete", "distinct", "dropindexes", "find", "listindexes", "count":
 		if jsonbTableExist {
 			return h.crud, nil
 		} else if collection == "system.js" || collection == "system.version" {
@@ -241,12 +312,18 @@ func (h *Handler) msgStorage(ctx context.Context, msg *wire.OpMsg) (common.Stora
 
 		return nil, fmt.Errorf("Collection %s does not exist", strings.ToUpper(collection))
 
-	case "insert", "update":
+	case "createindexes", "insert", "update", "findandmodify":
 		if jsonbTableExist {
+			// _id values are only unique in collections with the _id index
+			if err := h.hanaPool.EnsureIDIndex(ctx, db, collection); err != nil {
+				return nil, lazyerrors.Errorf("Handler.msgStorage: %w", err)
+			}
+
 			return h.crud, nil
 		}
 
-		if strings.EqualFold(command, "update") {
+		// update and findAndModify create the collection only for an upsert
+		if (command == "update" && !hasUpsert(m["updates"])) || (command == "findandmodify" && m["upsert"] != true) {
 			return nil, lazyerrors.Errorf("Collection %s does not exist", strings.ToUpper(collection))
 		}
 
@@ -266,3 +343,24 @@ func (h *Handler) msgStorage(ctx context.Context, msg *wire.OpMsg) (common.Stora
 		panic(fmt.Sprintf("unhandled command %q", command))
 	}
 }
+
+// hasUpsert returns true if any of the update statements is an upsert.
+func hasUpsert(updates any) bool {
+	arr, ok := updates.(*types.Array)
+	if !ok {
+		return false
+	}
+
+	for i := 0; i < arr.Len(); i++ {
+		doc, err := arr.Get(i)
+		if err != nil {
+			continue
+		}
+
+		if u, ok := doc.(types.Document); ok && u.Map()["upsert"] == true {
+			return true
+		}
+	}
+
+	return false
+}
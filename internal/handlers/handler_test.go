@@ -22,6 +22,8 @@ package handlers
 
 import (
 	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"database/sql"
 	"database/sql/driver"
 	"fmt"
@@ -35,6 +37,8 @@ import (
 
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/bson"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/auth"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/crud"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/testutil"
@@ -55,20 +59,27 @@ func setup(t *testing.T, qMatcher sqlmock.QueryMatcher) (context.Context, *Handl
 	}
 
 	hPool := hana.Hpool{
-		db,
+		DB: db,
 	}
 
 	ctx := testutil.Ctx(t)
 
 	l := zaptest.NewLogger(t)
 
-	crud := crud.NewStorage(&hPool, l)
+	crud := crud.NewStorage(&crud.NewStorageOpts{
+		HanaPool: &hPool,
+		Logger:   l,
+		Cursors:  crud.NewCursors(crud.DefaultCursorTimeout, l),
+		Sessions: crud.NewSessions(crud.DefaultTransactionTimeout, l),
+	})
 	handler := New(&NewOpts{
 		HanaPool:    &hPool,
 		Logger:      l,
 		CrudStorage: crud,
 		Metrics:     NewMetrics(),
 		PeerAddr:    "",
+
+		ConnectionID: 1,
 	})
 
 	return ctx, handler, mock
@@ -140,7 +151,7 @@ func TestFind(t *testing.T) {
 		row3 := sqlmock.NewRows([]string{"document"})
 		mock.ExpectQuery("SELECT object_count FROM m_feature_usage WHERE component_name = 'DOCSTORE' AND feature_name = 'COLLECTIONS'").WillReturnRows(row1)
 		mock.ExpectQuery("SELECT Table_name FROM PUBLIC.M_TABLES WHERE ").WillReturnRows(row2)
-		mock.ExpectQuery("SELECT * FROM databaseName.actor WHERE \"last_name\" = 'Doe' AND \"actor_id\" \u003e 50 AND \"actor_id\" \u003c 100").WillReturnRows(row3)
+		mock.ExpectQuery(`SELECT * FROM "DATABASENAME"."ACTOR" WHERE "last_name" = ? AND ("actor_id" > ? AND "actor_id" < ?)`).WithArgs("Doe", 50, 100).WillReturnRows(row3)
 
 		actual := handle(ctx, t, handler, reqDoc)
 		expected := types.MustMakeDocument(
@@ -218,15 +229,16 @@ func TestInsert(t *testing.T) {
 
 		row1 := sqlmock.NewRows([]string{"object_count"}).AddRow(10)
 		row2 := sqlmock.NewRows([]string{"Table_name"})
-		row3 := sqlmock.NewRows([]string{"_id"})
 		args := []driver.Value{[]byte{123, 34, 95, 105, 100, 34, 58, 49, 44, 34, 110, 101, 119, 34, 58, 34, 116, 101, 115, 116, 34, 125}}
 
 		mock.ExpectQuery("SELECT object_count FROM m_feature_usage WHERE component_name = 'DOCSTORE' AND feature_name = 'COLLECTIONS'").WillReturnRows(row1)
 		mock.ExpectQuery("SELECT Table_name FROM PUBLIC.M_TABLES WHERE ").WillReturnRows(row2)
-		mock.ExpectExec("CREATE SCHEMA testDatabase").WillReturnResult(sqlmock.NewResult(1, 1))
-		mock.ExpectExec("CREATE COLLECTION testDatabase.test").WillReturnResult(sqlmock.NewResult(1, 1))
-		mock.ExpectQuery("SELECT _id FROM testDatabase.test  WHERE \"_id\" = 1 LIMIT 1").WillReturnRows(row3)
-		mock.ExpectExec("INSERT INTO testDatabase.test VALUES ($1)").WithArgs(args...).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE SCHEMA "TESTDATABASE"`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE COLLECTION "TESTDATABASE"."TEST"`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE UNIQUE INDEX "TESTDATABASE"."TEST._id_" ON "TESTDATABASE"."TEST"("_id")`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectBegin()
+		mock.ExpectPrepare(`INSERT INTO "TESTDATABASE"."TEST" VALUES (?)`).ExpectExec().WithArgs(args...).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
 
 		actual := handle(ctx, t, handler, reqDoc)
 		expected := types.MustMakeDocument(
@@ -260,13 +272,19 @@ func TestInsert(t *testing.T) {
 
 		row1 := sqlmock.NewRows([]string{"object_count"}).AddRow(10)
 		row2 := sqlmock.NewRows([]string{"Table_name"}).AddRow("test")
-		row3 := sqlmock.NewRows([]string{"_id"})
+		row3 := sqlmock.NewRows([]string{"INDEX_NAME", "CONSTRAINT", "COLUMN_NAME", "ASCENDING_ORDER"})
 		args := []driver.Value{[]byte{123, 34, 95, 105, 100, 34, 58, 49, 44, 34, 110, 101, 119, 34, 58, 34, 116, 101, 115, 116, 34, 125}}
 
 		mock.ExpectQuery("SELECT object_count FROM m_feature_usage WHERE component_name = 'DOCSTORE' AND feature_name = 'COLLECTIONS'").WillReturnRows(row1)
 		mock.ExpectQuery("SELECT Table_name FROM PUBLIC.M_TABLES WHERE ").WillReturnRows(row2)
-		mock.ExpectQuery("SELECT _id FROM testDatabase.test  WHERE \"_id\" = 1 LIMIT 1").WillReturnRows(row3)
-		mock.ExpectExec("INSERT INTO testDatabase.test VALUES ($1)").WithArgs(args...).WillReturnResult(sqlmock.NewResult(1, 1))
+		// the collection was created without the _id index
+		mock.ExpectQuery("SELECT i.INDEX_NAME, i.CONSTRAINT, c.COLUMN_NAME, c.ASCENDING_ORDER FROM SYS.INDEXES i").
+			WithArgs("TESTDATABASE", "TEST").
+			WillReturnRows(row3)
+		mock.ExpectExec(`CREATE UNIQUE INDEX "TESTDATABASE"."TEST._id_" ON "TESTDATABASE"."TEST"("_id")`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectBegin()
+		mock.ExpectPrepare(`INSERT INTO "TESTDATABASE"."TEST" VALUES (?)`).ExpectExec().WithArgs(args...).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
 
 		actual := handle(ctx, t, handler, reqDoc)
 		expected := types.MustMakeDocument(
@@ -318,8 +336,9 @@ func TestDatabaseCommand(t *testing.T) {
 			"$db", "testDatabase",
 		)
 
-		mock.ExpectExec("CREATE SCHEMA testDatabase").WillReturnResult(sqlmock.NewResult(1, 1))
-		mock.ExpectExec("CREATE COLLECTION testDatabase.newTest").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE SCHEMA "TESTDATABASE"`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE COLLECTION "TESTDATABASE"."NEWTEST"`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE UNIQUE INDEX "TESTDATABASE"."NEWTEST._id_" ON "TESTDATABASE"."NEWTEST"("_id")`).WillReturnResult(sqlmock.NewResult(0, 0))
 
 		actual := handle(ctx, t, handler, reqDoc)
 		expected := types.MustMakeDocument(
@@ -343,7 +362,7 @@ func TestDatabaseCommand(t *testing.T) {
 			"$db", "testDatabase",
 		)
 
-		mock.ExpectExec("DROP COLLECTION testDatabase.newTest").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`DROP COLLECTION "TESTDATABASE"."NEWTEST"`).WillReturnResult(sqlmock.NewResult(1, 1))
 
 		actual := handle(ctx, t, handler, reqDoc)
 		expected := types.MustMakeDocument(
@@ -369,7 +388,7 @@ func TestDatabaseCommand(t *testing.T) {
 			"$db", "testDatabase",
 		)
 
-		mock.ExpectExec("DROP SCHEMA testDatabase").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`DROP SCHEMA "TESTDATABASE"`).WillReturnResult(sqlmock.NewResult(1, 1))
 
 		actual := handle(ctx, t, handler, reqDoc)
 		expected := types.MustMakeDocument(
@@ -404,7 +423,7 @@ func TestDatabaseCommand(t *testing.T) {
 		row := sqlmock.NewRows([]string{"table_name"}).AddRow("testTable")
 		args := []driver.Value{"TESTDATABASE"}
 
-		mock.ExpectExec("CREATE SCHEMA testDatabase").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE SCHEMA "TESTDATABASE"`).WillReturnResult(sqlmock.NewResult(1, 1))
 
 		mock.ExpectQuery("SELECT TABLE_NAME FROM \"PUBLIC\".\"M_TABLES\" WHERE SCHEMA_NAME = $1 AND TABLE_TYPE = 'COLLECTION';").WithArgs(args...).WillReturnRows(row)
 
@@ -540,6 +559,8 @@ func TestDatabaseCommand(t *testing.T) {
 			"maxBsonObjectSize", int32(16777216),
 			"maxMessageSizeBytes", int32(48000000),
 			"maxWriteBatchSize", int32(100000),
+			"logicalSessionTimeoutMinutes", int32(30),
+			"connectionId", int32(1),
 			"minWireVersion", int32(13),
 			"maxWireVersion", int32(13),
 			"readOnly", false,
@@ -621,6 +642,8 @@ func TestQueryCmd(t *testing.T) {
 		"maxBsonObjectSize", int32(16777216),
 		"maxMessageSizeBytes", int32(48000000),
 		"maxWriteBatchSize", int32(100000),
+		"logicalSessionTimeoutMinutes", int32(30),
+		"connectionId", int32(1),
 		"minWireVersion", int32(13),
 		"maxWireVersion", int32(13),
 		"readOnly", false,
@@ -1479,3 +1502,144 @@ func TestQueryCmd(t *testing.T) {
 // 		assert.Equal(t, expected, actual)
 // 	})
 // }
+
+func TestAuthentication(t *testing.T) {
+	t.Parallel()
+	ctx, handler, mock := setup(t, QueryMatcherEqualBytes)
+	handler.authenticator = auth.NewAuthenticator(&auth.NewAuthenticatorOpts{HanaPool: handler.hanaPool, Logger: handler.l})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		actual := handle(ctx, t, handler, types.MustMakeDocument(
+			"listDatabases", int32(1),
+			"$db", "admin",
+		))
+
+		protoErr, _ := common.ProtocolError(
+			common.NewErrorMessage(common.ErrUnauthorized, "command listdatabases requires authentication"),
+		)
+		assert.Equal(t, protoErr.Document(), actual)
+	})
+
+	t.Run("hello", func(t *testing.T) {
+		selectCredentials := "SELECT MECHANISM, SALT, ITERATIONS, STORED_KEY, SERVER_KEY FROM MONGODB_USERS WHERE DB = $1 AND USER_NAME = $2"
+		credentialsColumns := []string{"MECHANISM", "SALT", "ITERATIONS", "STORED_KEY", "SERVER_KEY"}
+
+		hello := func(name string) types.Document {
+			return handle(ctx, t, handler, types.MustMakeDocument(
+				"hello", int32(1),
+				"saslSupportedMechs", name,
+				"$db", "admin",
+			))
+		}
+
+		mock.ExpectQuery("SELECT COUNT(*) FROM SYS.TABLES WHERE SCHEMA_NAME = CURRENT_SCHEMA").
+			WillReturnRows(sqlmock.NewRows([]string{"COUNT"}).AddRow(1))
+		mock.ExpectQuery(selectCredentials).WithArgs("admin", "user").
+			WillReturnRows(sqlmock.NewRows(credentialsColumns).
+				AddRow("SCRAM-SHA-1", []byte("salt"), 10000, []byte("stored"), []byte("server")).
+				AddRow("SCRAM-SHA-256", []byte("salt"), 15000, []byte("stored"), []byte("server")))
+		mock.ExpectQuery("SELECT ROLE_NAME FROM SYS.GRANTED_ROLES WHERE GRANTEE = $1").WithArgs("user").
+			WillReturnRows(sqlmock.NewRows([]string{"ROLE_NAME"}))
+
+		actual := hello("admin.user")
+		assert.Equal(t, types.MustNewArray("SCRAM-SHA-1", "SCRAM-SHA-256"), actual.Map()["saslSupportedMechs"])
+		assert.Equal(t, float64(1), actual.Map()["ok"])
+
+		// SAP HANA users created by createUser only authenticate with PLAIN
+		mock.ExpectQuery(selectCredentials).WithArgs("test", "hana.user").
+			WillReturnRows(sqlmock.NewRows(credentialsColumns).AddRow("PLAIN", []byte{}, 0, []byte{}, []byte{}))
+
+		actual = hello("test.hana.user")
+		assert.Equal(t, types.MustNewArray("PLAIN"), actual.Map()["saslSupportedMechs"])
+
+		mock.ExpectQuery(selectCredentials).WithArgs("admin", "unknown").
+			WillReturnRows(sqlmock.NewRows(credentialsColumns))
+
+		actual = hello("admin.unknown")
+		assert.NotContains(t, actual.Map(), "saslSupportedMechs")
+		assert.Equal(t, float64(1), actual.Map()["ok"])
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("x509", func(t *testing.T) {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: "client", Organization: []string{"SAP"}}}
+		handler.peerCertificate = func() *x509.Certificate { return cert }
+
+		mock.ExpectQuery("SELECT MECHANISM, SALT, ITERATIONS, STORED_KEY, SERVER_KEY FROM MONGODB_USERS WHERE DB = $1 AND USER_NAME = $2").
+			WithArgs("$external", "CN=client,O=SAP").
+			WillReturnRows(sqlmock.NewRows([]string{"MECHANISM", "SALT", "ITERATIONS", "STORED_KEY", "SERVER_KEY"}).
+				AddRow("MONGODB-X509", []byte{}, 0, []byte{}, []byte{}))
+
+		actual := handle(ctx, t, handler, types.MustMakeDocument(
+			"authenticate", int32(1),
+			"mechanism", "MONGODB-X509",
+			"$db", "$external",
+		))
+
+		expected := types.MustMakeDocument(
+			"dbname", "$external",
+			"user", "CN=client,O=SAP",
+			"ok", float64(1),
+		)
+		assert.Equal(t, expected, actual)
+		require.NoError(t, mock.ExpectationsWereMet())
+
+		actual = handle(ctx, t, handler, types.MustMakeDocument(
+			"authenticate", int32(1),
+			"mechanism", "MONGODB-X509",
+			"user", "CN=other,O=SAP",
+			"$db", "$external",
+		))
+		assert.Equal(t, int32(common.ErrAuthenticationFailed), actual.Map()["code"])
+	})
+
+	t.Run("authenticated", func(t *testing.T) {
+		handler.user, handler.userDB = "user", "admin"
+
+		actual := handle(ctx, t, handler, types.MustMakeDocument(
+			"connectionStatus", int32(1),
+			"$db", "admin",
+		))
+
+		expected := types.MustMakeDocument(
+			"authInfo", types.MustMakeDocument(
+				"authenticatedUsers", types.MustNewArray(types.MustMakeDocument("user", "user", "db", "admin")),
+				"authenticatedUserRoles", types.MustNewArray(),
+				"authenticatedUserPrivileges", types.MustNewArray(),
+			),
+			"ok", float64(1),
+		)
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("insufficient privilege", func(t *testing.T) {
+		mock.ExpectQuery("SELECT SCHEMA_NAME FROM SCHEMAS").
+			WillReturnError(fmt.Errorf("SQL Error 258 - insufficient privilege: Not authorized"))
+
+		actual := handle(ctx, t, handler, types.MustMakeDocument(
+			"listDatabases", int32(1),
+			"$db", "admin",
+		))
+
+		assert.Equal(t, int32(common.ErrUnauthorized), actual.Map()["code"])
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("user management without USER ADMIN", func(t *testing.T) {
+		mock.ExpectQuery("SELECT COUNT(*) FROM SYS.EFFECTIVE_PRIVILEGES WHERE USER_NAME = CURRENT_USER").
+			WithArgs("USER ADMIN").
+			WillReturnRows(sqlmock.NewRows([]string{"COUNT"}).AddRow(0))
+
+		actual := handle(ctx, t, handler, types.MustMakeDocument(
+			"dropUser", "other",
+			"$db", "test",
+		))
+
+		protoErr, _ := common.ProtocolError(
+			common.NewErrorMessage(common.ErrUnauthorized, "not authorized on test to execute command dropUser"),
+		)
+		assert.Equal(t, protoErr.Document(), actual)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
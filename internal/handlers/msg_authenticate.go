@@ -6,18 +6,66 @@ package handlers
 
 import (
 	"context"
+	"crypto/x509"
 
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/auth"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
 )
 
-// MsgAuthenticate is so far only used for the authentication needed by MongoDB drivers when using tls.
-// Since username and password is so far not implemented, MsgAuthenticate sends ok: 1 no matter what.
+// MsgAuthenticate authenticates a client of the database $external with MONGODB-X509 by its client certificate.
+// If authentication is disabled, MsgAuthenticate sends ok: 1 no matter what, as required by MongoDB drivers when using tls.
+// Other mechanisms have to use saslStart and saslContinue.
 func (h *Handler) MsgAuthenticate(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	if h.authenticator == nil {
+		var reply wire.OpMsg
+		err := reply.SetSections(wire.OpMsgSection{
+			Documents: []types.Document{types.MustMakeDocument(
+				"ok", float64(1),
+			)},
+		})
+		if err != nil {
+			return nil, lazyerrors.Error(err)
+		}
+
+		return &reply, nil
+	}
+
+	document, err := msg.Document()
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	m := document.Map()
+
+	if mechanism, _ := m["mechanism"].(string); mechanism != auth.X509 {
+		return nil, common.NewErrorMessage(
+			common.ErrMechanismUnavailable, "Authentication with the authenticate command is only supported for %s, use SCRAM-SHA-256", auth.X509,
+		)
+	}
+
+	var cert *x509.Certificate
+	if h.peerCertificate != nil {
+		cert = h.peerCertificate()
+	}
+
+	db, _ := m["$db"].(string)
+	user, _ := m["user"].(string)
+
+	conversation, err := h.authenticator.AuthenticateX509(ctx, db, user, cert)
+	if err != nil {
+		return nil, err
+	}
+
+	h.login(conversation)
+
 	var reply wire.OpMsg
-	err := reply.SetSections(wire.OpMsgSection{
+	err = reply.SetSections(wire.OpMsgSection{
 		Documents: []types.Document{types.MustMakeDocument(
+			"dbname", h.userDB,
+			"user", h.user,
 			"ok", float64(1),
 		)},
 	})
@@ -29,12 +29,18 @@ import (
 
 // MsgConnectionStatus is a common implementation of the connectionStatus command.
 // Is a workaround to make it possible to connect and use GUI's like Studio 3T.
+// With authentication enabled, it returns the authenticated user.
 func (h *Handler) MsgConnectionStatus(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	users := types.MustNewArray("USERNAME")
+	if h.authenticator != nil {
+		users = types.MustNewArray(types.MustMakeDocument("user", h.user, "db", h.userDB))
+	}
+
 	var reply wire.OpMsg
 	err := reply.SetSections(wire.OpMsgSection{
 		Documents: []types.Document{types.MustMakeDocument(
 			"authInfo", types.MustMakeDocument(
-				"authenticatedUsers", types.MustNewArray("USERNAME"),
+				"authenticatedUsers", users,
 				"authenticatedUserRoles", types.MustNewArray(),
 				"authenticatedUserPrivileges", types.MustNewArray(),
 			),
@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package handlers
+
+import (
+	"context"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+)
+
+// MsgCreateUser creates a user of the database as SAP HANA user with the given password and built-in roles.
+// A user of the database $external has no password, it is authenticated with MONGODB-X509 by the subject of its client certificate.
+func (h *Handler) MsgCreateUser(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	cmd, err := h.getUserCommand(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = common.Unimplemented(&cmd.document, "authenticationRestrictions", "digestPassword"); err != nil {
+		return nil, err
+	}
+
+	password, _ := cmd.document.Map()["pwd"].(string)
+	if cmd.db == externalDB {
+		if password != "" {
+			return nil, errExternalPassword
+		}
+
+		if _, err = getRoles(cmd.document, cmd.db, true); err != nil {
+			return nil, err
+		}
+
+		if err = cmd.authenticator.CreateX509User(ctx, cmd.user); err != nil {
+			return nil, err
+		}
+
+		return okReply()
+	}
+
+	if password == "" {
+		return nil, common.NewErrorMessage(common.ErrBadValue, "Must provide a 'pwd' field for all user documents")
+	}
+
+	roles, err := getRoles(cmd.document, cmd.db, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cmd.authenticator.CreateUser(ctx, cmd.hanaPool, cmd.db, cmd.user, password, roles); err != nil {
+		return nil, err
+	}
+
+	return okReply()
+}
@@ -0,0 +1,25 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package handlers
+
+import (
+	"context"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+)
+
+// MsgDropUser drops a user of the database and the SAP HANA user.
+func (h *Handler) MsgDropUser(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	cmd, err := h.getUserCommand(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cmd.authenticator.DropUser(ctx, cmd.hanaPool, cmd.db, cmd.user); err != nil {
+		return nil, err
+	}
+
+	return okReply()
+}
@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package handlers
+
+import (
+	"context"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+)
+
+// MsgGrantRolesToUser grants built-in roles to a user of the database.
+func (h *Handler) MsgGrantRolesToUser(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	cmd, err := h.getUserCommand(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := getRoles(cmd.document, cmd.db, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cmd.authenticator.GrantRoles(ctx, cmd.hanaPool, cmd.db, cmd.user, roles); err != nil {
+		return nil, err
+	}
+
+	return okReply()
+}
+
+// MsgRevokeRolesFromUser revokes built-in roles from a user of the database.
+func (h *Handler) MsgRevokeRolesFromUser(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	cmd, err := h.getUserCommand(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := getRoles(cmd.document, cmd.db, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cmd.authenticator.RevokeRoles(ctx, cmd.hanaPool, cmd.db, cmd.user, roles); err != nil {
+		return nil, err
+	}
+
+	return okReply()
+}
@@ -20,9 +20,11 @@ package handlers
 
 import (
 	"context"
+	"strings"
 	"time"
 
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/bson"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/crud"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
@@ -30,24 +32,19 @@ import (
 
 // MsgHello returns a document that describes the role of the instance.
 func (h *Handler) MsgHello(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	document, err := msg.Document()
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	hello, err := h.helloDocument(ctx, document)
+	if err != nil {
+		return nil, err
+	}
+
 	var reply wire.OpMsg
-	err := reply.SetSections(wire.OpMsgSection{
-		// TODO merge with QueryCmd
-		Documents: []types.Document{types.MustMakeDocument(
-			"helloOk", true,
-			"ismaster", true,
-			// topologyVersion
-			"maxBsonObjectSize", int32(bson.MaxDocumentLen),
-			"maxMessageSizeBytes", int32(wire.MaxMsgLen),
-			"maxWriteBatchSize", int32(100000),
-			"localTime", time.Now(),
-			// logicalSessionTimeoutMinutes
-			// connectionId
-			"minWireVersion", int32(13),
-			"maxWireVersion", int32(13),
-			"readOnly", false,
-			"ok", float64(1),
-		)},
+	err = reply.SetSections(wire.OpMsgSection{
+		Documents: []types.Document{hello},
 	})
 	if err != nil {
 		return nil, lazyerrors.Error(err)
@@ -55,3 +52,42 @@ func (h *Handler) MsgHello(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, e
 
 	return &reply, nil
 }
+
+// helloDocument returns the reply to hello and to ismaster, which is sent as OP_QUERY by drivers during the handshake.
+func (h *Handler) helloDocument(ctx context.Context, req types.Document) (types.Document, error) {
+	pairs := []any{
+		"helloOk", true,
+		"ismaster", true,
+		// topologyVersion
+		"maxBsonObjectSize", int32(bson.MaxDocumentLen),
+		"maxMessageSizeBytes", int32(wire.MaxMsgLen),
+		"maxWriteBatchSize", int32(100000),
+		"localTime", time.Now(),
+		"logicalSessionTimeoutMinutes", int32(crud.SessionTimeout / time.Minute),
+		"connectionId", h.connectionID,
+		"minWireVersion", int32(13),
+		"maxWireVersion", int32(13),
+		"readOnly", false,
+	}
+
+	// drivers ask for the mechanisms of the user <db>.<user> to choose one it can authenticate with
+	if name, ok := req.Map()["saslSupportedMechs"].(string); ok && h.authenticator != nil {
+		if db, user, ok := strings.Cut(name, "."); ok {
+			mechanisms, err := h.authenticator.SupportedMechanisms(ctx, db, user)
+			if err != nil {
+				return types.Document{}, err
+			}
+
+			// unknown users have no entry
+			if mechanisms != nil {
+				values := make([]any, len(mechanisms))
+				for i, m := range mechanisms {
+					values[i] = m
+				}
+				pairs = append(pairs, "saslSupportedMechs", types.MustNewArray(values...))
+			}
+		}
+	}
+
+	return types.MustMakeDocument(append(pairs, "ok", float64(1))...), nil
+}
@@ -7,34 +7,93 @@ package handlers
 import (
 	"context"
 
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
 )
 
-// MsgRolesinfo returns information about the given roles.
-// Is a workaround to make it possible to connect and use GUI's like Studio 3T.
+// MsgRolesInfo returns information about the given roles.
+// Only the built-in roles read, readWrite and dbAdmin exist, user-defined roles are not supported.
 func (h *Handler) MsgRolesInfo(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
-	var reply wire.OpMsg
-	msgDoc, err := msg.Document()
+	document, err := msg.Document()
 	if err != nil {
 		return nil, lazyerrors.Error(err)
 	}
-	db, err := msgDoc.Get("$db")
-	if err != nil {
-		return nil, lazyerrors.Error(err)
+
+	m := document.Map()
+	db, _ := m["$db"].(string)
+
+	var roles []hana.SchemaRole
+	errInvalid := common.NewErrorMessage(common.ErrBadValue, "Role must be either a string or an object")
+
+	add := func(value any) error {
+		r := hana.SchemaRole{DB: db}
+		switch value := value.(type) {
+		case string:
+			r.Role = value
+		case types.Document:
+			r.Role, _ = value.Map()["role"].(string)
+			r.DB, _ = value.Map()["db"].(string)
+			if r.Role == "" || r.DB == "" {
+				return errInvalid
+			}
+		default:
+			return errInvalid
+		}
+
+		// roles which do not exist are omitted
+		if hana.IsBuiltinRole(r.Role) {
+			roles = append(roles, r)
+		}
+
+		return nil
+	}
+
+	switch value := m[document.Keys()[0]].(type) {
+	case int32, int64, float64:
+		// all user-defined roles, and the built-in roles if requested
+		if showBuiltinRoles, _ := m["showBuiltinRoles"].(bool); showBuiltinRoles {
+			for _, role := range hana.BuiltinRoles() {
+				roles = append(roles, hana.SchemaRole{Role: role, DB: db})
+			}
+		}
+	case *types.Array:
+		for i := 0; i < value.Len(); i++ {
+			v, err := value.Get(i)
+			if err != nil {
+				return nil, lazyerrors.Error(err)
+			}
+			if err = add(v); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		if err = add(value); err != nil {
+			return nil, err
+		}
 	}
+
+	res := types.MakeArray(len(roles))
+	for _, r := range roles {
+		err = res.Append(types.MustMakeDocument(
+			"_id", r.DB+"."+r.Role,
+			"role", r.Role,
+			"db", r.DB,
+			"isBuiltin", true,
+			"roles", types.MustNewArray(),
+			"inheritedRoles", types.MustNewArray(),
+		))
+		if err != nil {
+			return nil, lazyerrors.Error(err)
+		}
+	}
+
+	var reply wire.OpMsg
 	err = reply.SetSections(wire.OpMsgSection{
 		Documents: []types.Document{types.MustMakeDocument(
-			"roles", types.MustNewArray(
-				types.MustMakeDocument(
-					"role", "readWrite",
-					"db", db.(string),
-					"isBuiltIn", true,
-					"roles", types.MustNewArray(),
-					"inheretedRoles", types.MustNewArray(),
-				),
-			),
+			"roles", res,
 			"ok", float64(1),
 		)},
 	})
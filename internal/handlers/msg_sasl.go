@@ -0,0 +1,168 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package handlers
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/auth"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+)
+
+// conversationID identifies the SCRAM conversation of a connection. There is at most one at a time.
+const conversationID = int32(1)
+
+// MsgSaslStart starts a SCRAM-SHA-256 or SCRAM-SHA-1 conversation with the client-first message
+// or authenticates the client as SAP HANA user with PLAIN.
+func (h *Handler) MsgSaslStart(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	if h.authenticator == nil {
+		return nil, common.NewErrorMessage(common.ErrMechanismUnavailable, "Authentication is disabled")
+	}
+
+	document, err := msg.Document()
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	m := document.Map()
+
+	mechanism, ok := m["mechanism"].(string)
+	if !ok {
+		return nil, common.NewErrorMessage(common.ErrBadValue, "BSON field 'saslStart.mechanism' is missing but a required field")
+	}
+
+	payload, err := saslPayload(m["payload"], "saslStart")
+	if err != nil {
+		return nil, err
+	}
+
+	var skipEmptyExchange bool
+	if options, ok := m["options"].(types.Document); ok {
+		skipEmptyExchange, _ = options.Map()["skipEmptyExchange"].(bool)
+	}
+
+	db, _ := m["$db"].(string)
+
+	h.conversation = nil
+
+	conversation, reply, err := h.authenticator.StartConversation(ctx, db, mechanism, payload, skipEmptyExchange)
+	if err != nil {
+		return nil, err
+	}
+
+	if conversation.Done() {
+		h.login(conversation)
+		return saslReply(true, reply)
+	}
+
+	h.conversation = conversation
+
+	return saslReply(false, reply)
+}
+
+// MsgSaslContinue continues the SCRAM conversation started by saslStart.
+// The client is authenticated once the conversation is done.
+func (h *Handler) MsgSaslContinue(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	document, err := msg.Document()
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	m := document.Map()
+
+	if id, _ := m["conversationId"].(int32); h.conversation == nil || id != conversationID {
+		return nil, common.NewErrorMessage(common.ErrBadValue, "No SASL session state found")
+	}
+
+	payload, err := saslPayload(m["payload"], "saslContinue")
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := h.conversation.Continue(payload)
+	if err != nil {
+		h.conversation = nil
+		return nil, err
+	}
+
+	done := h.conversation.Done()
+	if done {
+		h.login(h.conversation)
+		h.conversation = nil
+	}
+
+	return saslReply(done, reply)
+}
+
+// login authenticates the client with the user of the done conversation.
+// A SAP HANA user authenticated with PLAIN runs all further statements of the connection with its own pool.
+func (h *Handler) login(conversation *auth.Conversation) {
+	h.releaseUserPool()
+
+	h.user, h.userDB = conversation.User()
+
+	if hanaPool := conversation.HanaPool(); hanaPool != nil {
+		// the pool is shared by the handler and the storage of the connection
+		h.userPool = hanaPool
+		h.connectDB = h.hanaPool.DB
+		h.hanaPool.DB = hanaPool.DB
+	}
+
+	h.l.Info("Authenticated", zap.String("user", h.user), zap.String("db", h.userDB))
+}
+
+// releaseUserPool switches the connection back from the pool of the SAP HANA user authenticated with PLAIN.
+func (h *Handler) releaseUserPool() {
+	if h.userPool == nil {
+		return
+	}
+
+	h.hanaPool.DB = h.connectDB
+	h.authenticator.ReleasePool(h.userPool)
+	h.userPool = nil
+}
+
+// saslPayload returns the payload of saslStart or saslContinue. Drivers send binary data, the legacy shell a string.
+func saslPayload(payload any, command string) ([]byte, error) {
+	switch payload := payload.(type) {
+	case types.Binary:
+		return payload.B, nil
+	case string:
+		return []byte(payload), nil
+	case nil:
+		return nil, common.NewErrorMessage(common.ErrBadValue, "BSON field '%s.payload' is missing but a required field", command)
+	default:
+		return nil, common.NewErrorMessage(
+			common.ErrTypeMismatch, "BSON field '%s.payload' is the wrong type '%T', expected type 'binData'", command, payload,
+		)
+	}
+}
+
+// saslReply returns the reply to saslStart and saslContinue.
+func saslReply(done bool, payload []byte) (*wire.OpMsg, error) {
+	if payload == nil {
+		payload = []byte{}
+	}
+
+	var reply wire.OpMsg
+	err := reply.SetSections(wire.OpMsgSection{
+		Documents: []types.Document{types.MustMakeDocument(
+			"conversationId", conversationID,
+			"done", done,
+			"payload", types.Binary{Subtype: types.BinaryGeneric, B: payload},
+			"ok", float64(1),
+		)},
+	})
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	return &reply, nil
+}
@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package handlers
+
+import (
+	"context"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+)
+
+// MsgUpdateUser changes the password of a user of the database and replaces its roles.
+func (h *Handler) MsgUpdateUser(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	cmd, err := h.getUserCommand(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = common.Unimplemented(&cmd.document, "authenticationRestrictions", "digestPassword"); err != nil {
+		return nil, err
+	}
+
+	password, _ := cmd.document.Map()["pwd"].(string)
+	if password != "" && cmd.db == externalDB {
+		return nil, errExternalPassword
+	}
+
+	roles, err := getRoles(cmd.document, cmd.db, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if password == "" && roles == nil {
+		return nil, common.NewErrorMessage(common.ErrBadValue, "Must specify at least one field to update in updateUser")
+	}
+
+	if err = cmd.authenticator.UpdateUser(ctx, cmd.hanaPool, cmd.db, cmd.user, password, roles); err != nil {
+		return nil, err
+	}
+
+	return okReply()
+}
@@ -7,14 +7,135 @@ package handlers
 import (
 	"context"
 
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
 )
 
 // MsgUsersInfo returns information about users.
-// Is a workaround to make it possible to connect and use GUI's like Studio 3T.
+// Without authentication it is a workaround to make it possible to connect and use GUI's like Studio 3T.
 func (h *Handler) MsgUsersInfo(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
+	if h.authenticator == nil {
+		return h.msgUsersInfoWorkaround(ctx, msg)
+	}
+
+	document, err := msg.Document()
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	m := document.Map()
+	db, _ := m["$db"].(string)
+
+	// users is nil if all users of the databases are returned
+	allDBs, users, err := getUsersInfoFilter(m[document.Keys()[0]], db)
+	if err != nil {
+		return nil, err
+	}
+
+	filterDB := db
+	if allDBs || users != nil {
+		filterDB = ""
+	}
+
+	all, err := h.authenticator.Users(ctx, filterDB)
+	if err != nil {
+		return nil, err
+	}
+
+	res := types.MakeArray(len(all))
+	for _, u := range all {
+		if users != nil && !users[u.DB+"."+u.Name] {
+			continue
+		}
+
+		mechanisms := make([]any, len(u.Mechanisms))
+		for i, m := range u.Mechanisms {
+			mechanisms[i] = m
+		}
+
+		err = res.Append(types.MustMakeDocument(
+			"_id", u.DB+"."+u.Name,
+			"user", u.Name,
+			"db", u.DB,
+			"roles", rolesArray(u.Roles),
+			"mechanisms", types.MustNewArray(mechanisms...),
+		))
+		if err != nil {
+			return nil, lazyerrors.Error(err)
+		}
+	}
+
+	var reply wire.OpMsg
+	err = reply.SetSections(wire.OpMsgSection{
+		Documents: []types.Document{types.MustMakeDocument(
+			"users", res,
+			"ok", float64(1),
+		)},
+	})
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	return &reply, nil
+}
+
+// getUsersInfoFilter returns the users selected by the value of usersInfo as set of db.user.
+// It returns nil for all users of the database and allDBs is true for {forAllDBs: true}.
+func getUsersInfoFilter(value any, db string) (allDBs bool, users map[string]bool, err error) {
+	errInvalid := common.NewErrorMessage(common.ErrBadValue, "User must be either a string or an object")
+
+	add := func(value any) error {
+		switch value := value.(type) {
+		case string:
+			users[db+"."+value] = true
+		case types.Document:
+			m := value.Map()
+			user, _ := m["user"].(string)
+			userDB, _ := m["db"].(string)
+			if user == "" || userDB == "" {
+				return errInvalid
+			}
+			users[userDB+"."+user] = true
+		default:
+			return errInvalid
+		}
+
+		return nil
+	}
+
+	switch value := value.(type) {
+	case int32, int64, float64:
+		return false, nil, nil
+	case types.Document:
+		if forAllDBs, _ := value.Map()["forAllDBs"].(bool); forAllDBs {
+			return true, nil, nil
+		}
+		users = make(map[string]bool)
+		err = add(value)
+	case *types.Array:
+		users = make(map[string]bool)
+		for i := 0; i < value.Len() && err == nil; i++ {
+			var v any
+			if v, err = value.Get(i); err == nil {
+				err = add(v)
+			}
+		}
+	default:
+		users = make(map[string]bool)
+		err = add(value)
+	}
+
+	if err != nil {
+		return false, nil, err
+	}
+
+	return false, users, nil
+}
+
+// msgUsersInfoWorkaround returns a fixed user.
+func (h *Handler) msgUsersInfoWorkaround(ctx context.Context, msg *wire.OpMsg) (*wire.OpMsg, error) {
 	var reply wire.OpMsg
 	msgDoc, err := msg.Document()
 	if err != nil {
@@ -20,9 +20,7 @@ package handlers
 
 import (
 	"context"
-	"time"
 
-	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/bson"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
 	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
@@ -31,26 +29,14 @@ import (
 func (h *Handler) QueryCmd(ctx context.Context, query *wire.OpQuery) (*wire.OpReply, error) {
 	switch cmd := query.Query.Command(); cmd {
 	case "ismaster":
-		// TODO merge with MsgHello
+		hello, err := h.helloDocument(ctx, query.Query)
+		if err != nil {
+			return nil, err
+		}
+
 		reply := &wire.OpReply{
 			NumberReturned: 1,
-			Documents: []types.Document{
-				types.MustMakeDocument(
-					"helloOk", true,
-					"ismaster", true,
-					// topologyVersion
-					"maxBsonObjectSize", int32(bson.MaxDocumentLen),
-					"maxMessageSizeBytes", int32(wire.MaxMsgLen),
-					"maxWriteBatchSize", int32(100000),
-					"localTime", time.Now(),
-					// logicalSessionTimeoutMinutes
-					// connectionId
-					"minWireVersion", int32(13),
-					"maxWireVersion", int32(13),
-					"readOnly", false,
-					"ok", float64(1),
-				),
-			},
+			Documents:      []types.Document{hello},
 		}
 		return reply, nil
 
@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package handlers
+
+import (
+	"context"
+
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/hana"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/auth"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/handlers/common"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/types"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/util/lazyerrors"
+	"github.com/SAP/sap-hana-compatibility-layer-for-mongodb-wire-protocol/internal/wire"
+)
+
+// externalDB is the database of the users authenticated with MONGODB-X509.
+const externalDB = "$external"
+
+// errExternalPassword is returned for passwords of users of the database $external.
+var errExternalPassword = common.NewErrorMessage(
+	common.ErrBadValue, "Cannot set the password for users defined on the '%s' database", externalDB,
+)
+
+// userAdminPrivilege is the SAP HANA system privilege required for user management.
+const userAdminPrivilege = "USER ADMIN"
+
+// userCommand holds the fields common to the user management commands.
+type userCommand struct {
+	authenticator *auth.Authenticator
+	hanaPool      *hana.Hpool // of the client
+	document      types.Document
+	user          string
+	db            string
+}
+
+// getUserCommand returns the fields of a user management command, whose value is the name of the user.
+// Users are stored by the authenticator, so user management requires authentication to be enabled.
+// The SAP HANA user of the client must have the system privilege USER ADMIN.
+func (h *Handler) getUserCommand(ctx context.Context, msg *wire.OpMsg) (*userCommand, error) {
+	document, err := msg.Document()
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	command := document.Keys()[0]
+
+	if h.authenticator == nil {
+		return nil, common.NewErrorMessage(common.ErrNotImplemented, "%s requires authentication to be enabled with the flag -auth", command)
+	}
+
+	m := document.Map()
+
+	user, ok := m[command].(string)
+	if !ok || user == "" {
+		return nil, common.NewErrorMessage(common.ErrBadValue, "BSON field '%s' must be the name of a user", command)
+	}
+
+	db, _ := m["$db"].(string)
+
+	allowed, err := h.hanaPool.HasSystemPrivilege(ctx, userAdminPrivilege)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, common.NewErrorMessage(common.ErrUnauthorized, "not authorized on %s to execute command %s", db, command)
+	}
+
+	return &userCommand{
+		authenticator: h.authenticator,
+		hanaPool:      h.hanaPool,
+		document:      document,
+		user:          user,
+		db:            db,
+	}, nil
+}
+
+// getRoles returns the roles of the field roles of the command, or nil if the field is not given and not required.
+// A role given by name is a role of the database db. Only the built-in roles are supported.
+func getRoles(document types.Document, db string, required bool) ([]hana.SchemaRole, error) {
+	command := document.Keys()[0]
+
+	value, ok := document.Map()["roles"]
+	if !ok {
+		if required {
+			return nil, common.NewErrorMessage(common.ErrBadValue, "BSON field '%s.roles' is missing but a required field", command)
+		}
+		return nil, nil
+	}
+
+	arr, ok := value.(*types.Array)
+	if !ok {
+		return nil, common.NewErrorMessage(
+			common.ErrTypeMismatch, "BSON field '%s.roles' is the wrong type '%T', expected type 'array'", command, value,
+		)
+	}
+
+	res := make([]hana.SchemaRole, 0, arr.Len())
+	for i := 0; i < arr.Len(); i++ {
+		value, err := arr.Get(i)
+		if err != nil {
+			return nil, lazyerrors.Error(err)
+		}
+
+		r := hana.SchemaRole{DB: db}
+		switch value := value.(type) {
+		case string:
+			r.Role = value
+		case types.Document:
+			m := value.Map()
+			r.Role, _ = m["role"].(string)
+			r.DB, _ = m["db"].(string)
+			if r.Role == "" || r.DB == "" {
+				return nil, common.NewErrorMessage(common.ErrBadValue, "A role must be given by name or as document with role and db")
+			}
+		default:
+			return nil, common.NewErrorMessage(common.ErrBadValue, "A role must be given by name or as document with role and db")
+		}
+
+		// users of $external are no SAP HANA users the roles could be granted to
+		if db == externalDB {
+			return nil, common.NewErrorMessage(common.ErrNotImplemented, "Roles of users of the database %s are not supported", externalDB)
+		}
+
+		if !hana.IsBuiltinRole(r.Role) {
+			return nil, common.NewErrorMessage(common.ErrRoleNotFound, "Could not find role: %s@%s", r.Role, r.DB)
+		}
+
+		res = append(res, r)
+	}
+
+	return res, nil
+}
+
+// rolesArray returns the roles as array of documents with role and db.
+func rolesArray(roles []hana.SchemaRole) *types.Array {
+	values := make([]any, len(roles))
+	for i, r := range roles {
+		values[i] = types.MustMakeDocument("role", r.Role, "db", r.DB)
+	}
+
+	return types.MustNewArray(values...)
+}
+
+// okReply returns the reply of a command which only reports success.
+func okReply() (*wire.OpMsg, error) {
+	var reply wire.OpMsg
+	err := reply.SetSections(wire.OpMsgSection{
+		Documents: []types.Document{types.MustMakeDocument(
+			"ok", float64(1),
+		)},
+	})
+	if err != nil {
+		return nil, lazyerrors.Error(err)
+	}
+
+	return &reply, nil
+}
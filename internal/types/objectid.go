@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package types
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// objectIDProcess is the random value unique to the process.
+	objectIDProcess [5]byte
+
+	// objectIDCounter is the incrementing counter, initialized to a random value.
+	objectIDCounter uint32
+)
+
+func init() {
+	if _, err := rand.Read(objectIDProcess[:]); err != nil {
+		panic(err)
+	}
+
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	objectIDCounter = binary.BigEndian.Uint32(b[:])
+}
+
+// NewObjectID returns a new ObjectID made of the current time, a process unique value and a counter.
+func NewObjectID() ObjectID {
+	return newObjectIDTime(time.Now())
+}
+
+// newObjectIDTime returns a new ObjectID with the given time.
+func newObjectIDTime(t time.Time) ObjectID {
+	var res ObjectID
+
+	binary.BigEndian.PutUint32(res[0:4], uint32(t.Unix()))
+	copy(res[4:9], objectIDProcess[:])
+
+	// the counter has three bytes, the most significant byte is dropped for a correct wraparound
+	c := atomic.AddUint32(&objectIDCounter, 1)
+	res[9] = byte(c >> 16)
+	res[10] = byte(c >> 8)
+	res[11] = byte(c)
+
+	return res
+}
@@ -0,0 +1,28 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package types
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewObjectID(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+
+	a := newObjectIDTime(ts)
+	b := newObjectIDTime(ts)
+
+	assert.Equal(t, uint32(ts.Unix()), binary.BigEndian.Uint32(a[0:4]))
+	assert.Equal(t, a[4:9], b[4:9], "process unique value must not change")
+	assert.NotEqual(t, a, b, "counter must increase")
+
+	assert.NotEqual(t, NewObjectID(), NewObjectID())
+}